@@ -0,0 +1,471 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/batch"
+	"github.com/gregjohnson2017/tabula-editor/pkg/codec"
+	"github.com/gregjohnson2017/tabula-editor/pkg/image"
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+)
+
+// Exit codes of the commands
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+	// exitDiffers is returned by diff for projects that differ
+	exitDiffers = 3
+)
+
+// ErrInvalidSize indicates a size not given as WIDTHxHEIGHT
+const ErrInvalidSize log.ConstErr = "size must be given as WIDTHxHEIGHT"
+
+// command is a subcommand of the command line
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+// commands lists the subcommands in the order they are shown in the help
+var commands []command
+
+func init() {
+	commands = []command{
+		{"gui", "open the editor window, the default", guiCommand},
+		{"info", "print the canvas and layers of a project", infoCommand},
+		{"export", "write a project as an image without a window", exportCommand},
+		{"convert", "convert an image or project to another format", convertCommand},
+		{"flatten", "write a project with its layers merged into one", flattenCommand},
+		{"new", "create a blank project", newCommand},
+		{"batch", "apply a sequence of operations to many images", batchCommand},
+		{"macro", "play a recorded macro on a project", macroCommand},
+		{"diff", "report the differences between two projects", diffCommand},
+		{"help", "show the help of a command", helpCommand},
+	}
+}
+
+// run runs the command named by the first argument with the rest, or the
+// editor window if no command is named, and returns the exit code
+func run(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return guiCommand(args)
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(args[1:])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+	printCommands(os.Stderr)
+	return exitUsage
+}
+
+// printCommands writes the list of commands and the exit codes to w
+func printCommands(w io.Writer) {
+	fmt.Fprintln(w, "\nCommands:")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  %v\t%v\n", c.name, c.summary)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "Run \"%v help COMMAND\" for the options of a command.\n", filepath.Base(os.Args[0]))
+	printExitCodes(w)
+}
+
+// printExitCodes writes the meaning of the exit codes to w
+func printExitCodes(w io.Writer) {
+	fmt.Fprintln(w, "\nExit codes:")
+	fmt.Fprintf(w, "  %v  success\n", exitOK)
+	fmt.Fprintf(w, "  %v  the command failed\n", exitError)
+	fmt.Fprintf(w, "  %v  the arguments are invalid\n", exitUsage)
+	fmt.Fprintf(w, "  %v  the compared projects differ (diff)\n", exitDiffers)
+}
+
+// helpCommand shows the help of the named command
+func helpCommand(args []string) int {
+	if len(args) == 0 {
+		return guiCommand([]string{"-h"})
+	}
+	for _, c := range commands {
+		if c.name == args[0] && c.name != "help" {
+			return c.run([]string{"-h"})
+		}
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+	printCommands(os.Stderr)
+	return exitUsage
+}
+
+// commandFlags returns the flags of a command that runs without a window,
+// with a help showing its usage line and description
+func commandFlags(name, usage, description string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage:")
+		fmt.Fprintf(fs.Output(), "  %v %v %v\n", filepath.Base(os.Args[0]), name, usage)
+		for _, line := range strings.Split(description, "\n") {
+			fmt.Fprintf(fs.Output(), "  %v\n", line)
+		}
+		fmt.Fprintln(fs.Output(), "\nOptions:")
+		fs.PrintDefaults()
+		printExitCodes(fs.Output())
+	}
+	return fs
+}
+
+// parseCommand parses the flags of a command that runs without a window,
+// adding a -quiet flag and showing information and warnings unless it is
+// given. The command stops with the returned exit code unless ok.
+func parseCommand(fs *flag.FlagSet, args []string) (code int, ok bool) {
+	quiet := fs.Bool("quiet", false, "hide all output besides the result")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK, false
+		}
+		return exitUsage, false
+	}
+	if !*quiet {
+		log.SetInfoOutput(os.Stderr)
+		log.SetWarnOutput(os.Stderr)
+		log.SetFatalOutput(os.Stderr)
+	}
+	return exitOK, true
+}
+
+// usageError reports a problem with the arguments of a command and returns
+// the exit code for it
+func usageError(fs *flag.FlagSet, v ...interface{}) int {
+	if len(v) > 0 {
+		fmt.Fprintln(fs.Output(), v...)
+	}
+	fs.Usage()
+	return exitUsage
+}
+
+// parseSize parses a size in the form WIDTHxHEIGHT
+func parseSize(s string) (int, int, error) {
+	parts := strings.Split(strings.ToLower(s), "x")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("%w, got %q", ErrInvalidSize, s)
+	}
+	w, errW := strconv.Atoi(parts[0])
+	h, errH := strconv.Atoi(parts[1])
+	if errW != nil || errH != nil {
+		return 0, 0, fmt.Errorf("%w, got %q", ErrInvalidSize, s)
+	}
+	return w, h, nil
+}
+
+// layerInfo describes a layer for the info command. The offset is relative
+// to the canvas.
+type layerInfo struct {
+	Name    string  `json:"name"`
+	X       int32   `json:"x"`
+	Y       int32   `json:"y"`
+	Width   int32   `json:"width"`
+	Height  int32   `json:"height"`
+	Opacity float64 `json:"opacity"`
+	Hidden  bool    `json:"hidden"`
+	// Link is the file a linked layer shows, if any
+	Link     string `json:"link,omitempty"`
+	Embedded bool   `json:"embedded,omitempty"`
+}
+
+// projectInfo describes a project for the info command
+type projectInfo struct {
+	Name     string      `json:"name"`
+	Width    int32       `json:"width"`
+	Height   int32       `json:"height"`
+	Depth    string      `json:"depth"`
+	Linear   bool        `json:"linear"`
+	Palette  int         `json:"palette"`
+	Layers   []layerInfo `json:"layers"`
+	Metadata bool        `json:"metadata"`
+}
+
+// describe returns the description of doc printed by the info command
+func describe(doc *image.Document) projectInfo {
+	info := projectInfo{
+		Name:     doc.ProjName,
+		Width:    doc.Canvas.W,
+		Height:   doc.Canvas.H,
+		Depth:    doc.Depth.String(),
+		Linear:   doc.Linear,
+		Palette:  len(doc.Palette),
+		Metadata: !doc.Metadata.Empty(),
+	}
+	for i, l := range doc.Layers {
+		name := l.Name
+		if i == 0 {
+			name = "(canvas)"
+		}
+		li := layerInfo{
+			Name:    name,
+			X:       l.Area.X - doc.Canvas.X,
+			Y:       l.Area.Y - doc.Canvas.Y,
+			Width:   l.Area.W,
+			Height:  l.Area.H,
+			Opacity: l.Opacity(),
+			Hidden:  l.Hidden,
+		}
+		if l.Linked {
+			li.Link, li.Embedded = l.Source, l.Embedded != nil
+		}
+		info.Layers = append(info.Layers, li)
+	}
+	return info
+}
+
+// infoCommand prints the canvas and layers of a project
+func infoCommand(args []string) int {
+	var asJSON bool
+	fs := commandFlags("info", "[OPTIONS] FILE", "Print the canvas size and the name, offset and size of every layer of a project.\nOffsets are relative to the top left corner of the canvas.")
+	fs.BoolVar(&asJSON, "json", false, "print the description as JSON")
+	if code, ok := parseCommand(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 1 {
+		return usageError(fs, "expected one project file")
+	}
+	doc, err := image.ReadDocument(fs.Arg(0))
+	if err != nil {
+		log.Warnf("reading %v: %v", fs.Arg(0), err)
+		return exitError
+	}
+	info := describe(doc)
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(info); err != nil {
+			log.Warn(err)
+			return exitError
+		}
+		return exitOK
+	}
+	palette := "none"
+	if info.Palette > 0 {
+		palette = fmt.Sprintf("%v colors", info.Palette)
+	}
+	fmt.Printf("Project: %v\n", info.Name)
+	fmt.Printf("Canvas:  %vx%v\n", info.Width, info.Height)
+	fmt.Printf("Depth:   %v\n", info.Depth)
+	fmt.Printf("Linear:  %v\n", info.Linear)
+	fmt.Printf("Palette: %v\n", palette)
+	fmt.Printf("Layers:  %v\n\n", len(info.Layers)-1)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tNAME\tOFFSET\tSIZE\tOPACITY\tHIDDEN\tLINK")
+	for i, l := range info.Layers {
+		link := l.Link
+		if l.Embedded {
+			link = "embedded " + filepath.Base(link)
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v,%v\t%vx%v\t%.2f\t%v\t%v\n", i, l.Name, l.X, l.Y, l.Width, l.Height, l.Opacity, l.Hidden, link)
+	}
+	if err = tw.Flush(); err != nil {
+		log.Warn(err)
+		return exitError
+	}
+	return exitOK
+}
+
+// convertCommand writes an image in the format of another file extension,
+// keeping its metadata, its precision if the format allows, and the frames
+// of animations if both formats store them. Projects are converted between
+// project files, project folders and OpenRaster files.
+func convertCommand(args []string) int {
+	var out string
+	opts := optionsFlag{}
+	fs := commandFlags("convert", "-o FILE [OPTIONS] FILE", "Convert an image to the format chosen by the extension of the output file.\nA project is converted to a project file (.tabula), a project folder ("+image.FolderExt+") or OpenRaster (.ora),\nand an image ending with any of these is written as a project.")
+	fs.StringVar(&out, "o", "", "name of the image file to write")
+	fs.Var(opts, "opt", "encoder option as name=value, e.g. \"Quality=90\", may be repeated")
+	if code, ok := parseCommand(fs, args); !ok {
+		return code
+	}
+	if out == "" || fs.NArg() != 1 {
+		return usageError(fs, "expected an output file and one input file")
+	}
+	in := fs.Arg(0)
+	if image.IsProject(in) || image.IsProject(out) {
+		return convertProject(in, out)
+	}
+	enc, err := codec.EncoderFor(out)
+	if err != nil {
+		log.Warn(err)
+		return exitUsage
+	}
+	if dec, err := codec.DecoderFor(in); err == nil && dec.DecodeFrames != nil && enc.EncodeFrames != nil {
+		frames, err := codec.DecodeFrames(in)
+		if err != nil {
+			log.Warn(err)
+			return exitError
+		}
+		if _, ok := opts[codec.FramesOption]; !ok && len(frames) > 1 {
+			opts[codec.FramesOption] = codec.FramesLayers
+		}
+		if opts[codec.FramesOption] == codec.FramesLayers {
+			if err = codec.EncodeFramesFile(out, frames, codec.Options(opts)); err != nil {
+				log.Warnf("writing %v: %v", out, err)
+				return exitError
+			}
+			return exitOK
+		}
+	}
+	img, depth, m, err := codec.DecodeFileDeep(in)
+	if err != nil {
+		log.Warn(err)
+		return exitError
+	}
+	if depth != raster.Depth8 {
+		if _, ok := opts[codec.BitDepthOption]; !ok {
+			opts[codec.BitDepthOption] = "16"
+			if _, err = enc.Resolve(codec.Options(opts)); err != nil {
+				delete(opts, codec.BitDepthOption)
+			}
+		}
+		err = codec.EncodeFileDeep(out, img.NRGBA64(), codec.Options(opts), m)
+	} else {
+		err = codec.EncodeFileMeta(out, img.NRGBA(), codec.Options(opts), m)
+	}
+	if err != nil {
+		log.Warnf("writing %v: %v", out, err)
+		return exitError
+	}
+	return exitOK
+}
+
+// convertProject writes the project or layered image at in as the project
+// at out
+func convertProject(in, out string) int {
+	doc, err := image.ReadDocument(in)
+	if err != nil {
+		log.Warnf("reading %v: %v", in, err)
+		return exitError
+	}
+	if err = doc.Save(out); err != nil {
+		log.Warnf("writing %v: %v", out, err)
+		return exitError
+	}
+	return exitOK
+}
+
+// flattenCommand writes a project with its visible layers merged into one
+func flattenCommand(args []string) int {
+	var out string
+	fs := commandFlags("flatten", "-o FILE [OPTIONS] FILE", "Write a project with its visible layers merged into a single layer.\nThe output must end with .tabula, "+image.FolderExt+" or .ora.")
+	fs.StringVar(&out, "o", "", "name of the project file to write")
+	if code, ok := parseCommand(fs, args); !ok {
+		return code
+	}
+	if out == "" || fs.NArg() != 1 {
+		return usageError(fs, "expected an output file and one project file")
+	}
+	doc, err := image.ReadDocument(fs.Arg(0))
+	if err != nil {
+		log.Warnf("reading %v: %v", fs.Arg(0), err)
+		return exitError
+	}
+	doc.Flatten()
+	if err = doc.Save(out); err != nil {
+		log.Warnf("writing %v: %v", out, err)
+		return exitError
+	}
+	return exitOK
+}
+
+// newCommand creates a blank project
+func newCommand(args []string) int {
+	var out string
+	var size string
+	var background string
+	var depth string
+	fs := commandFlags("new", "-o FILE [OPTIONS]", "Create a blank project with a canvas of the given size and background.\nThe output must end with .tabula, "+image.FolderExt+" or .ora.")
+	fs.StringVar(&background, "background", "transparent", "canvas color as RRGGBB or RRGGBBAA, or transparent")
+	fs.StringVar(&depth, "depth", "8", "bits per channel: 8, 16 or 32")
+	fs.StringVar(&out, "o", "", "name of the project file to write")
+	fs.StringVar(&size, "size", "100x100", "canvas size as WIDTHxHEIGHT")
+	if code, ok := parseCommand(fs, args); !ok {
+		return code
+	}
+	if out == "" || fs.NArg() != 0 {
+		return usageError(fs, "expected an output file")
+	}
+	w, h, err := parseSize(size)
+	if err != nil {
+		return usageError(fs, err)
+	}
+	var bg color.NRGBA
+	if background != "transparent" {
+		if bg, err = raster.ParseHex(background); err != nil {
+			return usageError(fs, err)
+		}
+	}
+	d, err := raster.ParseDepth(depth)
+	if err != nil {
+		return usageError(fs, err)
+	}
+	doc, err := image.NewDocument(w, h, bg, d)
+	if err != nil {
+		return usageError(fs, err)
+	}
+	if err = doc.Save(out); err != nil {
+		log.Warnf("writing %v: %v", out, err)
+		return exitError
+	}
+	return exitOK
+}
+
+// batchCommand applies the operations of a pipeline file to every input,
+// returning an error exit code if any input failed
+func batchCommand(args []string) int {
+	var ops string
+	var in string
+	var out string
+	var workers int
+	fs := commandFlags("batch", "-ops FILE -in PATTERN -out DIR [OPTIONS] [FILE...]", "Apply the operations listed in a JSON file to every input image and write the results to a folder.\nOperations are objects such as {\"op\": \"resize\", \"width\": 64}, {\"op\": \"crop\", \"x\": 0, \"y\": 0, \"width\": 32, \"height\": 32},\n{\"op\": \"filter\", \"name\": \"invert\"} or {\"op\": \"convert\", \"format\": \"png\"}. A failed input does not stop the others.")
+	fs.StringVar(&in, "in", "", "pattern of the input files, e.g. 'assets/*.png'")
+	fs.StringVar(&ops, "ops", "", "name of the JSON file listing the operations")
+	fs.StringVar(&out, "out", "", "folder to write the results to, created if needed")
+	fs.IntVar(&workers, "workers", runtime.NumCPU(), "number of inputs processed at once")
+	if code, ok := parseCommand(fs, args); !ok {
+		return code
+	}
+	if ops == "" || out == "" || in == "" && fs.NArg() == 0 {
+		return usageError(fs, "expected operations, inputs and an output folder")
+	}
+	p, err := batch.Load(ops)
+	if err != nil {
+		return usageError(fs, err)
+	}
+	inputs := fs.Args()
+	if in != "" {
+		matches, err := filepath.Glob(in)
+		if err != nil {
+			return usageError(fs, err)
+		}
+		inputs = append(matches, inputs...)
+	}
+	if len(inputs) == 0 {
+		log.Warnf("no files match %v", in)
+		return exitError
+	}
+	results := p.Run(inputs, out, workers)
+	batch.LogSummary(results)
+	if batch.Failed(results) > 0 {
+		return exitError
+	}
+	return exitOK
+}
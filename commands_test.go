@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testRun(expected int, args ...string) func(t *testing.T) {
+	return func(t *testing.T) {
+		if actual := run(args); actual != expected {
+			t.Fatalf("expected exit code %v, got %v for %q", expected, actual, args)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tabula")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	project := filepath.Join(dir, "a.tabula")
+	other := filepath.Join(dir, "b.tabula")
+	missing := filepath.Join(dir, "missing.tabula")
+	out := filepath.Join(dir, "out.png")
+
+	t.Run("unknown command", testRun(exitUsage, "frobnicate"))
+	t.Run("help", testRun(exitOK, "help", "new"))
+	t.Run("help unknown", testRun(exitUsage, "help", "frobnicate"))
+	t.Run("unknown flag", testRun(exitUsage, "info", "-frobnicate"))
+
+	t.Run("new", testRun(exitOK, "new", "-quiet", "-o", project, "-size", "4x3"))
+	t.Run("new other", testRun(exitOK, "new", "-quiet", "-o", other, "-size", "4x3", "-background", "ff0000"))
+	t.Run("new without output", testRun(exitUsage, "new", "-quiet", "-size", "4x3"))
+	t.Run("new malformed size", testRun(exitUsage, "new", "-quiet", "-o", project, "-size", "4"))
+	t.Run("new empty size", testRun(exitUsage, "new", "-quiet", "-o", project, "-size", "0x3"))
+	t.Run("new huge size", testRun(exitUsage, "new", "-quiet", "-o", project, "-size", "100000x100000"))
+	t.Run("new bad color", testRun(exitUsage, "new", "-quiet", "-o", project, "-background", "red"))
+	t.Run("new bad depth", testRun(exitUsage, "new", "-quiet", "-o", project, "-depth", "12"))
+
+	t.Run("info", testRun(exitOK, "info", "-quiet", project))
+	t.Run("info missing", testRun(exitError, "info", "-quiet", missing))
+	t.Run("info without project", testRun(exitUsage, "info", "-quiet"))
+
+	t.Run("export", testRun(exitOK, "export", "-quiet", "-project", project, "-o", out, "-scale", "2"))
+	t.Run("export missing", testRun(exitError, "export", "-quiet", "-project", missing, "-o", out))
+	t.Run("export both outputs", testRun(exitUsage, "export", "-quiet", "-project", project, "-o", out, "-layers", dir))
+	t.Run("export bad filter", testRun(exitUsage, "export", "-quiet", "-project", project, "-o", out, "-filter", "lanczos"))
+	t.Run("export negative scale", testRun(exitUsage, "export", "-quiet", "-project", project, "-o", out, "-scale", "-1"))
+	t.Run("export huge scale", testRun(exitUsage, "export", "-quiet", "-project", project, "-o", out, "-scale", "1e5"))
+
+	t.Run("diff same", testRun(exitOK, "diff", "-quiet", project, project))
+	t.Run("diff differs", testRun(exitDiffers, "diff", "-quiet", project, other))
+	t.Run("diff bad threshold", testRun(exitUsage, "diff", "-quiet", "-threshold", "256", project, other))
+	t.Run("diff one project", testRun(exitUsage, "diff", "-quiet", project))
+}
+
+func TestParseSize(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		w, h int
+		ok   bool
+	}{
+		{"4x3", 4, 3, true},
+		{"4X3", 4, 3, true},
+		{"4", 0, 0, false},
+		{"4x3x2", 0, 0, false},
+		{"ax3", 0, 0, false},
+	} {
+		w, h, err := parseSize(c.in)
+		if (err == nil) != c.ok || w != c.w || h != c.h {
+			t.Errorf("%q: expected %vx%v (ok %v), got %vx%v (%v)", c.in, c.w, c.h, c.ok, w, h, err)
+		}
+	}
+}
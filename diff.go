@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/codec"
+	"github.com/gregjohnson2017/tabula-editor/pkg/diff"
+	"github.com/gregjohnson2017/tabula-editor/pkg/image"
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+)
+
+// diffCommand reports how the second of two projects differs from the first
+func diffCommand(args []string) int {
+	var out string
+	var threshold int
+	fs := commandFlags("diff", "[OPTIONS] OLD NEW", "Report the layers added to, removed from, moved in and changed in the NEW project,\nand how many pixels of the composited canvas differ, one difference per line.\nLayers are matched by name. Exits with "+fmt.Sprint(exitDiffers)+" if the projects differ.")
+	fs.StringVar(&out, "o", "", "name of a PNG file to write the differing pixels to, highlighted over the faded new canvas")
+	fs.IntVar(&threshold, "threshold", 0, "how much a channel of a pixel may change, from 0 to 255, before it differs")
+	if code, ok := parseCommand(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 2 {
+		return usageError(fs, "expected two projects")
+	}
+	if threshold < 0 || threshold > 255 {
+		return usageError(fs, fmt.Errorf("%w, got %v", diff.ErrInvalidThreshold, threshold))
+	}
+	var docs [2]*image.Document
+	for i := range docs {
+		var err error
+		if docs[i], err = image.ReadDocument(fs.Arg(i)); err != nil {
+			log.Warnf("reading %v: %v", fs.Arg(i), err)
+			return exitError
+		}
+	}
+	r, visual, err := diff.Compare(docs[0], docs[1], threshold)
+	if err != nil {
+		log.Warn(err)
+		return exitError
+	}
+	for _, line := range r.Lines() {
+		fmt.Println(line)
+	}
+	if out != "" {
+		if err = codec.EncodeFile(out, visual, nil); err != nil {
+			log.Warnf("writing %v: %v", out, err)
+			return exitError
+		}
+	}
+	if !r.Empty() {
+		return exitDiffers
+	}
+	return exitOK
+}
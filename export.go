@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/codec"
+	"github.com/gregjohnson2017/tabula-editor/pkg/image"
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+)
+
+// optionsFlag collects repeated name=value encoder options
+type optionsFlag codec.Options
+
+func (o optionsFlag) String() string {
+	var pairs []string
+	for k, v := range o {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (o optionsFlag) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return fmt.Errorf("%w: expected name=value, got %q", codec.ErrInvalidOption, s)
+	}
+	o[s[:i]] = s[i+1:]
+	return nil
+}
+
+// exportCommand composites the layers of a project on the CPU and writes
+// them to an image file, or writes every layer to its own file, without
+// opening a window
+func exportCommand(args []string) int {
+	var project string
+	var out string
+	var layers string
+	var layerNames bool
+	var canvasSize bool
+	var scale float64
+	var filter string
+	opts := optionsFlag{}
+	fs := commandFlags("export", "-project FILE (-o FILE | -layers DIR) [OPTIONS]", "Composite the layers of a project and write them as an image, without a window.\nThe format is chosen by the extension of the output file.\nWith -layers, every layer is written as a PNG into the folder instead.")
+	fs.BoolVar(&canvasSize, "canvas-size", false, "keep layers written by -layers at canvas size instead of cropping them")
+	fs.StringVar(&filter, "filter", raster.Nearest.String(), "resampling filter used when scaling: nearest, bilinear or bicubic")
+	fs.BoolVar(&layerNames, "layer-names", false, "name layers written by -layers after the layers instead of numbering them")
+	fs.StringVar(&layers, "layers", "", "folder to write every layer to as a PNG")
+	fs.StringVar(&out, "o", "", "name of the image file to write")
+	fs.Var(opts, "opt", "encoder option as name=value, e.g. \"Quality=90\", may be repeated")
+	fs.StringVar(&project, "project", "", "name of the project file (.tabula) or layered image to export")
+	fs.Float64Var(&scale, "scale", 1, "factor to scale the canvas by")
+	if code, ok := parseCommand(fs, args); !ok {
+		return code
+	}
+	if project == "" || (out == "") == (layers == "") || fs.NArg() > 0 {
+		return usageError(fs, "expected a project and either an output file or a layer folder")
+	}
+	f, err := raster.ParseFilter(filter)
+	if err != nil {
+		return usageError(fs, err)
+	}
+
+	doc, err := image.ReadDocument(project)
+	if err != nil {
+		log.Warnf("reading %v: %v", project, err)
+		return exitError
+	}
+	if scale != 1 {
+		if err = doc.Scale(scale, f); err != nil {
+			return usageError(fs, err)
+		}
+	}
+	if layers != "" {
+		if err = os.MkdirAll(layers, 0755); err != nil {
+			log.Warn(err)
+			return exitError
+		}
+		paths, err := codec.WriteLayers(layers, doc.Layered(), codec.SplitOptions{Named: layerNames, CanvasSize: canvasSize})
+		if err != nil {
+			log.Warnf("writing layers to %v: %v", layers, err)
+			return exitError
+		}
+		log.Infof("exported %v layers to %v", len(paths), layers)
+		return exitOK
+	}
+	if err = doc.WriteFile(out, codec.Options(opts)); err != nil {
+		log.Warnf("writing %v: %v", out, err)
+		return exitError
+	}
+	return exitOK
+}
@@ -8,4 +8,5 @@ require (
 	github.com/kroppt/gfx v0.0.0-20210530031959-b265f606735b
 	github.com/kroppt/winfileask v0.0.0-20200406172824-13c3807ac64f
 	github.com/veandco/go-sdl2 v0.4.4
+	golang.org/x/image v0.0.0-20200119044424-58c23975cae1
 )
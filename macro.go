@@ -0,0 +1,50 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/app"
+	"github.com/gregjohnson2017/tabula-editor/pkg/image"
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+)
+
+// macroCommand plays a recorded macro on a project without a window
+func macroCommand(args []string) int {
+	var project string
+	var out string
+	fs := commandFlags("macro", "-project FILE -o FILE [OPTIONS] MACRO", "Play a macro recorded in the editor on a project, without a window.\nAn output ending with .tabula, "+image.FolderExt+" or .ora is written as a project, any other as an image.\nMenu entries that need the editor window, such as those asking for input, fail the command.")
+	fs.StringVar(&out, "o", "", "name of the project or image file to write")
+	fs.StringVar(&project, "project", "", "name of the project file or image to play the macro on")
+	if code, ok := parseCommand(fs, args); !ok {
+		return code
+	}
+	if project == "" || out == "" || fs.NArg() != 1 {
+		return usageError(fs, "expected a project, an output file and one macro file")
+	}
+	m, err := image.ReadMacro(fs.Arg(0))
+	if err != nil {
+		log.Warn(err)
+		return exitError
+	}
+	doc, err := image.ReadDocument(project)
+	if err != nil {
+		log.Warnf("reading %v: %v", project, err)
+		return exitError
+	}
+	if err = doc.PlayMacro(m, app.HeadlessMenus()); err != nil {
+		log.Warnf("playing %v: %v", fs.Arg(0), err)
+		return exitError
+	}
+	switch strings.ToLower(filepath.Ext(out)) {
+	case ".tabula", image.FolderExt, ".ora":
+		err = doc.Save(out)
+	default:
+		err = doc.WriteFile(out, nil)
+	}
+	if err != nil {
+		log.Warnf("writing %v: %v", out, err)
+		return exitError
+	}
+	return exitOK
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -11,9 +12,11 @@ import (
 	"github.com/go-gl/gl/v2.1/gl"
 	"github.com/gregjohnson2017/tabula-editor/pkg/app"
 	"github.com/gregjohnson2017/tabula-editor/pkg/config"
+	"github.com/gregjohnson2017/tabula-editor/pkg/image"
 	"github.com/gregjohnson2017/tabula-editor/pkg/log"
 	"github.com/gregjohnson2017/tabula-editor/pkg/perf"
 	"github.com/gregjohnson2017/tabula-editor/pkg/util"
+	"github.com/gregjohnson2017/tabula-editor/pkg/watch"
 	"github.com/veandco/go-sdl2/sdl"
 )
 
@@ -102,6 +105,12 @@ func getWindowRefreshRateRange() (low int32, high int32, _ error) {
 }
 
 func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// guiCommand opens the editor window, returning the exit code once it is
+// closed
+func guiCommand(args []string) int {
 	var fps int
 	var width int
 	var height int
@@ -113,27 +122,45 @@ func main() {
 	var quiet bool
 	var file string
 	var project string
-	flag.Usage = func() {
-		fmt.Fprintln(flag.CommandLine.Output(), "Usage:")
-		fmt.Fprintf(flag.CommandLine.Output(), "  %v [OPTIONS]\n", filepath.Base(os.Args[0]))
-		fmt.Fprintln(flag.CommandLine.Output(), "  Specify a file name with -file to open image as a layer.")
-		fmt.Fprintln(flag.CommandLine.Output(), "  Specify a project file (.tabula) with -project.")
-		fmt.Fprintln(flag.CommandLine.Output(), "  Otherwise, an open file dialog will be used, if supported.")
-		fmt.Fprintln(flag.CommandLine.Output(), "\nOptions:")
-		flag.PrintDefaults()
-	}
-	flag.BoolVar(&color, "color", true, "colorize the output logs")
-	flag.BoolVar(&debug, "debug", false, "show debug logging")
-	flag.StringVar(&file, "file", "", "name of the file to open without prompt")
-	flag.StringVar(&project, "project", "", "name of the project file (.tabula) to open")
-	flag.IntVar(&fps, "fps", 144, "the frames per second to render at")
-	flag.IntVar(&height, "height", 720, "the initial height of the window")
-	flag.BoolVar(&info, "info", true, "show info logging")
-	flag.BoolVar(&perform, "perf", false, "show performormance logging")
-	flag.BoolVar(&quiet, "quiet", false, "hide all output, overrides other logging options")
-	flag.BoolVar(&warn, "warn", true, "show warning logging")
-	flag.IntVar(&width, "width", 960, "the initial width of the window")
-	flag.Parse()
+	var scriptFile string
+	var listen string
+	var watchMode string
+	var watchInterval time.Duration
+	fs := flag.NewFlagSet("gui", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage:")
+		fmt.Fprintf(fs.Output(), "  %v [gui] [OPTIONS]\n", filepath.Base(os.Args[0]))
+		fmt.Fprintln(fs.Output(), "  Specify a file name with -file to open image as a layer.")
+		fmt.Fprintln(fs.Output(), "  Specify a project file (.tabula) or project folder ("+image.FolderExt+") with -project.")
+		fmt.Fprintln(fs.Output(), "  Otherwise, an open file dialog will be used, if supported.")
+		fmt.Fprintln(fs.Output(), "  Specify a socket path with -listen to accept JSON-RPC calls from other programs.")
+		fmt.Fprintln(fs.Output(), "  Layers linked to their source files are reloaded when the files change, as set by -watch.")
+		fmt.Fprintln(fs.Output(), "  Specify a script with -script to run it on the project, or a blank canvas, without a window.")
+		fmt.Fprintln(fs.Output(), "\nOptions:")
+		fs.PrintDefaults()
+		printCommands(fs.Output())
+	}
+	fs.BoolVar(&color, "color", true, "colorize the output logs")
+	fs.BoolVar(&debug, "debug", false, "show debug logging")
+	fs.StringVar(&file, "file", "", "name of the file to open without prompt")
+	fs.StringVar(&project, "project", "", "name of the project file (.tabula) or folder to open")
+	fs.IntVar(&fps, "fps", 144, "the frames per second to render at")
+	fs.IntVar(&height, "height", 720, "the initial height of the window")
+	fs.BoolVar(&info, "info", true, "show info logging")
+	fs.StringVar(&listen, "listen", "", "path of a Unix socket to serve JSON-RPC automation calls on")
+	fs.BoolVar(&perform, "perf", false, "show performormance logging")
+	fs.BoolVar(&quiet, "quiet", false, "hide all output, overrides other logging options")
+	fs.StringVar(&scriptFile, "script", "", "script to run without a window, then exit")
+	fs.BoolVar(&warn, "warn", true, "show warning logging")
+	fs.StringVar(&watchMode, "watch", string(watch.Auto), fmt.Sprintf("how changes to the files of linked layers are noticed, one of %v", watch.Modes))
+	fs.DurationVar(&watchInterval, "watch-interval", time.Second, "how often files are checked when the watch mode polls")
+	fs.IntVar(&width, "width", 960, "the initial width of the window")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
 
 	var loggers []string
 	// loggers are discarded by default
@@ -161,12 +188,24 @@ func main() {
 	}
 	log.SetColorized(color)
 
-	log.Debugf("args: [ %v ]", strings.Join(flag.Args(), ", "))
+	log.Debugf("args: [ %v ]", strings.Join(fs.Args(), ", "))
 	log.Debugf("file option: \"%v\"", file)
 	log.Debugf("project option: \"%v\"", project)
 	log.Debugf("enabled loggers: %v", strings.Join(loggers, ", "))
 	log.Debugf("output colorized: %v", color)
 
+	if scriptFile != "" {
+		return runScript(scriptFile, project)
+	}
+
+	mode, err := watch.ParseMode(watchMode)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if watchInterval <= 0 {
+		log.Fatal("watch-interval must be > 0")
+	}
+
 	if fps <= 0 {
 		log.Fatal("fps must be >= 0")
 	}
@@ -192,6 +231,15 @@ func main() {
 	}
 
 	app := app.New(file, project, win, cfg)
+	if listen != "" {
+		if err = app.Listen(listen); err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("listening on %v", listen)
+	}
+	if err = app.Watch(mode, watchInterval); err != nil {
+		log.Fatal(err)
+	}
 	app.Start()
 
 	for app.Running() {
@@ -211,4 +259,5 @@ func main() {
 	}
 
 	app.Quit()
+	return exitOK
 }
@@ -4,15 +4,17 @@ import (
 	"time"
 
 	"github.com/go-gl/gl/v2.1/gl"
+	"github.com/gregjohnson2017/tabula-editor/pkg/codec"
 	"github.com/gregjohnson2017/tabula-editor/pkg/comms"
 	"github.com/gregjohnson2017/tabula-editor/pkg/config"
 	"github.com/gregjohnson2017/tabula-editor/pkg/image"
 	"github.com/gregjohnson2017/tabula-editor/pkg/log"
 	"github.com/gregjohnson2017/tabula-editor/pkg/menu"
 	"github.com/gregjohnson2017/tabula-editor/pkg/perf"
+	"github.com/gregjohnson2017/tabula-editor/pkg/remote"
 	"github.com/gregjohnson2017/tabula-editor/pkg/ui"
 	"github.com/gregjohnson2017/tabula-editor/pkg/util"
-	"github.com/kroppt/gfx"
+	"github.com/gregjohnson2017/tabula-editor/pkg/watch"
 	"github.com/veandco/go-sdl2/sdl"
 )
 
@@ -25,7 +27,11 @@ type Application struct {
 	moved       bool
 	postEvtActs chan func()
 	running     bool
+	server      *remote.Server
 	ticker      *time.Ticker
+	view        *image.View
+	watched     map[string]bool
+	watcher     watch.Watcher
 	win         *sdl.Window
 }
 
@@ -56,11 +62,13 @@ func New(fileName, project string, win *sdl.Window, cfg *config.Config) *Applica
 	}
 
 	if fileName != "" {
-		tex, err := gfx.NewTextureFromFile(fileName)
+		img, err := openImage(fileName)
 		if err != nil {
 			log.Fatal(err)
 		}
-		iv.AddLayer(tex)
+		if err = addImage(iv, img); err != nil {
+			log.Fatal(err)
+		}
 	}
 	if project != "" {
 		if err = iv.LoadProject(project); err != nil {
@@ -73,25 +81,28 @@ func New(fileName, project string, win *sdl.Window, cfg *config.Config) *Applica
 		log.Fatal(err)
 	}
 
-	menuBar, err := menu.NewBar(cfg, []menu.Definition{
+	menus := recordMenus(iv, nil, []menu.Definition{
 		{
 			Text: "File",
-			Children: []menu.Definition{
+			Children: append(append([]menu.Definition{
 				{
 					Text: "Open as Layer",
 					Action: func() {
-						newFileName, err := util.OpenFileDialog(win)
+						newFileName, err := util.OpenFileDialog(win, imageFilters()...)
 						if err != nil {
 							log.Warn(err)
 							return
 						}
 						go func() {
+							img, err := openImage(newFileName)
+							if err != nil {
+								log.Warn(err)
+								return
+							}
 							actionComms <- func() {
-								tex, err := gfx.NewTextureFromFile(newFileName)
-								if err != nil {
-									log.Fatal(err)
+								if err := addImage(iv, img); err != nil {
+									log.Warn(err)
 								}
-								iv.AddLayer(tex)
 							}
 						}()
 					},
@@ -99,25 +110,31 @@ func New(fileName, project string, win *sdl.Window, cfg *config.Config) *Applica
 				{
 					Text: "Export Canvas",
 					Action: func() {
-						newFileName, err := util.SaveFileDialog(win)
-						if err != nil {
-							log.Warn(err)
-							return
-						}
 						go func() {
+							newFileName, err := util.SaveFileDialog(win, exportFilters()...)
+							if err != nil {
+								log.Warn(err)
+								return
+							}
+							opts, err := promptExportOptions(win, newFileName)
+							if err != nil {
+								log.Warn(err)
+								return
+							}
 							actionComms <- func() {
-								if err := iv.WriteToFile(newFileName); err != nil {
-									log.Fatal(err)
+								if err := iv.WriteToFile(newFileName, opts); err != nil {
+									log.Warn(err)
 								}
 							}
 						}()
 					},
 				},
+			}, exportMenus(win, iv, actionComms)...), []menu.Definition{
 				{
 					Text: "Save Project",
 					Action: func() {
 						go func() {
-							newFileName, err := util.SaveFileDialog(win)
+							newFileName, err := util.SaveFileDialog(win, projectFilter, folderFilter, oraFilter)
 							if err != nil {
 								log.Warn(err)
 								return
@@ -135,7 +152,7 @@ func New(fileName, project string, win *sdl.Window, cfg *config.Config) *Applica
 					Text: "Load Project",
 					Action: func() {
 						go func() {
-							newFileName, err := util.OpenFileDialog(win)
+							newFileName, err := util.OpenFileDialog(win, projectFilters()...)
 							if err != nil {
 								log.Warn(err)
 								return
@@ -149,6 +166,7 @@ func New(fileName, project string, win *sdl.Window, cfg *config.Config) *Applica
 						}()
 					},
 				},
+				scriptMenu(win, iv, actionComms),
 				{
 					Text:   "kitten",
 					Action: func() { log.Info("kitten") },
@@ -167,7 +185,7 @@ func New(fileName, project string, win *sdl.Window, cfg *config.Config) *Applica
 						},
 					},
 				},
-			},
+			}...),
 		},
 		{
 			Text: "Tools",
@@ -193,11 +211,24 @@ func New(fileName, project string, win *sdl.Window, cfg *config.Config) *Applica
 						go func() { toolComms <- &image.PixelColorTool{} }()
 					},
 				},
+				brushColorMenu(win, iv, actionComms),
 			},
 		},
 		{
 			Text: "Image",
-			Children: []menu.Definition{
+			Children: append([]menu.Definition{
+				precisionMenu(iv, actionComms),
+				paletteMenu(win, iv, actionComms),
+				{
+					Text: "Strip Metadata",
+					Action: func() {
+						go func() {
+							actionComms <- func() {
+								iv.SetMetadata(codec.Metadata{})
+							}
+						}()
+					},
+				},
 				{
 					Text: "Center Canvas",
 					Action: func() {
@@ -208,9 +239,23 @@ func New(fileName, project string, win *sdl.Window, cfg *config.Config) *Applica
 						}()
 					},
 				},
-			},
+			}, canvasMenus(win, iv, actionComms, toolComms)...),
 		},
+		{
+			Text: "Layer",
+			Children: append([]menu.Definition{
+				transformMenu(win, iv, actionComms),
+				linkMenu(win, iv, actionComms),
+			}, append(layerMenus(win, iv, actionComms), sliceMenus(win, iv, actionComms)...)...),
+		},
+		filtersMenu(win, iv, actionComms),
 	})
+	menuBar, err := menu.NewBar(cfg, append(menus, macroMenu(win, iv, actionComms, menus)))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	palettePanel, err := NewPalettePanel(cfg, win, iv, actionComms)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -221,10 +266,11 @@ func New(fileName, project string, win *sdl.Window, cfg *config.Config) *Applica
 
 	return &Application{
 		running:     false,
-		comps:       []ui.Component{iv, bottomBar, menuBar},
+		comps:       []ui.Component{iv, bottomBar, palettePanel, menuBar},
 		cfg:         cfg,
 		postEvtActs: actionComms,
 		ticker:      ticker,
+		view:        iv,
 		win:         win,
 	}
 }
@@ -267,6 +313,7 @@ func (app *Application) PostEventActions() {
 			hasEvents = false
 		}
 	}
+	app.syncWatches()
 	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
 
 	for _, comp := range app.comps {
@@ -379,6 +426,16 @@ func (app *Application) Running() bool {
 
 // Quit cleans up resources
 func (app *Application) Quit() {
+	if app.server != nil {
+		if err := app.server.Close(); err != nil {
+			log.Warn(err)
+		}
+	}
+	if app.watcher != nil {
+		if err := app.watcher.Close(); err != nil {
+			log.Warn(err)
+		}
+	}
 	// free ui.Component assets
 	for _, comp := range app.comps {
 		log.Debugln("destroying", comp.String())
@@ -0,0 +1,163 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/image"
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/menu"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+	"github.com/gregjohnson2017/tabula-editor/pkg/util"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// canvasMenus returns the image menu entries that change the canvas
+func canvasMenus(win *sdl.Window, iv *image.View, actionComms chan<- func(), toolComms chan<- image.Tool) []menu.Definition {
+	crop := &image.CropTool{}
+	// run posts f to the main thread, logging any error it returns
+	run := func(f func() error) {
+		actionComms <- func() {
+			if err := f(); err != nil {
+				log.Warn(err)
+			}
+		}
+	}
+	return []menu.Definition{
+		{
+			Text: "Image Size",
+			Action: func() {
+				canvas := iv.Canvas()
+				go func() {
+					w, h, err := promptSize(win, "New image size", canvas.W, canvas.H)
+					if err != nil {
+						log.Warn(err)
+						return
+					}
+					f, err := promptFilter(win)
+					if err != nil {
+						log.Warn(err)
+						return
+					}
+					run(func() error { return iv.ResizeImage(w, h, f) })
+				}()
+			},
+		},
+		{
+			Text: "Canvas Size",
+			Action: func() {
+				canvas := iv.Canvas()
+				go func() {
+					w, h, err := promptSize(win, "New canvas size", canvas.W, canvas.H)
+					if err != nil {
+						log.Warn(err)
+						return
+					}
+					names := make([]string, 0, len(image.Anchors))
+					for _, a := range image.Anchors {
+						names = append(names, a.String())
+					}
+					name, err := util.ListDialog(win, "Anchor", names...)
+					if err != nil {
+						log.Warn(err)
+						return
+					}
+					anchor, err := image.ParseAnchor(name)
+					if err != nil {
+						log.Warn(err)
+						return
+					}
+					text, err := util.EntryDialog(win, "Fill color (RRGGBBAA)", "00000000")
+					if err != nil {
+						log.Warn(err)
+						return
+					}
+					fill, err := raster.ParseHex(text)
+					if err != nil {
+						log.Warn(err)
+						return
+					}
+					run(func() error { return iv.ResizeCanvas(w, h, anchor, fill) })
+				}()
+			},
+		},
+		{
+			Text: "Orientation",
+			Children: []menu.Definition{
+				orientMenu("Rotate 90 CW", raster.OrientRotate90, iv, run),
+				orientMenu("Rotate 90 CCW", raster.OrientRotate270, iv, run),
+				orientMenu("Rotate 180", raster.OrientRotate180, iv, run),
+				orientMenu("Flip Horizontal", raster.OrientFlipH, iv, run),
+				orientMenu("Flip Vertical", raster.OrientFlipV, iv, run),
+			},
+		},
+		{
+			Text: "Crop to Selection",
+			Action: func() {
+				go run(iv.CropToSelection)
+			},
+		},
+		{
+			Text: "Trim",
+			Action: func() {
+				go run(iv.Trim)
+			},
+		},
+		{
+			Text: "Crop",
+			Action: func() {
+				// set the image view tool to the crop tool
+				go func() { toolComms <- crop }()
+			},
+			Children: []menu.Definition{
+				{
+					Text: "Apply Crop",
+					Action: func() {
+						go run(func() error { return crop.Apply(iv) })
+					},
+				},
+			},
+		},
+		{
+			Text: "Deselect",
+			Action: func() {
+				go func() { actionComms <- iv.ClearSelection }()
+			},
+		},
+	}
+}
+
+// orientMenu returns a menu entry that reorients the whole image
+func orientMenu(text string, o raster.Orientation, iv *image.View, run func(func() error)) menu.Definition {
+	return menu.Definition{
+		Text: text,
+		Action: func() {
+			go run(func() error { return iv.Reorient(o) })
+		},
+	}
+}
+
+// promptSize asks the user for a width and height, defaulting to w and h
+func promptSize(win *sdl.Window, prompt string, w, h int32) (int32, int32, error) {
+	text, err := util.EntryDialog(win, prompt+" (width height)", fmt.Sprintf("%v %v", w, h))
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, err = fmt.Sscan(text, &w, &h); err != nil {
+		return 0, 0, fmt.Errorf("parsing size %q: %w", text, err)
+	}
+	return w, h, nil
+}
+
+// promptFilter asks the user to choose a resampling filter
+func promptFilter(win *sdl.Window) (raster.Filter, error) {
+	names := make([]string, 0, len(raster.Filters))
+	for _, f := range raster.Filters {
+		names = append(names, strings.Title(f.String()))
+	}
+	name, err := util.ListDialog(win, "Resampling", names...)
+	if err != nil {
+		return raster.Nearest, err
+	}
+	return raster.ParseFilter(name)
+}
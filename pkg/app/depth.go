@@ -0,0 +1,43 @@
+package app
+
+import (
+	"github.com/gregjohnson2017/tabula-editor/pkg/image"
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/menu"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+)
+
+// precisionMenu returns the menu choosing the depth of the document and
+// whether it blends in linear light
+func precisionMenu(iv *image.View, actionComms chan<- func()) menu.Definition {
+	def := menu.Definition{Text: "Precision"}
+	for _, d := range raster.Depths {
+		d := d
+		def.Children = append(def.Children, menu.Definition{
+			Text: d.String(),
+			Action: func() {
+				go func() {
+					actionComms <- func() {
+						if err := iv.SetDepth(d); err != nil {
+							log.Warn(err)
+							return
+						}
+						log.Infof("document depth set to %v", d)
+					}
+				}()
+			},
+		})
+	}
+	def.Children = append(def.Children, menu.Definition{
+		Text: "Toggle Linear Light Blending",
+		Action: func() {
+			go func() {
+				actionComms <- func() {
+					iv.SetLinear(!iv.Linear())
+					log.Infof("linear light blending: %v", iv.Linear())
+				}
+			}()
+		},
+	})
+	return def
+}
@@ -0,0 +1,120 @@
+package app
+
+import (
+	"errors"
+	stdimage "image"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/codec"
+	"github.com/gregjohnson2017/tabula-editor/pkg/image"
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/menu"
+	"github.com/gregjohnson2017/tabula-editor/pkg/util"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// Layer export choices
+const (
+	sizeCropped   = "cropped to layer"
+	sizeCanvas    = "canvas size"
+	namesNumbered = "numbered"
+	namesLayer    = "layer names"
+)
+
+// promptChoice asks the user to pick one of the choices, the first being the
+// default where dialogs are unsupported
+func promptChoice(win *sdl.Window, prompt string, choices ...string) (string, error) {
+	v, err := util.ListDialog(win, prompt, choices...)
+	if errors.Is(err, util.ErrDialogUnsupported) {
+		return choices[0], nil
+	}
+	return v, err
+}
+
+// exportMenus returns the File menu entries writing parts of the document
+// to separate files
+func exportMenus(win *sdl.Window, iv *image.View, actionComms chan<- func()) []menu.Definition {
+	// exportImage asks for a file and its options and writes the image made
+	// by img on the main thread
+	exportImage := func(size bool, img func(canvasSize bool) (*stdimage.NRGBA, error)) {
+		go func() {
+			canvasSize := false
+			if size {
+				v, err := promptChoice(win, "Layer size", sizeCropped, sizeCanvas)
+				if err != nil {
+					log.Warn(err)
+					return
+				}
+				canvasSize = v == sizeCanvas
+			}
+			fileName, err := util.SaveFileDialog(win, exportFilters()...)
+			if err != nil {
+				log.Warn(err)
+				return
+			}
+			opts, err := promptExportOptions(win, fileName)
+			if err != nil {
+				log.Warn(err)
+				return
+			}
+			actionComms <- func() {
+				m, err := img(canvasSize)
+				if err == nil {
+					err = codec.EncodeFileMeta(fileName, m, opts, iv.Metadata())
+				}
+				if err != nil {
+					log.Warn(err)
+				}
+			}
+		}()
+	}
+
+	return []menu.Definition{
+		{
+			Text: "Export Layer",
+			Action: func() {
+				exportImage(true, iv.LayerImage)
+			},
+		},
+		{
+			Text: "Export Selection",
+			Action: func() {
+				exportImage(false, func(bool) (*stdimage.NRGBA, error) {
+					return iv.SelectionImage()
+				})
+			},
+		},
+		{
+			Text: "Export All Layers",
+			Action: func() {
+				go func() {
+					var opts codec.SplitOptions
+					v, err := promptChoice(win, "File names", namesNumbered, namesLayer)
+					if err != nil {
+						log.Warn(err)
+						return
+					}
+					opts.Named = v == namesLayer
+					if v, err = promptChoice(win, "Layer size", sizeCropped, sizeCanvas); err != nil {
+						log.Warn(err)
+						return
+					}
+					opts.CanvasSize = v == sizeCanvas
+					dir, err := util.FolderDialog(win)
+					if err != nil {
+						log.Warn(err)
+						return
+					}
+					actionComms <- func() {
+						paths, err := iv.ExportLayers(dir, opts)
+						if err != nil {
+							log.Warn(err)
+							return
+						}
+						log.Infof("exported %v layers to %v", len(paths), dir)
+					}
+				}()
+			},
+		},
+		spriteSheetMenu(win, iv, actionComms),
+	}
+}
@@ -0,0 +1,182 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/codec"
+	"github.com/gregjohnson2017/tabula-editor/pkg/config"
+	"github.com/gregjohnson2017/tabula-editor/pkg/image"
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+	"github.com/gregjohnson2017/tabula-editor/pkg/util"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// exportSettingsFile is the settings file remembering the last used options
+// of each export format
+const exportSettingsFile = "export.json"
+
+// projectFilter matches tabula project files in file dialogs
+var projectFilter = util.FileFilter{Name: "Tabula project", Patterns: []string{"*.tabula"}}
+
+// folderFilter matches tabula project folders, which are opened by their
+// manifest
+var folderFilter = util.FileFilter{Name: "Tabula project folder", Patterns: []string{"*" + image.FolderExt, "manifest.json"}}
+
+// oraFilter matches OpenRaster files, which are saved and loaded as projects
+var oraFilter = util.FileFilter{Name: "OpenRaster", Patterns: []string{"*.ora"}}
+
+// formatFilter returns a file dialog filter matching the extensions
+func formatFilter(name string, exts []string) util.FileFilter {
+	f := util.FileFilter{Name: name}
+	for _, ext := range exts {
+		// match both cases since dialogs may compare case sensitively
+		f.Patterns = append(f.Patterns, "*"+ext, "*"+strings.ToUpper(ext))
+	}
+	return f
+}
+
+// imageFilters returns a file dialog filter for every registered image
+// format, preceded by one matching all of them
+func imageFilters() []util.FileFilter {
+	all := util.FileFilter{Name: "All images"}
+	filters := []util.FileFilter{}
+	for _, d := range codec.Decoders() {
+		f := formatFilter(d.Name, d.Extensions)
+		all.Patterns = append(all.Patterns, f.Patterns...)
+		filters = append(filters, f)
+	}
+	return append([]util.FileFilter{all}, filters...)
+}
+
+// projectFilters returns a file dialog filter for tabula projects and one
+// for every layered image format, which load as projects
+func projectFilters() []util.FileFilter {
+	filters := []util.FileFilter{projectFilter, folderFilter}
+	for _, d := range codec.Decoders() {
+		if d.DecodeLayers != nil {
+			filters = append(filters, formatFilter(d.Name, d.Extensions))
+		}
+	}
+	return filters
+}
+
+// openedImage is an image file read by openImage
+type openedImage struct {
+	frames []codec.Frame
+	// deep, if set, is the only frame with more than 8 bits per channel
+	deep     *raster.Float
+	metadata codec.Metadata
+	// path is the file the image was read from
+	path string
+}
+
+// openImage reads the frames of the image file at path, along with its
+// metadata if it is not animated
+func openImage(path string) (openedImage, error) {
+	if d, err := codec.DecoderFor(path); err == nil && d.DecodeFrames != nil {
+		frames, err := codec.DecodeFrames(path)
+		return openedImage{frames: frames, path: path}, err
+	}
+	f, depth, m, err := codec.DecodeFileDeep(path)
+	if err != nil {
+		return openedImage{}, err
+	}
+	if depth == raster.Depth8 {
+		return openedImage{frames: []codec.Frame{{Image: f.NRGBA()}}, metadata: m, path: path}, nil
+	}
+	return openedImage{deep: f, metadata: m, path: path}, nil
+}
+
+// addImage adds the frames as layers, keeping the metadata with the document
+// unless it already has some. An empty 8-bit document takes the depth of a
+// deeper image. A single layer remembers its file so it can be linked to it.
+func addImage(iv *image.View, img openedImage) error {
+	first := len(iv.Layers())
+	if img.deep != nil {
+		if iv.Empty() && iv.Depth() == raster.Depth8 {
+			if err := iv.SetDepth(raster.Depth16); err != nil {
+				return err
+			}
+			log.Infof("document depth set to %v", iv.Depth())
+		}
+		if err := iv.AddDeepLayer(img.deep); err != nil {
+			return err
+		}
+	} else if err := iv.AddFrames(img.frames); err != nil {
+		return err
+	}
+	if iv.Metadata().Empty() {
+		iv.SetMetadata(img.metadata)
+	}
+	if img.path != "" && len(iv.Layers()) == first+1 {
+		return iv.SetLayerSource(first, img.path)
+	}
+	return nil
+}
+
+// exportFilters returns a file dialog filter for every registered export
+// format
+func exportFilters() []util.FileFilter {
+	filters := []util.FileFilter{}
+	for _, e := range codec.Encoders() {
+		filters = append(filters, formatFilter(e.Name, e.Extensions))
+	}
+	return filters
+}
+
+// promptExportOptions asks the user for the options of the format of path,
+// starting from the last used ones, and remembers the answers. Where dialogs
+// are unsupported the last used options are returned unchanged.
+func promptExportOptions(win *sdl.Window, path string) (codec.Options, error) {
+	enc, err := codec.EncoderFor(path)
+	if err != nil {
+		return nil, err
+	}
+	saved := make(map[string]codec.Options)
+	if err = config.LoadJSON(exportSettingsFile, &saved); err != nil {
+		log.Warnf("loading export settings: %v", err)
+	}
+	opts, err := enc.Resolve(saved[enc.Name])
+	if err != nil {
+		// forget settings that are no longer valid
+		if opts, err = enc.Resolve(nil); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, o := range enc.Options {
+		last := opts[o.Name]
+		var v string
+		if len(o.Choices) > 0 {
+			// list the last used choice first
+			choices := []string{last}
+			for _, c := range o.Choices {
+				if c != last {
+					choices = append(choices, c)
+				}
+			}
+			v, err = util.ListDialog(win, fmt.Sprintf("%v %v", enc.Name, strings.ToLower(o.Name)), choices...)
+		} else {
+			v, err = util.EntryDialog(win, fmt.Sprintf("%v %v (%v-%v)", enc.Name, strings.ToLower(o.Name), o.Min, o.Max), last)
+		}
+		if errors.Is(err, util.ErrDialogUnsupported) {
+			return opts, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		opts[o.Name] = strings.TrimSpace(v)
+	}
+	if opts, err = enc.Resolve(opts); err != nil {
+		return nil, err
+	}
+
+	saved[enc.Name] = opts
+	if err = config.SaveJSON(exportSettingsFile, saved); err != nil {
+		log.Warnf("saving export settings: %v", err)
+	}
+	return opts, nil
+}
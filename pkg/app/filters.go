@@ -0,0 +1,189 @@
+package app
+
+import (
+	"fmt"
+	stdimage "image"
+	"sort"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/config"
+	"github.com/gregjohnson2017/tabula-editor/pkg/image"
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/menu"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+	"github.com/gregjohnson2017/tabula-editor/pkg/util"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// kernelPresetsFile is the settings file holding saved convolution kernels
+const kernelPresetsFile = "filters.json"
+
+// filtersMenu returns the menu of filters applied to the selected layer
+func filtersMenu(win *sdl.Window, iv *image.View, actionComms chan<- func()) menu.Definition {
+	return menu.Definition{
+		Text: "Filters",
+		Children: append(stylizeMenus(win, iv, actionComms), []menu.Definition{
+			{
+				Text: "Custom Filter",
+				Action: func() {
+					go customFilter(win, iv, actionComms)
+				},
+			},
+			{
+				Text: "Apply Preset",
+				Action: func() {
+					go func() {
+						presets, err := loadKernelPresets()
+						if err != nil {
+							log.Warn(err)
+							return
+						}
+						if len(presets) == 0 {
+							log.Warn("no filter presets saved")
+							return
+						}
+						name, err := util.ListDialog(win, "Filter preset", presetNames(presets)...)
+						if err != nil {
+							log.Warn(err)
+							return
+						}
+						k, ok := presets[name]
+						if !ok {
+							log.Warnf("no filter preset named %q", name)
+							return
+						}
+						actionComms <- func() {
+							if err := iv.ApplyDeepFilter(convolution(k)); err != nil {
+								log.Warn(err)
+							}
+						}
+					}()
+				},
+			},
+		}...),
+	}
+}
+
+// convolution returns layer filters applying the kernel at 8 bits and at the
+// precision of deeper documents
+func convolution(k raster.Kernel) (image.LayerFilter, image.DeepFilter) {
+	return func(img *stdimage.NRGBA) (*stdimage.NRGBA, error) {
+			return raster.Convolve(img, k)
+		}, func(f *raster.Float) (*raster.Float, error) {
+			return raster.ConvolveFloat(f, k)
+		}
+}
+
+// customFilter asks the user for a kernel, previews it on the selected layer
+// and optionally saves it as a preset. It must not run on the main thread.
+func customFilter(win *sdl.Window, iv *image.View, actionComms chan<- func()) {
+	presets, err := loadKernelPresets()
+	if err != nil {
+		log.Warn(err)
+	}
+	k := raster.Kernel{Size: 3, Weights: []float64{0, 0, 0, 0, 1, 0, 0, 0, 0}, Divisor: 1}
+	if len(presets) > 0 {
+		name, err := util.ListDialog(win, "Start from", append([]string{"New"}, presetNames(presets)...)...)
+		if err != nil {
+			log.Warn(err)
+			return
+		}
+		if p, ok := presets[name]; ok {
+			k = p
+		}
+	}
+
+	text, err := util.EntryDialog(win, fmt.Sprintf("Kernel rows, up to %[1]vx%[1]v (e.g. 0 -1 0; -1 5 -1; 0 -1 0)", raster.MaxKernelSize), k.FormatWeights())
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+	kernel, err := raster.ParseKernel(text)
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+	if kernel.FormatWeights() == k.FormatWeights() {
+		kernel.Divisor, kernel.Offset = k.Divisor, k.Offset
+	}
+	text, err = util.EntryDialog(win, "Divisor and offset", fmt.Sprintf("%g %g", kernel.Divisor, kernel.Offset))
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+	if _, err = fmt.Sscan(text, &kernel.Divisor, &kernel.Offset); err != nil {
+		log.Warnf("parsing divisor and offset %q: %v", text, err)
+		return
+	}
+	modes := make([]string, 0, len(raster.EdgeModes))
+	for _, e := range raster.EdgeModes {
+		modes = append(modes, e.String())
+	}
+	text, err = util.ListDialog(win, "Edge mode", modes...)
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+	if kernel.Edge, err = raster.ParseEdgeMode(text); err != nil {
+		log.Warn(err)
+		return
+	}
+	if kernel.Alpha, err = util.QuestionDialog(win, "Filter the alpha channel too?"); err != nil {
+		log.Warn(err)
+		return
+	}
+	if err = kernel.Validate(); err != nil {
+		log.Warn(err)
+		return
+	}
+
+	done := make(chan error, 1)
+	actionComms <- func() { done <- iv.PreviewDeepFilter(convolution(kernel)) }
+	if err = <-done; err != nil {
+		log.Warn(err)
+		return
+	}
+	keep, err := util.QuestionDialog(win, "Keep the filtered result?")
+	if err != nil || !keep {
+		if err != nil {
+			log.Warn(err)
+		}
+		actionComms <- func() {
+			if err := iv.RevertPreview(); err != nil {
+				log.Warn(err)
+			}
+		}
+		return
+	}
+	actionComms <- iv.CommitPreview
+
+	name, err := util.EntryDialog(win, "Save as preset (leave empty to skip)", "")
+	if err != nil || name == "" {
+		return
+	}
+	if presets == nil {
+		presets = make(map[string]raster.Kernel)
+	}
+	presets[name] = kernel
+	if err = config.SaveJSON(kernelPresetsFile, presets); err != nil {
+		log.Warn(err)
+	}
+}
+
+// loadKernelPresets reads the saved convolution kernels by name
+func loadKernelPresets() (map[string]raster.Kernel, error) {
+	presets := make(map[string]raster.Kernel)
+	if err := config.LoadJSON(kernelPresetsFile, &presets); err != nil {
+		return nil, fmt.Errorf("loading filter presets: %w", err)
+	}
+	return presets, nil
+}
+
+// presetNames returns the sorted names of the presets
+func presetNames(presets map[string]raster.Kernel) []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
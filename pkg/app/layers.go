@@ -0,0 +1,127 @@
+package app
+
+import (
+	"math"
+	"strings"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/image"
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/menu"
+	"github.com/gregjohnson2017/tabula-editor/pkg/util"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// layerMenus returns the menu entries controlling layer names, visibility,
+// links to source files, opacity and animation frame timing
+func layerMenus(win *sdl.Window, iv *image.View, actionComms chan<- func()) []menu.Definition {
+	return []menu.Definition{
+		{
+			Text: "Rename",
+			Action: func() {
+				name, err := iv.LayerName()
+				if err != nil {
+					log.Warn(err)
+					return
+				}
+				go func() {
+					name, err := util.EntryDialog(win, "Layer name", name)
+					if err != nil {
+						log.Warn(err)
+						return
+					}
+					actionComms <- func() {
+						if err := iv.SetLayerName(strings.TrimSpace(name)); err != nil {
+							log.Warn(err)
+						}
+					}
+				}()
+			},
+		},
+		{
+			Text: "Toggle Visibility",
+			Action: func() {
+				go func() {
+					actionComms <- func() {
+						if err := iv.ToggleVisibility(); err != nil {
+							log.Warn(err)
+						}
+					}
+				}()
+			},
+		},
+		{
+			Text: "Toggle Linked",
+			Action: func() {
+				go func() {
+					actionComms <- func() {
+						if err := iv.ToggleLinked(); err != nil {
+							log.Warn(err)
+							return
+						}
+						if source, linked, err := iv.LayerLinked(); err == nil && linked {
+							log.Infof("layer linked to %v", source)
+						} else if err == nil {
+							log.Infof("layer unlinked from %v", source)
+						}
+					}
+				}()
+			},
+		},
+		{
+			Text: "Show All Layers",
+			Action: func() {
+				go func() {
+					actionComms <- iv.ShowAllLayers
+				}()
+			},
+		},
+		{
+			Text: "Opacity",
+			Action: func() {
+				opacity, err := iv.LayerOpacity()
+				if err != nil {
+					log.Warn(err)
+					return
+				}
+				go func() {
+					percent, err := promptInt(win, "Layer opacity (0-100 %)", int(math.Round(opacity*100)))
+					if err != nil {
+						log.Warn(err)
+						return
+					}
+					actionComms <- func() {
+						if err := iv.SetLayerOpacity(float64(percent) / 100); err != nil {
+							log.Warn(err)
+						}
+					}
+				}()
+			},
+		},
+		{
+			Text: "Frame Delay",
+			Action: func() {
+				delay, err := iv.FrameDelay()
+				if err != nil {
+					log.Warn(err)
+					return
+				}
+				go func() {
+					delay, err := promptInt(win, "Frame delay (1/100 s, 0 for default)", delay)
+					if err != nil {
+						log.Warn(err)
+						return
+					}
+					if delay < 0 {
+						log.Warnf("frame delay must not be negative, got %v", delay)
+						return
+					}
+					actionComms <- func() {
+						if err := iv.SetFrameDelay(delay); err != nil {
+							log.Warn(err)
+						}
+					}
+				}()
+			},
+		},
+	}
+}
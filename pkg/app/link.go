@@ -0,0 +1,129 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/image"
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/menu"
+	"github.com/gregjohnson2017/tabula-editor/pkg/util"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// linkMenu returns the menu of layers linked to images or other projects
+func linkMenu(win *sdl.Window, iv *image.View, actionComms chan<- func()) menu.Definition {
+	return menu.Definition{
+		Text: "Link",
+		Children: []menu.Definition{
+			{
+				Text: "Add Linked File",
+				Action: func() {
+					go func() {
+						path, err := util.OpenFileDialog(win, append([]util.FileFilter{projectFilter, folderFilter}, imageFilters()...)...)
+						if err != nil {
+							log.Warn(err)
+							return
+						}
+						actionComms <- func() {
+							if err := iv.AddLinkedLayer(path); err != nil {
+								log.Warn(err)
+							}
+						}
+					}()
+				},
+			},
+			{
+				Text: "Scale",
+				Action: func() {
+					sx, sy, err := iv.LinkedScale()
+					if err != nil {
+						log.Warn(err)
+						return
+					}
+					go func() {
+						def := fmt.Sprintf("%g %g", sx*100, sy*100)
+						if sx == sy {
+							def = fmt.Sprintf("%g", sx*100)
+						}
+						text, err := util.EntryDialog(win, "Scale of the linked file (%, or horizontal and vertical %)", def)
+						if err != nil {
+							log.Warn(err)
+							return
+						}
+						sx, sy, err := parseScale(text)
+						if err != nil {
+							log.Warn(err)
+							return
+						}
+						actionComms <- func() {
+							if err := iv.ScaleLinked(sx, sy); err != nil {
+								log.Warn(err)
+							}
+						}
+					}()
+				},
+			},
+			{
+				Text: "Edit Original",
+				Action: func() {
+					path, err := iv.OriginalPath()
+					if err != nil {
+						log.Warn(err)
+						return
+					}
+					if err = editOriginal(path); err != nil {
+						log.Warn(err)
+					}
+				},
+			},
+			{
+				Text: "Embed",
+				Action: func() {
+					if err := iv.EmbedLink(); err != nil {
+						log.Warn(err)
+						return
+					}
+					log.Info("linked file embedded in the project")
+				},
+			},
+		},
+	}
+}
+
+// parseScale parses one percentage scaling both ways, or a horizontal and
+// a vertical one
+func parseScale(text string) (float64, float64, error) {
+	fields := strings.Fields(strings.ReplaceAll(text, "%", " "))
+	var scales []float64
+	for _, f := range fields {
+		var v float64
+		if _, err := fmt.Sscan(f, &v); err != nil {
+			return 0, 0, fmt.Errorf("parsing %q: %w", text, err)
+		}
+		scales = append(scales, v/100)
+	}
+	switch len(scales) {
+	case 1:
+		return scales[0], scales[0], nil
+	case 2:
+		return scales[0], scales[1], nil
+	}
+	return 0, 0, fmt.Errorf("%w: expected one or two percentages, got %q", image.ErrInvalidScale, text)
+}
+
+// editOriginal opens the file of a linked layer for editing, projects in
+// another editor window and images in the program the desktop prefers. The
+// layer shows the saved changes when its file is watched.
+func editOriginal(path string) error {
+	if !image.IsProject(path) {
+		return util.OpenExternal(path)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	return exec.Command(exe, "gui", "-project", path).Start()
+}
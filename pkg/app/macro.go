@@ -0,0 +1,204 @@
+package app
+
+import (
+	stdimage "image"
+	"strings"
+	"time"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/codec"
+	"github.com/gregjohnson2017/tabula-editor/pkg/image"
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/menu"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+	"github.com/gregjohnson2017/tabula-editor/pkg/util"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// macroFilter matches macro files in file dialogs
+var macroFilter = util.FileFilter{Name: "Tabula macro", Patterns: []string{"*.tmacro"}}
+
+// macroSettle is how long playback waits after a menu entry, whose action
+// may post more work to the main thread or change the tool on a later frame
+const macroSettle = 100 * time.Millisecond
+
+// recordMenus returns the definitions with every action also recording the
+// path of its entry while a macro is recorded
+func recordMenus(iv *image.View, parent []string, defs []menu.Definition) []menu.Definition {
+	wrapped := make([]menu.Definition, len(defs))
+	for i, def := range defs {
+		path := append(append([]string(nil), parent...), def.Text)
+		if action := def.Action; action != nil {
+			def.Action = func() {
+				iv.RecordMenu(path)
+				action()
+			}
+		}
+		def.Children = recordMenus(iv, path, def.Children)
+		wrapped[i] = def
+	}
+	return wrapped
+}
+
+// findMenu returns the action of the entry at path, or nil if there is none
+func findMenu(defs []menu.Definition, path []string) func() {
+	for _, def := range defs {
+		if len(path) == 0 || def.Text != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			return def.Action
+		}
+		return findMenu(def.Children, path[1:])
+	}
+	return nil
+}
+
+// macroMenu returns the menu recording macros and playing them with the
+// entries of defs
+func macroMenu(win *sdl.Window, iv *image.View, actionComms chan<- func(), defs []menu.Definition) menu.Definition {
+	return menu.Definition{
+		Text: "Macro",
+		Children: []menu.Definition{
+			{
+				Text: "Start Recording",
+				Action: func() {
+					if iv.Recording() {
+						log.Warn("already recording a macro")
+						return
+					}
+					iv.StartRecording()
+					log.Info("recording a macro")
+				},
+			},
+			{
+				Text: "Stop Recording",
+				Action: func() {
+					m := iv.StopRecording()
+					if m == nil {
+						log.Warn("no macro is being recorded")
+						return
+					}
+					go func() {
+						path, err := util.SaveFileDialog(win, macroFilter)
+						if err != nil {
+							log.Warn(err)
+							return
+						}
+						if err = m.Save(path); err != nil {
+							log.Warn(err)
+						}
+					}()
+				},
+			},
+			{
+				Text: "Play Macro",
+				Action: func() {
+					go func() {
+						path, err := util.OpenFileDialog(win, macroFilter)
+						if err != nil {
+							log.Warn(err)
+							return
+						}
+						m, err := image.ReadMacro(path)
+						if err != nil {
+							log.Warn(err)
+							return
+						}
+						playMacro(m, iv, actionComms, defs)
+					}()
+				},
+			},
+		},
+	}
+}
+
+// playMacro plays the events of m one at a time on the main thread. It must
+// not run on the main thread.
+func playMacro(m *image.Macro, iv *image.View, actionComms chan<- func(), defs []menu.Definition) {
+	for _, e := range m.Events {
+		e := e
+		done := make(chan struct{})
+		actionComms <- func() {
+			defer close(done)
+			switch e.Kind {
+			case image.MacroMenu:
+				if action := findMenu(defs, e.Menu); action != nil {
+					action()
+				} else {
+					log.Warnf("macro menu entry %q not found", strings.Join(e.Menu, "/"))
+				}
+			case image.MacroTool:
+				// tools without a constructor are set by their menu entry
+				if t, ok := image.NewTool(e.Tool); ok {
+					iv.SetTool(t)
+				}
+			case image.MacroBrush:
+				c, err := raster.ParseHex(e.Color)
+				if err != nil {
+					log.Warn(err)
+					return
+				}
+				iv.SetBrushColor(c)
+			case image.MacroClick, image.MacroMotion:
+				iv.PlayInput(e)
+			}
+		}
+		<-done
+		if e.Kind == image.MacroMenu {
+			time.Sleep(macroSettle)
+		}
+	}
+	log.Info("macro played")
+}
+
+// HeadlessMenus returns the menu entries macros can play without a window,
+// by the texts of their path joined with "/".
+func HeadlessMenus() map[string]image.MacroMenuAction {
+	// filter applies f to the selected layer
+	filter := func(f func(*stdimage.NRGBA) *stdimage.NRGBA, deep func(*raster.Float) *raster.Float) image.MacroMenuAction {
+		return func(d *image.Document, l *image.DocumentLayer) error {
+			if l == nil {
+				return image.ErrNoLayerSelected
+			}
+			return d.FilterLayer(l, func(img *stdimage.NRGBA) (*stdimage.NRGBA, error) { return f(img), nil },
+				func(img *raster.Float) (*raster.Float, error) { return deep(img), nil })
+		}
+	}
+	// none is an entry whose effect is recorded by other events
+	none := func(d *image.Document, l *image.DocumentLayer) error { return nil }
+	menus := map[string]image.MacroMenuAction{
+		"Tools/None":                none,
+		"Tools/Pixel selector":      none,
+		"Tools/Pixel color changer": none,
+		"Image/Center Canvas":       none,
+		"Image/Strip Metadata": func(d *image.Document, l *image.DocumentLayer) error {
+			d.Metadata = codec.Metadata{}
+			return nil
+		},
+		"Layer/Toggle Visibility": func(d *image.Document, l *image.DocumentLayer) error {
+			if l == nil {
+				return image.ErrNoLayerSelected
+			}
+			l.Hidden = !l.Hidden
+			return nil
+		},
+		"Layer/Show All Layers": func(d *image.Document, l *image.DocumentLayer) error {
+			for _, l := range d.Layers {
+				l.Hidden = false
+			}
+			return nil
+		},
+		"Filters/Emboss":     filter(raster.Emboss, raster.EmbossFloat),
+		"Filters/Invert":     filter(raster.Invert, raster.InvertFloat),
+		"Filters/Desaturate": filter(raster.Desaturate, raster.DesaturateFloat),
+	}
+	for _, o := range raster.EdgeOperators {
+		o := o
+		menus["Filters/Edge Detect/"+strings.Title(o.String())] = filter(func(img *stdimage.NRGBA) *stdimage.NRGBA {
+			return raster.DetectEdges(img, o)
+		}, func(img *raster.Float) *raster.Float {
+			return raster.DetectEdgesFloat(img, o)
+		})
+	}
+	return menus
+}
@@ -0,0 +1,202 @@
+package app
+
+import (
+	stdimage "image"
+	"image/color"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/codec"
+	"github.com/gregjohnson2017/tabula-editor/pkg/image"
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/menu"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+	"github.com/gregjohnson2017/tabula-editor/pkg/util"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// kMeansIterations bounds the refinement of k-means palettes
+const kMeansIterations = 16
+
+// paletteMethodNames lists the keys of paletteMethods in the order offered
+var paletteMethodNames = []string{"median cut", "k-means", "octree"}
+
+// paletteMethods are the ways of computing a palette offered to the user, by
+// name
+var paletteMethods = map[string]image.PaletteMethod{
+	"median cut": raster.MedianCut,
+	"k-means": func(img *stdimage.NRGBA, n int) color.Palette {
+		return raster.KMeans(img, n, kMeansIterations)
+	},
+	"octree": raster.Octree,
+}
+
+// paletteFilter matches the palette file formats in file dialogs
+var paletteFilter = formatFilter("Palette", codec.PaletteExtensions)
+
+// promptDither asks the user for a dithering method
+func promptDither(win *sdl.Window) (raster.Dither, error) {
+	var names []string
+	for _, d := range raster.Dithers {
+		names = append(names, d.String())
+	}
+	v, err := promptChoice(win, "Dithering", names...)
+	if err != nil {
+		return 0, err
+	}
+	return raster.ParseDither(v)
+}
+
+// paletteMenu returns the menu converting the document to and from indexed
+// colors and editing its palette
+func paletteMenu(win *sdl.Window, iv *image.View, actionComms chan<- func()) menu.Definition {
+	// withPaletteFile asks for a palette file and passes its colors to f on
+	// the main thread
+	withPaletteFile := func(dither bool, f func(p color.Palette, d raster.Dither) error) {
+		go func() {
+			fileName, err := util.OpenFileDialog(win, paletteFilter)
+			if err != nil {
+				log.Warn(err)
+				return
+			}
+			p, err := codec.ReadPaletteFile(fileName)
+			if err != nil {
+				log.Warn(err)
+				return
+			}
+			d := raster.DitherNone
+			if dither {
+				if d, err = promptDither(win); err != nil {
+					log.Warn(err)
+					return
+				}
+			}
+			actionComms <- func() {
+				if err := f(p, d); err != nil {
+					log.Warn(err)
+				}
+			}
+		}()
+	}
+
+	return menu.Definition{
+		Text: "Indexed Mode",
+		Children: []menu.Definition{
+			{
+				Text: "Convert to Indexed",
+				Action: func() {
+					go func() {
+						name, err := promptChoice(win, "Palette", paletteMethodNames...)
+						if err != nil {
+							log.Warn(err)
+							return
+						}
+						n, err := promptInt(win, "Colors (1-256)", 16)
+						if err != nil {
+							log.Warn(err)
+							return
+						}
+						d, err := promptDither(win)
+						if err != nil {
+							log.Warn(err)
+							return
+						}
+						actionComms <- func() {
+							if err := iv.ConvertToIndexed(paletteMethods[name], n, d); err != nil {
+								log.Warn(err)
+							}
+						}
+					}()
+				},
+			},
+			{
+				Text: "Remap to Palette File",
+				Action: func() {
+					withPaletteFile(true, iv.RemapToPalette)
+				},
+			},
+			{
+				Text: "Swap Palette from File",
+				Action: func() {
+					withPaletteFile(false, func(p color.Palette, _ raster.Dither) error {
+						return iv.SwapPalette(p)
+					})
+				},
+			},
+			{
+				Text: "Swap Palette Entries",
+				Action: func() {
+					go func() {
+						i, err := promptInt(win, "First palette index", 0)
+						if err != nil {
+							log.Warn(err)
+							return
+						}
+						j, err := promptInt(win, "Second palette index", 1)
+						if err != nil {
+							log.Warn(err)
+							return
+						}
+						actionComms <- func() {
+							if err := iv.SwapPaletteEntries(i, j); err != nil {
+								log.Warn(err)
+							}
+						}
+					}()
+				},
+			},
+			{
+				Text: "Export Palette",
+				Action: func() {
+					go func() {
+						fileName, err := util.SaveFileDialog(win, paletteFilter)
+						if err != nil {
+							log.Warn(err)
+							return
+						}
+						actionComms <- func() {
+							if !iv.Indexed() {
+								log.Warn(image.ErrNotIndexed)
+								return
+							}
+							if err := codec.WritePaletteFile(fileName, iv.Palette()); err != nil {
+								log.Warn(err)
+							}
+						}
+					}()
+				},
+			},
+			{
+				Text: "Convert to RGBA",
+				Action: func() {
+					go func() {
+						actionComms <- iv.ConvertToRGBA
+					}()
+				},
+			},
+		},
+	}
+}
+
+// brushColorMenu returns the Tools menu entry choosing the color painted by
+// the pixel color tool
+func brushColorMenu(win *sdl.Window, iv *image.View, actionComms chan<- func()) menu.Definition {
+	return menu.Definition{
+		Text: "Brush Color",
+		Action: func() {
+			go func() {
+				text, err := util.EntryDialog(win, "Brush color (RRGGBB or RRGGBBAA)", "")
+				if err != nil {
+					log.Warn(err)
+					return
+				}
+				c, err := raster.ParseHex(text)
+				if err != nil {
+					log.Warn(err)
+					return
+				}
+				actionComms <- func() {
+					iv.SetBrushColor(c)
+				}
+			}()
+		},
+	}
+}
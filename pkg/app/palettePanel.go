@@ -0,0 +1,212 @@
+package app
+
+import (
+	"github.com/go-gl/gl/v2.1/gl"
+	"github.com/gregjohnson2017/tabula-editor/pkg/config"
+	"github.com/gregjohnson2017/tabula-editor/pkg/image"
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+	"github.com/gregjohnson2017/tabula-editor/pkg/shaders"
+	"github.com/gregjohnson2017/tabula-editor/pkg/ui"
+	"github.com/gregjohnson2017/tabula-editor/pkg/util"
+	"github.com/kroppt/gfx"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+var _ ui.Component = ui.Component(&PalettePanel{})
+
+// Palette panel layout in pixels
+const (
+	swatchSize    = 16
+	swatchColumns = 8
+	swatchBorder  = 2
+)
+
+// PalettePanel shows the palette of an indexed document above the bottom
+// right corner of the image view. Left clicking an entry paints with it and
+// right clicking one changes its color.
+type PalettePanel struct {
+	cfg         *config.Config
+	iv          *image.View
+	win         *sdl.Window
+	actionComms chan<- func()
+	program     gfx.Program
+	quad        *gfx.VAO
+}
+
+// NewPalettePanel returns a pointer to a new PalettePanel struct that
+// implements ui.Component
+func NewPalettePanel(cfg *config.Config, win *sdl.Window, iv *image.View, actionComms chan<- func()) (*PalettePanel, error) {
+	v, err := gfx.NewShader(shaders.SolidColorVertex, gl.VERTEX_SHADER)
+	if err != nil {
+		return nil, err
+	}
+	f, err := gfx.NewShader(shaders.SolidColorFragment, gl.FRAGMENT_SHADER)
+	if err != nil {
+		return nil, err
+	}
+	program, err := gfx.NewProgram(v, f)
+	if err != nil {
+		return nil, err
+	}
+	quad := gfx.NewVAO(gl.TRIANGLES, []int32{2})
+	err = quad.Load([]float32{
+		-1.0, -1.0, // bottom-left
+		-1.0, +1.0, // top-left
+		+1.0, +1.0, // top-right
+
+		-1.0, -1.0, // bottom-left
+		+1.0, +1.0, // top-right
+		+1.0, -1.0, // bottom-right
+	}, gl.STATIC_DRAW)
+	if err != nil {
+		log.Warnf("failed to load palette panel triangles: %v", err)
+	}
+	return &PalettePanel{
+		cfg:         cfg,
+		iv:          iv,
+		win:         win,
+		actionComms: actionComms,
+		program:     program,
+		quad:        quad,
+	}, nil
+}
+
+// area returns the region of the panel, which is empty unless the document
+// is indexed
+func (pp *PalettePanel) area() sdl.Rect {
+	n := int32(len(pp.iv.Palette()))
+	if n == 0 {
+		return sdl.Rect{}
+	}
+	rows := (n + swatchColumns - 1) / swatchColumns
+	w, h := int32(swatchColumns*swatchSize), rows*swatchSize
+	return sdl.Rect{X: pp.cfg.ScreenWidth - w, Y: pp.cfg.ScreenHeight - pp.cfg.BottomBarHeight - h, W: w, H: h}
+}
+
+// swatchAt returns the palette index under pt, or -1 if there is none
+func (pp *PalettePanel) swatchAt(pt sdl.Point) int {
+	a := pp.area()
+	if !ui.InBounds(a, pt) {
+		return -1
+	}
+	i := int((pt.Y-a.Y)/swatchSize*swatchColumns + (pt.X-a.X)/swatchSize)
+	if i >= len(pp.iv.Palette()) {
+		return -1
+	}
+	return i
+}
+
+// fill draws a solid rectangle given in window coordinates
+func (pp *PalettePanel) fill(r sdl.Rect, col [4]float32) {
+	err := pp.program.UploadUniform("uni_color", col[0], col[1], col[2], col[3])
+	if err != nil {
+		log.Warnf("failed to upload uniform \"%v\": %v", "uni_color", err)
+	}
+	gl.Viewport(r.X, pp.cfg.ScreenHeight-r.Y-r.H, r.W, r.H)
+	pp.program.Bind()
+	pp.quad.Draw()
+	pp.program.Unbind()
+}
+
+// Destroy frees all assets obtained by the ui.Component
+func (pp *PalettePanel) Destroy() {
+	pp.quad.Destroy()
+}
+
+// InBoundary returns whether a point is in this ui.Component's bounds
+func (pp *PalettePanel) InBoundary(pt sdl.Point) bool {
+	return ui.InBounds(pp.area(), pt)
+}
+
+// Render draws the ui.Component
+func (pp *PalettePanel) Render() {
+	palette := pp.iv.Palette()
+	if len(palette) == 0 {
+		return
+	}
+	a := pp.area()
+	pp.fill(a, [4]float32{0.2, 0.2, 0.2, 1.0})
+	selected := pp.iv.BrushIndex()
+	for i, c := range palette {
+		r := sdl.Rect{
+			X: a.X + int32(i%swatchColumns)*swatchSize,
+			Y: a.Y + int32(i/swatchColumns)*swatchSize,
+			W: swatchSize,
+			H: swatchSize,
+		}
+		if i == selected {
+			pp.fill(r, [4]float32{1.0, 1.0, 1.0, 1.0})
+		}
+		r = sdl.Rect{X: r.X + swatchBorder, Y: r.Y + swatchBorder, W: r.W - 2*swatchBorder, H: r.H - 2*swatchBorder}
+		cr, cg, cb, ca := c.RGBA()
+		if ca == 0 {
+			// show transparency as a split light and dark swatch
+			pp.fill(r, [4]float32{0.8, 0.8, 0.8, 1.0})
+			pp.fill(sdl.Rect{X: r.X, Y: r.Y, W: r.W / 2, H: r.H / 2}, [4]float32{0.5, 0.5, 0.5, 1.0})
+			continue
+		}
+		pp.fill(r, [4]float32{float32(cr) / float32(ca), float32(cg) / float32(ca), float32(cb) / float32(ca), 1.0})
+	}
+	gl.Viewport(0, 0, pp.cfg.ScreenWidth, pp.cfg.ScreenHeight)
+}
+
+// OnEnter is called when the cursor enters the ui.Component's region
+func (pp *PalettePanel) OnEnter() {}
+
+// OnLeave is called when the cursor leaves the ui.Component's region
+func (pp *PalettePanel) OnLeave() {}
+
+// OnMotion is called when the cursor moves within the ui.Component's region
+func (pp *PalettePanel) OnMotion(evt *sdl.MouseMotionEvent) bool {
+	return true
+}
+
+// OnScroll is called when the user scrolls within the ui.Component's region
+func (pp *PalettePanel) OnScroll(evt *sdl.MouseWheelEvent) bool {
+	return true
+}
+
+// OnClick is called when the user clicks within the ui.Component's region
+func (pp *PalettePanel) OnClick(evt *sdl.MouseButtonEvent) bool {
+	if evt.State != sdl.PRESSED {
+		return true
+	}
+	i := pp.swatchAt(sdl.Point{X: evt.X, Y: evt.Y})
+	if i < 0 {
+		return true
+	}
+	switch evt.Button {
+	case sdl.BUTTON_LEFT:
+		if err := pp.iv.SetBrushIndex(i); err != nil {
+			log.Warn(err)
+		}
+	case sdl.BUTTON_RIGHT:
+		go func() {
+			text, err := util.EntryDialog(pp.win, "Palette color (RRGGBB or RRGGBBAA)", "")
+			if err != nil {
+				log.Warn(err)
+				return
+			}
+			c, err := raster.ParseHex(text)
+			if err != nil {
+				log.Warn(err)
+				return
+			}
+			pp.actionComms <- func() {
+				if err := pp.iv.SetPaletteColor(i, c); err != nil {
+					log.Warn(err)
+				}
+			}
+		}()
+	}
+	return true
+}
+
+// OnResize is called when the user resizes the window
+func (pp *PalettePanel) OnResize(x, y int32) {}
+
+// String returns the name of the component type
+func (pp *PalettePanel) String() string {
+	return "app.PalettePanel"
+}
@@ -0,0 +1,251 @@
+package app
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"sort"
+	"strings"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/codec"
+	"github.com/gregjohnson2017/tabula-editor/pkg/image"
+	"github.com/gregjohnson2017/tabula-editor/pkg/remote"
+)
+
+// remoteTools are the tools setTool can choose, by name
+var remoteTools = map[string]func() image.Tool{
+	"none":   func() image.Tool { return image.EmptyTool{} },
+	"select": func() image.Tool { return &image.PixelSelectionTool{} },
+	"color":  func() image.Tool { return &image.PixelColorTool{} },
+}
+
+// remoteLayer describes a layer to remote callers. The position is relative
+// to the canvas.
+type remoteLayer struct {
+	Index    int     `json:"index"`
+	Name     string  `json:"name"`
+	X        int32   `json:"x"`
+	Y        int32   `json:"y"`
+	Width    int32   `json:"width"`
+	Height   int32   `json:"height"`
+	Opacity  float64 `json:"opacity"`
+	Hidden   bool    `json:"hidden"`
+	Selected bool    `json:"selected"`
+	// Source is the file a linked layer shows, Missing if it was not found
+	Source  string `json:"source,omitempty"`
+	Linked  bool   `json:"linked,omitempty"`
+	Missing bool   `json:"missing,omitempty"`
+}
+
+// remoteCanvas describes the canvas to remote callers
+type remoteCanvas struct {
+	Width  int32  `json:"width"`
+	Height int32  `json:"height"`
+	Depth  string `json:"depth"`
+	Linear bool   `json:"linear"`
+	Layers int    `json:"layers"`
+	// PNG is the composited canvas encoded as base64, if asked for
+	PNG string `json:"png,omitempty"`
+}
+
+// Listen serves the remote methods on a Unix domain socket at path until
+// the application quits. Every call runs on the main thread through the post
+// event actions.
+func (app *Application) Listen(path string) error {
+	s, err := remote.Listen(path, app.remoteMethods())
+	if err != nil {
+		return err
+	}
+	app.server = s
+	go s.Serve()
+	return nil
+}
+
+// onMain runs f on the main thread and waits for its result
+func (app *Application) onMain(f func() (interface{}, error)) (interface{}, error) {
+	type result struct {
+		v   interface{}
+		err error
+	}
+	done := make(chan result, 1)
+	app.postEvtActs <- func() {
+		v, err := f()
+		done <- result{v, err}
+	}
+	r := <-done
+	return r.v, r.err
+}
+
+// remoteMethods returns the methods served by Listen
+func (app *Application) remoteMethods() map[string]remote.Method {
+	iv := app.view
+	return map[string]remote.Method{
+		"openLayer": func(params json.RawMessage) (interface{}, error) {
+			var p struct {
+				Path string `json:"path"`
+				Name string `json:"name"`
+				X    *int32 `json:"x"`
+				Y    *int32 `json:"y"`
+			}
+			if err := remote.Decode(params, &p); err != nil {
+				return nil, err
+			}
+			if p.Path == "" {
+				return nil, fmt.Errorf("%w: path is required", remote.ErrInvalidParams)
+			}
+			img, err := openImage(p.Path)
+			if err != nil {
+				return nil, err
+			}
+			return app.onMain(func() (interface{}, error) {
+				first := len(iv.Layers())
+				if err := addImage(iv, img); err != nil {
+					return nil, err
+				}
+				last := len(iv.Layers()) - 1
+				for i := first; i <= last; i++ {
+					if p.X != nil || p.Y != nil {
+						area := iv.Layers()[i].Area
+						x, y := area.X, area.Y
+						if p.X != nil {
+							x = *p.X
+						}
+						if p.Y != nil {
+							y = *p.Y
+						}
+						if err := iv.MoveLayer(i, x, y); err != nil {
+							return nil, err
+						}
+					}
+					if p.Name != "" {
+						if err := iv.SelectLayer(i); err != nil {
+							return nil, err
+						}
+						if err := iv.SetLayerName(p.Name); err != nil {
+							return nil, err
+						}
+					}
+				}
+				if err := iv.SelectLayer(last); err != nil {
+					return nil, err
+				}
+				return map[string]int{"index": first, "count": last - first + 1}, nil
+			})
+		},
+		"listLayers": func(params json.RawMessage) (interface{}, error) {
+			return app.onMain(func() (interface{}, error) {
+				sel := iv.SelectedLayer()
+				var layers []remoteLayer
+				for i, l := range iv.Layers() {
+					layers = append(layers, remoteLayer{
+						Index: i, Name: l.Name,
+						X: l.Area.X, Y: l.Area.Y, Width: l.Area.W, Height: l.Area.H,
+						Opacity: l.Opacity, Hidden: l.Hidden, Selected: i == sel,
+						Source: l.Source, Linked: l.Linked, Missing: l.Missing,
+					})
+				}
+				return layers, nil
+			})
+		},
+		"moveLayer": func(params json.RawMessage) (interface{}, error) {
+			var p struct {
+				Index int   `json:"index"`
+				X     int32 `json:"x"`
+				Y     int32 `json:"y"`
+			}
+			if err := remote.Decode(params, &p); err != nil {
+				return nil, err
+			}
+			return app.onMain(func() (interface{}, error) {
+				return nil, iv.MoveLayer(p.Index, p.X, p.Y)
+			})
+		},
+		"export": func(params json.RawMessage) (interface{}, error) {
+			var p struct {
+				Path    string        `json:"path"`
+				Options codec.Options `json:"options"`
+			}
+			if err := remote.Decode(params, &p); err != nil {
+				return nil, err
+			}
+			if p.Path == "" {
+				return nil, fmt.Errorf("%w: path is required", remote.ErrInvalidParams)
+			}
+			return app.onMain(func() (interface{}, error) {
+				return nil, iv.WriteToFile(p.Path, p.Options)
+			})
+		},
+		"save": func(params json.RawMessage) (interface{}, error) {
+			var p struct {
+				Path string `json:"path"`
+			}
+			if err := remote.Decode(params, &p); err != nil {
+				return nil, err
+			}
+			if p.Path == "" {
+				return nil, fmt.Errorf("%w: path is required", remote.ErrInvalidParams)
+			}
+			return app.onMain(func() (interface{}, error) {
+				return nil, iv.SaveProject(p.Path)
+			})
+		},
+		"setTool": func(params json.RawMessage) (interface{}, error) {
+			var p struct {
+				Tool string `json:"tool"`
+			}
+			if err := remote.Decode(params, &p); err != nil {
+				return nil, err
+			}
+			newTool, ok := remoteTools[strings.ToLower(p.Tool)]
+			if !ok {
+				names := make([]string, 0, len(remoteTools))
+				for name := range remoteTools {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				return nil, fmt.Errorf("%w: unknown tool %q, expected one of %v", remote.ErrInvalidParams, p.Tool, strings.Join(names, ", "))
+			}
+			return app.onMain(func() (interface{}, error) {
+				iv.SetTool(newTool())
+				return nil, nil
+			})
+		},
+		"getCanvas": func(params json.RawMessage) (interface{}, error) {
+			var p struct {
+				Image bool `json:"image"`
+			}
+			if err := remote.Decode(params, &p); err != nil {
+				return nil, err
+			}
+			var c remoteCanvas
+			var doc *image.Document
+			_, err := app.onMain(func() (interface{}, error) {
+				canvas := iv.Canvas()
+				c = remoteCanvas{
+					Width:  canvas.W,
+					Height: canvas.H,
+					Depth:  iv.Depth().String(),
+					Linear: iv.Linear(),
+					Layers: len(iv.Layers()) - 1,
+				}
+				if p.Image {
+					doc = iv.Document()
+				}
+				return nil, nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			if p.Image {
+				var buf bytes.Buffer
+				if err = png.Encode(&buf, doc.Composite().NRGBA()); err != nil {
+					return nil, err
+				}
+				c.PNG = base64.StdEncoding.EncodeToString(buf.Bytes())
+			}
+			return c, nil
+		},
+	}
+}
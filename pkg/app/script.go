@@ -0,0 +1,50 @@
+package app
+
+import (
+	"github.com/gregjohnson2017/tabula-editor/pkg/image"
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/menu"
+	"github.com/gregjohnson2017/tabula-editor/pkg/script"
+	"github.com/gregjohnson2017/tabula-editor/pkg/util"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// scriptFilter matches script files in file dialogs
+var scriptFilter = util.FileFilter{Name: "Tabula script", Patterns: []string{"*.tscript"}}
+
+// scriptMenu runs a chosen script on a copy of the project off the main
+// thread, then replaces the project with the result. The result is dropped
+// if the project was edited while the script ran.
+func scriptMenu(win *sdl.Window, iv *image.View, actionComms chan<- func()) menu.Definition {
+	return menu.Definition{
+		Text: "Run Script...",
+		Action: func() {
+			go func() {
+				path, err := util.OpenFileDialog(win, scriptFilter)
+				if err != nil {
+					log.Warn(err)
+					return
+				}
+				actionComms <- func() {
+					before, doc := iv.Revision(), iv.Document()
+					go func() {
+						doc, err := script.RunFile(path, doc)
+						if err != nil {
+							log.Warn(err)
+							return
+						}
+						actionComms <- func() {
+							if iv.Revision() != before {
+								log.Warnf("%v: the project changed while the script ran, so its result was dropped", path)
+								return
+							}
+							if err := iv.LoadDocument(doc); err != nil {
+								log.Warn(err)
+							}
+						}
+					}()
+				}
+			}()
+		},
+	}
+}
@@ -0,0 +1,163 @@
+package app
+
+import (
+	stdimage "image"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/image"
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/menu"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+	"github.com/gregjohnson2017/tabula-editor/pkg/util"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// Tile grid choices
+const (
+	sliceBySize  = "cell size"
+	sliceByCount = "cell count"
+)
+
+// pngFilter matches PNG files, which sprite sheets are written as
+var pngFilter = util.FileFilter{Name: "PNG", Patterns: []string{"*.png", "*.PNG"}}
+
+// gridParams are the answers of promptGrid
+type gridParams struct {
+	bySize          bool
+	x, y            int
+	margin, spacing int
+}
+
+// grid returns the tile grid for a layer of the given size
+func (p gridParams) grid(size stdimage.Point) (raster.Grid, error) {
+	if p.bySize {
+		return raster.GridBySize(size, p.x, p.y, p.margin, p.spacing)
+	}
+	return raster.GridByCount(size, p.x, p.y, p.margin, p.spacing)
+}
+
+// promptGrid asks the user how to divide a layer into tiles
+func promptGrid(win *sdl.Window) (gridParams, error) {
+	var p gridParams
+	v, err := promptChoice(win, "Slice by", sliceBySize, sliceByCount)
+	if err != nil {
+		return p, err
+	}
+	p.bySize = v == sliceBySize
+	xName, yName, def := "Columns", "Rows", 4
+	if p.bySize {
+		xName, yName, def = "Cell width", "Cell height", 16
+	}
+	if p.x, err = promptInt(win, xName, def); err != nil {
+		return p, err
+	}
+	if p.y, err = promptInt(win, yName, def); err != nil {
+		return p, err
+	}
+	if p.margin, err = promptInt(win, "Margin", 0); err != nil {
+		return p, err
+	}
+	p.spacing, err = promptInt(win, "Spacing", 0)
+	return p, err
+}
+
+// sliceMenus returns the Layer menu entries cutting the selected layer into
+// tiles
+func sliceMenus(win *sdl.Window, iv *image.View, actionComms chan<- func()) []menu.Definition {
+	return []menu.Definition{
+		{
+			Text: "Slice to Layers",
+			Action: func() {
+				go func() {
+					p, err := promptGrid(win)
+					if err != nil {
+						log.Warn(err)
+						return
+					}
+					actionComms <- func() {
+						size, err := iv.LayerSize()
+						if err != nil {
+							log.Warn(err)
+							return
+						}
+						g, err := p.grid(size)
+						if err != nil {
+							log.Warn(err)
+							return
+						}
+						n, err := iv.SliceToLayers(g)
+						if err != nil {
+							log.Warn(err)
+							return
+						}
+						log.Infof("added %v tile layers", n)
+					}
+				}()
+			},
+		},
+		{
+			Text: "Export Tiles",
+			Action: func() {
+				go func() {
+					p, err := promptGrid(win)
+					if err != nil {
+						log.Warn(err)
+						return
+					}
+					dir, err := util.FolderDialog(win)
+					if err != nil {
+						log.Warn(err)
+						return
+					}
+					actionComms <- func() {
+						size, err := iv.LayerSize()
+						if err != nil {
+							log.Warn(err)
+							return
+						}
+						g, err := p.grid(size)
+						if err != nil {
+							log.Warn(err)
+							return
+						}
+						paths, err := iv.ExportTiles(dir, g)
+						if err != nil {
+							log.Warn(err)
+							return
+						}
+						log.Infof("exported %v tiles to %v", len(paths), dir)
+					}
+				}()
+			},
+		},
+	}
+}
+
+// spriteSheetMenu returns the File menu entry packing the visible layers
+// into a sprite sheet
+func spriteSheetMenu(win *sdl.Window, iv *image.View, actionComms chan<- func()) menu.Definition {
+	return menu.Definition{
+		Text: "Export Sprite Sheet",
+		Action: func() {
+			go func() {
+				padding, err := promptInt(win, "Padding", 1)
+				if err != nil {
+					log.Warn(err)
+					return
+				}
+				fileName, err := util.SaveFileDialog(win, pngFilter)
+				if err != nil {
+					log.Warn(err)
+					return
+				}
+				actionComms <- func() {
+					atlas, err := iv.ExportSpriteSheet(fileName, padding)
+					if err != nil {
+						log.Warn(err)
+						return
+					}
+					log.Infof("exported sprite sheet %v with atlas %v", fileName, atlas)
+				}
+			}()
+		},
+	}
+}
@@ -0,0 +1,179 @@
+package app
+
+import (
+	"fmt"
+	stdimage "image"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/image"
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/menu"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+	"github.com/gregjohnson2017/tabula-editor/pkg/util"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// stylizeMenus returns the filter menu entries for the built in filters
+func stylizeMenus(win *sdl.Window, iv *image.View, actionComms chan<- func()) []menu.Definition {
+	// apply posts f to the main thread to filter the selected layer, or deep
+	// if the document has more than 8 bits per channel
+	apply := func(f image.LayerFilter, deep image.DeepFilter) {
+		actionComms <- func() {
+			if err := iv.ApplyDeepFilter(f, deep); err != nil {
+				log.Warn(err)
+			}
+		}
+	}
+	// simple returns an entry for a filter without parameters
+	simple := func(text string, f func(*stdimage.NRGBA) *stdimage.NRGBA, deep func(*raster.Float) *raster.Float) menu.Definition {
+		return menu.Definition{
+			Text: text,
+			Action: func() {
+				go apply(func(img *stdimage.NRGBA) (*stdimage.NRGBA, error) { return f(img), nil },
+					func(img *raster.Float) (*raster.Float, error) { return deep(img), nil })
+			},
+		}
+	}
+	edges := make([]menu.Definition, 0, len(raster.EdgeOperators))
+	for _, o := range raster.EdgeOperators {
+		o := o
+		edges = append(edges, simple(strings.Title(o.String()), func(img *stdimage.NRGBA) *stdimage.NRGBA {
+			return raster.DetectEdges(img, o)
+		}, func(img *raster.Float) *raster.Float {
+			return raster.DetectEdgesFloat(img, o)
+		}))
+	}
+	return []menu.Definition{
+		{
+			Text: "Add Noise",
+			Action: func() {
+				go func() {
+					names := make([]string, 0, len(raster.Noises))
+					for _, n := range raster.Noises {
+						names = append(names, strings.Title(n.String()))
+					}
+					name, err := util.ListDialog(win, "Distribution", names...)
+					if err != nil {
+						log.Warn(err)
+						return
+					}
+					n, err := raster.ParseNoise(name)
+					if err != nil {
+						log.Warn(err)
+						return
+					}
+					text, err := util.EntryDialog(win, "Amount (0-255)", "32")
+					if err != nil {
+						log.Warn(err)
+						return
+					}
+					var amount float64
+					if _, err = fmt.Sscan(text, &amount); err != nil {
+						log.Warnf("parsing amount %q: %v", text, err)
+						return
+					}
+					mono, err := util.QuestionDialog(win, "Monochromatic noise?")
+					if err != nil {
+						log.Warn(err)
+						return
+					}
+					rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+					apply(func(img *stdimage.NRGBA) (*stdimage.NRGBA, error) {
+						return raster.AddNoise(img, n, amount, mono, rng), nil
+					}, func(img *raster.Float) (*raster.Float, error) {
+						return raster.AddNoiseFloat(img, n, amount, mono, rng), nil
+					})
+				}()
+			},
+		},
+		{
+			Text: "Pixelate",
+			Action: func() {
+				go func() {
+					cell, err := promptInt(win, "Cell size in pixels", 8)
+					if err != nil {
+						log.Warn(err)
+						return
+					}
+					apply(func(img *stdimage.NRGBA) (*stdimage.NRGBA, error) { return raster.Pixelate(img, cell) },
+						func(img *raster.Float) (*raster.Float, error) { return raster.PixelateFloat(img, cell) })
+				}()
+			},
+		},
+		simple("Emboss", raster.Emboss, raster.EmbossFloat),
+		{
+			Text:     "Edge Detect",
+			Children: edges,
+		},
+		{
+			Text: "Posterize",
+			Action: func() {
+				go func() {
+					levels, err := promptInt(win, "Levels per channel", 4)
+					if err != nil {
+						log.Warn(err)
+						return
+					}
+					apply(func(img *stdimage.NRGBA) (*stdimage.NRGBA, error) { return raster.Posterize(img, levels) },
+						func(img *raster.Float) (*raster.Float, error) { return raster.PosterizeFloat(img, levels) })
+				}()
+			},
+		},
+		{
+			Text: "Threshold",
+			Action: func() {
+				go func() {
+					level, err := promptInt(win, "Threshold (0-255)", 128)
+					if err != nil {
+						log.Warn(err)
+						return
+					}
+					if level < 0 || level > 255 {
+						log.Warnf("%v: threshold %v", raster.ErrInvalidParameter, level)
+						return
+					}
+					apply(func(img *stdimage.NRGBA) (*stdimage.NRGBA, error) { return raster.Threshold(img, uint8(level)), nil },
+						func(img *raster.Float) (*raster.Float, error) { return raster.ThresholdFloat(img, uint8(level)), nil })
+				}()
+			},
+		},
+		simple("Invert", raster.Invert, raster.InvertFloat),
+		simple("Desaturate", raster.Desaturate, raster.DesaturateFloat),
+		{
+			Text: "Median",
+			Action: func() {
+				go func() {
+					radius, err := promptInt(win, "Radius", 2)
+					if err != nil {
+						log.Warn(err)
+						return
+					}
+					apply(func(img *stdimage.NRGBA) (*stdimage.NRGBA, error) { return raster.Median(img, radius) },
+						func(img *raster.Float) (*raster.Float, error) { return raster.MedianFloat(img, radius) })
+				}()
+			},
+		},
+		{
+			Text: "Despeckle",
+			Action: func() {
+				go apply(func(img *stdimage.NRGBA) (*stdimage.NRGBA, error) { return raster.Median(img, 1) },
+					func(img *raster.Float) (*raster.Float, error) { return raster.MedianFloat(img, 1) })
+			},
+		},
+	}
+}
+
+// promptInt asks the user for a whole number, defaulting to def
+func promptInt(win *sdl.Window, prompt string, def int) (int, error) {
+	text, err := util.EntryDialog(win, prompt, fmt.Sprint(def))
+	if err != nil {
+		return 0, err
+	}
+	var v int
+	if _, err = fmt.Sscan(text, &v); err != nil {
+		return 0, fmt.Errorf("parsing %q: %w", text, err)
+	}
+	return v, nil
+}
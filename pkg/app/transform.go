@@ -0,0 +1,124 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/image"
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/menu"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+	"github.com/gregjohnson2017/tabula-editor/pkg/util"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// transformValuesFormat describes the numeric transform entry to the user
+const transformValuesFormat = "scale x %, scale y %, angle, skew x, skew y (degrees), move x, move y (pixels)"
+
+// transformMenu returns the menu controlling the free transform tool
+func transformMenu(win *sdl.Window, iv *image.View, actionComms chan<- func()) menu.Definition {
+	tool := &image.TransformTool{}
+	// do runs f on the main thread with the transform tool active
+	do := func(f func()) {
+		go func() {
+			actionComms <- func() {
+				iv.SetTool(tool)
+				f()
+			}
+		}()
+	}
+
+	filters := make([]menu.Definition, 0, len(raster.Filters))
+	for _, f := range raster.Filters {
+		f := f
+		filters = append(filters, menu.Definition{
+			Text: strings.Title(f.String()),
+			Action: func() {
+				do(func() { tool.SetFilter(f) })
+			},
+		})
+	}
+
+	return menu.Definition{
+		Text: "Free Transform",
+		Action: func() {
+			do(func() {})
+		},
+		Children: []menu.Definition{
+			{
+				Text: "Numeric Entry",
+				Action: func() {
+					do(func() {
+						vals, ok := tool.Values(iv)
+						if !ok {
+							log.Warn("no layer selected to transform")
+							return
+						}
+						go func() {
+							vals, err := promptTransformValues(win, vals)
+							if err != nil {
+								log.Warn(err)
+								return
+							}
+							do(func() {
+								if err := tool.SetValues(iv, vals); err != nil {
+									log.Warn(err)
+								}
+							})
+						}()
+					})
+				},
+			},
+			{
+				Text:   "Flip Horizontal",
+				Action: func() { do(func() { tool.FlipHorizontal(iv) }) },
+			},
+			{
+				Text:   "Flip Vertical",
+				Action: func() { do(func() { tool.FlipVertical(iv) }) },
+			},
+			{
+				Text: "Toggle Aspect Lock",
+				Action: func() {
+					do(func() {
+						tool.SetAspectLock(!tool.AspectLock())
+						log.Infof("transform aspect lock: %v", tool.AspectLock())
+					})
+				},
+			},
+			{
+				Text:     "Resampling",
+				Children: filters,
+			},
+			{
+				Text: "Apply",
+				Action: func() {
+					do(func() {
+						if err := tool.Apply(iv); err != nil {
+							log.Warn(err)
+						}
+					})
+				},
+			},
+			{
+				Text:   "Cancel",
+				Action: func() { do(func() { tool.Cancel(iv) }) },
+			},
+		},
+	}
+}
+
+// promptTransformValues asks the user for new transform values
+func promptTransformValues(win *sdl.Window, vals image.TransformValues) (image.TransformValues, error) {
+	current := fmt.Sprintf("%g %g %g %g %g %g %g", vals.ScaleX, vals.ScaleY, vals.Angle, vals.SkewX, vals.SkewY, vals.MoveX, vals.MoveY)
+	text, err := util.EntryDialog(win, "Transform: "+transformValuesFormat, current)
+	if err != nil {
+		return vals, err
+	}
+	var v image.TransformValues
+	_, err = fmt.Sscan(text, &v.ScaleX, &v.ScaleY, &v.Angle, &v.SkewX, &v.SkewY, &v.MoveX, &v.MoveY)
+	if err != nil {
+		return vals, fmt.Errorf("parsing transform values %q: %w", text, err)
+	}
+	return v, nil
+}
@@ -0,0 +1,71 @@
+package app
+
+import (
+	"time"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/image"
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/watch"
+)
+
+// Watch reloads linked layers whenever their source files change, noticing
+// changes with the given mode. A polling watcher checks every interval.
+func (app *Application) Watch(mode watch.Mode, interval time.Duration) error {
+	w, err := watch.New(mode, interval)
+	if err != nil || w == nil {
+		return err
+	}
+	app.watcher = w
+	app.watched = make(map[string]bool)
+	go app.reloadChanges(w)
+	return nil
+}
+
+// syncWatches watches the source file of every linked layer, and no others
+func (app *Application) syncWatches() {
+	if app.watcher == nil {
+		return
+	}
+	linked := make(map[string]bool)
+	for _, path := range app.view.LinkedSources() {
+		linked[path] = true
+		if app.watched[path] {
+			continue
+		}
+		// failures are not retried every frame
+		app.watched[path] = true
+		if err := app.watcher.Add(path); err != nil {
+			log.Warnf("watching %v: %v", path, err)
+		}
+	}
+	for path := range app.watched {
+		if !linked[path] {
+			app.watcher.Remove(path)
+			delete(app.watched, path)
+		}
+	}
+}
+
+// reloadChanges reads each changed file and reloads the layers linked to
+// it on the main thread, until w is closed
+func (app *Application) reloadChanges(w watch.Watcher) {
+	for path := range w.Changes() {
+		f, err := image.ReadLinked(path)
+		if err != nil {
+			// the file may be replaced again once fully written
+			log.Warnf("reloading %v: %v", path, err)
+			continue
+		}
+		path := path
+		app.postEvtActs <- func() {
+			n, err := app.view.ReloadSource(path, f)
+			if err != nil {
+				log.Warn(err)
+				return
+			}
+			if n > 0 {
+				log.Infof("reloaded %v layer(s) from %v", n, path)
+			}
+		}
+	}
+}
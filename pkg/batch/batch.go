@@ -0,0 +1,336 @@
+// Package batch applies a declared sequence of operations to many image
+// files.
+package batch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/codec"
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+)
+
+// ErrInvalidOp indicates that an operation of a pipeline is malformed
+const ErrInvalidOp log.ConstErr = "invalid batch operation"
+
+// ErrDuplicateOutput indicates that two inputs would be written to the same
+// file
+const ErrDuplicateOutput log.ConstErr = "another input is written to the same file"
+
+// Op is one step of a Pipeline as declared in an operations file. Fields
+// the kind of operation does not use are ignored.
+type Op struct {
+	// Op is the kind of operation: resize, scale, crop, trim, filter or
+	// convert.
+	Op string `json:"op"`
+	// Width and Height are the size of resize and crop. Resizing with one of
+	// them zero keeps the aspect ratio.
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+	// X and Y are the top left corner of crop.
+	X int `json:"x,omitempty"`
+	Y int `json:"y,omitempty"`
+	// Factor is the scale of both dimensions for scale.
+	Factor float64 `json:"factor,omitempty"`
+	// Filter is the resampling filter of resize and scale, nearest if empty.
+	Filter string `json:"filter,omitempty"`
+	// Name is the filter applied by filter: invert, desaturate, emboss,
+	// edges, posterize, threshold, pixelate, median or convolve.
+	Name string `json:"name,omitempty"`
+	// Amount is the number of levels of posterize, the luminance of
+	// threshold, the cell size of pixelate and the radius of median.
+	Amount int `json:"amount,omitempty"`
+	// Kernel holds the weights of convolve in the form read by
+	// raster.ParseKernel.
+	Kernel string `json:"kernel,omitempty"`
+	// Format is the extension written by convert, and Options its encoder
+	// options.
+	Format  string        `json:"format,omitempty"`
+	Options codec.Options `json:"options,omitempty"`
+}
+
+// Step is a compiled operation that changes the pixels of an image.
+type Step func(*image.NRGBA) (*image.NRGBA, error)
+
+// Pipeline is a sequence of operations applied to each input, along with the
+// format the results are written in.
+type Pipeline struct {
+	steps []Step
+	// ext is the extension of the outputs, or empty to keep that of the
+	// input
+	ext     string
+	options codec.Options
+}
+
+// Load reads a pipeline from a JSON file holding a list of operations.
+func Load(path string) (*Pipeline, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ops []Op
+	if err = json.Unmarshal(data, &ops); err != nil {
+		return nil, fmt.Errorf("reading %v: %w", path, err)
+	}
+	p, err := New(ops)
+	if err != nil {
+		return nil, fmt.Errorf("reading %v: %w", path, err)
+	}
+	return p, nil
+}
+
+// New checks the operations and returns the pipeline applying them in
+// order. A later convert replaces the format of an earlier one.
+func New(ops []Op) (*Pipeline, error) {
+	p := &Pipeline{}
+	for i, op := range ops {
+		if err := p.add(op); err != nil {
+			return nil, fmt.Errorf("operation %v (%v): %w", i+1, op.Op, err)
+		}
+	}
+	return p, nil
+}
+
+// add compiles op onto the end of the pipeline
+func (p *Pipeline) add(op Op) error {
+	f := raster.Nearest
+	if op.Filter != "" {
+		var err error
+		if f, err = raster.ParseFilter(op.Filter); err != nil {
+			return err
+		}
+	}
+	switch strings.ToLower(op.Op) {
+	case "resize":
+		if op.Width < 0 || op.Height < 0 || op.Width == 0 && op.Height == 0 {
+			return fmt.Errorf("%w: width and height must not be negative and not both zero", ErrInvalidOp)
+		}
+		p.steps = append(p.steps, func(img *image.NRGBA) (*image.NRGBA, error) {
+			w, h := op.Width, op.Height
+			sw, sh := img.Rect.Dx(), img.Rect.Dy()
+			if w == 0 {
+				w = int(math.Max(1, math.Round(float64(h*sw)/float64(sh))))
+			}
+			if h == 0 {
+				h = int(math.Max(1, math.Round(float64(w*sh)/float64(sw))))
+			}
+			return raster.Resize(img, w, h, f), nil
+		})
+	case "scale":
+		if !(op.Factor > 0) || math.IsInf(op.Factor, 0) {
+			return fmt.Errorf("%w: factor must be a positive number, got %v", ErrInvalidOp, op.Factor)
+		}
+		p.steps = append(p.steps, func(img *image.NRGBA) (*image.NRGBA, error) {
+			w := int(math.Max(1, math.Round(float64(img.Rect.Dx())*op.Factor)))
+			h := int(math.Max(1, math.Round(float64(img.Rect.Dy())*op.Factor)))
+			return raster.Resize(img, w, h, f), nil
+		})
+	case "crop":
+		if op.Width <= 0 || op.Height <= 0 {
+			return fmt.Errorf("%w: crop needs a positive width and height", ErrInvalidOp)
+		}
+		r := image.Rect(op.X, op.Y, op.X+op.Width, op.Y+op.Height)
+		p.steps = append(p.steps, func(img *image.NRGBA) (*image.NRGBA, error) {
+			return raster.Crop(img, r), nil
+		})
+	case "trim":
+		p.steps = append(p.steps, func(img *image.NRGBA) (*image.NRGBA, error) {
+			r := raster.OpaqueBounds(img)
+			if r.Empty() {
+				return img, nil
+			}
+			return raster.Crop(img, r), nil
+		})
+	case "filter":
+		s, err := FilterStep(op.Name, op.Amount, op.Kernel)
+		if err != nil {
+			return err
+		}
+		p.steps = append(p.steps, s)
+	case "convert":
+		ext := "." + strings.TrimPrefix(strings.ToLower(op.Format), ".")
+		e, err := codec.EncoderFor(ext)
+		if err != nil {
+			return err
+		}
+		if _, err = e.Resolve(op.Options); err != nil {
+			return err
+		}
+		p.ext, p.options = ext, op.Options
+	default:
+		return fmt.Errorf("%w: unknown operation %q", ErrInvalidOp, op.Op)
+	}
+	return nil
+}
+
+// FilterStep returns the named filter: invert, desaturate, emboss, edges,
+// posterize, threshold, pixelate, median or convolve. The amount is the
+// parameter of the filters that take one, and the kernel holds the weights
+// of convolve.
+func FilterStep(name string, amount int, kernel string) (Step, error) {
+	switch strings.ToLower(name) {
+	case "invert":
+		return func(img *image.NRGBA) (*image.NRGBA, error) { return raster.Invert(img), nil }, nil
+	case "desaturate":
+		return func(img *image.NRGBA) (*image.NRGBA, error) { return raster.Desaturate(img), nil }, nil
+	case "emboss":
+		return func(img *image.NRGBA) (*image.NRGBA, error) { return raster.Emboss(img), nil }, nil
+	case "edges":
+		return func(img *image.NRGBA) (*image.NRGBA, error) { return raster.DetectEdges(img, raster.Sobel), nil }, nil
+	case "posterize":
+		return func(img *image.NRGBA) (*image.NRGBA, error) { return raster.Posterize(img, amount) }, nil
+	case "threshold":
+		if amount < 0 || amount > 0xFF {
+			return nil, fmt.Errorf("%w: threshold must be from 0 to 255, got %v", raster.ErrInvalidParameter, amount)
+		}
+		return func(img *image.NRGBA) (*image.NRGBA, error) { return raster.Threshold(img, uint8(amount)), nil }, nil
+	case "pixelate":
+		return func(img *image.NRGBA) (*image.NRGBA, error) { return raster.Pixelate(img, amount) }, nil
+	case "median":
+		return func(img *image.NRGBA) (*image.NRGBA, error) { return raster.Median(img, amount) }, nil
+	case "convolve":
+		k, err := raster.ParseKernel(kernel)
+		if err != nil {
+			return nil, err
+		}
+		return func(img *image.NRGBA) (*image.NRGBA, error) { return raster.Convolve(img, k) }, nil
+	}
+	return nil, fmt.Errorf("%w: unknown filter %q", ErrInvalidOp, name)
+}
+
+// Apply runs every operation on img in order.
+func (p *Pipeline) Apply(img *image.NRGBA) (*image.NRGBA, error) {
+	var err error
+	for _, s := range p.steps {
+		if img, err = s(img); err != nil {
+			return nil, err
+		}
+	}
+	return img, nil
+}
+
+// Output returns the path in dir the result for input is written to.
+func (p *Pipeline) Output(input, dir string) string {
+	name := filepath.Base(input)
+	if p.ext != "" {
+		name = strings.TrimSuffix(name, filepath.Ext(name)) + p.ext
+	}
+	return filepath.Join(dir, name)
+}
+
+// Process reads input, applies the operations and writes the result to
+// output, keeping the metadata if the format allows.
+func (p *Pipeline) Process(input, output string) error {
+	img, m, err := codec.DecodeFileMeta(input)
+	if err != nil {
+		return err
+	}
+	if img, err = p.Apply(img); err != nil {
+		return err
+	}
+	return codec.EncodeFileMeta(output, img, p.options, m)
+}
+
+// Result is the outcome of processing one input.
+type Result struct {
+	Input, Output string
+	Err           error
+	Elapsed       time.Duration
+}
+
+// Run processes the inputs into dir with the given number of workers,
+// creating dir if needed, and returns a result for every input in the same
+// order. A failed input is logged and does not stop the others.
+func (p *Pipeline) Run(inputs []string, dir string, workers int) []Result {
+	results := make([]Result, len(inputs))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		for i, in := range inputs {
+			results[i] = Result{Input: in, Err: err}
+		}
+		return results
+	}
+	seen := make(map[string]bool)
+	var jobs []int
+	for i, in := range inputs {
+		out := p.Output(in, dir)
+		results[i] = Result{Input: in, Output: out}
+		if seen[out] {
+			results[i].Err = fmt.Errorf("%w: %v", ErrDuplicateOutput, out)
+			log.Warnf("%v: %v", in, results[i].Err)
+			continue
+		}
+		seen[out] = true
+		jobs = append(jobs, i)
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				r := &results[i]
+				start := time.Now()
+				r.Err = p.Process(r.Input, r.Output)
+				r.Elapsed = time.Since(start)
+				if r.Err != nil {
+					log.Warnf("%v: %v", r.Input, r.Err)
+				}
+			}
+		}()
+	}
+	for _, i := range jobs {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+	return results
+}
+
+// Failed returns the number of results with an error.
+func Failed(results []Result) int {
+	n := 0
+	for _, r := range results {
+		if r.Err != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// LogSummary logs a table of the results and their totals.
+func LogSummary(results []Result) {
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "INPUT\tOUTPUT\tTIME\tSTATUS")
+	var total time.Duration
+	for _, r := range results {
+		status := "ok"
+		if r.Err != nil {
+			status = "failed: " + r.Err.Error()
+		}
+		total += r.Elapsed
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\n", r.Input, r.Output, r.Elapsed.Round(time.Millisecond), status)
+	}
+	tw.Flush()
+	for _, line := range strings.Split(strings.TrimRight(buf.String(), "\n"), "\n") {
+		log.Info(line)
+	}
+	failed := Failed(results)
+	log.Infof("%v processed, %v succeeded, %v failed, %v of work", len(results), len(results)-failed, failed, total.Round(time.Millisecond))
+}
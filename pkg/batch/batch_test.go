@@ -0,0 +1,64 @@
+package batch_test
+
+import (
+	"errors"
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/batch"
+	"github.com/gregjohnson2017/tabula-editor/pkg/codec"
+)
+
+func TestPipeline(t *testing.T) {
+	if _, err := batch.New([]batch.Op{{Op: "resize"}}); !errors.Is(err, batch.ErrInvalidOp) {
+		t.Fatalf("expected ErrInvalidOp for a resize without a size, got %v", err)
+	}
+	if _, err := batch.New([]batch.Op{{Op: "spin"}}); !errors.Is(err, batch.ErrInvalidOp) {
+		t.Fatalf("expected ErrInvalidOp for an unknown operation, got %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var inputs []string
+	for _, name := range []string{"a.png", "b.png"} {
+		path := filepath.Join(dir, name)
+		if err = codec.EncodeFile(path, image.NewNRGBA(image.Rect(0, 0, 8, 4)), nil); err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, path)
+	}
+	inputs = append(inputs, filepath.Join(dir, "missing.png"))
+
+	p, err := batch.New([]batch.Op{
+		{Op: "resize", Width: 4},
+		{Op: "crop", X: 1, Width: 2, Height: 2},
+		{Op: "filter", Name: "invert"},
+		{Op: "convert", Format: "bmp"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out")
+	results := p.Run(inputs, out, 2)
+	if n := batch.Failed(results); n != 1 || results[2].Err == nil {
+		t.Fatalf("expected only the missing input to fail, got %v failures", n)
+	}
+	for _, r := range results[:2] {
+		if filepath.Ext(r.Output) != ".bmp" {
+			t.Fatalf("expected a bmp output, got %v", r.Output)
+		}
+		img, err := codec.DecodeFile(r.Output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := img.Bounds(); b.Dx() != 2 || b.Dy() != 2 {
+			t.Fatalf("expected 2x2, got %v", b)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package codec
+
+import (
+	"fmt"
+	"image"
+	"os"
+)
+
+// Names of the option choosing whether formats that store animations write
+// the flattened canvas or one frame per layer
+const (
+	FramesOption  = "Frames"
+	FramesFlatten = "flatten"
+	FramesLayers  = "layers"
+)
+
+// Frame is one image of an animation.
+type Frame struct {
+	Image *image.NRGBA
+	// Delay is how long the frame is shown in hundredths of a second, or zero
+	// for the default.
+	Delay int
+}
+
+// DecodeFrames reads every frame of the animation at path. Files in formats
+// without animation support give a single frame.
+func DecodeFrames(path string) ([]Frame, error) {
+	d, err := DecoderFor(path)
+	if err != nil || d.DecodeFrames == nil {
+		img, err := DecodeFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return []Frame{{Image: img}}, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	frames, err := d.DecodeFrames(f)
+	if err != nil {
+		return nil, fmt.Errorf("decoding %v: %w", path, err)
+	}
+	return frames, nil
+}
+
+// EncodeFramesFile writes frames to path as an animation in the format
+// registered for its extension. Missing options take their default values.
+func EncodeFramesFile(path string, frames []Frame, opts Options) error {
+	e, err := EncoderFor(path)
+	if err != nil {
+		return err
+	}
+	if e.EncodeFrames == nil {
+		return fmt.Errorf("%w: %v cannot store animations", ErrUnknownFormat, e.Name)
+	}
+	if opts, err = e.Resolve(opts); err != nil {
+		return err
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = e.EncodeFrames(out, frames, opts); err != nil {
+		out.Close()
+		return fmt.Errorf("encoding %v: %w", path, err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,106 @@
+// Package codec reads and writes raster image files through registries of
+// formats. Decoded images are normalized to non-premultiplied RGBA.
+package codec
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+)
+
+// ErrUnknownFormat indicates that no registered format handles a file
+const ErrUnknownFormat log.ConstErr = "unknown image format"
+
+// Decoder reads one image file format.
+type Decoder struct {
+	// Name is shown to the user, e.g. in file dialog filters.
+	Name string
+	// Extensions are the lower case file extensions, including the dot.
+	Extensions []string
+	Decode     func(io.Reader) (image.Image, error)
+	// DecodeFrames, if set, reads every frame of an animated file.
+	DecodeFrames func(io.Reader) ([]Frame, error)
+	// DecodeLayers, if set, reads the layers of a layered file.
+	DecodeLayers func(r io.ReaderAt, size int64) (*Layered, error)
+}
+
+var decoders []Decoder
+
+// RegisterDecoder adds a format to the registry. A later registration takes
+// precedence for extensions claimed by an earlier one.
+func RegisterDecoder(d Decoder) {
+	decoders = append(decoders, d)
+}
+
+// Decoders returns the registered formats in registration order.
+func Decoders() []Decoder {
+	return append([]Decoder(nil), decoders...)
+}
+
+// DecoderFor returns the registered format for the extension of path.
+func DecoderFor(path string) (Decoder, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	for i := len(decoders) - 1; i >= 0; i-- {
+		for _, e := range decoders[i].Extensions {
+			if e == ext {
+				return decoders[i], nil
+			}
+		}
+	}
+	return Decoder{}, fmt.Errorf("%w: %v", ErrUnknownFormat, path)
+}
+
+// DecodeFile reads the image at path with the format registered for its
+// extension, falling back to the content sniffing of image.Decode.
+func DecodeFile(path string) (*image.NRGBA, error) {
+	img, err := decodeImage(path)
+	if err != nil {
+		return nil, err
+	}
+	return ToNRGBA(img), nil
+}
+
+// decodeImage reads the image at path as DecodeFile does, without
+// converting it
+func decodeImage(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return decodeReader(path, f)
+}
+
+// decodeReader reads an image from r with the format registered for the
+// extension of name, falling back to the content sniffing of image.Decode
+func decodeReader(name string, r io.Reader) (image.Image, error) {
+	decode := func(r io.Reader) (image.Image, error) {
+		img, _, err := image.Decode(r)
+		return img, err
+	}
+	if d, err := DecoderFor(name); err == nil {
+		decode = d.Decode
+	}
+	img, err := decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("decoding %v: %w", name, err)
+	}
+	return img, nil
+}
+
+// ToNRGBA converts img to non-premultiplied RGBA with its origin at zero.
+func ToNRGBA(img image.Image) *image.NRGBA {
+	b := img.Bounds()
+	if n, ok := img.(*image.NRGBA); ok && b.Min == (image.Point{}) {
+		return n
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Rect, img, b.Min, draw.Src)
+	return dst
+}
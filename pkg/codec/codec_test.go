@@ -0,0 +1,932 @@
+package codec_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/codec"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+)
+
+var (
+	red   = color.NRGBA{R: 0xFF, A: 0xFF}
+	green = color.NRGBA{G: 0xFF, A: 0xFF}
+	blue  = color.NRGBA{B: 0xFF, A: 0xFF}
+	white = color.NRGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF}
+	black = color.NRGBA{A: 0xFF}
+)
+
+// expectPixels checks a decoded 2 by 2 image row by row
+func expectPixels(t *testing.T, img image.Image, err error, expected ...color.NRGBA) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := codec.ToNRGBA(img)
+	if n.Rect.Dx() != 2 || n.Rect.Dy() != 2 {
+		t.Fatalf("expected 2x2 image, got %v", n.Rect)
+	}
+	for i, c := range expected {
+		if a := n.NRGBAAt(i%2, i/2); a != c {
+			t.Fatalf("pixel %v: expected %v, got %v", i, c, a)
+		}
+	}
+}
+
+func TestDecodeTGA(t *testing.T) {
+	hdr := []byte{0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 0, 2, 0, 24, 0}
+	// bottom up BGR rows
+	raw := append(append([]byte{}, hdr...), 0, 0, 0, 0xFF, 0xFF, 0xFF, 0, 0, 0xFF, 0, 0xFF, 0)
+	img, err := codec.DecodeTGA(bytes.NewReader(raw))
+	expectPixels(t, img, err, red, green, black, white)
+
+	// the same image run-length encoded and stored top down
+	hdr[2], hdr[17] = 10, 0x20
+	rle := append(append([]byte{}, hdr...), 0x01, 0, 0, 0xFF, 0, 0xFF, 0, 0x80, 0, 0, 0, 0x80, 0xFF, 0xFF, 0xFF)
+	img, err = codec.DecodeTGA(bytes.NewReader(rle))
+	expectPixels(t, img, err, red, green, black, white)
+
+	for _, data := range []string{
+		"\x000\x02000000\xc7\xc7\xc7\xc7\xc7\xc7\xc7 00",
+		"\x00\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00\x00\xff\xff\xff\xff\x20\x00",
+		"\x00\x00\x02",
+	} {
+		if _, err = codec.DecodeTGA(bytes.NewReader([]byte(data))); !errors.Is(err, codec.ErrInvalidTGA) {
+			t.Errorf("%q: expected %v, got %v", data, codec.ErrInvalidTGA, err)
+		}
+	}
+}
+
+func TestDecodePNM(t *testing.T) {
+	for name, data := range map[string]string{
+		"plain ppm": "P3\n# comment\n2 2\n255\n255 0 0  0 255 0\n0 0 0  255 255 255\n",
+		"raw ppm":   "P6 2 2 255\n\xFF\x00\x00\x00\xFF\x00\x00\x00\x00\xFF\xFF\xFF",
+		"pam":       "P7\nWIDTH 2\nHEIGHT 2\nDEPTH 3\nMAXVAL 15\nTUPLTYPE RGB\nENDHDR\n\x0F\x00\x00\x00\x0F\x00\x00\x00\x00\x0F\x0F\x0F",
+	} {
+		img, err := codec.DecodePNM(bytes.NewReader([]byte(data)))
+		t.Run(name, func(t *testing.T) { expectPixels(t, img, err, red, green, black, white) })
+	}
+	img, err := codec.DecodePNM(bytes.NewReader([]byte("P4\n2 2\n\x40\x80")))
+	expectPixels(t, img, err, white, black, black, white)
+
+	for _, data := range []string{
+		"P6 4000000000 4000000000 255\n",
+		"P6 60000 60000 255\n",
+		"P7\nWIDTH 4000000000\nHEIGHT 4000000000\nDEPTH 4\nMAXVAL 255\nENDHDR\n",
+		"P7\nWIDTH\nENDHDR\n",
+		"P3 2 x",
+	} {
+		if _, err = codec.DecodePNM(bytes.NewReader([]byte(data))); !errors.Is(err, codec.ErrInvalidPNM) {
+			t.Errorf("%q: expected %v, got %v", data, codec.ErrInvalidPNM, err)
+		}
+	}
+}
+
+func TestDecodeICO(t *testing.T) {
+	var buf bytes.Buffer
+	le := func(v ...interface{}) {
+		for _, x := range v {
+			_ = binary.Write(&buf, binary.LittleEndian, x)
+		}
+	}
+	// directory with one 2x2 entry of 24 bits per pixel
+	le(uint16(0), uint16(1), uint16(1))
+	pixels := 2 * 8
+	mask := 2 * 4
+	le(uint8(2), uint8(2), uint8(0), uint8(0), uint16(1), uint16(24), uint32(40+pixels+mask), uint32(6+16))
+	le(uint32(40), int32(2), int32(4), uint16(1), uint16(24), uint32(0), uint32(pixels+mask), int32(0), int32(0), uint32(0), uint32(0))
+	// bottom up BGR rows padded to four bytes
+	buf.Write([]byte{0, 0, 0, 0xFF, 0xFF, 0xFF, 0, 0})
+	buf.Write([]byte{0, 0, 0xFF, 0, 0xFF, 0, 0, 0})
+	// the mask hides the bottom right pixel
+	buf.Write([]byte{0x40, 0, 0, 0, 0, 0, 0, 0})
+	img, err := codec.DecodeICO(&buf)
+	expectPixels(t, img, err, red, green, black, color.NRGBA{R: 0xFF, G: 0xFF, B: 0xFF})
+}
+
+func TestDecoderFor(t *testing.T) {
+	for path, name := range map[string]string{
+		"a.PNG":       "PNG",
+		"b/c.tga":     "TGA",
+		"d.pgm":       "PNM",
+		"e.ico":       "ICO",
+		"f.tiff":      "TIFF",
+		"g.webp":      "WebP",
+		"h.bmp":       "BMP",
+		"i.jpeg":      "JPEG",
+		"j.animation": "",
+	} {
+		d, err := codec.DecoderFor(path)
+		if name == "" {
+			if err == nil {
+				t.Fatalf("%v: expected no decoder, got %v", path, d.Name)
+			}
+			continue
+		}
+		if err != nil || d.Name != name {
+			t.Fatalf("%v: expected %v, got %v (%v)", path, name, d.Name, err)
+		}
+	}
+}
+
+// gradient returns an image with varied colors and alpha
+func gradient(w, h int, alpha bool) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			a := uint8(0xFF)
+			if alpha && (x+y)%3 == 0 {
+				a = uint8(x * 16)
+			}
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 255 / w), G: uint8(y * 255 / h), B: uint8((x + y) * 255 / (w + h)), A: a})
+		}
+	}
+	return img
+}
+
+func TestEncoders(t *testing.T) {
+	// JPEG and GIF are lossy and covered by TestEncodeLossy
+	lossless := map[string][]codec.Options{
+		"PNG": {
+			{},
+			{"Bit depth": "16", "Compression": "best"},
+			{"Interlace": "adam7"},
+			{"Interlace": "adam7", "Bit depth": "16", "Compression": "none"},
+		},
+		"TIFF": {{}, {"Compression": "none"}},
+		"TGA":  {{}, {"Run-length encoding": "no"}},
+		"BMP":  {{}},
+	}
+	for _, e := range codec.Encoders() {
+		for _, alpha := range []bool{false, true} {
+			src := gradient(19, 11, alpha)
+			for _, opts := range lossless[e.Name] {
+				opts, err := e.Resolve(opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var buf bytes.Buffer
+				if err = e.Encode(&buf, src, opts); err != nil {
+					t.Fatalf("%v %v: %v", e.Name, opts, err)
+				}
+				d, err := codec.DecoderFor("x" + e.Extensions[0])
+				if err != nil {
+					t.Fatal(err)
+				}
+				img, err := d.Decode(&buf)
+				if err != nil {
+					t.Fatalf("%v %v: %v", e.Name, opts, err)
+				}
+				actual := codec.ToNRGBA(img)
+				for i := 0; i < len(src.Pix); i += 4 {
+					// fully transparent pixels may lose their color
+					if src.Pix[i+3] == 0 && actual.Pix[i+3] == 0 {
+						continue
+					}
+					if !bytes.Equal(src.Pix[i:i+4], actual.Pix[i:i+4]) {
+						t.Fatalf("%v %v alpha %v: pixel %v expected %v, got %v", e.Name, opts, alpha, i/4, src.Pix[i:i+4], actual.Pix[i:i+4])
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeLossy(t *testing.T) {
+	src := gradient(37, 21, false)
+	jpg, err := codec.EncoderFor("a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, chroma := range []string{"4:2:0", "4:2:2", "4:4:4"} {
+		var buf bytes.Buffer
+		if err = jpg.Encode(&buf, src, codec.Options{"Quality": "95", "Chroma subsampling": chroma}); err != nil {
+			t.Fatal(err)
+		}
+		img, err := jpeg.Decode(&buf)
+		if err != nil {
+			t.Fatalf("%v: %v", chroma, err)
+		}
+		actual := codec.ToNRGBA(img)
+		for i := 0; i < len(src.Pix); i++ {
+			if d := int(src.Pix[i]) - int(actual.Pix[i]); d > 24 || d < -24 {
+				t.Fatalf("%v: byte %v expected about %v, got %v", chroma, i, src.Pix[i], actual.Pix[i])
+			}
+		}
+	}
+
+	enc, err := codec.EncoderFor("a.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src = gradient(8, 8, true)
+	for _, opts := range []codec.Options{{}, {"Colors": "4", "Dither": "floyd-steinberg"}, {"Palette": "octree", "Colors": "5"}, {"Palette": "web safe"}} {
+		opts, err = enc.Resolve(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err = enc.Encode(&buf, src, opts); err != nil {
+			t.Fatal(err)
+		}
+		m, err := gif.Decode(&buf)
+		if err != nil {
+			t.Fatalf("%v: %v", opts, err)
+		}
+		p, ok := m.(*image.Paletted)
+		if !ok {
+			t.Fatalf("expected paletted image, got %T", m)
+		}
+		if n := opts.Int("Colors"); opts["Palette"] != "web safe" && len(p.Palette) > n {
+			t.Fatalf("%v: expected at most %v colors, got %v", opts, n, len(p.Palette))
+		}
+		for i := 0; i < len(src.Pix); i += 4 {
+			if transparent := src.Pix[i+3] < 0x80; transparent != (codec.ToNRGBA(m).Pix[i+3] == 0) {
+				t.Fatalf("%v: pixel %v expected transparent %v", opts, i/4, transparent)
+			}
+		}
+	}
+
+	if _, err = enc.Resolve(codec.Options{"Colors": "300"}); !errors.Is(err, codec.ErrInvalidOption) {
+		t.Fatalf("expected %v, got %v", codec.ErrInvalidOption, err)
+	}
+}
+
+func TestGIFFrames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "codec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "anim.gif")
+
+	frames := []codec.Frame{
+		{Image: gradient(6, 4, true), Delay: 5},
+		{Image: gradient(6, 4, false)},
+		{Image: image.NewNRGBA(image.Rect(0, 0, 6, 4)), Delay: 50},
+	}
+	opts := codec.Options{"Palette": "octree", "Delay": "20", "Loops": "3"}
+	if err = codec.EncodeFramesFile(path, frames, opts); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	anim, err := gif.DecodeAll(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if anim.LoopCount != 2 {
+		t.Fatalf("expected loop count 2, got %v", anim.LoopCount)
+	}
+
+	decoded, err := codec.DecodeFrames(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != len(frames) {
+		t.Fatalf("expected %v frames, got %v", len(frames), len(decoded))
+	}
+	for i, delay := range []int{5, 20, 50} {
+		if decoded[i].Delay != delay {
+			t.Fatalf("frame %v: expected delay %v, got %v", i, delay, decoded[i].Delay)
+		}
+		src := frames[i].Image
+		for j := 3; j < len(src.Pix); j += 4 {
+			// background disposal keeps earlier frames out of transparent areas
+			if transparent := src.Pix[j] < 0x80; transparent != (decoded[i].Image.Pix[j] == 0) {
+				t.Fatalf("frame %v: pixel %v expected transparent %v", i, j/4, transparent)
+			}
+		}
+	}
+
+	if err = codec.EncodeFramesFile(filepath.Join(dir, "a.png"), frames, nil); !errors.Is(err, codec.ErrUnknownFormat) {
+		t.Fatalf("expected %v, got %v", codec.ErrUnknownFormat, err)
+	}
+}
+
+func TestGIFDisposal(t *testing.T) {
+	p := color.Palette{color.NRGBA{}, color.NRGBA{R: 0xFF, A: 0xFF}, color.NRGBA{B: 0xFF, A: 0xFF}}
+	fill := func(r image.Rectangle, i uint8) *image.Paletted {
+		img := image.NewPaletted(r, p)
+		for j := range img.Pix {
+			img.Pix[j] = i
+		}
+		return img
+	}
+	anim := &gif.GIF{
+		Image: []*image.Paletted{
+			fill(image.Rect(0, 0, 4, 1), 1),
+			fill(image.Rect(0, 0, 1, 1), 2),
+			fill(image.Rect(1, 0, 2, 1), 2),
+			fill(image.Rect(3, 0, 4, 1), 0),
+		},
+		Delay:    []int{0, 0, 0, 0},
+		Disposal: []byte{gif.DisposalNone, gif.DisposalPrevious, gif.DisposalBackground, gif.DisposalNone},
+		Config:   image.Config{Width: 4, Height: 1},
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "codec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "disposal.gif")
+	if err = ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	frames, err := codec.DecodeFrames(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// r is red, b is blue and . is transparent
+	expected := []string{"rrrr", "brrr", "rbrr", "r.rr"}
+	for i, f := range frames {
+		var row string
+		for x := 0; x < 4; x++ {
+			switch c := f.Image.NRGBAAt(x, 0); {
+			case c.A == 0:
+				row += "."
+			case c.R == 0xFF:
+				row += "r"
+			default:
+				row += "b"
+			}
+		}
+		if row != expected[i] {
+			t.Fatalf("frame %v: expected %v, got %v", i, expected[i], row)
+		}
+	}
+}
+
+func TestORA(t *testing.T) {
+	doc := &codec.Layered{
+		Width:  8,
+		Height: 6,
+		Layers: []codec.Layer{
+			{Name: "bottom", Image: gradient(8, 6, false), Opacity: 1, Composite: codec.CompositeSrcOver},
+			{Name: "middle", Image: gradient(3, 2, true), Offset: image.Pt(-1, 4), Opacity: 0.5, Composite: "svg:multiply"},
+			{Name: "top", Image: gradient(2, 2, false), Offset: image.Pt(2, 1), Opacity: 1, Hidden: true, Composite: codec.CompositeSrcOver},
+		},
+	}
+	var buf bytes.Buffer
+	if err := codec.EncodeORA(&buf, doc, nil); err != nil {
+		t.Fatal(err)
+	}
+	actual, err := codec.DecodeORA(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(doc, actual) {
+		t.Fatalf("expected %+v, got %+v", doc, actual)
+	}
+
+	d, err := codec.DecoderFor("a.ora")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := d.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(codec.ToNRGBA(img), codec.Flatten(doc)) {
+		t.Fatal("merged image differs from flattened layers")
+	}
+}
+
+func TestORAStacks(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	add := func(name string, data []byte) {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var px bytes.Buffer
+	if err := png.Encode(&px, gradient(1, 1, false)); err != nil {
+		t.Fatal(err)
+	}
+	add("stack.xml", []byte(`<image w="4" h="4"><stack>
+		<layer name="a" src="a.png" x="1" y="1"/>
+		<stack name="group" x="1" y="2" opacity="0.5" visibility="hidden">
+			<layer name="b" src="a.png" opacity="0.5"/>
+		</stack>
+	</stack></image>`))
+	add("a.png", px.Bytes())
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := codec.DecodeORA(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Layers) != 2 {
+		t.Fatalf("expected 2 layers, got %v", len(doc.Layers))
+	}
+	b, a := doc.Layers[0], doc.Layers[1]
+	if a.Name != "a" || a.Offset != image.Pt(1, 1) || a.Opacity != 1 || a.Hidden || a.Composite != codec.CompositeSrcOver {
+		t.Fatalf("unexpected top layer %+v", a)
+	}
+	if b.Name != "b" || b.Offset != image.Pt(1, 2) || b.Opacity != 0.25 || !b.Hidden {
+		t.Fatalf("unexpected grouped layer %+v", b)
+	}
+}
+
+// psdTestLayer describes a layer record of a generated Photoshop document
+type psdTestLayer struct {
+	name, unicode, blend     string
+	rect                     image.Rectangle
+	opacity, clipping, flags uint8
+	section                  uint32
+	img                      *image.NRGBA
+	rle                      bool
+}
+
+// buildPSD writes an 8 bit RGB Photoshop document with the layers, bottom
+// first, and composite
+func buildPSD(t *testing.T, layers []psdTestLayer, composite *image.NRGBA) []byte {
+	be := binary.BigEndian
+	put := func(buf *bytes.Buffer, vs ...interface{}) {
+		for _, v := range vs {
+			if err := binary.Write(buf, be, v); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	var records, pixels bytes.Buffer
+	put(&records, int16(len(layers)))
+	for _, l := range layers {
+		put(&records, int32(l.rect.Min.Y), int32(l.rect.Min.X), int32(l.rect.Max.Y), int32(l.rect.Max.X))
+		if l.img == nil {
+			put(&records, uint16(0))
+		} else {
+			put(&records, uint16(4))
+			w, h := l.img.Rect.Dx(), l.img.Rect.Dy()
+			for _, id := range []int16{-1, 0, 1, 2} {
+				c := int(id)
+				if id < 0 {
+					c = 3
+				}
+				var ch bytes.Buffer
+				if l.rle {
+					put(&ch, uint16(1))
+					for y := 0; y < h; y++ {
+						put(&ch, uint16(w+1))
+					}
+				} else {
+					put(&ch, uint16(0))
+				}
+				for y := 0; y < h; y++ {
+					if l.rle {
+						ch.WriteByte(byte(w - 1))
+					}
+					for x := 0; x < w; x++ {
+						ch.WriteByte(l.img.Pix[l.img.PixOffset(x, y)+c])
+					}
+				}
+				put(&records, id, uint32(ch.Len()))
+				pixels.Write(ch.Bytes())
+			}
+		}
+		records.WriteString("8BIM" + l.blend)
+		put(&records, l.opacity, l.clipping, l.flags, uint8(0))
+
+		var extra bytes.Buffer
+		put(&extra, uint32(0), uint32(0), uint8(len(l.name)))
+		extra.WriteString(l.name)
+		// the name is padded to 4 bytes including its length
+		for (extra.Len()-8)%4 != 0 {
+			extra.WriteByte(0)
+		}
+		if l.unicode != "" {
+			units := utf16.Encode([]rune(l.unicode))
+			extra.WriteString("8BIMluni")
+			put(&extra, uint32(4+2*len(units)), uint32(len(units)), units)
+		}
+		if l.section != 0 {
+			extra.WriteString("8BIMlsct")
+			put(&extra, uint32(4), l.section)
+		}
+		put(&records, uint32(extra.Len()))
+		records.Write(extra.Bytes())
+	}
+	info := append(records.Bytes(), pixels.Bytes()...)
+	if len(info)%2 != 0 {
+		info = append(info, 0)
+	}
+
+	var out bytes.Buffer
+	out.WriteString("8BPS")
+	w, h := composite.Rect.Dx(), composite.Rect.Dy()
+	put(&out, uint16(1), [6]byte{}, uint16(3), uint32(h), uint32(w), uint16(8), uint16(3), uint32(0), uint32(0))
+	put(&out, uint32(4+len(info)+4), uint32(len(info)))
+	out.Write(info)
+	put(&out, uint32(0), uint16(0))
+	for c := 0; c < 3; c++ {
+		for i := c; i < len(composite.Pix); i += 4 {
+			out.WriteByte(composite.Pix[i])
+		}
+	}
+	return out.Bytes()
+}
+
+func TestDecodePSD(t *testing.T) {
+	a, b := gradient(2, 2, true), gradient(1, 2, false)
+	composite := gradient(4, 3, false)
+	for i := 3; i < len(composite.Pix); i += 4 {
+		composite.Pix[i] = 0xFF
+	}
+	layers := []psdTestLayer{
+		{name: "A", blend: "mul ", rect: image.Rect(1, 0, 3, 2), opacity: 0x80, img: a},
+		{name: "</Layer group>", blend: "norm", opacity: 0xFF, section: 3},
+		{name: "B", unicode: "Bé", blend: "norm", rect: image.Rect(0, 1, 1, 3), opacity: 0xFF, flags: 2, img: b, rle: true},
+		{name: "G", blend: "pass", opacity: 0x80, section: 1},
+	}
+	doc, err := codec.DecodePSD(bytes.NewReader(buildPSD(t, layers, composite)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &codec.Layered{Width: 4, Height: 3, Layers: []codec.Layer{
+		{Name: "A", Image: a, Offset: image.Pt(1, 0), Opacity: 128.0 / 255, Composite: "svg:multiply"},
+		{Name: "G/Bé", Image: b, Offset: image.Pt(0, 1), Opacity: 128.0 / 255, Hidden: true, Composite: codec.CompositeSrcOver},
+	}}
+	if !reflect.DeepEqual(doc, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, doc)
+	}
+
+	// clipping masks cannot be reproduced, so the composite is used
+	layers[0].clipping = 1
+	doc, err = codec.DecodePSD(bytes.NewReader(buildPSD(t, layers, composite)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Layers) != 1 || !reflect.DeepEqual(doc.Layers[0].Image, composite) {
+		t.Fatalf("expected the composite as the only layer, got %+v", doc.Layers)
+	}
+
+	if _, err = codec.DecodePSD(bytes.NewReader([]byte("8BPX"))); !errors.Is(err, codec.ErrInvalidPSD) {
+		t.Fatalf("expected %v, got %v", codec.ErrInvalidPSD, err)
+	}
+
+	// sizes above the limits of the format are rejected before allocating
+	for _, c := range []struct {
+		version, channels uint16
+		size              uint32
+	}{{1, 3, 0xFFFFFFF0}, {1, 3, 30001}, {2, 3, 0x7FFFFFFF}, {1, 60000, 20000}, {1, 56, 20000}, {1, 3, 20000}} {
+		var hdr bytes.Buffer
+		hdr.WriteString("8BPS")
+		for _, v := range []interface{}{c.version, [6]byte{}, c.channels, c.size, c.size, uint16(8), uint16(3), uint32(0), uint32(0), uint32(0), uint16(0)} {
+			_ = binary.Write(&hdr, binary.BigEndian, v)
+		}
+		if _, err = codec.DecodePSD(&hdr); !errors.Is(err, codec.ErrInvalidPSD) {
+			t.Errorf("version %v of %v channels %v pixels square: expected %v, got %v", c.version, c.channels, c.size, codec.ErrInvalidPSD, err)
+		}
+	}
+	huge := []psdTestLayer{{name: "huge", blend: "norm", rect: image.Rect(0, 0, 0x7FFFFFF0, 0x7FFFFFF0), opacity: 255}}
+	if _, err = codec.DecodePSD(bytes.NewReader(buildPSD(t, huge, composite))); !errors.Is(err, codec.ErrInvalidPSD) {
+		t.Fatalf("expected %v for a huge layer, got %v", codec.ErrInvalidPSD, err)
+	}
+	// layers that fit on their own but not together
+	big := psdTestLayer{name: "big", blend: "norm", rect: image.Rect(0, 0, 30000, 30000), opacity: 255}
+	if _, err = codec.DecodePSD(bytes.NewReader(buildPSD(t, []psdTestLayer{big, big}, composite))); !errors.Is(err, codec.ErrInvalidPSD) {
+		t.Fatalf("expected %v for too many pixels, got %v", codec.ErrInvalidPSD, err)
+	}
+
+	// lengths read from a mutated document are checked before allocating
+	data := buildPSD(t, layers, composite)
+	luni := bytes.Index(data, []byte("8BIMluni")) + 12
+	binary.BigEndian.PutUint32(data[luni:], 0xFFFFFFFF)
+	if _, err = codec.DecodePSD(bytes.NewReader(data)); !errors.Is(err, codec.ErrInvalidPSD) {
+		t.Errorf("expected %v for a long layer name, got %v", codec.ErrInvalidPSD, err)
+	}
+	data = buildPSD(t, layers, composite)
+	// the length of the first channel of the first layer
+	binary.BigEndian.PutUint32(data[26+4+4+4+2+16+2+2:], 0x7FFFFFFF)
+	if _, err = codec.DecodePSD(bytes.NewReader(data)); !errors.Is(err, codec.ErrInvalidPSD) {
+		t.Errorf("expected %v for a long channel, got %v", codec.ErrInvalidPSD, err)
+	}
+}
+
+func TestWriteLayers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "codec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	doc := &codec.Layered{Width: 4, Height: 3, Layers: []codec.Layer{
+		{Name: "sky/clouds", Image: gradient(2, 2, true), Offset: image.Pt(3, -1)},
+		{Name: "Sky_clouds", Image: gradient(1, 1, false)},
+		{Image: gradient(4, 3, false), Hidden: true},
+	}}
+	for _, c := range []struct {
+		opts     codec.SplitOptions
+		names    []string
+		expected image.Rectangle
+	}{
+		{codec.SplitOptions{}, []string{"001.png", "002.png", "003.png"}, image.Rect(0, 0, 2, 2)},
+		{codec.SplitOptions{Named: true, CanvasSize: true}, []string{"sky_clouds.png", "Sky_clouds-2.png", "003.png"}, image.Rect(0, 0, 4, 3)},
+	} {
+		paths, err := codec.WriteLayers(dir, doc, c.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, name := range c.names {
+			if filepath.Base(paths[i]) != name {
+				t.Fatalf("%+v: expected %v, got %v", c.opts, name, filepath.Base(paths[i]))
+			}
+		}
+		img, err := codec.DecodeFile(paths[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Rect != c.expected {
+			t.Fatalf("%+v: expected bounds %v, got %v", c.opts, c.expected, img.Rect)
+		}
+		if c.opts.CanvasSize {
+			// only the bottom left pixel of the layer is on the canvas
+			if expected, actual := doc.Layers[0].Image.NRGBAAt(0, 1), img.NRGBAAt(3, 0); expected != actual {
+				t.Fatalf("expected %v, got %v", expected, actual)
+			}
+		}
+	}
+}
+
+// exifWithOrientation returns a little endian EXIF structure holding only
+// an orientation
+func exifWithOrientation(o raster.Orientation) []byte {
+	exif := []byte("II*\x00\x08\x00\x00\x00\x01\x00")
+	entry := make([]byte, 12)
+	binary.LittleEndian.PutUint16(entry, 0x0112)
+	binary.LittleEndian.PutUint16(entry[2:], 3)
+	binary.LittleEndian.PutUint32(entry[4:], 1)
+	binary.LittleEndian.PutUint16(entry[8:], uint16(o))
+	return append(append(exif, entry...), 0, 0, 0, 0)
+}
+
+func TestMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "codec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	icc := make([]byte, 70000)
+	for i := range icc {
+		icc[i] = byte(i * 7)
+	}
+	m := codec.Metadata{
+		EXIF: exifWithOrientation(raster.OrientRotate90),
+		XMP:  []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"/>`),
+		ICC:  icc,
+	}
+	src := gradient(3, 2, false)
+	for _, name := range []string{"a.jpg", "a.png"} {
+		path := filepath.Join(dir, name)
+		if err = codec.EncodeFileMeta(path, src, codec.Options{"Quality": "100", "Chroma subsampling": "4:4:4"}, m); err != nil {
+			t.Fatal(err)
+		}
+		actual, err := codec.ReadMetadata(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(actual, m) {
+			t.Fatalf("%v: metadata differs after writing", name)
+		}
+
+		img, actual, err := codec.DecodeFileMeta(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Rect != image.Rect(0, 0, 2, 3) {
+			t.Fatalf("%v: expected the image turned upright, got bounds %v", name, img.Rect)
+		}
+		if o := actual.Orientation(); o != raster.OrientNormal {
+			t.Fatalf("%v: expected the orientation reset, got %v", name, o)
+		}
+		if m.Orientation() != raster.OrientRotate90 {
+			t.Fatal("resetting the orientation changed the original")
+		}
+
+		if err = codec.EncodeFileMeta(path, src, codec.Options{codec.MetadataOption: codec.MetadataStrip}, m); err != nil {
+			t.Fatal(err)
+		}
+		if actual, err = codec.ReadMetadata(path); err != nil || !actual.Empty() {
+			t.Fatalf("%v: expected no metadata, got %v", name, err)
+		}
+	}
+}
+
+func TestDeep(t *testing.T) {
+	dir, err := ioutil.TempDir("", "codec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := image.NewNRGBA64(image.Rect(0, 0, 5, 3))
+	for i := range src.Pix {
+		src.Pix[i] = byte(i*37 + 11)
+	}
+	for _, name := range []string{"a.png", "b.png", "a.tif"} {
+		opts := codec.Options{"Bit depth": "16"}
+		if name == "b.png" {
+			opts["Interlace"] = "adam7"
+		}
+		path := filepath.Join(dir, name)
+		if err = codec.EncodeFileDeep(path, src, opts, codec.Metadata{}); err != nil {
+			t.Fatal(err)
+		}
+		f, depth, _, err := codec.DecodeFileDeep(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if depth != raster.Depth16 {
+			t.Fatalf("%v: expected depth %v, got %v", name, raster.Depth16, depth)
+		}
+		if actual := f.NRGBA64(); !bytes.Equal(actual.Pix, src.Pix) {
+			t.Fatalf("%v: pixels differ after writing 16 bits", name)
+		}
+	}
+
+	path := filepath.Join(dir, "c.png")
+	if err = codec.EncodeFileDeep(path, src, codec.Options{"Bit depth": "8"}, codec.Metadata{}); err != nil {
+		t.Fatal(err)
+	}
+	f, depth, _, err := codec.DecodeFileDeep(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if depth != raster.Depth8 {
+		t.Fatalf("expected depth %v, got %v", raster.Depth8, depth)
+	}
+	expected := raster.FloatFrom(src).NRGBA()
+	if actual := f.NRGBA(); !bytes.Equal(actual.Pix, expected.Pix) {
+		t.Fatalf("expected rounded pixels %v, got %v", expected.Pix, actual.Pix)
+	}
+}
+
+func TestWriteSheet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "codec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	frames := []codec.SheetFrame{
+		{Name: "walk", Image: gradient(3, 2, false)},
+		{Name: "jump", Image: gradient(2, 4, true)},
+	}
+	path := filepath.Join(dir, "sheet.png")
+	atlasPath, err := codec.WriteSheet(path, frames, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(atlasPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var atlas codec.Atlas
+	if err = json.Unmarshal(data, &atlas); err != nil {
+		t.Fatal(err)
+	}
+	sheet, err := codec.DecodeFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if atlas.Meta.Image != "sheet.png" || atlas.Meta.Size != (codec.AtlasSize{W: sheet.Rect.Dx(), H: sheet.Rect.Dy()}) {
+		t.Fatalf("unexpected atlas meta %+v for a %v sheet", atlas.Meta, sheet.Rect)
+	}
+	if len(atlas.Frames) != len(frames) {
+		t.Fatalf("expected %v frames, got %v", len(frames), len(atlas.Frames))
+	}
+	for i, f := range atlas.Frames {
+		if f.Filename != frames[i].Name {
+			t.Fatalf("expected frame %v named %q, got %q", i, frames[i].Name, f.Filename)
+		}
+		r := image.Rect(f.Frame.X, f.Frame.Y, f.Frame.X+f.Frame.W, f.Frame.Y+f.Frame.H)
+		if actual := raster.Crop(sheet, r); !reflect.DeepEqual(actual.Pix, frames[i].Image.Pix) {
+			t.Fatalf("frame %q differs in the sheet", f.Filename)
+		}
+	}
+}
+
+func TestPaletteFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "codec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := color.Palette{red, green, blue, white, black}
+	for _, ext := range codec.PaletteExtensions {
+		path := filepath.Join(dir, "p"+ext)
+		if err = codec.WritePaletteFile(path, p); err != nil {
+			t.Fatal(err)
+		}
+		actual, err := codec.ReadPaletteFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(actual, p) {
+			t.Fatalf("%v: expected %v, got %v", ext, p, actual)
+		}
+	}
+
+	// ACT files mark a transparent entry
+	path := filepath.Join(dir, "t.act")
+	if err = codec.WritePaletteFile(path, color.Palette{red, color.NRGBA{}}); err != nil {
+		t.Fatal(err)
+	}
+	if actual, err := codec.ReadPaletteFile(path); err != nil || !reflect.DeepEqual(actual, color.Palette{red, color.NRGBA{}}) {
+		t.Fatalf("expected a transparent entry, got %v, %v", actual, err)
+	}
+
+	// binary RIFF palettes share the .pal extension
+	var riff bytes.Buffer
+	riff.WriteString("RIFF")
+	binary.Write(&riff, binary.LittleEndian, uint32(4+8+4+2*4))
+	riff.WriteString("PAL data")
+	binary.Write(&riff, binary.LittleEndian, uint32(4+2*4))
+	riff.Write([]byte{0, 3, 2, 0, 0xFF, 0, 0, 0, 0, 0, 0xFF, 0})
+	path = filepath.Join(dir, "riff.pal")
+	if err = ioutil.WriteFile(path, riff.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if actual, err := codec.ReadPaletteFile(path); err != nil || !reflect.DeepEqual(actual, color.Palette{red, blue}) {
+		t.Fatalf("expected red and blue, got %v, %v", actual, err)
+	}
+
+	path = filepath.Join(dir, "bad.gpl")
+	if err = ioutil.WriteFile(path, []byte("not a palette"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = codec.ReadPaletteFile(path); !errors.Is(err, codec.ErrInvalidPalette) {
+		t.Fatalf("expected %v, got %v", codec.ErrInvalidPalette, err)
+	}
+}
+
+func TestEncodeIndexed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "codec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	img := image.NewPaletted(image.Rect(0, 0, 4, 3), color.Palette{red, green, blue, color.NRGBA{}})
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i % 4)
+	}
+	for _, name := range []string{"a.png", "a.gif"} {
+		path := filepath.Join(dir, name)
+		if err = codec.EncodeFileIndexed(path, img, nil, codec.Metadata{}); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, _, err := image.Decode(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, ok := decoded.(*image.Paletted)
+		if !ok {
+			t.Fatalf("%v: expected a paletted image, got %T", name, decoded)
+		}
+		if !reflect.DeepEqual(actual.Pix, img.Pix) || len(actual.Palette) < len(img.Palette) {
+			t.Fatalf("%v: indices differ after writing", name)
+		}
+		for i, c := range img.Palette {
+			if color.NRGBAModel.Convert(actual.Palette[i]) != color.NRGBAModel.Convert(c) {
+				t.Fatalf("%v: palette entry %v is %v, expected %v", name, i, actual.Palette[i], c)
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package codec
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"io/ioutil"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+)
+
+// BitDepthOption is the name of the option of formats that choose how many
+// bits per channel they write
+const BitDepthOption = "Bit depth"
+
+// SourceDepth returns the depth that holds every bit of the colors of img.
+func SourceDepth(img image.Image) raster.Depth {
+	switch img.(type) {
+	case *image.NRGBA64, *image.RGBA64, *image.Gray16, *raster.Float:
+		return raster.Depth16
+	}
+	return raster.Depth8
+}
+
+// DecodeFileDeep reads the image at path like DecodeFileMeta, keeping up to
+// 16 bits per channel, and returns the depth of the stored colors.
+func DecodeFileDeep(path string) (*raster.Float, raster.Depth, Metadata, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, 0, Metadata{}, err
+	}
+	return DecodeDeep(path, data)
+}
+
+// DecodeDeep is DecodeFileDeep for the contents of a file named name, such
+// as one embedded in a project.
+func DecodeDeep(name string, data []byte) (*raster.Float, raster.Depth, Metadata, error) {
+	img, err := decodeReader(name, bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, Metadata{}, err
+	}
+	f := raster.FloatFrom(img)
+	f.Rect = f.Rect.Sub(f.Rect.Min)
+	m, err := parseMetadata(name, data)
+	if err != nil {
+		log.Warn(err)
+		return f, SourceDepth(img), Metadata{}, nil
+	}
+	if o := m.Orientation(); o != raster.OrientNormal {
+		f = raster.ReorientFloat(f, o)
+		m.ResetOrientation()
+	}
+	return f, SourceDepth(img), m, nil
+}
+
+// EncodeFileDeep writes img to path like EncodeFileMeta, keeping more than
+// 8 bits per channel if the format and its options allow it.
+func EncodeFileDeep(path string, img *image.NRGBA64, opts Options, m Metadata) error {
+	e, err := EncoderFor(path)
+	if err != nil {
+		return err
+	}
+	if e.EncodeDeep == nil {
+		return EncodeFileMeta(path, narrow(img), opts, m)
+	}
+	if opts, err = e.Resolve(opts); err != nil {
+		return err
+	}
+	return writeEncoded(path, e, opts, m, func(w io.Writer) error {
+		return e.EncodeDeep(w, img, opts)
+	})
+}
+
+// widen converts img to 16 bits per channel by repeating each byte
+func widen(img *image.NRGBA) *image.NRGBA64 {
+	wide := image.NewNRGBA64(img.Rect)
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		src := img.Pix[img.PixOffset(img.Rect.Min.X, y):img.PixOffset(img.Rect.Max.X, y)]
+		dst := wide.Pix[wide.PixOffset(img.Rect.Min.X, y):]
+		for i, v := range src {
+			dst[i*2], dst[i*2+1] = v, v
+		}
+	}
+	return wide
+}
+
+// narrow rounds img to 8 bits per channel
+func narrow(img *image.NRGBA64) *image.NRGBA {
+	out := image.NewNRGBA(img.Rect)
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		src := img.Pix[img.PixOffset(img.Rect.Min.X, y):img.PixOffset(img.Rect.Max.X, y)]
+		dst := out.Pix[out.PixOffset(img.Rect.Min.X, y):]
+		for i := range dst[:len(src)/2] {
+			v := uint32(src[i*2])<<8 | uint32(src[i*2+1])
+			dst[i] = uint8((v*0xff + 0x7fff) / 0xffff)
+		}
+	}
+	return out
+}
@@ -0,0 +1,144 @@
+package codec
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+)
+
+// ErrInvalidOption indicates that an encoder option has an unusable value
+const ErrInvalidOption log.ConstErr = "invalid encoder option"
+
+// Option describes a setting the user chooses when writing a format.
+type Option struct {
+	Name string
+	// Choices lists the valid values. Options without choices take a whole
+	// number from Min to Max.
+	Choices  []string
+	Min, Max int
+	Default  string
+}
+
+// validate checks that v is an allowed value of the option
+func (o Option) validate(v string) error {
+	if len(o.Choices) > 0 {
+		for _, c := range o.Choices {
+			if c == v {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: %v must be one of %v, got %q", ErrInvalidOption, o.Name, strings.Join(o.Choices, ", "), v)
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < o.Min || n > o.Max {
+		return fmt.Errorf("%w: %v must be a number from %v to %v, got %q", ErrInvalidOption, o.Name, o.Min, o.Max, v)
+	}
+	return nil
+}
+
+// Options holds encoder option values by option name.
+type Options map[string]string
+
+// Int returns the named option as a number, or zero if it is not one.
+func (opts Options) Int(name string) int {
+	n, _ := strconv.Atoi(opts[name])
+	return n
+}
+
+// Encoder writes one image file format.
+type Encoder struct {
+	// Name is shown to the user, e.g. in file dialog filters.
+	Name string
+	// Extensions are the lower case file extensions, including the dot. The
+	// first is the preferred one.
+	Extensions []string
+	Options    []Option
+	// Encode is given a value for every option.
+	Encode func(w io.Writer, img *image.NRGBA, opts Options) error
+	// EncodeDeep, if set, writes an image of more than 8 bits per channel,
+	// keeping as many bits as the options allow.
+	EncodeDeep func(w io.Writer, img *image.NRGBA64, opts Options) error
+	// EncodeIndexed, if set, writes an image of palette indices as such.
+	EncodeIndexed func(w io.Writer, img *image.Paletted, opts Options) error
+	// EncodeFrames, if set, writes an animation of equally sized frames and
+	// is used when the FramesOption chooses FramesLayers.
+	EncodeFrames func(w io.Writer, frames []Frame, opts Options) error
+	// Embed, if set, inserts metadata into an encoded file and is used when
+	// the MetadataOption chooses MetadataKeep.
+	Embed func(data []byte, m Metadata) ([]byte, error)
+}
+
+// Animates reports whether the resolved options ask for an animation.
+func (e Encoder) Animates(opts Options) bool {
+	return e.EncodeFrames != nil && opts[FramesOption] == FramesLayers
+}
+
+// Resolve returns a copy of opts with defaults for missing options, or an
+// error if any value is invalid.
+func (e Encoder) Resolve(opts Options) (Options, error) {
+	resolved := make(Options, len(e.Options))
+	for _, o := range e.Options {
+		v, ok := opts[o.Name]
+		if !ok {
+			v = o.Default
+		}
+		if err := o.validate(v); err != nil {
+			return nil, err
+		}
+		resolved[o.Name] = v
+	}
+	return resolved, nil
+}
+
+var encoders []Encoder
+
+// RegisterEncoder adds a format to the registry. A later registration takes
+// precedence for extensions claimed by an earlier one.
+func RegisterEncoder(e Encoder) {
+	encoders = append(encoders, e)
+}
+
+// Encoders returns the registered formats in registration order.
+func Encoders() []Encoder {
+	return append([]Encoder(nil), encoders...)
+}
+
+// EncoderFor returns the registered format for the extension of path.
+func EncoderFor(path string) (Encoder, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	for i := len(encoders) - 1; i >= 0; i-- {
+		for _, e := range encoders[i].Extensions {
+			if e == ext {
+				return encoders[i], nil
+			}
+		}
+	}
+	return Encoder{}, fmt.Errorf("%w: %v", ErrUnknownFormat, path)
+}
+
+// EncodeFile writes img to path in the format registered for its extension.
+// Missing options take their default values.
+func EncodeFile(path string, img *image.NRGBA, opts Options) error {
+	e, err := EncoderFor(path)
+	if err != nil {
+		return err
+	}
+	if opts, err = e.Resolve(opts); err != nil {
+		return err
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = e.Encode(out, img, opts); err != nil {
+		out.Close()
+		return fmt.Errorf("encoding %v: %w", path, err)
+	}
+	return out.Close()
+}
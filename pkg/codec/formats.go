@@ -0,0 +1,70 @@
+package codec
+
+import (
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+)
+
+func init() {
+	RegisterDecoder(Decoder{Name: "PNG", Extensions: []string{".png"}, Decode: png.Decode})
+	RegisterDecoder(Decoder{Name: "JPEG", Extensions: []string{".jpg", ".jpeg", ".jpe", ".jfif"}, Decode: jpeg.Decode})
+	RegisterDecoder(Decoder{Name: "GIF", Extensions: []string{".gif"}, Decode: gif.Decode, DecodeFrames: decodeGIFFrames})
+	RegisterDecoder(Decoder{Name: "BMP", Extensions: []string{".bmp", ".dib"}, Decode: bmp.Decode})
+	RegisterDecoder(Decoder{Name: "TIFF", Extensions: []string{".tif", ".tiff"}, Decode: tiff.Decode})
+	RegisterDecoder(Decoder{Name: "WebP", Extensions: []string{".webp"}, Decode: webp.Decode})
+	RegisterDecoder(Decoder{Name: "TGA", Extensions: []string{".tga", ".icb", ".vda", ".vst"}, Decode: DecodeTGA})
+	RegisterDecoder(Decoder{Name: "PNM", Extensions: []string{".pbm", ".pgm", ".ppm", ".pnm", ".pam"}, Decode: DecodePNM})
+	RegisterDecoder(Decoder{Name: "ICO", Extensions: []string{".ico", ".cur"}, Decode: DecodeICO})
+	RegisterDecoder(Decoder{Name: "OpenRaster", Extensions: []string{".ora"}, Decode: decodeORAMerged, DecodeLayers: DecodeORA})
+	RegisterDecoder(Decoder{Name: "Photoshop", Extensions: []string{".psd", ".psb"}, Decode: decodePSDComposite, DecodeLayers: decodePSDLayers})
+
+	RegisterEncoder(Encoder{Name: "PNG", Extensions: []string{".png"}, Options: pngOptions, Encode: encodePNG, EncodeDeep: encodePNGDeep, EncodeIndexed: encodePNGIndexed, Embed: embedPNG})
+	RegisterEncoder(Encoder{Name: "JPEG", Extensions: []string{".jpg", ".jpeg", ".jpe", ".jfif"}, Options: jpegOptions, Encode: encodeJPEG, Embed: embedJPEG})
+	RegisterEncoder(Encoder{Name: "BMP", Extensions: []string{".bmp", ".dib"}, Encode: encodeBMP})
+	RegisterEncoder(Encoder{Name: "TIFF", Extensions: []string{".tif", ".tiff"}, Options: tiffOptions, Encode: encodeTIFF, EncodeDeep: encodeTIFFDeep})
+	RegisterEncoder(Encoder{Name: "TGA", Extensions: []string{".tga"}, Options: tgaOptions, Encode: encodeTGA})
+	RegisterEncoder(Encoder{Name: "GIF", Extensions: []string{".gif"}, Options: gifOptions, Encode: encodeGIF, EncodeFrames: encodeGIFFrames, EncodeIndexed: encodeGIFIndexed})
+}
+
+// encodeBMP writes img as a 24 bit BMP if it is opaque, otherwise 32 bit
+func encodeBMP(w io.Writer, img *image.NRGBA, _ Options) error {
+	return bmp.Encode(w, img)
+}
+
+// tiffOptions are the settings of the TIFF encoder
+var tiffOptions = []Option{
+	{Name: "Compression", Choices: []string{"deflate", "none"}, Default: "deflate"},
+	{Name: BitDepthOption, Choices: []string{"8", "16"}, Default: "8"},
+}
+
+// encodeTIFF writes img as an RGBA TIFF
+func encodeTIFF(w io.Writer, img *image.NRGBA, opts Options) error {
+	if opts.Int(BitDepthOption) == 16 {
+		return encodeTIFFDeep(w, widen(img), opts)
+	}
+	return tiff.Encode(w, img, tiffSettings(opts))
+}
+
+// encodeTIFFDeep writes img as an RGBA TIFF of the bit depth in the options
+func encodeTIFFDeep(w io.Writer, img *image.NRGBA64, opts Options) error {
+	if opts.Int(BitDepthOption) == 16 {
+		return tiff.Encode(w, img, tiffSettings(opts))
+	}
+	return tiff.Encode(w, narrow(img), tiffSettings(opts))
+}
+
+// tiffSettings returns the image/tiff settings for the options
+func tiffSettings(opts Options) *tiff.Options {
+	compression := tiff.Deflate
+	if opts["Compression"] == "none" {
+		compression = tiff.Uncompressed
+	}
+	return &tiff.Options{Compression: compression}
+}
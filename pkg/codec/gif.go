@@ -0,0 +1,165 @@
+package codec
+
+import (
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+)
+
+// ErrNoFrames indicates that an animation without frames was given
+const ErrNoFrames log.ConstErr = "animation has no frames"
+
+// GIF option values
+const (
+	gifPaletteMedianCut     = "median cut"
+	gifPaletteOctree        = "octree"
+	gifPaletteWebSafe       = "web safe"
+	gifPalettePlan9         = "plan9"
+	gifDitherNone           = "none"
+	gifDitherFloydSteinberg = "floyd-steinberg"
+)
+
+// gifOptions are the settings of the GIF encoder. Delay applies to frames
+// without their own, and a loop count of zero repeats forever.
+var gifOptions = []Option{
+	{Name: FramesOption, Choices: []string{FramesFlatten, FramesLayers}, Default: FramesFlatten},
+	{Name: "Palette", Choices: []string{gifPaletteMedianCut, gifPaletteOctree, gifPaletteWebSafe, gifPalettePlan9}, Default: gifPaletteMedianCut},
+	{Name: "Colors", Min: 2, Max: 256, Default: "256"},
+	{Name: "Dither", Choices: []string{gifDitherNone, gifDitherFloydSteinberg}, Default: gifDitherNone},
+	{Name: "Delay", Min: 1, Max: 65535, Default: "10"},
+	{Name: "Loops", Min: 0, Max: 65535, Default: "0"},
+}
+
+// encodeGIF writes img as a single frame GIF. Pixels with an alpha below one
+// half become transparent.
+func encodeGIF(w io.Writer, img *image.NRGBA, opts Options) error {
+	return gif.Encode(w, gifPaletted(img, opts), nil)
+}
+
+// encodeGIFFrames writes an animated GIF with a local palette per frame.
+// Every frame replaces the previous one, so transparent pixels stay
+// transparent.
+func encodeGIFFrames(w io.Writer, frames []Frame, opts Options) error {
+	if len(frames) == 0 {
+		return ErrNoFrames
+	}
+	anim := &gif.GIF{LoopCount: gifLoopCount(opts.Int("Loops"))}
+	for _, f := range frames {
+		delay := f.Delay
+		if delay <= 0 {
+			delay = opts.Int("Delay")
+		}
+		anim.Image = append(anim.Image, gifPaletted(f.Image, opts))
+		anim.Delay = append(anim.Delay, delay)
+		anim.Disposal = append(anim.Disposal, gif.DisposalBackground)
+	}
+	return gif.EncodeAll(w, anim)
+}
+
+// gifLoopCount converts a number of plays, zero meaning forever, to the loop
+// count of image/gif, which counts repeats and uses -1 for a single play
+func gifLoopCount(loops int) int {
+	switch loops {
+	case 0:
+		return 0
+	case 1:
+		return -1
+	default:
+		return loops - 1
+	}
+}
+
+// gifPaletted reduces img to the palette chosen by the options, reserving
+// an entry for transparency if any pixel needs it
+func gifPaletted(img *image.NRGBA, opts Options) *image.Paletted {
+	transparent := !img.Opaque()
+	n := opts.Int("Colors")
+	if transparent {
+		n--
+	}
+	var p color.Palette
+	switch opts["Palette"] {
+	case gifPaletteWebSafe:
+		p = append(p, palette.WebSafe...)
+	case gifPalettePlan9:
+		p = append(p, palette.Plan9...)
+	case gifPaletteOctree:
+		p = raster.Octree(img, n)
+	default:
+		p = raster.MedianCut(img, n)
+	}
+	if transparent {
+		if len(p) >= 256 {
+			p = p[:255]
+		}
+		p = append(p, color.NRGBA{})
+	}
+	if len(p) == 0 {
+		// gif requires a non-empty palette
+		p = append(p, color.NRGBA{A: 0xFF})
+	}
+	return raster.ToPaletted(img, p, opts["Dither"] == gifDitherFloydSteinberg)
+}
+
+// encodeGIFIndexed writes img as a single frame GIF with its own palette,
+// ignoring the palette options
+func encodeGIFIndexed(w io.Writer, img *image.Paletted, _ Options) error {
+	if len(img.Palette) == 0 || len(img.Palette) > 256 {
+		return ErrPaletteSize
+	}
+	return gif.Encode(w, img, nil)
+}
+
+// decodeGIFFrames reads every frame of a GIF composited onto its logical
+// screen, honoring the disposal method of the frame before
+func decodeGIFFrames(r io.Reader) ([]Frame, error) {
+	anim, err := gif.DecodeAll(r)
+	if err != nil {
+		return nil, err
+	}
+	bounds := image.Rect(0, 0, anim.Config.Width, anim.Config.Height)
+	for _, img := range anim.Image {
+		bounds = bounds.Union(img.Rect)
+	}
+	screen := image.NewNRGBA(bounds)
+	var restore *image.NRGBA
+	frames := make([]Frame, 0, len(anim.Image))
+	for i, img := range anim.Image {
+		disposal := byte(gif.DisposalNone)
+		if i < len(anim.Disposal) {
+			disposal = anim.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious {
+			restore = copyNRGBA(screen)
+		}
+		draw.Draw(screen, img.Rect, img, img.Rect.Min, draw.Over)
+
+		frame := Frame{Image: ToNRGBA(copyNRGBA(screen))}
+		if i < len(anim.Delay) {
+			frame.Delay = anim.Delay[i]
+		}
+		frames = append(frames, frame)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			// browsers clear to transparent rather than the background color
+			draw.Draw(screen, img.Rect, image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			screen = restore
+		}
+	}
+	return frames, nil
+}
+
+// copyNRGBA returns a copy of img with the same bounds
+func copyNRGBA(img *image.NRGBA) *image.NRGBA {
+	dst := image.NewNRGBA(img.Rect)
+	copy(dst.Pix, img.Pix)
+	return dst
+}
@@ -0,0 +1,158 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+)
+
+// ErrInvalidICO indicates that a file is not a supported icon or cursor
+const ErrInvalidICO log.ConstErr = "invalid ICO image"
+
+// ICO layout constants
+const (
+	icoHeaderSize   = 6
+	icoEntrySize    = 16
+	dibHeaderSize   = 40
+	icoTypeIcon     = 1
+	icoTypeCursor   = 2
+	icoMaxDimension = 256
+)
+
+// pngMagic starts every PNG file, including PNG images embedded in icons
+var pngMagic = []byte("\x89PNG\r\n\x1a\n")
+
+// DecodeICO reads the largest image of a Windows icon or cursor file. Both
+// embedded PNG images and bitmaps with 1, 4, 8, 24 or 32 bits per pixel are
+// supported.
+func DecodeICO(r io.Reader) (image.Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < icoHeaderSize {
+		return nil, fmt.Errorf("%w: short header", ErrInvalidICO)
+	}
+	kind := binary.LittleEndian.Uint16(data[2:])
+	count := int(binary.LittleEndian.Uint16(data[4:]))
+	if binary.LittleEndian.Uint16(data) != 0 || (kind != icoTypeIcon && kind != icoTypeCursor) || count == 0 {
+		return nil, fmt.Errorf("%w: bad header", ErrInvalidICO)
+	}
+	if len(data) < icoHeaderSize+count*icoEntrySize {
+		return nil, fmt.Errorf("%w: short directory", ErrInvalidICO)
+	}
+
+	// pick the entry with the most pixels, then the most bits per pixel
+	best, bestArea, bestBits := -1, 0, 0
+	for i := 0; i < count; i++ {
+		e := data[icoHeaderSize+i*icoEntrySize:]
+		w, h := int(e[0]), int(e[1])
+		if w == 0 {
+			w = icoMaxDimension
+		}
+		if h == 0 {
+			h = icoMaxDimension
+		}
+		bits := int(binary.LittleEndian.Uint16(e[6:]))
+		if w*h > bestArea || (w*h == bestArea && bits > bestBits) {
+			best, bestArea, bestBits = i, w*h, bits
+		}
+	}
+	e := data[icoHeaderSize+best*icoEntrySize:]
+	size := int(binary.LittleEndian.Uint32(e[8:]))
+	offset := int(binary.LittleEndian.Uint32(e[12:]))
+	if offset < 0 || size < 0 || offset+size > len(data) || offset+size < offset {
+		return nil, fmt.Errorf("%w: image data out of range", ErrInvalidICO)
+	}
+	img := data[offset : offset+size]
+	if bytes.HasPrefix(img, pngMagic) {
+		return png.Decode(bytes.NewReader(img))
+	}
+	return decodeDIB(img)
+}
+
+// decodeDIB reads an icon bitmap: a BITMAPINFOHEADER, an optional palette,
+// the bottom up color pixels and a 1 bit transparency mask. The header height
+// covers both the pixels and the mask.
+func decodeDIB(data []byte) (image.Image, error) {
+	if len(data) < dibHeaderSize {
+		return nil, fmt.Errorf("%w: short bitmap header", ErrInvalidICO)
+	}
+	hdrSize := int(binary.LittleEndian.Uint32(data))
+	w := int(int32(binary.LittleEndian.Uint32(data[4:])))
+	h := int(int32(binary.LittleEndian.Uint32(data[8:]))) / 2
+	bits := int(binary.LittleEndian.Uint16(data[14:]))
+	compression := binary.LittleEndian.Uint32(data[16:])
+	colors := int(binary.LittleEndian.Uint32(data[32:]))
+	if hdrSize < dibHeaderSize || hdrSize > len(data) || w <= 0 || h <= 0 || w > icoMaxDimension || h > icoMaxDimension {
+		return nil, fmt.Errorf("%w: bad bitmap header", ErrInvalidICO)
+	}
+	// only BI_RGB is valid for icon bitmaps
+	if compression != 0 {
+		return nil, fmt.Errorf("%w: compressed bitmap", ErrInvalidICO)
+	}
+	switch bits {
+	case 1, 4, 8, 24, 32:
+	default:
+		return nil, fmt.Errorf("%w: %v bits per pixel", ErrInvalidICO, bits)
+	}
+
+	pos := hdrSize
+	var palette [][4]uint8
+	if bits <= 8 {
+		if colors == 0 {
+			colors = 1 << uint(bits)
+		}
+		if pos+colors*4 > len(data) {
+			return nil, fmt.Errorf("%w: short palette", ErrInvalidICO)
+		}
+		palette = make([][4]uint8, colors)
+		for i := range palette {
+			p := data[pos+i*4:]
+			palette[i] = [4]uint8{p[2], p[1], p[0], 0xFF}
+		}
+		pos += colors * 4
+	}
+
+	// rows are padded to multiples of four bytes
+	stride := (w*bits + 31) / 32 * 4
+	maskStride := (w + 31) / 32 * 4
+	if pos+stride*h > len(data) {
+		return nil, fmt.Errorf("%w: short pixel data", ErrInvalidICO)
+	}
+	mask := data[pos+stride*h:]
+	hasMask := bits != 32 && len(mask) >= maskStride*h
+
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		row := data[pos+(h-1-y)*stride:]
+		for x := 0; x < w; x++ {
+			var c [4]uint8
+			switch bits {
+			case 32:
+				c = [4]uint8{row[x*4+2], row[x*4+1], row[x*4], row[x*4+3]}
+			case 24:
+				c = [4]uint8{row[x*3+2], row[x*3+1], row[x*3], 0xFF}
+			default:
+				perByte := 8 / bits
+				shift := uint(8 - bits - (x%perByte)*bits)
+				i := int(row[x/perByte]>>shift) & (1<<uint(bits) - 1)
+				if i >= len(palette) {
+					return nil, fmt.Errorf("%w: color index %v out of range", ErrInvalidICO, i)
+				}
+				c = palette[i]
+			}
+			if hasMask && mask[(h-1-y)*maskStride+x/8]&(0x80>>uint(x%8)) != 0 {
+				c[3] = 0
+			}
+			copy(img.Pix[img.PixOffset(x, y):], c[:])
+		}
+	}
+	return img, nil
+}
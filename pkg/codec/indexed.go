@@ -0,0 +1,26 @@
+package codec
+
+import (
+	"image"
+	"io"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+)
+
+// EncodeFileIndexed writes img to path like EncodeFileMeta, keeping the
+// palette if the format can store one.
+func EncodeFileIndexed(path string, img *image.Paletted, opts Options, m Metadata) error {
+	e, err := EncoderFor(path)
+	if err != nil {
+		return err
+	}
+	if e.EncodeIndexed == nil {
+		return EncodeFileMeta(path, raster.FromPaletted(img), opts, m)
+	}
+	if opts, err = e.Resolve(opts); err != nil {
+		return err
+	}
+	return writeEncoded(path, e, opts, m, func(w io.Writer) error {
+		return e.EncodeIndexed(w, img, opts)
+	})
+}
@@ -0,0 +1,389 @@
+package codec
+
+import (
+	"bufio"
+	"image"
+	"image/jpeg"
+	"io"
+	"math"
+)
+
+// JPEG chroma subsampling option values
+const (
+	jpegChroma420 = "4:2:0"
+	jpegChroma422 = "4:2:2"
+	jpegChroma444 = "4:4:4"
+)
+
+// jpegOptions are the settings of the JPEG encoder
+var jpegOptions = []Option{
+	{Name: "Quality", Min: 1, Max: 100, Default: "90"},
+	{Name: "Chroma subsampling", Choices: []string{jpegChroma420, jpegChroma422, jpegChroma444}, Default: jpegChroma420},
+	metadataOption,
+}
+
+// encodeJPEG writes img as a baseline JPEG. Transparent pixels are blended
+// onto black. image/jpeg only writes 4:2:0 subsampling, so other modes use
+// writeJPEG.
+func encodeJPEG(w io.Writer, img *image.NRGBA, opts Options) error {
+	quality := opts.Int("Quality")
+	switch opts["Chroma subsampling"] {
+	case jpegChroma422:
+		return writeJPEG(w, img, quality, 2)
+	case jpegChroma444:
+		return writeJPEG(w, img, quality, 1)
+	}
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+}
+
+// jpegUnscaledQuant are the example luminance and chrominance quantization
+// tables of the JPEG specification in zig-zag order
+var jpegUnscaledQuant = [2][64]int{
+	{
+		16, 11, 12, 14, 12, 10, 16, 14,
+		13, 14, 18, 17, 16, 19, 24, 40,
+		26, 24, 22, 22, 24, 49, 35, 37,
+		29, 40, 58, 51, 61, 60, 57, 51,
+		56, 55, 64, 72, 92, 78, 64, 68,
+		87, 69, 55, 56, 80, 109, 81, 87,
+		95, 98, 103, 104, 103, 62, 77, 113,
+		121, 112, 100, 120, 92, 101, 103, 99,
+	},
+	{
+		17, 18, 18, 24, 21, 24, 47, 26,
+		26, 47, 99, 66, 56, 66, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	},
+}
+
+// jpegUnzig maps zig-zag order to natural order within a block
+var jpegUnzig = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// jpegHuffman is a Huffman table given as the number of codes of each
+// length and the values in order of increasing code
+type jpegHuffman struct {
+	counts [16]byte
+	values []byte
+}
+
+// jpegHuffmanTables are the example luminance DC and AC, and chrominance DC
+// and AC tables of the JPEG specification
+var jpegHuffmanTables = [4]jpegHuffman{
+	{
+		[16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		[16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+		[]byte{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12,
+			0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+			0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16,
+			0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
+			0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+			0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79,
+			0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98,
+			0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+			0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4,
+			0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea,
+			0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+	{
+		[16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		[16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+		[]byte{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21,
+			0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91,
+			0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34,
+			0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38,
+			0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58,
+			0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78,
+			0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96,
+			0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4,
+			0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2,
+			0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9,
+			0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+}
+
+// jpegCode is a Huffman code of the given bit length
+type jpegCode struct {
+	bits uint32
+	len  uint
+}
+
+// codes returns the code of every value of the table
+func (h jpegHuffman) codes() [256]jpegCode {
+	var codes [256]jpegCode
+	code, k := uint32(0), 0
+	for n, count := range h.counts {
+		for i := 0; i < int(count); i++ {
+			codes[h.values[k]] = jpegCode{code, uint(n + 1)}
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return codes
+}
+
+// jpegCos holds C(u)/2 * cos((2x+1)uπ/16) for the forward DCT
+var jpegCos = func() (t [8][8]float64) {
+	for u := 0; u < 8; u++ {
+		c := 0.5
+		if u == 0 {
+			c = 0.5 / math.Sqrt2
+		}
+		for x := 0; x < 8; x++ {
+			t[u][x] = c * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16)
+		}
+	}
+	return t
+}()
+
+// jpegWriter writes the entropy coded segment of a JPEG
+type jpegWriter struct {
+	w     *bufio.Writer
+	acc   uint32
+	nbits uint
+	err   error
+}
+
+// writeBits writes the low n bits of v, stuffing a zero after 0xFF bytes
+func (jw *jpegWriter) writeBits(v uint32, n uint) {
+	jw.acc = jw.acc<<n | v&(1<<n-1)
+	jw.nbits += n
+	for jw.nbits >= 8 {
+		b := byte(jw.acc >> (jw.nbits - 8))
+		jw.nbits -= 8
+		jw.writeByte(b)
+		if b == 0xFF {
+			jw.writeByte(0)
+		}
+	}
+}
+
+func (jw *jpegWriter) writeByte(b byte) {
+	if jw.err == nil {
+		jw.err = jw.w.WriteByte(b)
+	}
+}
+
+func (jw *jpegWriter) write(p ...byte) {
+	if jw.err == nil {
+		_, jw.err = jw.w.Write(p)
+	}
+}
+
+// marker writes a segment marker followed by its length and the data
+func (jw *jpegWriter) marker(m byte, data []byte) {
+	jw.write(0xFF, m, byte((len(data)+2)>>8), byte(len(data)+2))
+	jw.write(data...)
+}
+
+// writeJPEG writes img as a baseline JPEG whose luma is sampled hs times as
+// often as the chroma horizontally
+func writeJPEG(w io.Writer, img *image.NRGBA, quality, hs int) error {
+	scale := 200 - quality*2
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+	var quant [2][64]float64
+	dqt := []byte{}
+	for t := range quant {
+		dqt = append(dqt, byte(t))
+		for i, v := range jpegUnscaledQuant[t] {
+			q := (v*scale + 50) / 100
+			if q < 1 {
+				q = 1
+			} else if q > 255 {
+				q = 255
+			}
+			quant[t][i] = float64(q)
+			dqt = append(dqt, byte(q))
+		}
+	}
+
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	jw := &jpegWriter{w: bufio.NewWriter(w)}
+	jw.write(0xFF, 0xD8)
+	jw.marker(0xDB, dqt)
+	jw.marker(0xC0, []byte{
+		8, byte(height >> 8), byte(height), byte(width >> 8), byte(width), 3,
+		1, byte(hs<<4 | 1), 0,
+		2, 0x11, 1,
+		3, 0x11, 1,
+	})
+	dht := []byte{}
+	var codes [4][256]jpegCode
+	for i, h := range jpegHuffmanTables {
+		// class in the high nibble, table id in the low nibble
+		dht = append(dht, byte(i%2<<4|i/2))
+		dht = append(dht, h.counts[:]...)
+		dht = append(dht, h.values...)
+		codes[i] = h.codes()
+	}
+	jw.marker(0xC4, dht)
+	jw.marker(0xDA, []byte{3, 1, 0x00, 2, 0x11, 3, 0x11, 0, 63, 0})
+
+	// ycc returns the luma and chroma of the pixel nearest to (x, y)
+	ycc := func(x, y int) (float64, float64, float64) {
+		if x >= width {
+			x = width - 1
+		}
+		if y >= height {
+			y = height - 1
+		}
+		p := img.Pix[img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y):]
+		a := float64(p[3]) / 255
+		r, g, b := float64(p[0])*a, float64(p[1])*a, float64(p[2])*a
+		return 0.299*r + 0.587*g + 0.114*b,
+			-0.168736*r - 0.331264*g + 0.5*b + 128,
+			0.5*r - 0.418688*g - 0.081312*b + 128
+	}
+
+	var pred [3]int
+	// block transforms, quantizes and encodes the 8x8 samples of component c
+	block := func(c int, samples *[64]float64) {
+		var rows, coef [64]float64
+		for y := 0; y < 8; y++ {
+			for u := 0; u < 8; u++ {
+				var s float64
+				for x := 0; x < 8; x++ {
+					s += (samples[y*8+x] - 128) * jpegCos[u][x]
+				}
+				rows[y*8+u] = s
+			}
+		}
+		for v := 0; v < 8; v++ {
+			for u := 0; u < 8; u++ {
+				var s float64
+				for y := 0; y < 8; y++ {
+					s += rows[y*8+u] * jpegCos[v][y]
+				}
+				coef[v*8+u] = s
+			}
+		}
+		t := 0
+		if c > 0 {
+			t = 1
+		}
+		dc, ac := &codes[t*2], &codes[t*2+1]
+		var zz [64]int
+		for i := range zz {
+			zz[i] = int(math.Round(coef[jpegUnzig[i]] / quant[t][i]))
+		}
+		emit := func(table *[256]jpegCode, run int, v int) {
+			n, bits := jpegMagnitude(v)
+			code := table[run<<4|int(n)]
+			jw.writeBits(code.bits, code.len)
+			jw.writeBits(bits, n)
+		}
+		emit(dc, 0, zz[0]-pred[c])
+		pred[c] = zz[0]
+		run := 0
+		for _, v := range zz[1:] {
+			if v == 0 {
+				run++
+				continue
+			}
+			for ; run > 15; run -= 16 {
+				jw.writeBits(ac[0xF0].bits, ac[0xF0].len)
+			}
+			emit(ac, run, v)
+			run = 0
+		}
+		if run > 0 {
+			jw.writeBits(ac[0].bits, ac[0].len)
+		}
+	}
+
+	var ys, cbs, crs [64]float64
+	for my := 0; my < height; my += 8 {
+		for mx := 0; mx < width; mx += 8 * hs {
+			for bx := 0; bx < hs; bx++ {
+				for j := 0; j < 8; j++ {
+					for i := 0; i < 8; i++ {
+						ys[j*8+i], _, _ = ycc(mx+bx*8+i, my+j)
+					}
+				}
+				block(0, &ys)
+			}
+			for j := 0; j < 8; j++ {
+				for i := 0; i < 8; i++ {
+					var cb, cr float64
+					for k := 0; k < hs; k++ {
+						_, b, r := ycc(mx+i*hs+k, my+j)
+						cb += b
+						cr += r
+					}
+					cbs[j*8+i], crs[j*8+i] = cb/float64(hs), cr/float64(hs)
+				}
+			}
+			block(1, &cbs)
+			block(2, &crs)
+		}
+	}
+	// pad the last byte with ones
+	if jw.nbits > 0 {
+		jw.writeBits(0xFF, 8-jw.nbits)
+	}
+	jw.write(0xFF, 0xD9)
+	if jw.err != nil {
+		return jw.err
+	}
+	return jw.w.Flush()
+}
+
+// jpegMagnitude returns the bit size category of v and the bits encoding it
+func jpegMagnitude(v int) (uint, uint32) {
+	a := v
+	if a < 0 {
+		a = -a
+		v--
+	}
+	n := uint(0)
+	for ; a > 0; a >>= 1 {
+		n++
+	}
+	return n, uint32(v) & (1<<n - 1)
+}
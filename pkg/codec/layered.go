@@ -0,0 +1,78 @@
+package codec
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"os"
+)
+
+// CompositeSrcOver is the composite operation of normally blended layers, as
+// named by OpenRaster
+const CompositeSrcOver = "svg:src-over"
+
+// Layered is a document of positioned layers, as stored by layered formats.
+type Layered struct {
+	Width, Height int
+	// Layers are in stack order, bottom first.
+	Layers []Layer
+}
+
+// Layer is one layer of a Layered document.
+type Layer struct {
+	Name  string
+	Image *image.NRGBA
+	// Offset is the position of the top left corner of Image on the canvas.
+	Offset  image.Point
+	Opacity float64
+	Hidden  bool
+	// Composite is the OpenRaster name of the blend operation.
+	Composite string
+}
+
+// Flatten composites the visible layers of doc with their opacity onto a
+// transparent canvas. Every composite operation is treated as source over.
+func Flatten(doc *Layered) *image.NRGBA {
+	dst := image.NewNRGBA(image.Rect(0, 0, doc.Width, doc.Height))
+	for _, l := range doc.Layers {
+		if l.Hidden || l.Image == nil {
+			continue
+		}
+		alpha := uint8(math.Round(math.Max(0, math.Min(1, l.Opacity)) * 0xFF))
+		if alpha == 0 {
+			continue
+		}
+		r := l.Image.Rect.Sub(l.Image.Rect.Min).Add(l.Offset)
+		mask := image.NewUniform(color.Alpha{A: alpha})
+		draw.DrawMask(dst, r, l.Image, l.Image.Rect.Min, mask, image.Point{}, draw.Over)
+	}
+	return dst
+}
+
+// DecodeLayersFile reads the layers of the file at path with the layered
+// format registered for its extension.
+func DecodeLayersFile(path string) (*Layered, error) {
+	d, err := DecoderFor(path)
+	if err != nil {
+		return nil, err
+	}
+	if d.DecodeLayers == nil {
+		return nil, fmt.Errorf("%w: %v has no layers", ErrUnknownFormat, d.Name)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	doc, err := d.DecodeLayers(f, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("decoding %v: %w", path, err)
+	}
+	return doc, nil
+}
@@ -0,0 +1,428 @@
+package codec
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+)
+
+// ErrMetadataTooLarge indicates that a metadata block does not fit the file
+// format
+const ErrMetadataTooLarge log.ConstErr = "metadata block too large"
+
+// Names of the option choosing whether formats that store metadata write
+// the metadata of the document back
+const (
+	MetadataOption = "Metadata"
+	MetadataKeep   = "keep"
+	MetadataStrip  = "strip"
+)
+
+// metadataOption is the metadata setting of formats that can store it
+var metadataOption = Option{Name: MetadataOption, Choices: []string{MetadataKeep, MetadataStrip}, Default: MetadataKeep}
+
+// Metadata holds the raw metadata blocks of an image file.
+type Metadata struct {
+	// EXIF is a TIFF structure, starting with its byte order mark.
+	EXIF []byte
+	// XMP is an XML packet.
+	XMP []byte
+	// ICC is a color profile.
+	ICC []byte
+}
+
+// Empty reports whether there are no metadata blocks.
+func (m Metadata) Empty() bool {
+	return len(m.EXIF) == 0 && len(m.XMP) == 0 && len(m.ICC) == 0
+}
+
+// Metadata block signatures
+var (
+	jpegEXIFSig = []byte("Exif\x00\x00")
+	jpegXMPSig  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	jpegICCSig  = []byte("ICC_PROFILE\x00")
+	pngXMPKey   = []byte("XML:com.adobe.xmp\x00")
+)
+
+// JPEG markers
+const (
+	jpegSOI  = 0xD8
+	jpegSOS  = 0xDA
+	jpegEOI  = 0xD9
+	jpegAPP0 = 0xE0
+	jpegAPP1 = 0xE1
+	jpegAPP2 = 0xE2
+	// jpegMaxSegment is the most data a segment holds after its length
+	jpegMaxSegment = 0xFFFF - 2
+)
+
+// exifOrientationTag is the EXIF tag of the orientation in the first IFD
+const exifOrientationTag = 0x0112
+
+// ReadMetadata returns the metadata blocks of the JPEG or PNG file at path.
+// Other formats have none.
+func ReadMetadata(path string) (Metadata, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Metadata{}, err
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return Metadata{}, err
+	}
+	return parseMetadata(path, data)
+}
+
+// parseMetadata returns the metadata blocks of the contents of the file
+// named name
+func parseMetadata(name string, data []byte) (Metadata, error) {
+	var m Metadata
+	var err error
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, jpegSOI}):
+		m, err = jpegMetadata(data)
+	case bytes.HasPrefix(data, pngMagic):
+		m, err = pngMetadata(data)
+	}
+	if err != nil {
+		return Metadata{}, fmt.Errorf("reading metadata of %v: %w", name, err)
+	}
+	return m, nil
+}
+
+// jpegMetadata collects the metadata segments before the image data
+func jpegMetadata(data []byte) (Metadata, error) {
+	var m Metadata
+	// ICC profiles may be split over numbered segments
+	var icc [][]byte
+	for pos := 2; pos+4 <= len(data); {
+		if data[pos] != 0xFF {
+			return m, fmt.Errorf("%w: bad JPEG marker", ErrUnknownFormat)
+		}
+		marker := data[pos+1]
+		if marker == 0xFF {
+			// fill byte
+			pos++
+			continue
+		}
+		if marker == jpegSOS || marker == jpegEOI {
+			break
+		}
+		n := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if n < 2 || pos+2+n > len(data) {
+			return m, fmt.Errorf("%w: truncated JPEG segment", ErrUnknownFormat)
+		}
+		seg := data[pos+4 : pos+2+n]
+		pos += 2 + n
+		switch {
+		case marker == jpegAPP1 && bytes.HasPrefix(seg, jpegEXIFSig):
+			m.EXIF = append([]byte(nil), seg[len(jpegEXIFSig):]...)
+		case marker == jpegAPP1 && bytes.HasPrefix(seg, jpegXMPSig):
+			m.XMP = append([]byte(nil), seg[len(jpegXMPSig):]...)
+		case marker == jpegAPP2 && bytes.HasPrefix(seg, jpegICCSig) && len(seg) > len(jpegICCSig)+2:
+			seq, count := int(seg[len(jpegICCSig)]), int(seg[len(jpegICCSig)+1])
+			if icc == nil {
+				icc = make([][]byte, count)
+			}
+			if seq >= 1 && seq <= len(icc) {
+				icc[seq-1] = seg[len(jpegICCSig)+2:]
+			}
+		}
+	}
+	for _, chunk := range icc {
+		m.ICC = append(m.ICC, chunk...)
+	}
+	return m, nil
+}
+
+// pngMetadata collects the metadata chunks of a PNG
+func pngMetadata(data []byte) (Metadata, error) {
+	var m Metadata
+	for pos := len(pngMagic); pos+12 <= len(data); {
+		n := int(binary.BigEndian.Uint32(data[pos:]))
+		if n < 0 || pos+12+n > len(data) {
+			return m, fmt.Errorf("%w: truncated PNG chunk", ErrUnknownFormat)
+		}
+		name := string(data[pos+4 : pos+8])
+		chunk := data[pos+8 : pos+8+n]
+		pos += 12 + n
+		switch name {
+		case "eXIf":
+			m.EXIF = append([]byte(nil), chunk...)
+		case "iTXt":
+			if !bytes.HasPrefix(chunk, pngXMPKey) {
+				continue
+			}
+			// skip the compression flag and method, language and
+			// translated keyword
+			rest := chunk[len(pngXMPKey):]
+			if len(rest) < 2 || rest[0] != 0 {
+				continue
+			}
+			rest = rest[2:]
+			for i := 0; i < 2; i++ {
+				end := bytes.IndexByte(rest, 0)
+				if end < 0 {
+					return m, fmt.Errorf("%w: bad XMP chunk", ErrUnknownFormat)
+				}
+				rest = rest[end+1:]
+			}
+			m.XMP = append([]byte(nil), rest...)
+		case "iCCP":
+			end := bytes.IndexByte(chunk, 0)
+			if end < 0 || end+2 > len(chunk) {
+				return m, fmt.Errorf("%w: bad ICC chunk", ErrUnknownFormat)
+			}
+			zr, err := zlib.NewReader(bytes.NewReader(chunk[end+2:]))
+			if err != nil {
+				return m, err
+			}
+			if m.ICC, err = ioutil.ReadAll(zr); err != nil {
+				return m, err
+			}
+		case "IDAT", "IEND":
+			// metadata after the image data is not kept
+			return m, nil
+		}
+	}
+	return m, nil
+}
+
+// exifOrientation finds the orientation entry of the first IFD of an EXIF
+// structure, returning its offset and byte order
+func exifOrientation(exif []byte) (int, binary.ByteOrder, bool) {
+	if len(exif) < 8 {
+		return 0, nil, false
+	}
+	var order binary.ByteOrder
+	switch string(exif[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0, nil, false
+	}
+	ifd := int(order.Uint32(exif[4:]))
+	if ifd < 8 || ifd+2 > len(exif) {
+		return 0, nil, false
+	}
+	n := int(order.Uint16(exif[ifd:]))
+	for i := 0; i < n; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(exif) {
+			break
+		}
+		if order.Uint16(exif[entry:]) == exifOrientationTag {
+			// a SHORT value is stored at the start of the value field
+			return entry + 8, order, true
+		}
+	}
+	return 0, nil, false
+}
+
+// Orientation returns the EXIF orientation, or normal if there is none.
+func (m Metadata) Orientation() raster.Orientation {
+	off, order, ok := exifOrientation(m.EXIF)
+	if !ok {
+		return raster.OrientNormal
+	}
+	o := raster.Orientation(order.Uint16(m.EXIF[off:]))
+	if o < raster.OrientNormal || o > raster.OrientRotate270 {
+		return raster.OrientNormal
+	}
+	return o
+}
+
+// ResetOrientation sets the EXIF orientation to normal, for pixels that have
+// been reoriented.
+func (m *Metadata) ResetOrientation() {
+	if off, order, ok := exifOrientation(m.EXIF); ok {
+		m.EXIF = append([]byte(nil), m.EXIF...)
+		order.PutUint16(m.EXIF[off:], uint16(raster.OrientNormal))
+	}
+}
+
+// embedJPEG inserts the metadata segments after the start of image marker
+func embedJPEG(data []byte, m Metadata) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte{0xFF, jpegSOI}) {
+		return nil, fmt.Errorf("%w: not a JPEG stream", ErrUnknownFormat)
+	}
+	// keep a JFIF segment first, as readers expect
+	start := 2
+	if len(data) >= 6 && data[2] == 0xFF && data[3] == jpegAPP0 {
+		start = 4 + int(binary.BigEndian.Uint16(data[4:]))
+		if start > len(data) {
+			return nil, fmt.Errorf("%w: truncated JPEG segment", ErrUnknownFormat)
+		}
+	}
+	var buf bytes.Buffer
+	buf.Write(data[:start])
+	segment := func(marker byte, parts ...[]byte) error {
+		n := 0
+		for _, p := range parts {
+			n += len(p)
+		}
+		if n > jpegMaxSegment {
+			return fmt.Errorf("%w: %v bytes in a JPEG segment", ErrMetadataTooLarge, n)
+		}
+		buf.Write([]byte{0xFF, marker, byte((n + 2) >> 8), byte(n + 2)})
+		for _, p := range parts {
+			buf.Write(p)
+		}
+		return nil
+	}
+	if len(m.EXIF) > 0 {
+		if err := segment(jpegAPP1, jpegEXIFSig, m.EXIF); err != nil {
+			return nil, err
+		}
+	}
+	if len(m.XMP) > 0 {
+		if err := segment(jpegAPP1, jpegXMPSig, m.XMP); err != nil {
+			return nil, err
+		}
+	}
+	if len(m.ICC) > 0 {
+		size := jpegMaxSegment - len(jpegICCSig) - 2
+		count := (len(m.ICC) + size - 1) / size
+		if count > 0xFF {
+			return nil, fmt.Errorf("%w: %v byte ICC profile", ErrMetadataTooLarge, len(m.ICC))
+		}
+		for i := 0; i < count; i++ {
+			chunk := m.ICC[i*size:]
+			if len(chunk) > size {
+				chunk = chunk[:size]
+			}
+			if err := segment(jpegAPP2, jpegICCSig, []byte{byte(i + 1), byte(count)}, chunk); err != nil {
+				return nil, err
+			}
+		}
+	}
+	buf.Write(data[start:])
+	return buf.Bytes(), nil
+}
+
+// embedPNG inserts the metadata chunks after the header chunk
+func embedPNG(data []byte, m Metadata) ([]byte, error) {
+	// the signature is followed by the 13 byte header chunk
+	hdrEnd := len(pngMagic) + 12 + 13
+	if !bytes.HasPrefix(data, pngMagic) || len(data) < hdrEnd {
+		return nil, fmt.Errorf("%w: not a PNG stream", ErrUnknownFormat)
+	}
+	var buf bytes.Buffer
+	buf.Write(data[:hdrEnd])
+	if len(m.ICC) > 0 {
+		var z bytes.Buffer
+		z.WriteString("ICC profile\x00\x00")
+		zw := zlib.NewWriter(&z)
+		if _, err := zw.Write(m.ICC); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		if err := writeChunk(&buf, "iCCP", z.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+	if len(m.EXIF) > 0 {
+		if err := writeChunk(&buf, "eXIf", m.EXIF); err != nil {
+			return nil, err
+		}
+	}
+	if len(m.XMP) > 0 {
+		// uncompressed, with empty language and translated keyword
+		chunk := append(append(append([]byte(nil), pngXMPKey...), 0, 0, 0, 0), m.XMP...)
+		if err := writeChunk(&buf, "iTXt", chunk); err != nil {
+			return nil, err
+		}
+	}
+	buf.Write(data[hdrEnd:])
+	return buf.Bytes(), nil
+}
+
+// DecodeFileMeta reads the image at path like DecodeFile, along with its
+// metadata. Images are turned upright according to their EXIF orientation,
+// which is then reset.
+func DecodeFileMeta(path string) (*image.NRGBA, Metadata, error) {
+	img, err := DecodeFile(path)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	m, err := ReadMetadata(path)
+	if err != nil {
+		log.Warn(err)
+		return img, Metadata{}, nil
+	}
+	if o := m.Orientation(); o != raster.OrientNormal {
+		img = raster.Reorient(img, o)
+		m.ResetOrientation()
+	}
+	return img, m, nil
+}
+
+// EncodeFileMeta writes img to path like EncodeFile. The metadata is written
+// too if the format can store it and its options keep it.
+func EncodeFileMeta(path string, img *image.NRGBA, opts Options, m Metadata) error {
+	e, err := EncoderFor(path)
+	if err != nil {
+		return err
+	}
+	if opts, err = e.Resolve(opts); err != nil {
+		return err
+	}
+	if e.Embed == nil || opts[MetadataOption] != MetadataKeep || m.Empty() {
+		return EncodeFile(path, img, opts)
+	}
+	return writeEmbedded(path, e, m, func(w io.Writer) error {
+		return e.Encode(w, img, opts)
+	})
+}
+
+// writeEncoded writes the output of encode to path, embedding the metadata
+// if e can store it and the resolved options keep it
+func writeEncoded(path string, e Encoder, opts Options, m Metadata, encode func(io.Writer) error) error {
+	if e.Embed != nil && opts[MetadataOption] == MetadataKeep && !m.Empty() {
+		return writeEmbedded(path, e, m, encode)
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = encode(out); err != nil {
+		out.Close()
+		return fmt.Errorf("encoding %v: %w", path, err)
+	}
+	return out.Close()
+}
+
+// writeEmbedded writes the output of encode to path with the metadata
+// embedded by e
+func writeEmbedded(path string, e Encoder, m Metadata, encode func(io.Writer) error) error {
+	var buf bytes.Buffer
+	if err := encode(&buf); err != nil {
+		return fmt.Errorf("encoding %v: %w", path, err)
+	}
+	data, err := e.Embed(buf.Bytes(), m)
+	if err != nil {
+		return fmt.Errorf("encoding %v: %w", path, err)
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err = out.Write(data); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,269 @@
+package codec
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"path"
+	"strconv"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+)
+
+// ErrInvalidORA indicates that a file is not a readable OpenRaster archive
+const ErrInvalidORA log.ConstErr = "invalid OpenRaster file"
+
+// OpenRaster archive members
+const (
+	oraMimetype  = "image/openraster"
+	oraStackFile = "stack.xml"
+	oraMerged    = "mergedimage.png"
+	oraThumbnail = "Thumbnails/thumbnail.png"
+	// oraThumbnailSize is the largest side of the thumbnail
+	oraThumbnailSize = 256
+)
+
+// oraImage is the root element of stack.xml
+type oraImage struct {
+	XMLName xml.Name   `xml:"image"`
+	Version string     `xml:"version,attr"`
+	Width   int        `xml:"w,attr"`
+	Height  int        `xml:"h,attr"`
+	Stack   oraElement `xml:"stack"`
+}
+
+// oraElement is a layer or a stack of elements listed topmost first
+type oraElement struct {
+	XMLName    xml.Name
+	Name       string       `xml:"name,attr,omitempty"`
+	Src        string       `xml:"src,attr,omitempty"`
+	X          int          `xml:"x,attr"`
+	Y          int          `xml:"y,attr"`
+	Opacity    *string      `xml:"opacity,attr"`
+	Visibility string       `xml:"visibility,attr,omitempty"`
+	Composite  string       `xml:"composite-op,attr,omitempty"`
+	Children   []oraElement `xml:",any"`
+}
+
+// oraOpacity parses an opacity attribute, which defaults to opaque
+func oraOpacity(v *string) (float64, error) {
+	if v == nil {
+		return 1, nil
+	}
+	f, err := strconv.ParseFloat(*v, 64)
+	if err != nil || f < 0 || f > 1 {
+		return 0, fmt.Errorf("%w: opacity %q", ErrInvalidORA, *v)
+	}
+	return f, nil
+}
+
+// DecodeORA reads an OpenRaster archive. Layers of nested stacks are
+// flattened into the document, inheriting the offset, opacity and
+// visibility of their stacks.
+func DecodeORA(r io.ReaderAt, size int64) (*Layered, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidORA, err)
+	}
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	read := func(name string) ([]byte, error) {
+		f, ok := files[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: missing %v", ErrInvalidORA, name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return ioutil.ReadAll(rc)
+	}
+
+	data, err := read(oraStackFile)
+	if err != nil {
+		return nil, err
+	}
+	var root oraImage
+	if err = xml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidORA, err)
+	}
+	if root.Width <= 0 || root.Height <= 0 {
+		return nil, fmt.Errorf("%w: size %vx%v", ErrInvalidORA, root.Width, root.Height)
+	}
+	doc := &Layered{Width: root.Width, Height: root.Height}
+	var walk func(e oraElement, offset image.Point, opacity float64, hidden bool) error
+	walk = func(e oraElement, offset image.Point, opacity float64, hidden bool) error {
+		op, err := oraOpacity(e.Opacity)
+		if err != nil {
+			return err
+		}
+		offset = offset.Add(image.Pt(e.X, e.Y))
+		opacity *= op
+		hidden = hidden || e.Visibility == "hidden"
+		switch e.XMLName.Local {
+		case "stack":
+			// children are listed topmost first
+			for i := len(e.Children) - 1; i >= 0; i-- {
+				if err = walk(e.Children[i], offset, opacity, hidden); err != nil {
+					return err
+				}
+			}
+		case "layer":
+			data, err := read(e.Src)
+			if err != nil {
+				return err
+			}
+			img, err := png.Decode(bytes.NewReader(data))
+			if err != nil {
+				return fmt.Errorf("decoding %v: %w", e.Src, err)
+			}
+			composite := e.Composite
+			if composite == "" {
+				composite = CompositeSrcOver
+			}
+			doc.Layers = append(doc.Layers, Layer{
+				Name:      e.Name,
+				Image:     ToNRGBA(img),
+				Offset:    offset,
+				Opacity:   opacity,
+				Hidden:    hidden,
+				Composite: composite,
+			})
+		}
+		// other elements, such as text or filters, are not supported
+		return nil
+	}
+	if err = walk(root.Stack, image.Point{}, 1, false); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// EncodeORA writes doc as an OpenRaster archive with one PNG per layer. The
+// merged image and thumbnail are made from merged, or from flattening doc if
+// merged is nil.
+func EncodeORA(w io.Writer, doc *Layered, merged *image.NRGBA) error {
+	if merged == nil {
+		merged = Flatten(doc)
+	}
+	zw := zip.NewWriter(w)
+	// the mimetype comes first and uncompressed so it can be sniffed
+	mw, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if _, err = io.WriteString(mw, oraMimetype); err != nil {
+		return err
+	}
+	writePNG := func(name string, img image.Image) error {
+		fw, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		return png.Encode(fw, img)
+	}
+
+	root := oraImage{Version: "0.0.3", Width: doc.Width, Height: doc.Height}
+	for i := len(doc.Layers) - 1; i >= 0; i-- {
+		l := doc.Layers[i]
+		src := path.Join("data", fmt.Sprintf("layer%03d.png", i))
+		if err = writePNG(src, l.Image); err != nil {
+			return err
+		}
+		opacity := strconv.FormatFloat(l.Opacity, 'f', -1, 64)
+		visibility := "visible"
+		if l.Hidden {
+			visibility = "hidden"
+		}
+		composite := l.Composite
+		if composite == "" {
+			composite = CompositeSrcOver
+		}
+		root.Stack.Children = append(root.Stack.Children, oraElement{
+			XMLName:    xml.Name{Local: "layer"},
+			Name:       l.Name,
+			Src:        src,
+			X:          l.Offset.X,
+			Y:          l.Offset.Y,
+			Opacity:    &opacity,
+			Visibility: visibility,
+			Composite:  composite,
+		})
+	}
+	sw, err := zw.Create(oraStackFile)
+	if err != nil {
+		return err
+	}
+	if _, err = io.WriteString(sw, xml.Header); err != nil {
+		return err
+	}
+	if err = xml.NewEncoder(sw).Encode(root); err != nil {
+		return err
+	}
+
+	if err = writePNG(oraMerged, merged); err != nil {
+		return err
+	}
+	if err = writePNG(oraThumbnail, oraThumb(merged)); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// oraThumb scales img down to fit the thumbnail size, keeping its aspect
+func oraThumb(img *image.NRGBA) *image.NRGBA {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if w <= oraThumbnailSize && h <= oraThumbnailSize {
+		return img
+	}
+	if w > h {
+		w, h = oraThumbnailSize, h*oraThumbnailSize/w
+	} else {
+		w, h = w*oraThumbnailSize/h, oraThumbnailSize
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	return raster.Resize(img, w, h, raster.Bilinear)
+}
+
+// decodeORAMerged reads the merged image of an OpenRaster archive, flattening
+// the layers if the archive has none
+func decodeORAMerged(r io.Reader) (image.Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidORA, err)
+	}
+	for _, f := range zr.File {
+		if f.Name != oraMerged {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return png.Decode(rc)
+	}
+	doc, err := DecodeORA(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	return Flatten(doc), nil
+}
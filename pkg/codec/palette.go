@@ -0,0 +1,251 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+)
+
+// ErrInvalidPalette indicates that a palette file is malformed
+const ErrInvalidPalette log.ConstErr = "invalid palette file"
+
+// ErrPaletteSize indicates that a palette has no colors or more than an
+// indexed image can reference
+const ErrPaletteSize log.ConstErr = "palette must have 1 to 256 colors"
+
+// PaletteExtensions lists the extensions of the palette file formats, GIMP,
+// Adobe Color Table, JASC or RIFF palette and a list of hex colors.
+var PaletteExtensions = []string{".gpl", ".act", ".pal", ".hex"}
+
+// ReadPaletteFile reads the palette file at path in the format of its
+// extension. Only ACT files can mark a transparent entry.
+func ReadPaletteFile(path string) (color.Palette, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p color.Palette
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".gpl":
+		p, err = decodeGPL(data)
+	case ".act":
+		p, err = decodeACT(data)
+	case ".pal":
+		if bytes.HasPrefix(data, []byte("RIFF")) {
+			p, err = decodeRIFFPalette(data)
+		} else {
+			p, err = decodeJASC(data)
+		}
+	case ".hex":
+		p, err = decodeHexPalette(data)
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrUnknownFormat, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %v: %w", path, err)
+	}
+	if len(p) == 0 || len(p) > 256 {
+		return nil, fmt.Errorf("reading %v: %w, got %v", path, ErrPaletteSize, len(p))
+	}
+	return p, nil
+}
+
+// WritePaletteFile writes p to path in the format of its extension. PAL
+// files are written in the JASC format.
+func WritePaletteFile(path string, p color.Palette) error {
+	if len(p) == 0 || len(p) > 256 {
+		return fmt.Errorf("%w, got %v", ErrPaletteSize, len(p))
+	}
+	var buf bytes.Buffer
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".gpl":
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		fmt.Fprintf(&buf, "GIMP Palette\nName: %v\nColumns: 16\n#\n", name)
+		for _, c := range nrgbaColors(p) {
+			fmt.Fprintf(&buf, "%3d %3d %3d\t#%02x%02x%02x\n", c.R, c.G, c.B, c.R, c.G, c.B)
+		}
+	case ".act":
+		table := make([]byte, 772)
+		transparent := 0xFFFF
+		for i, c := range nrgbaColors(p) {
+			table[i*3], table[i*3+1], table[i*3+2] = c.R, c.G, c.B
+			if c.A == 0 && transparent == 0xFFFF {
+				transparent = i
+			}
+		}
+		binary.BigEndian.PutUint16(table[768:], uint16(len(p)))
+		binary.BigEndian.PutUint16(table[770:], uint16(transparent))
+		buf.Write(table)
+	case ".pal":
+		fmt.Fprintf(&buf, "JASC-PAL\r\n0100\r\n%v\r\n", len(p))
+		for _, c := range nrgbaColors(p) {
+			fmt.Fprintf(&buf, "%v %v %v\r\n", c.R, c.G, c.B)
+		}
+	case ".hex":
+		for _, c := range nrgbaColors(p) {
+			fmt.Fprintf(&buf, "%02x%02x%02x\n", c.R, c.G, c.B)
+		}
+	default:
+		return fmt.Errorf("%w: %v", ErrUnknownFormat, path)
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// nrgbaColors converts the colors of p to non-premultiplied RGBA
+func nrgbaColors(p color.Palette) []color.NRGBA {
+	colors := make([]color.NRGBA, len(p))
+	for i, c := range p {
+		colors[i] = color.NRGBAModel.Convert(c).(color.NRGBA)
+	}
+	return colors
+}
+
+// paletteLines returns the trimmed lines of a text palette that are not
+// empty or comments
+func paletteLines(data []byte) []string {
+	var lines []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line != "" && !strings.HasPrefix(line, "#") && !strings.HasPrefix(line, ";") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// parseRGB parses the first three whitespace separated numbers of line
+func parseRGB(line string) (color.NRGBA, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return color.NRGBA{}, fmt.Errorf("%w: expected red, green and blue in %q", ErrInvalidPalette, line)
+	}
+	var rgb [3]uint8
+	for i := range rgb {
+		v, err := strconv.ParseUint(fields[i], 10, 8)
+		if err != nil {
+			return color.NRGBA{}, fmt.Errorf("%w: %v", ErrInvalidPalette, err)
+		}
+		rgb[i] = uint8(v)
+	}
+	return color.NRGBA{R: rgb[0], G: rgb[1], B: rgb[2], A: 0xFF}, nil
+}
+
+// decodeGPL reads a GIMP palette
+func decodeGPL(data []byte) (color.Palette, error) {
+	lines := paletteLines(data)
+	if len(lines) == 0 || lines[0] != "GIMP Palette" {
+		return nil, fmt.Errorf("%w: missing GIMP Palette header", ErrInvalidPalette)
+	}
+	var p color.Palette
+	for _, line := range lines[1:] {
+		if strings.HasPrefix(line, "Name:") || strings.HasPrefix(line, "Columns:") {
+			continue
+		}
+		c, err := parseRGB(line)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, c)
+	}
+	return p, nil
+}
+
+// decodeJASC reads a JASC text palette
+func decodeJASC(data []byte) (color.Palette, error) {
+	lines := paletteLines(data)
+	if len(lines) < 3 || lines[0] != "JASC-PAL" {
+		return nil, fmt.Errorf("%w: missing JASC-PAL header", ErrInvalidPalette)
+	}
+	n, err := strconv.Atoi(lines[2])
+	if err != nil || n > len(lines)-3 {
+		return nil, fmt.Errorf("%w: bad color count %q", ErrInvalidPalette, lines[2])
+	}
+	p := make(color.Palette, n)
+	for i := range p {
+		if p[i], err = parseRGB(lines[3+i]); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// decodeRIFFPalette reads a binary RIFF palette
+func decodeRIFFPalette(data []byte) (color.Palette, error) {
+	if len(data) < 12 || string(data[8:12]) != "PAL " {
+		return nil, fmt.Errorf("%w: not a RIFF palette", ErrInvalidPalette)
+	}
+	for pos := 12; pos+8 <= len(data); {
+		id, size := string(data[pos:pos+4]), int(binary.LittleEndian.Uint32(data[pos+4:]))
+		body := data[pos+8:]
+		if size > len(body) {
+			break
+		}
+		body = body[:size]
+		if id == "data" && size >= 4 {
+			n := int(binary.LittleEndian.Uint16(body[2:]))
+			if 4+n*4 > len(body) {
+				break
+			}
+			p := make(color.Palette, n)
+			for i := range p {
+				e := body[4+i*4:]
+				p[i] = color.NRGBA{R: e[0], G: e[1], B: e[2], A: 0xFF}
+			}
+			return p, nil
+		}
+		pos += 8 + size + size%2
+	}
+	return nil, fmt.Errorf("%w: missing palette data", ErrInvalidPalette)
+}
+
+// decodeACT reads an Adobe Color Table. Tables without the trailing color
+// count hold 256 colors.
+func decodeACT(data []byte) (color.Palette, error) {
+	if len(data) != 768 && len(data) != 772 {
+		return nil, fmt.Errorf("%w: expected 768 or 772 bytes, got %v", ErrInvalidPalette, len(data))
+	}
+	n, transparent := 256, -1
+	if len(data) == 772 {
+		if count := int(binary.BigEndian.Uint16(data[768:])); count > 0 && count <= 256 {
+			n = count
+		}
+		if t := int(binary.BigEndian.Uint16(data[770:])); t < n {
+			transparent = t
+		}
+	}
+	p := make(color.Palette, n)
+	for i := range p {
+		p[i] = color.NRGBA{R: data[i*3], G: data[i*3+1], B: data[i*3+2], A: 0xFF}
+		if i == transparent {
+			p[i] = color.NRGBA{}
+		}
+	}
+	return p, nil
+}
+
+// decodeHexPalette reads a list of RRGGBB colors, one per line
+func decodeHexPalette(data []byte) (color.Palette, error) {
+	var p color.Palette
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimPrefix(strings.TrimSpace(sc.Text()), "#")
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+		v, err := strconv.ParseUint(line, 16, 32)
+		if err != nil || len(line) != 6 {
+			return nil, fmt.Errorf("%w: bad hex color %q", ErrInvalidPalette, line)
+		}
+		p = append(p, color.NRGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xFF})
+	}
+	return p, nil
+}
@@ -0,0 +1,228 @@
+package codec
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+)
+
+// PNG option values
+const (
+	pngCompressionDefault = "default"
+	pngCompressionNone    = "none"
+	pngCompressionFast    = "fast"
+	pngCompressionBest    = "best"
+	pngInterlaceNone      = "none"
+	pngInterlaceAdam7     = "adam7"
+)
+
+// pngOptions are the settings of the PNG encoder
+var pngOptions = []Option{
+	{Name: "Compression", Choices: []string{pngCompressionDefault, pngCompressionNone, pngCompressionFast, pngCompressionBest}, Default: pngCompressionDefault},
+	{Name: BitDepthOption, Choices: []string{"8", "16"}, Default: "8"},
+	{Name: "Interlace", Choices: []string{pngInterlaceNone, pngInterlaceAdam7}, Default: pngInterlaceNone},
+	metadataOption,
+}
+
+// pngLevels maps compression option values to image/png and zlib levels
+var pngLevels = map[string]struct {
+	png  png.CompressionLevel
+	zlib int
+}{
+	pngCompressionDefault: {png.DefaultCompression, zlib.DefaultCompression},
+	pngCompressionNone:    {png.NoCompression, zlib.NoCompression},
+	pngCompressionFast:    {png.BestSpeed, zlib.BestSpeed},
+	pngCompressionBest:    {png.BestCompression, zlib.BestCompression},
+}
+
+// encodePNG writes img as a PNG with the given options. Interlaced images
+// are written by writeInterlacedPNG since image/png does not support them.
+func encodePNG(w io.Writer, img *image.NRGBA, opts Options) error {
+	if opts.Int(BitDepthOption) == 16 || opts["Interlace"] == pngInterlaceAdam7 {
+		// 8 bit samples widen to 16 bits by repeating the byte
+		return encodePNGDeep(w, widen(img), opts)
+	}
+	return (&png.Encoder{CompressionLevel: pngLevels[opts["Compression"]].png}).Encode(w, img)
+}
+
+// encodePNGDeep writes img as a PNG of the bit depth in the options
+func encodePNGDeep(w io.Writer, img *image.NRGBA64, opts Options) error {
+	depth := opts.Int(BitDepthOption)
+	level := pngLevels[opts["Compression"]]
+	if opts["Interlace"] == pngInterlaceAdam7 {
+		return writeInterlacedPNG(w, img, depth, level.zlib)
+	}
+	enc := png.Encoder{CompressionLevel: level.png}
+	if depth == 16 {
+		return enc.Encode(w, img)
+	}
+	return enc.Encode(w, narrow(img))
+}
+
+// encodePNGIndexed writes img as a PNG with a palette. Interlaced images are
+// written in RGBA since writeInterlacedPNG does not support palettes.
+func encodePNGIndexed(w io.Writer, img *image.Paletted, opts Options) error {
+	if opts["Interlace"] == pngInterlaceAdam7 {
+		return encodePNG(w, raster.FromPaletted(img), opts)
+	}
+	return (&png.Encoder{CompressionLevel: pngLevels[opts["Compression"]].png}).Encode(w, img)
+}
+
+// adam7 lists the x and y start and step of each interlace pass
+var adam7 = [7][4]int{
+	{0, 0, 8, 8},
+	{4, 0, 8, 8},
+	{0, 4, 4, 8},
+	{2, 0, 4, 4},
+	{0, 2, 2, 4},
+	{1, 0, 2, 2},
+	{0, 1, 1, 2},
+}
+
+// PNG color type and interlace method for Adam7 RGBA images
+const (
+	pngColorRGBA  = 6
+	pngMethodAdam = 1
+)
+
+// writeInterlacedPNG writes img as an Adam7 interlaced RGBA PNG with 8 or 16
+// bits per channel
+func writeInterlacedPNG(w io.Writer, img *image.NRGBA64, depth, level int) error {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	var data bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&data, level)
+	if err != nil {
+		return err
+	}
+	bpp := 4 * depth / 8
+	for _, pass := range adam7 {
+		x0, y0, dx, dy := pass[0], pass[1], pass[2], pass[3]
+		pw := (width - x0 + dx - 1) / dx
+		if pw <= 0 || y0 >= height {
+			continue
+		}
+		prev := make([]byte, pw*bpp)
+		cur := make([]byte, pw*bpp)
+		for y := y0; y < height; y += dy {
+			for i, x := 0, x0; x < width; i, x = i+1, x+dx {
+				p := img.Pix[img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y):]
+				for c := 0; c < 4; c++ {
+					if depth == 16 {
+						cur[i*8+c*2], cur[i*8+c*2+1] = p[c*2], p[c*2+1]
+					} else {
+						cur[i*4+c] = uint8((uint32(p[c*2])<<8 | uint32(p[c*2+1]) + 0x80) / 0x101)
+					}
+				}
+			}
+			if _, err = zw.Write(filterRow(cur, prev, bpp)); err != nil {
+				return err
+			}
+			prev, cur = cur, prev
+		}
+	}
+	if err = zw.Close(); err != nil {
+		return err
+	}
+
+	if _, err = w.Write(pngMagic); err != nil {
+		return err
+	}
+	var ihdr [13]byte
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(width))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(height))
+	ihdr[8], ihdr[9], ihdr[12] = uint8(depth), pngColorRGBA, pngMethodAdam
+	for _, c := range []struct {
+		name string
+		data []byte
+	}{{"IHDR", ihdr[:]}, {"IDAT", data.Bytes()}, {"IEND", nil}} {
+		if err = writeChunk(w, c.name, c.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filterRow returns the filter type byte followed by the row filtered with
+// whichever PNG filter gives the smallest sum of absolute differences
+func filterRow(cur, prev []byte, bpp int) []byte {
+	var best []byte
+	bestSum := -1
+	for f := byte(0); f <= 4; f++ {
+		out := make([]byte, len(cur)+1)
+		out[0] = f
+		sum := 0
+		for i := range cur {
+			var a, c int
+			b := int(prev[i])
+			if i >= bpp {
+				a, c = int(cur[i-bpp]), int(prev[i-bpp])
+			}
+			var pred int
+			switch f {
+			case 1:
+				pred = a
+			case 2:
+				pred = b
+			case 3:
+				pred = (a + b) / 2
+			case 4:
+				pred = paeth(a, b, c)
+			}
+			v := cur[i] - byte(pred)
+			out[i+1] = v
+			if int8(v) < 0 {
+				sum -= int(int8(v))
+			} else {
+				sum += int(v)
+			}
+		}
+		if bestSum < 0 || sum < bestSum {
+			best, bestSum = out, sum
+		}
+	}
+	return best
+}
+
+// paeth returns whichever of a, b or c is closest to a + b - c
+func paeth(a, b, c int) int {
+	p := a + b - c
+	pa, pb, pc := abs(p-a), abs(p-b), abs(p-c)
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// writeChunk writes a PNG chunk with its length and checksum
+func writeChunk(w io.Writer, name string, data []byte) error {
+	var hdr [8]byte
+	binary.BigEndian.PutUint32(hdr[:4], uint32(len(data)))
+	copy(hdr[4:], name)
+	crc := crc32.NewIEEE()
+	crc.Write(hdr[4:])
+	crc.Write(data)
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	for _, b := range [][]byte{hdr[:], data, sum[:]} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package codec
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+)
+
+// ErrInvalidPNM indicates that a file is not a supported Netpbm image
+const ErrInvalidPNM log.ConstErr = "invalid PNM image"
+
+// PNM limits, bounding the memory a header can make the decoder allocate
+const (
+	pnmMaxDimension = 1 << 16
+	pnmMaxPixels    = 1 << 28
+)
+
+// DecodePNM reads a plain or raw PBM, PGM or PPM image, or a PAM image with
+// a grayscale or RGB tuple type, with or without alpha.
+func DecodePNM(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	var magic [2]byte
+	if _, err := io.ReadFull(br, magic[:]); err != nil {
+		return nil, pnmError(err)
+	}
+	if magic[0] != 'P' || magic[1] < '1' || magic[1] > '7' {
+		return nil, fmt.Errorf("%w: bad magic %q", ErrInvalidPNM, magic[:])
+	}
+	kind := magic[1] - '0'
+
+	var w, h, depth, maxVal int
+	var err error
+	if kind == 7 {
+		w, h, depth, maxVal, err = pamHeader(br)
+	} else {
+		depth, maxVal = 1, 1
+		if kind == 3 || kind == 6 {
+			depth = 3
+		}
+		fields := []*int{&w, &h, &maxVal}
+		if kind == 1 || kind == 4 {
+			fields = fields[:2]
+		}
+		// reading the last number also consumes the single whitespace
+		// character separating the header from raw data
+		for _, f := range fields {
+			if *f, err = pnmInt(br); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return nil, pnmError(err)
+	}
+	if w <= 0 || h <= 0 || w > pnmMaxDimension || h > pnmMaxDimension || w*h > pnmMaxPixels ||
+		maxVal <= 0 || maxVal > 0xFFFF || depth < 1 || depth > 4 {
+		return nil, fmt.Errorf("%w: %vx%v with depth %v and max value %v", ErrInvalidPNM, w, h, depth, maxVal)
+	}
+
+	// next returns the next sample of the image scaled to eight bits
+	var next func() (uint8, error)
+	scale := func(v int) (uint8, error) {
+		if v > maxVal {
+			return 0, fmt.Errorf("%w: sample %v above %v", ErrInvalidPNM, v, maxVal)
+		}
+		return uint8((v*255 + maxVal/2) / maxVal), nil
+	}
+	switch kind {
+	case 1:
+		next = func() (uint8, error) {
+			for {
+				c, err := br.ReadByte()
+				if err != nil {
+					return 0, err
+				}
+				switch c {
+				case '0':
+					return 0xFF, nil
+				case '1':
+					return 0, nil
+				case '#':
+					if _, err = br.ReadString('\n'); err != nil {
+						return 0, err
+					}
+				}
+			}
+		}
+	case 2, 3:
+		next = func() (uint8, error) {
+			v, err := pnmInt(br)
+			if err != nil {
+				return 0, err
+			}
+			return scale(v)
+		}
+	case 4:
+		// handled per row below because rows are padded to whole bytes
+	default:
+		// raw samples are one byte, or two big endian bytes above 255
+		next = func() (uint8, error) {
+			v, err := br.ReadByte()
+			if err != nil {
+				return 0, err
+			}
+			if maxVal < 0x100 {
+				return scale(int(v))
+			}
+			lo, err := br.ReadByte()
+			if err != nil {
+				return 0, err
+			}
+			return scale(int(v)<<8 | int(lo))
+		}
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	row := make([]byte, (w+7)/8)
+	for y := 0; y < h; y++ {
+		if kind == 4 {
+			if _, err := io.ReadFull(br, row); err != nil {
+				return nil, pnmError(err)
+			}
+		}
+		for x := 0; x < w; x++ {
+			var s [4]uint8
+			if kind == 4 {
+				if row[x/8]&(0x80>>uint(x%8)) == 0 {
+					s[0] = 0xFF
+				}
+			} else {
+				for c := 0; c < depth; c++ {
+					if s[c], err = next(); err != nil {
+						return nil, pnmError(err)
+					}
+				}
+			}
+			p := img.Pix[img.PixOffset(x, y):]
+			switch depth {
+			case 1:
+				p[0], p[1], p[2], p[3] = s[0], s[0], s[0], 0xFF
+			case 2:
+				p[0], p[1], p[2], p[3] = s[0], s[0], s[0], s[1]
+			case 3:
+				p[0], p[1], p[2], p[3] = s[0], s[1], s[2], 0xFF
+			case 4:
+				copy(p, s[:])
+			}
+		}
+	}
+	return img, nil
+}
+
+// pnmError wraps err in ErrInvalidPNM unless it already is one
+func pnmError(err error) error {
+	if errors.Is(err, ErrInvalidPNM) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrInvalidPNM, err)
+}
+
+// pamHeader reads the header lines of a PAM image up to ENDHDR
+func pamHeader(br *bufio.Reader) (w, h, depth, maxVal int, err error) {
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return 0, 0, 0, 0, err
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		var dst *int
+		switch fields[0] {
+		case "ENDHDR":
+			return w, h, depth, maxVal, nil
+		case "WIDTH":
+			dst = &w
+		case "HEIGHT":
+			dst = &h
+		case "DEPTH":
+			dst = &depth
+		case "MAXVAL":
+			dst = &maxVal
+		default:
+			// TUPLTYPE is implied by the depth
+			continue
+		}
+		if len(fields) < 2 {
+			return 0, 0, 0, 0, fmt.Errorf("%w: missing value for %v", ErrInvalidPNM, fields[0])
+		}
+		if *dst, err = strconv.Atoi(fields[1]); err != nil {
+			return 0, 0, 0, 0, err
+		}
+	}
+}
+
+// pnmInt reads a decimal number, skipping whitespace and comments
+func pnmInt(br *bufio.Reader) (int, error) {
+	var digits []byte
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			if errors.Is(err, io.EOF) && len(digits) > 0 {
+				break
+			}
+			return 0, err
+		}
+		switch {
+		case c >= '0' && c <= '9':
+			digits = append(digits, c)
+			continue
+		case c == '#':
+			if _, err = br.ReadString('\n'); err != nil {
+				return 0, err
+			}
+		case c != ' ' && c != '\t' && c != '\n' && c != '\r' && c != '\v' && c != '\f':
+			return 0, fmt.Errorf("%w: unexpected %q", ErrInvalidPNM, c)
+		}
+		if len(digits) > 0 {
+			break
+		}
+	}
+	return strconv.Atoi(string(digits))
+}
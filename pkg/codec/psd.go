@@ -0,0 +1,668 @@
+package codec
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"math"
+	"unicode/utf16"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+)
+
+// ErrInvalidPSD indicates that a file is not a readable Photoshop document
+const ErrInvalidPSD log.ConstErr = "invalid Photoshop document"
+
+// Photoshop size limits. Large documents are bounded by their pixel count
+// too, since even a PSB of the largest size cannot be held in memory.
+const (
+	psdMaxDimension = 30000
+	psbMaxDimension = 300000
+	psdMaxPixels    = 1 << 30
+	psdMaxChannels  = 56
+)
+
+// deflateMaxRatio is the most a deflate stream can expand its input by
+const deflateMaxRatio = 1032
+
+// Photoshop color modes
+const (
+	psdGray    = 1
+	psdIndexed = 2
+	psdRGB     = 3
+	psdCMYK    = 4
+)
+
+// Photoshop channel compression methods
+const (
+	psdRaw        = 0
+	psdRLE        = 1
+	psdZip        = 2
+	psdZipPredict = 3
+)
+
+// Photoshop section divider types, marking groups of layers
+const (
+	psdOpenFolder   = 1
+	psdClosedFolder = 2
+	psdDivider      = 3
+)
+
+// psdComposites maps Photoshop blend mode keys to OpenRaster composite
+// operations
+var psdComposites = map[string]string{
+	"norm": CompositeSrcOver,
+	"pass": CompositeSrcOver,
+	"mul ": "svg:multiply",
+	"scrn": "svg:screen",
+	"over": "svg:overlay",
+	"dark": "svg:darken",
+	"lite": "svg:lighten",
+	"div ": "svg:color-dodge",
+	"idiv": "svg:color-burn",
+	"hLit": "svg:hard-light",
+	"sLit": "svg:soft-light",
+	"diff": "svg:difference",
+	"smud": "svg:exclusion",
+	"hue ": "svg:hue",
+	"sat ": "svg:saturation",
+	"colr": "svg:color",
+	"lum ": "svg:luminosity",
+	"lddg": "svg:plus",
+}
+
+// psdAdjustments are the additional layer information keys of adjustment and
+// fill layers, whose effect on the layers below cannot be reproduced
+var psdAdjustments = map[string]bool{
+	"levl": true, "curv": true, "brit": true, "blnc": true, "hue2": true,
+	"hue ": true, "selc": true, "mixr": true, "grdm": true, "phfl": true,
+	"expA": true, "vibA": true, "thrs": true, "nvrt": true, "post": true,
+	"blwh": true, "clrL": true, "CgEd": true,
+}
+
+// psdLongKeys are the additional layer information keys with 8 byte lengths
+// in large documents
+var psdLongKeys = map[string]bool{
+	"LMsk": true, "Lr16": true, "Lr32": true, "Layr": true, "Mt16": true,
+	"Mt32": true, "Mtrn": true, "Alph": true, "FMsk": true, "lnk2": true,
+	"FEid": true, "FXid": true, "PxSD": true,
+}
+
+// psdReader reads big endian values from a document in memory, remembering
+// the first error
+type psdReader struct {
+	data []byte
+	pos  int
+	// psb is set for large documents, which use wider lengths
+	psb bool
+	err error
+}
+
+// next returns the next n bytes
+func (r *psdReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.data)-r.pos {
+		r.err = fmt.Errorf("%w: unexpected end of data", ErrInvalidPSD)
+		return nil
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *psdReader) u8() uint8 {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *psdReader) u16() uint16 {
+	if b := r.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *psdReader) u32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+// length reads a section length, which is wider in large documents
+func (r *psdReader) length() int {
+	if !r.psb {
+		return int(r.u32())
+	}
+	b := r.next(8)
+	if b == nil {
+		return 0
+	}
+	n := binary.BigEndian.Uint64(b)
+	if n > uint64(len(r.data)) {
+		r.err = fmt.Errorf("%w: section length %v", ErrInvalidPSD, n)
+		return 0
+	}
+	return int(n)
+}
+
+// psdHeader describes the pixels of a document
+type psdHeader struct {
+	channels, width, height, depth, mode int
+	// palette holds the colors of indexed documents as 256 reds, greens
+	// and blues
+	palette []byte
+}
+
+// psdChannel is the pixel data of one channel of a layer
+type psdChannel struct {
+	id     int
+	length int
+}
+
+// psdRecord is a layer record
+type psdRecord struct {
+	rect     image.Rectangle
+	channels []psdChannel
+	blend    string
+	opacity  uint8
+	clipping uint8
+	flags    uint8
+	name     string
+	section  int
+	// unsupported describes a feature that cannot be reproduced, if any
+	unsupported string
+	img         *image.NRGBA
+}
+
+// DecodePSD reads the layers of a Photoshop document or large document. The
+// raster layers of 8 bit RGB and grayscale documents are read with their
+// offsets, names, opacity, visibility and blend modes, and layers in groups
+// inherit the opacity and visibility of their groups and are named after
+// them. Documents using features that cannot be reproduced are read as a
+// single layer of their flattened composite, with a warning.
+func DecodePSD(r io.Reader) (*Layered, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	pr := &psdReader{data: data}
+	hdr, err := pr.header()
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*psdRecord
+	var reason string
+	if end := pr.length(); end > 0 {
+		end += pr.pos
+		records, reason = pr.layers(hdr)
+		if pr.err != nil {
+			return nil, pr.err
+		}
+		pr.pos = end
+	}
+
+	var doc *Layered
+	if reason == "" && len(records) > 0 {
+		doc = psdLayered(hdr, records)
+	}
+	composite, err := pr.composite(hdr)
+	if err != nil {
+		if doc != nil {
+			// the layers do not need the composite
+			log.Warnf("reading Photoshop composite: %v", err)
+			return doc, nil
+		}
+		return nil, err
+	}
+	if doc == nil {
+		if reason != "" {
+			log.Warnf("Photoshop document uses %v, reading the flattened composite", reason)
+		}
+		doc = &Layered{Width: hdr.width, Height: hdr.height, Layers: []Layer{{
+			Name:      backgroundLayerName,
+			Image:     composite,
+			Opacity:   1,
+			Composite: CompositeSrcOver,
+		}}}
+	}
+	return doc, nil
+}
+
+// backgroundLayerName names the layer holding a flattened composite
+const backgroundLayerName = "Background"
+
+// validSize reports whether an image of w by h pixels fits the limits of
+// the format
+func (r *psdReader) validSize(w, h int) bool {
+	max := psdMaxDimension
+	if r.psb {
+		max = psbMaxDimension
+	}
+	return w <= max && h <= max && w*h <= psdMaxPixels
+}
+
+// header reads the file header, color mode data and image resources
+func (r *psdReader) header() (psdHeader, error) {
+	var hdr psdHeader
+	if sig := r.next(4); r.err != nil || string(sig) != "8BPS" {
+		return hdr, fmt.Errorf("%w: bad signature", ErrInvalidPSD)
+	}
+	switch v := r.u16(); v {
+	case 1:
+	case 2:
+		r.psb = true
+	default:
+		return hdr, fmt.Errorf("%w: version %v", ErrInvalidPSD, v)
+	}
+	r.next(6)
+	hdr.channels = int(r.u16())
+	hdr.height = int(r.u32())
+	hdr.width = int(r.u32())
+	hdr.depth = int(r.u16())
+	hdr.mode = int(r.u16())
+	if r.err != nil {
+		return hdr, r.err
+	}
+	if hdr.width <= 0 || hdr.height <= 0 || hdr.channels <= 0 || hdr.channels > psdMaxChannels || !r.validSize(hdr.width, hdr.height) {
+		return hdr, fmt.Errorf("%w: %vx%v with %v channels", ErrInvalidPSD, hdr.width, hdr.height, hdr.channels)
+	}
+	hdr.palette = r.next(int(r.u32()))
+	r.next(int(r.u32()))
+	return hdr, r.err
+}
+
+// layers reads the layer records and their pixels. If the document uses a
+// feature that cannot be reproduced, it is described instead.
+func (r *psdReader) layers(hdr psdHeader) ([]*psdRecord, string) {
+	n := r.length()
+	if n == 0 || r.err != nil {
+		return nil, ""
+	}
+	end := r.pos + n
+	// a negative count means the first alpha channel is the composite's
+	count := int(int16(r.u16()))
+	if count < 0 {
+		count = -count
+	}
+	records := make([]*psdRecord, 0, count)
+	for i := 0; i < count && r.err == nil; i++ {
+		records = append(records, r.record())
+	}
+	if r.err != nil {
+		return nil, ""
+	}
+	if hdr.depth != 8 || (hdr.mode != psdRGB && hdr.mode != psdGray) {
+		r.pos = end
+		return nil, fmt.Sprintf("layers of depth %v in color mode %v", hdr.depth, hdr.mode)
+	}
+	// check the channel data is there before allocating the layers
+	data, pixels := 0, 0
+	for _, rec := range records {
+		for _, ch := range rec.channels {
+			data += ch.length
+		}
+		pixels += rec.rect.Dx() * rec.rect.Dy()
+	}
+	if data > end-r.pos || pixels > psdMaxPixels {
+		r.err = fmt.Errorf("%w: %v bytes of channel data for %v pixels in %v bytes", ErrInvalidPSD, data, pixels, end-r.pos)
+		return nil, ""
+	}
+	reason := ""
+	for _, rec := range records {
+		if rec.unsupported != "" && reason == "" {
+			reason = fmt.Sprintf("%v on layer %q", rec.unsupported, rec.name)
+		}
+		rec.img = image.NewNRGBA(image.Rect(0, 0, rec.rect.Dx(), rec.rect.Dy()))
+		for i := 3; i < len(rec.img.Pix); i += 4 {
+			rec.img.Pix[i] = 0xFF
+		}
+		for _, ch := range rec.channels {
+			start := r.pos
+			plane, err := r.channel(rec.rect.Dx(), rec.rect.Dy(), ch.length)
+			if err != nil {
+				r.err = fmt.Errorf("layer %q: %w", rec.name, err)
+				return nil, ""
+			}
+			r.pos = start + ch.length
+			psdSetChannel(rec.img, plane, ch.id, hdr.mode)
+		}
+	}
+	r.pos = end
+	return records, reason
+}
+
+// record reads one layer record
+func (r *psdReader) record() *psdRecord {
+	rec := &psdRecord{section: -1}
+	top, left := int(int32(r.u32())), int(int32(r.u32()))
+	bottom, right := int(int32(r.u32())), int(int32(r.u32()))
+	rec.rect = image.Rect(left, top, right, bottom)
+	if !r.validSize(rec.rect.Dx(), rec.rect.Dy()) {
+		r.err = fmt.Errorf("%w: layer of %vx%v", ErrInvalidPSD, rec.rect.Dx(), rec.rect.Dy())
+		return rec
+	}
+	nch := int(r.u16())
+	for i := 0; i < nch && r.err == nil; i++ {
+		id := int(int16(r.u16()))
+		rec.channels = append(rec.channels, psdChannel{id: id, length: r.length()})
+		if id < -1 {
+			rec.unsupported = "a layer mask"
+		}
+	}
+	if sig := r.next(4); r.err == nil && string(sig) != "8BIM" {
+		r.err = fmt.Errorf("%w: bad blend mode signature", ErrInvalidPSD)
+	}
+	rec.blend = string(r.next(4))
+	rec.opacity = r.u8()
+	rec.clipping = r.u8()
+	rec.flags = r.u8()
+	r.u8()
+	if rec.clipping != 0 {
+		rec.unsupported = "a clipping mask"
+	}
+
+	extra := int(r.u32())
+	end := r.pos + extra
+	r.next(int(r.u32())) // layer mask data
+	r.next(int(r.u32())) // blending ranges
+	nameLen := int(r.u8())
+	rec.name = string(r.next(nameLen))
+	// the name is padded to a multiple of 4 bytes, including its length
+	r.next((4 - (nameLen+1)%4) % 4)
+	for r.err == nil && r.pos+12 <= end {
+		r.next(4) // 8BIM or 8B64
+		key := string(r.next(4))
+		var n int
+		if psdLongKeys[key] {
+			n = r.length()
+		} else {
+			n = int(r.u32())
+		}
+		info := &psdReader{data: r.next(n)}
+		r.next(n & 1)
+		switch {
+		case key == "luni":
+			n := int(info.u32())
+			if n > (len(info.data)-info.pos)/2 {
+				r.err = fmt.Errorf("%w: layer name of %v characters", ErrInvalidPSD, n)
+				break
+			}
+			units := make([]uint16, n)
+			for i := range units {
+				units[i] = info.u16()
+			}
+			if info.err == nil {
+				rec.name = string(utf16.Decode(units))
+			}
+		case key == "lsct" || key == "lsdk":
+			rec.section = int(info.u32())
+		case psdAdjustments[key]:
+			rec.unsupported = "an adjustment layer"
+		}
+	}
+	if r.err == nil {
+		r.pos = end
+	}
+	return rec
+}
+
+// channel reads the pixels of a channel of a w by h layer from the next n
+// bytes
+func (r *psdReader) channel(w, h, n int) ([]byte, error) {
+	data := r.next(n)
+	if r.err != nil {
+		return nil, r.err
+	}
+	if n < 2 {
+		// an empty channel of an empty layer
+		return nil, nil
+	}
+	cr := &psdReader{data: data, psb: r.psb}
+	return cr.plane(int(cr.u16()), w, h, 1)
+}
+
+// plane reads count planes of w by h pixels compressed together
+func (r *psdReader) plane(compression, w, h, count int) ([]byte, error) {
+	size := w * h
+	if count > psdMaxChannels || size*count > psdMaxPixels {
+		return nil, fmt.Errorf("%w: %v planes of %vx%v", ErrInvalidPSD, count, w, h)
+	}
+	// the fewest bytes the planes can be compressed to
+	least := size * count
+	switch compression {
+	case psdRLE:
+		least = h * count * 2
+	case psdZip, psdZipPredict:
+		least = size * count / deflateMaxRatio
+	}
+	if least > len(r.data)-r.pos {
+		return nil, fmt.Errorf("%w: %v planes of %vx%v in %v bytes", ErrInvalidPSD, count, w, h, len(r.data)-r.pos)
+	}
+	out := make([]byte, 0, size*count)
+	switch compression {
+	case psdRaw:
+		out = append(out, r.next(size*count)...)
+	case psdRLE:
+		counts := make([]int, h*count)
+		for i := range counts {
+			if r.psb {
+				counts[i] = int(r.u32())
+			} else {
+				counts[i] = int(r.u16())
+			}
+		}
+		for _, c := range counts {
+			row, err := unpackBits(r.next(c), w)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, row...)
+		}
+	case psdZip, psdZipPredict:
+		zr, err := zlib.NewReader(bytes.NewReader(r.data[r.pos:]))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPSD, err)
+		}
+		out = out[:size*count]
+		if _, err = io.ReadFull(zr, out); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPSD, err)
+		}
+		if compression == psdZipPredict {
+			for y := 0; y < h*count; y++ {
+				row := out[y*w : (y+1)*w]
+				for x := 1; x < w; x++ {
+					row[x] += row[x-1]
+				}
+			}
+		}
+	default:
+		return nil, fmt.Errorf("%w: compression %v", ErrInvalidPSD, compression)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return out, nil
+}
+
+// unpackBits expands a PackBits run-length encoded row of w bytes
+func unpackBits(src []byte, w int) ([]byte, error) {
+	row := make([]byte, 0, w)
+	for i := 0; i < len(src) && len(row) < w; {
+		n := int(int8(src[i]))
+		i++
+		switch {
+		case n >= 0:
+			if i+n+1 > len(src) {
+				return nil, fmt.Errorf("%w: truncated literal run", ErrInvalidPSD)
+			}
+			row = append(row, src[i:i+n+1]...)
+			i += n + 1
+		case n > -128:
+			if i >= len(src) {
+				return nil, fmt.Errorf("%w: truncated repeat run", ErrInvalidPSD)
+			}
+			for j := 0; j < 1-n; j++ {
+				row = append(row, src[i])
+			}
+			i++
+		}
+	}
+	if len(row) != w {
+		return nil, fmt.Errorf("%w: row of %v bytes, expected %v", ErrInvalidPSD, len(row), w)
+	}
+	return row, nil
+}
+
+// psdSetChannel copies a plane of channel id into img
+func psdSetChannel(img *image.NRGBA, plane []byte, id, mode int) {
+	if len(plane) < len(img.Pix)/4 {
+		return
+	}
+	var offsets []int
+	switch {
+	case id == -1:
+		offsets = []int{3}
+	case mode == psdGray && id == 0:
+		offsets = []int{0, 1, 2}
+	case mode == psdRGB && id >= 0 && id < 3:
+		offsets = []int{id}
+	default:
+		return
+	}
+	for i, v := range plane[:len(img.Pix)/4] {
+		for _, o := range offsets {
+			img.Pix[i*4+o] = v
+		}
+	}
+}
+
+// psdLayered builds a document from the layer records, which are stored
+// bottom first with each group closed by its folder record above its layers
+func psdLayered(hdr psdHeader, records []*psdRecord) *Layered {
+	groups := [][]Layer{nil}
+	for _, rec := range records {
+		switch rec.section {
+		case psdDivider:
+			groups = append(groups, nil)
+			continue
+		case psdOpenFolder, psdClosedFolder:
+			if len(groups) < 2 {
+				continue
+			}
+			children := groups[len(groups)-1]
+			groups = groups[:len(groups)-1]
+			for _, l := range children {
+				l.Name = rec.name + "/" + l.Name
+				l.Opacity *= float64(rec.opacity) / 0xFF
+				l.Hidden = l.Hidden || rec.flags&2 != 0
+				groups[len(groups)-1] = append(groups[len(groups)-1], l)
+			}
+			continue
+		}
+		if rec.rect.Empty() {
+			continue
+		}
+		composite, ok := psdComposites[rec.blend]
+		if !ok {
+			log.Warnf("layer %q uses unknown blend mode %q, treating it as normal", rec.name, rec.blend)
+			composite = CompositeSrcOver
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], Layer{
+			Name:      rec.name,
+			Image:     rec.img,
+			Offset:    rec.rect.Min,
+			Opacity:   float64(rec.opacity) / 0xFF,
+			Hidden:    rec.flags&2 != 0,
+			Composite: composite,
+		})
+	}
+	// close groups missing their folder record
+	for len(groups) > 1 {
+		last := groups[len(groups)-1]
+		groups = groups[:len(groups)-1]
+		groups[len(groups)-1] = append(groups[len(groups)-1], last...)
+	}
+	return &Layered{Width: hdr.width, Height: hdr.height, Layers: groups[0]}
+}
+
+// composite reads the flattened image stored at the end of the document
+func (r *psdReader) composite(hdr psdHeader) (*image.NRGBA, error) {
+	compression := int(r.u16())
+	bps := hdr.depth / 8
+	if hdr.depth != 8 && hdr.depth != 16 && hdr.depth != 32 {
+		return nil, fmt.Errorf("%w: composite depth %v", ErrInvalidPSD, hdr.depth)
+	}
+	data, err := r.plane(compression, hdr.width*bps, hdr.height, hdr.channels)
+	if err != nil {
+		return nil, err
+	}
+	size := hdr.width * hdr.height
+	// sample returns channel c of pixel i scaled to 8 bits
+	sample := func(c, i int) uint8 {
+		p := data[(c*size+i)*bps:]
+		switch bps {
+		case 2:
+			return p[0]
+		case 4:
+			f := math.Float32frombits(binary.BigEndian.Uint32(p))
+			return uint8(math.Round(math.Max(0, math.Min(1, float64(f))) * 0xFF))
+		}
+		return p[0]
+	}
+	colors := map[int]int{psdGray: 1, psdIndexed: 1, psdRGB: 3, psdCMYK: 4}[hdr.mode]
+	if colors == 0 {
+		return nil, fmt.Errorf("%w: color mode %v", ErrInvalidPSD, hdr.mode)
+	}
+	if hdr.channels < colors || (hdr.mode == psdIndexed && len(hdr.palette) < 768) {
+		return nil, fmt.Errorf("%w: %v channels in color mode %v", ErrInvalidPSD, hdr.channels, hdr.mode)
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, hdr.width, hdr.height))
+	for i := 0; i < size; i++ {
+		p := img.Pix[i*4 : i*4+4]
+		switch hdr.mode {
+		case psdGray:
+			v := sample(0, i)
+			p[0], p[1], p[2] = v, v, v
+		case psdIndexed:
+			v := int(data[i])
+			p[0], p[1], p[2] = hdr.palette[v], hdr.palette[256+v], hdr.palette[512+v]
+		case psdRGB:
+			p[0], p[1], p[2] = sample(0, i), sample(1, i), sample(2, i)
+		case psdCMYK:
+			// samples are stored inverted, 0xFF meaning no ink
+			k := uint32(sample(3, i))
+			for c := 0; c < 3; c++ {
+				p[c] = uint8((uint32(sample(c, i))*k + 0x7F) / 0xFF)
+			}
+		}
+		p[3] = 0xFF
+		if hdr.channels > colors {
+			p[3] = sample(colors, i)
+		}
+	}
+	return img, nil
+}
+
+// decodePSDComposite reads a Photoshop document flattened to one image
+func decodePSDComposite(r io.Reader) (image.Image, error) {
+	doc, err := DecodePSD(r)
+	if err != nil {
+		return nil, err
+	}
+	return Flatten(doc), nil
+}
+
+// decodePSDLayers reads the layers of a Photoshop document
+func decodePSDLayers(r io.ReaderAt, size int64) (*Layered, error) {
+	return DecodePSD(io.NewSectionReader(r, 0, size))
+}
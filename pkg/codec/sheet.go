@@ -0,0 +1,112 @@
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+)
+
+// ErrEmptySheet indicates that a sprite sheet without any pixels was asked for
+const ErrEmptySheet log.ConstErr = "sprite sheet has no frames"
+
+// ErrInvalidPadding indicates that a negative sprite sheet padding was given
+const ErrInvalidPadding log.ConstErr = "padding must not be negative"
+
+// SheetFrame is an image to pack into a sprite sheet.
+type SheetFrame struct {
+	Name  string
+	Image *image.NRGBA
+}
+
+// Atlas describes the frames of a sprite sheet in the JSON array format
+// read by common game engines.
+type Atlas struct {
+	Frames []AtlasFrame `json:"frames"`
+	Meta   AtlasMeta    `json:"meta"`
+}
+
+// AtlasFrame is the location of one frame in a sprite sheet.
+type AtlasFrame struct {
+	Filename         string    `json:"filename"`
+	Frame            AtlasRect `json:"frame"`
+	Rotated          bool      `json:"rotated"`
+	Trimmed          bool      `json:"trimmed"`
+	SpriteSourceSize AtlasRect `json:"spriteSourceSize"`
+	SourceSize       AtlasSize `json:"sourceSize"`
+}
+
+// AtlasRect is a rectangle of an atlas in pixels.
+type AtlasRect struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+// AtlasSize is a size of an atlas in pixels.
+type AtlasSize struct {
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+// AtlasMeta describes the sheet image of an atlas.
+type AtlasMeta struct {
+	App    string    `json:"app"`
+	Image  string    `json:"image"`
+	Format string    `json:"format"`
+	Size   AtlasSize `json:"size"`
+	Scale  string    `json:"scale"`
+}
+
+// WriteSheet packs the frames into one image written to path, with padding
+// pixels between them, and writes their atlas next to it with the extension
+// .json. It returns the path of the atlas.
+func WriteSheet(path string, frames []SheetFrame, padding int) (string, error) {
+	if padding < 0 {
+		return "", fmt.Errorf("%w: %v", ErrInvalidPadding, padding)
+	}
+	sizes := make([]image.Point, len(frames))
+	for i, f := range frames {
+		sizes[i] = f.Image.Rect.Size()
+	}
+	rects, size := raster.Pack(sizes, padding)
+	if size.X == 0 || size.Y == 0 {
+		return "", ErrEmptySheet
+	}
+	sheet := image.NewNRGBA(image.Rectangle{Max: size})
+	atlas := Atlas{Meta: AtlasMeta{
+		App:    "tabula-editor",
+		Image:  filepath.Base(path),
+		Format: "RGBA8888",
+		Size:   AtlasSize{W: size.X, H: size.Y},
+		Scale:  "1",
+	}}
+	for i, f := range frames {
+		r := rects[i]
+		for y := 0; y < r.Dy(); y++ {
+			src := f.Image.Pix[f.Image.PixOffset(f.Image.Rect.Min.X, f.Image.Rect.Min.Y+y):f.Image.PixOffset(f.Image.Rect.Max.X, f.Image.Rect.Min.Y+y)]
+			copy(sheet.Pix[sheet.PixOffset(r.Min.X, r.Min.Y+y):], src)
+		}
+		atlas.Frames = append(atlas.Frames, AtlasFrame{
+			Filename:         f.Name,
+			Frame:            AtlasRect{X: r.Min.X, Y: r.Min.Y, W: r.Dx(), H: r.Dy()},
+			SpriteSourceSize: AtlasRect{W: r.Dx(), H: r.Dy()},
+			SourceSize:       AtlasSize{W: r.Dx(), H: r.Dy()},
+		})
+	}
+	if err := EncodeFile(path, sheet, nil); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(atlas, "", "\t")
+	if err != nil {
+		return "", err
+	}
+	atlasPath := strings.TrimSuffix(path, filepath.Ext(path)) + ".json"
+	return atlasPath, ioutil.WriteFile(atlasPath, data, 0644)
+}
@@ -0,0 +1,71 @@
+package codec
+
+import (
+	"fmt"
+	"image"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+)
+
+// SplitOptions choose how WriteLayers names and sizes the layer files.
+type SplitOptions struct {
+	// Named names files after their layers instead of numbering them from
+	// the bottom.
+	Named bool
+	// CanvasSize keeps every file at the size of the document instead of
+	// cropping it to the bounds of its layer.
+	CanvasSize bool
+}
+
+// LayerImage returns the pixels of l, placed on an image the size of doc if
+// canvasSize is set. Opacity and visibility are ignored.
+func LayerImage(doc *Layered, l Layer, canvasSize bool) *image.NRGBA {
+	if !canvasSize {
+		return l.Image
+	}
+	r := image.Rect(0, 0, doc.Width, doc.Height).Sub(l.Offset)
+	return raster.Crop(l.Image, r)
+}
+
+// WriteLayers writes every layer of doc as a PNG into dir, which must exist,
+// and returns the paths of the files written.
+func WriteLayers(dir string, doc *Layered, opts SplitOptions) ([]string, error) {
+	var paths []string
+	used := make(map[string]bool, len(doc.Layers))
+	for i, l := range doc.Layers {
+		name := fmt.Sprintf("%03d", i+1)
+		if opts.Named {
+			if n := fileSafe(l.Name); n != "" {
+				name = n
+			}
+		}
+		// keep layers with the same name apart
+		base := name
+		for n := 2; used[strings.ToLower(name)]; n++ {
+			name = fmt.Sprintf("%v-%v", base, n)
+		}
+		used[strings.ToLower(name)] = true
+
+		path := filepath.Join(dir, name+".png")
+		if err := EncodeFile(path, LayerImage(doc, l, opts.CanvasSize), nil); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// fileSafe replaces the characters of name that are not letters, digits,
+// dashes, underscores or dots
+func fileSafe(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '_'
+	}, strings.TrimSpace(name))
+	return strings.Trim(name, ".")
+}
@@ -0,0 +1,251 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+)
+
+// ErrInvalidTGA indicates that a file is not a supported Truevision TGA image
+const ErrInvalidTGA log.ConstErr = "invalid TGA image"
+
+// TGA header constants
+const (
+	tgaHeaderSize = 18
+	// image types
+	tgaColorMapped = 1
+	tgaTrueColor   = 2
+	tgaGray        = 3
+	tgaRLE         = 8
+	// image descriptor bits
+	tgaDescAlphaBits = 0x0F
+	tgaDescRightLeft = 0x10
+	tgaDescTopBottom = 0x20
+)
+
+// tgaMaxPixels bounds the memory a header can make the decoder allocate
+const tgaMaxPixels = 1 << 28
+
+// DecodeTGA reads an uncompressed or run-length encoded true color, gray or
+// color mapped TGA image.
+func DecodeTGA(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	var hdr [tgaHeaderSize]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTGA, err)
+	}
+	idLen := int(hdr[0])
+	hasMap := hdr[1] == 1
+	kind := int(hdr[2])
+	mapFirst := int(binary.LittleEndian.Uint16(hdr[3:]))
+	mapLen := int(binary.LittleEndian.Uint16(hdr[5:]))
+	mapDepth := int(hdr[7])
+	w := int(binary.LittleEndian.Uint16(hdr[12:]))
+	h := int(binary.LittleEndian.Uint16(hdr[14:]))
+	depth := int(hdr[16])
+	desc := hdr[17]
+	rle := kind&tgaRLE != 0
+	base := kind &^ tgaRLE
+
+	if w == 0 || h == 0 {
+		return nil, fmt.Errorf("%w: empty image", ErrInvalidTGA)
+	}
+	if w*h > tgaMaxPixels {
+		return nil, fmt.Errorf("%w: %vx%v is too large", ErrInvalidTGA, w, h)
+	}
+	if _, err := br.Discard(idLen); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTGA, err)
+	}
+
+	// alpha is only meaningful when the descriptor declares alpha bits
+	hasAlpha := desc&tgaDescAlphaBits != 0
+	var palette [][4]uint8
+	if hasMap {
+		size := (mapDepth + 7) / 8
+		raw := make([]byte, mapLen*size)
+		if _, err := io.ReadFull(br, raw); err != nil {
+			return nil, fmt.Errorf("%w: color map: %v", ErrInvalidTGA, err)
+		}
+		palette = make([][4]uint8, mapFirst+mapLen)
+		for i := 0; i < mapLen; i++ {
+			c, err := tgaColor(raw[i*size:(i+1)*size], mapDepth, hasAlpha)
+			if err != nil {
+				return nil, err
+			}
+			palette[mapFirst+i] = c
+		}
+	}
+
+	switch {
+	case base == tgaColorMapped && hasMap && (depth == 8 || depth == 16):
+	case base == tgaTrueColor && (depth == 15 || depth == 16 || depth == 24 || depth == 32):
+	case base == tgaGray && (depth == 8 || depth == 16):
+	default:
+		return nil, fmt.Errorf("%w: type %v with %v bits per pixel", ErrInvalidTGA, kind, depth)
+	}
+
+	size := (depth + 7) / 8
+	pix := make([]byte, w*h*size)
+	if rle {
+		for n := 0; n < len(pix); {
+			head, err := br.ReadByte()
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidTGA, err)
+			}
+			count := int(head&0x7F) + 1
+			if n+count*size > len(pix) {
+				return nil, fmt.Errorf("%w: run past end of image", ErrInvalidTGA)
+			}
+			if head&0x80 != 0 {
+				if _, err = io.ReadFull(br, pix[n:n+size]); err != nil {
+					return nil, fmt.Errorf("%w: %v", ErrInvalidTGA, err)
+				}
+				for i := 1; i < count; i++ {
+					copy(pix[n+i*size:], pix[n:n+size])
+				}
+			} else if _, err = io.ReadFull(br, pix[n:n+count*size]); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidTGA, err)
+			}
+			n += count * size
+		}
+	} else if _, err := io.ReadFull(br, pix); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTGA, err)
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		dy := h - 1 - y
+		if desc&tgaDescTopBottom != 0 {
+			dy = y
+		}
+		for x := 0; x < w; x++ {
+			dx := x
+			if desc&tgaDescRightLeft != 0 {
+				dx = w - 1 - x
+			}
+			p := pix[(y*w+x)*size : (y*w+x+1)*size]
+			var c [4]uint8
+			switch base {
+			case tgaColorMapped:
+				i := int(p[0])
+				if size == 2 {
+					i = int(binary.LittleEndian.Uint16(p))
+				}
+				if i >= len(palette) {
+					return nil, fmt.Errorf("%w: color index %v out of range", ErrInvalidTGA, i)
+				}
+				c = palette[i]
+			case tgaGray:
+				c = [4]uint8{p[0], p[0], p[0], 0xFF}
+				if size == 2 && hasAlpha {
+					c[3] = p[1]
+				}
+			default:
+				var err error
+				if c, err = tgaColor(p, depth, hasAlpha); err != nil {
+					return nil, err
+				}
+			}
+			copy(img.Pix[img.PixOffset(dx, dy):], c[:])
+		}
+	}
+	return img, nil
+}
+
+// tgaColor decodes a little endian BGR(A) pixel of the given bit depth
+func tgaColor(p []byte, depth int, alpha bool) ([4]uint8, error) {
+	switch depth {
+	case 15, 16:
+		v := binary.LittleEndian.Uint16(p)
+		c := [4]uint8{expand5(v >> 10), expand5(v >> 5), expand5(v), 0xFF}
+		if depth == 16 && alpha && v&0x8000 == 0 {
+			c[3] = 0
+		}
+		return c, nil
+	case 24:
+		return [4]uint8{p[2], p[1], p[0], 0xFF}, nil
+	case 32:
+		c := [4]uint8{p[2], p[1], p[0], 0xFF}
+		if alpha {
+			c[3] = p[3]
+		}
+		return c, nil
+	}
+	return [4]uint8{}, fmt.Errorf("%w: %v bit colors", ErrInvalidTGA, depth)
+}
+
+// expand5 scales the low five bits of v to eight bits
+func expand5(v uint16) uint8 {
+	v &= 0x1F
+	return uint8(v<<3 | v>>2)
+}
+
+// tgaOptions are the settings of the TGA encoder
+var tgaOptions = []Option{
+	{Name: "Run-length encoding", Choices: []string{"yes", "no"}, Default: "yes"},
+}
+
+// encodeTGA writes img as a top down 32 bit TGA image, optionally run-length
+// encoded.
+func encodeTGA(w io.Writer, img *image.NRGBA, opts Options) error {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	if width > 0xFFFF || height > 0xFFFF {
+		return fmt.Errorf("%w: %vx%v is too large", ErrInvalidTGA, width, height)
+	}
+	rle := opts["Run-length encoding"] == "yes"
+	var hdr [tgaHeaderSize]byte
+	hdr[2] = tgaTrueColor
+	if rle {
+		hdr[2] |= tgaRLE
+	}
+	binary.LittleEndian.PutUint16(hdr[12:], uint16(width))
+	binary.LittleEndian.PutUint16(hdr[14:], uint16(height))
+	hdr[16] = 32
+	hdr[17] = 8 | tgaDescTopBottom
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(hdr[:]); err != nil {
+		return err
+	}
+	row := make([]byte, width*4)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			p := img.Pix[img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y):]
+			row[x*4], row[x*4+1], row[x*4+2], row[x*4+3] = p[2], p[1], p[0], p[3]
+		}
+		if !rle {
+			if _, err := bw.Write(row); err != nil {
+				return err
+			}
+			continue
+		}
+		// packets hold up to 128 pixels and never cross rows
+		for x := 0; x < width; {
+			same := 1
+			for x+same < width && same < 128 && bytes.Equal(row[x*4:x*4+4], row[(x+same)*4:(x+same)*4+4]) {
+				same++
+			}
+			if same > 1 {
+				if _, err := bw.Write(append([]byte{0x80 | byte(same-1)}, row[x*4:x*4+4]...)); err != nil {
+					return err
+				}
+				x += same
+				continue
+			}
+			// a raw packet runs until the next pair of equal pixels
+			n := 1
+			for x+n < width && n < 128 && (x+n+1 >= width || !bytes.Equal(row[(x+n)*4:(x+n)*4+4], row[(x+n+1)*4:(x+n+1)*4+4])) {
+				n++
+			}
+			if _, err := bw.Write(append([]byte{byte(n - 1)}, row[x*4:(x+n)*4]...)); err != nil {
+				return err
+			}
+			x += n
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// appDir is the name of the directory holding tabula's settings files
+const appDir = "tabula-editor"
+
+// Dir returns the directory holding the user's settings files, creating it
+// if it does not exist.
+func Dir() (string, error) {
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(base, appDir)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// LoadJSON decodes the settings file with the given name into v. If the file
+// does not exist yet, v is left unchanged.
+func LoadJSON(name string, v interface{}) error {
+	dir, err := Dir()
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// SaveJSON encodes v into the settings file with the given name.
+func SaveJSON(name string, v interface{}) error {
+	dir, err := Dir()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, name), data, 0644)
+}
@@ -0,0 +1,272 @@
+// Package diff compares two projects, reporting how their canvas and layers
+// changed and which pixels of the composited canvas differ.
+package diff
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"path/filepath"
+
+	tabula "github.com/gregjohnson2017/tabula-editor/pkg/image"
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+)
+
+// ErrInvalidThreshold indicates a threshold outside of 0 to 255
+const ErrInvalidThreshold log.ConstErr = "threshold must be from 0 to 255"
+
+// Highlight marks the pixels that differ in a visual diff
+var Highlight = color.NRGBA{0xFF, 0, 0, 0xFF}
+
+// fade is how far the pixels that do not differ are blended to white
+const fade = 0.75
+
+// Layer identifies a layer by its name and position from 1 above the canvas
+// background, in the first project for removed layers and in the second
+// otherwise.
+type Layer struct {
+	Name  string
+	Index int
+}
+
+func (l Layer) String() string {
+	if l.Name == "" {
+		return fmt.Sprintf("#%v", l.Index)
+	}
+	return fmt.Sprintf("%q", l.Name)
+}
+
+// Move is a layer at a different offset on the canvas.
+type Move struct {
+	Layer
+	From, To image.Point
+}
+
+// Change is a layer whose pixels or properties changed, described by what
+// changed, such as "size 2x2 -> 3x3".
+type Change struct {
+	Layer
+	What []string
+}
+
+// Report is how the second project differs from the first.
+type Report struct {
+	// From and To are the canvas sizes
+	From, To image.Point
+	Added    []Layer
+	Removed  []Layer
+	Moved    []Move
+	Changed  []Change
+	// Pixels is how many pixels of the composited canvases differ by more
+	// than the threshold, of Total
+	Pixels, Total int
+}
+
+// Empty reports whether the projects do not differ.
+func (r *Report) Empty() bool {
+	return r.From == r.To && len(r.Added) == 0 && len(r.Removed) == 0 &&
+		len(r.Moved) == 0 && len(r.Changed) == 0 && r.Pixels == 0
+}
+
+// Lines describes each difference on a line.
+func (r *Report) Lines() []string {
+	var lines []string
+	if r.From != r.To {
+		lines = append(lines, fmt.Sprintf("canvas: %vx%v -> %vx%v", r.From.X, r.From.Y, r.To.X, r.To.Y))
+	}
+	for _, l := range r.Removed {
+		lines = append(lines, fmt.Sprintf("removed: %v", l))
+	}
+	for _, l := range r.Added {
+		lines = append(lines, fmt.Sprintf("added: %v", l))
+	}
+	for _, m := range r.Moved {
+		lines = append(lines, fmt.Sprintf("moved: %v %v,%v -> %v,%v", m.Layer, m.From.X, m.From.Y, m.To.X, m.To.Y))
+	}
+	for _, c := range r.Changed {
+		for _, what := range c.What {
+			lines = append(lines, fmt.Sprintf("changed: %v %v", c.Layer, what))
+		}
+	}
+	if r.Pixels > 0 {
+		lines = append(lines, fmt.Sprintf("pixels: %v of %v differ", r.Pixels, r.Total))
+	}
+	return lines
+}
+
+// Compare returns how b differs from a, and a visual diff the size of the
+// larger canvas showing the pixels of the composited canvases that differ by
+// more than threshold in Highlight over a faded b. Layers are matched by
+// name, and layers of the same name by their order.
+func Compare(a, b *tabula.Document, threshold int) (*Report, *image.NRGBA, error) {
+	if threshold < 0 || threshold > 255 {
+		return nil, nil, fmt.Errorf("%w, got %v", ErrInvalidThreshold, threshold)
+	}
+	r := &Report{
+		From: image.Pt(int(a.Canvas.W), int(a.Canvas.H)),
+		To:   image.Pt(int(b.Canvas.W), int(b.Canvas.H)),
+	}
+	compareLayers(r, a, b, threshold)
+	visual := comparePixels(r, a.Composite().NRGBA(), b.Composite().NRGBA(), threshold)
+	return r, visual, nil
+}
+
+// layers returns the layers of d above the canvas background by name
+func layers(d *tabula.Document) (map[string][]int, []string) {
+	byName := make(map[string][]int)
+	var order []string
+	for i := 1; i < len(d.Layers); i++ {
+		name := d.Layers[i].Name
+		if _, ok := byName[name]; !ok {
+			order = append(order, name)
+		}
+		byName[name] = append(byName[name], i)
+	}
+	return byName, order
+}
+
+func compareLayers(r *Report, a, b *tabula.Document, threshold int) {
+	inA, _ := layers(a)
+	inB, order := layers(b)
+	offset := func(d *tabula.Document, i int) image.Point {
+		l := d.Layers[i]
+		return image.Pt(int(l.Area.X-d.Canvas.X), int(l.Area.Y-d.Canvas.Y))
+	}
+	for i := 1; i < len(a.Layers); i++ {
+		name := a.Layers[i].Name
+		nth := 0
+		for _, j := range inA[name] {
+			if j < i {
+				nth++
+			}
+		}
+		if nth >= len(inB[name]) {
+			r.Removed = append(r.Removed, Layer{name, i})
+		}
+	}
+	for _, name := range order {
+		for nth, j := range inB[name] {
+			l := Layer{name, j}
+			if nth >= len(inA[name]) {
+				r.Added = append(r.Added, l)
+				continue
+			}
+			i := inA[name][nth]
+			if from, to := offset(a, i), offset(b, j); from != to {
+				r.Moved = append(r.Moved, Move{l, from, to})
+			}
+			if what := layerChanges(a.Layers[i], b.Layers[j], threshold); len(what) > 0 {
+				r.Changed = append(r.Changed, Change{l, what})
+			}
+		}
+	}
+}
+
+// layerChanges describes how the properties and pixels of to differ from
+// those of from
+func layerChanges(from, to *tabula.DocumentLayer, threshold int) []string {
+	var what []string
+	if from.Area.W != to.Area.W || from.Area.H != to.Area.H {
+		what = append(what, fmt.Sprintf("size %vx%v -> %vx%v", from.Area.W, from.Area.H, to.Area.W, to.Area.H))
+	} else if n := differing(from.Image, to.Image, threshold); n > 0 {
+		what = append(what, fmt.Sprintf("pixels %v differ", n))
+	}
+	if math.Abs(from.Opacity()-to.Opacity()) > 0.005 {
+		what = append(what, fmt.Sprintf("opacity %.2f -> %.2f", from.Opacity(), to.Opacity()))
+	}
+	if from.Hidden != to.Hidden {
+		if to.Hidden {
+			what = append(what, "hidden")
+		} else {
+			what = append(what, "shown")
+		}
+	}
+	if from.Linked != to.Linked {
+		if to.Linked {
+			what = append(what, "linked to "+filepath.Base(to.Source))
+		} else {
+			what = append(what, "unlinked")
+		}
+	}
+	return what
+}
+
+// differing counts the pixels of two images of the same size that differ by
+// more than threshold
+func differing(a, b *image.NRGBA, threshold int) int {
+	n := 0
+	w, h := a.Rect.Dx(), a.Rect.Dy()
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if distance(at(a, x, y), at(b, x, y)) > threshold {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// comparePixels counts the differing pixels of the canvases into r and
+// returns the visual diff
+func comparePixels(r *Report, a, b *image.NRGBA, threshold int) *image.NRGBA {
+	w, h := a.Rect.Dx(), a.Rect.Dy()
+	if bw := b.Rect.Dx(); bw > w {
+		w = bw
+	}
+	if bh := b.Rect.Dy(); bh > h {
+		h = bh
+	}
+	visual := image.NewNRGBA(image.Rect(0, 0, w, h))
+	r.Total = w * h
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			ca, cb := at(a, x, y), at(b, x, y)
+			if distance(ca, cb) > threshold {
+				r.Pixels++
+				visual.SetNRGBA(x, y, Highlight)
+				continue
+			}
+			visual.SetNRGBA(x, y, faded(cb))
+		}
+	}
+	return visual
+}
+
+// at returns the pixel of img at x, y from its top left corner, or
+// transparent outside of it
+func at(img *image.NRGBA, x, y int) color.NRGBA {
+	p := image.Pt(img.Rect.Min.X+x, img.Rect.Min.Y+y)
+	if !p.In(img.Rect) {
+		return color.NRGBA{}
+	}
+	return img.NRGBAAt(p.X, p.Y)
+}
+
+// distance is the largest difference between the premultiplied channels of
+// two colors, so that the colors of transparent pixels do not matter
+func distance(a, b color.NRGBA) int {
+	pa := [4]int{int(a.R) * int(a.A) / 255, int(a.G) * int(a.A) / 255, int(a.B) * int(a.A) / 255, int(a.A)}
+	pb := [4]int{int(b.R) * int(b.A) / 255, int(b.G) * int(b.A) / 255, int(b.B) * int(b.A) / 255, int(b.A)}
+	d := 0
+	for i := range pa {
+		v := pa[i] - pb[i]
+		if v < 0 {
+			v = -v
+		}
+		if v > d {
+			d = v
+		}
+	}
+	return d
+}
+
+// faded returns c as gray over white, blended toward white
+func faded(c color.NRGBA) color.NRGBA {
+	gray := (299*float64(c.R) + 587*float64(c.G) + 114*float64(c.B)) / 1000
+	a := float64(c.A) / 255
+	v := 255 - a*(255-gray)
+	v = 255 - (1-fade)*(255-v)
+	g := uint8(math.Round(v))
+	return color.NRGBA{g, g, g, 0xFF}
+}
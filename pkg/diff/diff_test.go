@@ -0,0 +1,83 @@
+package diff_test
+
+import (
+	stdimage "image"
+	"image/color"
+	"reflect"
+	"testing"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/diff"
+	"github.com/gregjohnson2017/tabula-editor/pkg/image"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+)
+
+func filled(w, h int, c color.NRGBA) *stdimage.NRGBA {
+	img := stdimage.NewNRGBA(stdimage.Rect(0, 0, w, h))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	return img
+}
+
+func TestCompare(t *testing.T) {
+	red := color.NRGBA{0xFF, 0, 0, 0xFF}
+	newDoc := func(w, h int) *image.Document {
+		d, err := image.NewDocument(w, h, color.NRGBA{0xFF, 0xFF, 0xFF, 0xFF}, raster.Depth8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	a := newDoc(4, 4)
+	a.AddImage(filled(2, 2, red), 0, 0, "kept")
+	a.AddImage(filled(1, 1, red), 3, 3, "gone")
+	b := newDoc(4, 4)
+	b.AddImage(filled(2, 2, red), 1, 0, "kept")
+
+	r, visual, err := diff.Compare(a, a, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Empty() {
+		t.Fatalf("expected no difference with itself, got %v", r.Lines())
+	}
+
+	if r, visual, err = diff.Compare(a, b, 0); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`removed: "gone"`,
+		`moved: "kept" 0,0 -> 1,0`,
+		"pixels: 5 of 16 differ",
+	}
+	if got := r.Lines(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	if c := visual.NRGBAAt(0, 0); c != diff.Highlight {
+		t.Errorf("expected a highlighted pixel, got %v", c)
+	}
+	if c := visual.NRGBAAt(1, 0); c == diff.Highlight {
+		t.Errorf("expected a faded pixel, got %v", c)
+	}
+
+	c := newDoc(5, 4)
+	c.AddImage(filled(2, 2, red), 0, 0, "kept")
+	c.AddImage(filled(1, 1, red), 3, 3, "gone")
+	c.AddImage(filled(1, 1, red), 0, 3, "")
+	c.Layers[1].Transparency = 0.5
+	if r, visual, err = diff.Compare(a, c, 0); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{
+		"canvas: 4x4 -> 5x4",
+		"added: #3",
+		`changed: "kept" opacity 1.00 -> 0.50`,
+		"pixels: 9 of 20 differ",
+	}
+	if got := r.Lines(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	if visual.Rect.Dx() != 5 {
+		t.Errorf("expected a visual diff as wide as the larger canvas, got %v", visual.Rect)
+	}
+}
@@ -0,0 +1,65 @@
+package image
+
+import (
+	"github.com/gregjohnson2017/tabula-editor/pkg/codec"
+)
+
+// AddFrames adds a layer at the origin for every frame, in order, keeping
+// the frame delays
+func (iv *View) AddFrames(frames []codec.Frame) error {
+	for _, f := range frames {
+		if err := iv.AddImageLayer(f.Image); err != nil {
+			return err
+		}
+		iv.layers[len(iv.layers)-1].attrs.Delay = f.Delay
+	}
+	return nil
+}
+
+// Frames returns an animation frame of canvas size for every visible layer in
+// stack order, each drawn over the canvas background
+func (iv *View) Frames() []codec.Frame {
+	return iv.Document().Frames()
+}
+
+// ToggleVisibility shows the selected layer if it is hidden and hides it
+// otherwise. Hidden layers stay selected until another layer is clicked.
+func (iv *View) ToggleVisibility() error {
+	l, err := iv.selectedLayer()
+	if err != nil {
+		return err
+	}
+	l.attrs.Hidden = !l.attrs.Hidden
+	iv.edited()
+	return nil
+}
+
+// ShowAllLayers makes every layer visible
+func (iv *View) ShowAllLayers() {
+	iv.edited()
+	for _, l := range iv.layers {
+		l.attrs.Hidden = false
+	}
+}
+
+// FrameDelay returns the animation frame delay of the selected layer in
+// hundredths of a second, zero meaning the default
+func (iv *View) FrameDelay() (int, error) {
+	l, err := iv.selectedLayer()
+	if err != nil {
+		return 0, err
+	}
+	return l.attrs.Delay, nil
+}
+
+// SetFrameDelay sets the animation frame delay of the selected layer in
+// hundredths of a second, zero meaning the default
+func (iv *View) SetFrameDelay(delay int) error {
+	l, err := iv.selectedLayer()
+	if err != nil {
+		return err
+	}
+	l.attrs.Delay = delay
+	iv.edited()
+	return nil
+}
@@ -0,0 +1,385 @@
+package image
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strings"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+	"github.com/gregjohnson2017/tabula-editor/pkg/ui"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// Anchor selects which part of the image stays in place when the canvas
+// size changes.
+type Anchor int
+
+// Constants for the anchor grid, row by row.
+const (
+	AnchorTopLeft Anchor = iota
+	AnchorTop
+	AnchorTopRight
+	AnchorLeft
+	AnchorCenter
+	AnchorRight
+	AnchorBottomLeft
+	AnchorBottom
+	AnchorBottomRight
+)
+
+// Anchors lists every anchor, row by row.
+var Anchors = []Anchor{
+	AnchorTopLeft, AnchorTop, AnchorTopRight,
+	AnchorLeft, AnchorCenter, AnchorRight,
+	AnchorBottomLeft, AnchorBottom, AnchorBottomRight,
+}
+
+func (a Anchor) String() string {
+	switch a {
+	case AnchorTopLeft:
+		return "top left"
+	case AnchorTop:
+		return "top"
+	case AnchorTopRight:
+		return "top right"
+	case AnchorLeft:
+		return "left"
+	case AnchorCenter:
+		return "center"
+	case AnchorRight:
+		return "right"
+	case AnchorBottomLeft:
+		return "bottom left"
+	case AnchorBottom:
+		return "bottom"
+	case AnchorBottomRight:
+		return "bottom right"
+	}
+	return fmt.Sprintf("Anchor(%d)", int(a))
+}
+
+// ErrUnknownAnchor indicates that an anchor name was not recognized
+const ErrUnknownAnchor log.ConstErr = "unknown anchor"
+
+// ParseAnchor returns the anchor with the given case-insensitive name.
+func ParseAnchor(name string) (Anchor, error) {
+	for _, a := range Anchors {
+		if strings.EqualFold(name, a.String()) {
+			return a, nil
+		}
+	}
+	return AnchorCenter, fmt.Errorf("%w: %v", ErrUnknownAnchor, name)
+}
+
+// offset returns where the old canvas is placed within a canvas that grew by
+// dw by dh pixels
+func (a Anchor) offset(dw, dh int32) (int32, int32) {
+	col, row := int32(a)%3, int32(a)/3
+	return dw * col / 2, dh * row / 2
+}
+
+// ErrInvalidSize indicates that a requested image size is not positive
+const ErrInvalidSize log.ConstErr = "size must be positive"
+
+// ErrNoSelection indicates that an operation needs selected pixels
+const ErrNoSelection log.ConstErr = "nothing is selected"
+
+// ErrEmptyCrop indicates that a crop would leave no pixels on the canvas
+const ErrEmptyCrop log.ConstErr = "crop rectangle does not overlap the canvas"
+
+// Canvas returns the area of the canvas in canvas pixel coordinates
+func (iv *View) Canvas() sdl.Rect {
+	return iv.canvas
+}
+
+// setCanvas moves the canvas to r with the given background pixels
+func (iv *View) setCanvas(r sdl.Rect, bg *image.NRGBA) error {
+	if err := iv.canvasLayer.SetImage(sdl.Point{X: r.X, Y: r.Y}, bg); err != nil {
+		return err
+	}
+	iv.canvas = r
+	iv.edited()
+	return nil
+}
+
+// ResizeImage resamples every layer with the given filter so the canvas
+// becomes w by h pixels. Layers keep their position relative to the canvas.
+func (iv *View) ResizeImage(w, h int32, f raster.Filter) error {
+	if w <= 0 || h <= 0 {
+		return fmt.Errorf("resizing image to %vx%v: %w", w, h, ErrInvalidSize)
+	}
+	if err := raster.CheckSize(int(w), int(h)); err != nil {
+		return fmt.Errorf("resizing image: %w", err)
+	}
+	sx := float64(w) / float64(iv.canvas.W)
+	sy := float64(h) / float64(iv.canvas.H)
+	// scale returns the new start and length of a span relative to the canvas
+	scale := func(start, length int32, s float64) (int32, int32) {
+		lo := int32(math.Round(float64(start) * s))
+		hi := int32(math.Round(float64(start+length) * s))
+		if hi <= lo {
+			hi = lo + 1
+		}
+		return lo, hi - lo
+	}
+	// layers may be larger than the canvas, so check them all before
+	// changing any
+	areas := make([]sdl.Rect, len(iv.layers))
+	for i, l := range iv.layers {
+		x, lw := scale(l.area.X-iv.canvas.X, l.area.W, sx)
+		y, lh := scale(l.area.Y-iv.canvas.Y, l.area.H, sy)
+		if err := raster.CheckSize(int(lw), int(lh)); err != nil {
+			return fmt.Errorf("resizing layer %q: %w", l.attrs.Name, err)
+		}
+		areas[i] = sdl.Rect{X: iv.canvas.X + x, Y: iv.canvas.Y + y, W: lw, H: lh}
+	}
+	for i, l := range iv.layers {
+		if l == iv.canvasLayer {
+			continue
+		}
+		lw, lh := areas[i].W, areas[i].H
+		err := iv.resampleLayer(l, sdl.Point{X: areas[i].X, Y: areas[i].Y}, func(img *image.NRGBA) *image.NRGBA {
+			return raster.Resize(img, int(lw), int(lh), f)
+		}, func(d *raster.Float) *raster.Float {
+			return raster.ResizeFloat(d, int(lw), int(lh), f)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	bg := raster.Resize(iv.canvasLayer.Image(), int(w), int(h), f)
+	return iv.setCanvas(sdl.Rect{X: iv.canvas.X, Y: iv.canvas.Y, W: w, H: h}, bg)
+}
+
+// ResizeCanvas grows or shrinks the canvas to w by h pixels without scaling
+// the layers. The anchor decides which part of the old canvas stays in place,
+// and any newly exposed canvas is filled with the given color.
+func (iv *View) ResizeCanvas(w, h int32, anchor Anchor, fill color.NRGBA) error {
+	if w <= 0 || h <= 0 {
+		return fmt.Errorf("resizing canvas to %vx%v: %w", w, h, ErrInvalidSize)
+	}
+	if err := raster.CheckSize(int(w), int(h)); err != nil {
+		return fmt.Errorf("resizing canvas: %w", err)
+	}
+	dx, dy := anchor.offset(w-iv.canvas.W, h-iv.canvas.H)
+	r := sdl.Rect{X: iv.canvas.X - dx, Y: iv.canvas.Y - dy, W: w, H: h}
+
+	bg := image.NewNRGBA(image.Rect(0, 0, int(w), int(h)))
+	draw.Draw(bg, bg.Rect, &image.Uniform{C: fill}, image.Point{}, draw.Src)
+	old := iv.canvasLayer.Image()
+	at := image.Rect(int(dx), int(dy), int(dx+iv.canvas.W), int(dy+iv.canvas.H))
+	draw.Draw(bg, at, old, image.Point{}, draw.Src)
+	return iv.setCanvas(r, bg)
+}
+
+// Crop shrinks the canvas to the part of r that overlaps it. Layers are not
+// modified, so pixels outside of the new canvas are kept but not exported.
+func (iv *View) Crop(r sdl.Rect) error {
+	inter, ok := iv.canvas.Intersect(&r)
+	if !ok {
+		return ErrEmptyCrop
+	}
+	rel := image.Rect(0, 0, int(inter.W), int(inter.H)).Add(image.Pt(int(inter.X-iv.canvas.X), int(inter.Y-iv.canvas.Y)))
+	bg := raster.Crop(iv.canvasLayer.Image(), rel)
+	return iv.setCanvas(inter, bg)
+}
+
+// CropToSelection crops the canvas to the bounds of the selected pixels and
+// clears the selection.
+func (iv *View) CropToSelection() error {
+	r, ok := iv.selectionBounds()
+	if !ok {
+		return ErrNoSelection
+	}
+	if err := iv.Crop(r); err != nil {
+		return err
+	}
+	iv.ClearSelection()
+	return nil
+}
+
+// Trim crops the canvas to the smallest rectangle containing every visible
+// pixel.
+func (iv *View) Trim() error {
+	img, err := iv.CanvasImage()
+	if err != nil {
+		return err
+	}
+	b := raster.OpaqueBounds(img)
+	if b.Empty() {
+		return ErrEmptyCrop
+	}
+	return iv.Crop(sdl.Rect{
+		X: iv.canvas.X + int32(b.Min.X),
+		Y: iv.canvas.Y + int32(b.Min.Y),
+		W: int32(b.Dx()),
+		H: int32(b.Dy()),
+	})
+}
+
+var (
+	cropColor  = [4]float32{1.0, 1.0, 1.0, 1.0}
+	guideColor = [4]float32{1.0, 1.0, 1.0, 0.5}
+	shadeColor = [4]float32{0.0, 0.0, 0.0, 0.5}
+)
+
+// CropTool crops the canvas to a rectangle dragged out with the left mouse
+// button, showing rule-of-thirds guides. Double-clicking inside the rectangle
+// or calling Apply performs the crop.
+type CropTool struct {
+	start    sdl.Point
+	rect     sdl.Rect
+	dragging bool
+}
+
+// OnClick is called when the user clicks within the Image View's region and the
+// tool is currently active for the image view.
+func (t *CropTool) OnClick(evt *sdl.MouseButtonEvent, iv *View) {
+	if evt.Button != sdl.BUTTON_LEFT {
+		return
+	}
+	if evt.State == sdl.RELEASED {
+		t.dragging = false
+		return
+	}
+	if evt.Clicks == 2 && t.rect.W > 0 && ui.InBounds(t.rect, iv.mousePix) {
+		if err := t.Apply(iv); err != nil {
+			log.Warnf("failed to crop: %v", err)
+		}
+		return
+	}
+	t.start = iv.mousePix
+	t.dragging = true
+	t.update(iv)
+}
+
+// OnMotion is called when the user clicks within the Image View's region and
+// the tool is currently active for the image view.
+func (t *CropTool) OnMotion(evt *sdl.MouseMotionEvent, iv *View) {
+	if t.dragging && evt.State == sdl.ButtonLMask() {
+		t.update(iv)
+	}
+}
+
+// update spans the crop rectangle from the drag start to the cursor
+func (t *CropTool) update(iv *View) {
+	a, b := t.start, iv.mousePix
+	r := sdl.Rect{
+		X: min32(a.X, b.X),
+		Y: min32(a.Y, b.Y),
+		W: abs32(a.X-b.X) + 1,
+		H: abs32(a.Y-b.Y) + 1,
+	}
+	if inter, ok := iv.canvas.Intersect(&r); ok {
+		t.rect = inter
+	} else {
+		t.rect = sdl.Rect{}
+	}
+}
+
+// Apply crops the canvas to the dragged rectangle.
+func (t *CropTool) Apply(iv *View) error {
+	if t.rect.W <= 0 || t.rect.H <= 0 {
+		return nil
+	}
+	err := iv.Crop(t.rect)
+	t.rect = sdl.Rect{}
+	return err
+}
+
+func (t *CropTool) activate(iv *View) {}
+
+func (t *CropTool) deactivate(iv *View) {
+	t.rect = sdl.Rect{}
+	t.dragging = false
+}
+
+// RenderOverlay shades the canvas outside of the crop rectangle and draws the
+// rectangle with rule-of-thirds guides.
+func (t *CropTool) RenderOverlay(iv *View) {
+	if t.rect.W <= 0 || t.rect.H <= 0 {
+		return
+	}
+	c := ui.RectToFRect(iv.canvas)
+	r := ui.RectToFRect(t.rect)
+	quad := func(x1, y1, x2, y2 float32) []float32 {
+		return []float32{x1, y2, x1, y1, x2, y1, x1, y2, x2, y1, x2, y2}
+	}
+	var shade []float32
+	shade = append(shade, quad(c.X, c.Y, c.X+c.W, r.Y)...)
+	shade = append(shade, quad(c.X, r.Y+r.H, c.X+c.W, c.Y+c.H)...)
+	shade = append(shade, quad(c.X, r.Y, r.X, r.Y+r.H)...)
+	shade = append(shade, quad(r.X+r.W, r.Y, c.X+c.W, r.Y+r.H)...)
+	iv.drawOverlay(iv.triBuf, shade, shadeColor)
+
+	var guides []float32
+	for i := float32(1); i < 3; i++ {
+		x := r.X + r.W*i/3
+		y := r.Y + r.H*i/3
+		guides = append(guides, x, r.Y, x, r.Y+r.H, r.X, y, r.X+r.W, y)
+	}
+	iv.drawOverlay(iv.lineBuf, guides, guideColor)
+
+	outline := []float32{
+		r.X, r.Y, r.X + r.W, r.Y,
+		r.X + r.W, r.Y, r.X + r.W, r.Y + r.H,
+		r.X + r.W, r.Y + r.H, r.X, r.Y + r.H,
+		r.X, r.Y + r.H, r.X, r.Y,
+	}
+	iv.drawOverlay(iv.lineBuf, outline, cropColor)
+}
+
+func (t *CropTool) String() string {
+	return "image.CropTool"
+}
+
+func min32(a, b int32) int32 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func abs32(a int32) int32 {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+// Reorient rotates or mirrors the whole image. Every layer's pixels and
+// position are transformed within the canvas, so the operation is lossless
+// and can be undone exactly by the opposite orientation.
+func (iv *View) Reorient(o raster.Orientation) error {
+	iv.edited()
+	w, h := int(iv.canvas.W), int(iv.canvas.H)
+	for _, l := range iv.layers {
+		rel := image.Rect(0, 0, int(l.area.W), int(l.area.H)).Add(image.Pt(int(l.area.X-iv.canvas.X), int(l.area.Y-iv.canvas.Y)))
+		r := o.MapRect(rel, w, h)
+		offset := sdl.Point{X: iv.canvas.X + int32(r.Min.X), Y: iv.canvas.Y + int32(r.Min.Y)}
+		err := iv.resampleLayer(l, offset, func(img *image.NRGBA) *image.NRGBA {
+			return raster.Reorient(img, o)
+		}, func(f *raster.Float) *raster.Float {
+			return raster.ReorientFloat(f, o)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if o.SwapsAxes() {
+		iv.canvas.W, iv.canvas.H = iv.canvas.H, iv.canvas.W
+	}
+
+	selection := make(map[sdl.Point]struct{}, len(iv.selection))
+	for p := range iv.selection {
+		rel := image.Rect(int(p.X-iv.canvas.X), int(p.Y-iv.canvas.Y), int(p.X-iv.canvas.X)+1, int(p.Y-iv.canvas.Y)+1)
+		r := o.MapRect(rel, w, h)
+		selection[sdl.Point{X: iv.canvas.X + int32(r.Min.X), Y: iv.canvas.Y + int32(r.Min.Y)}] = struct{}{}
+	}
+	iv.selection = selection
+	return nil
+}
@@ -0,0 +1,143 @@
+package image
+
+import (
+	"image"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// DeepFilter is a LayerFilter for pixels of more than 8 bits per channel
+type DeepFilter func(*raster.Float) (*raster.Float, error)
+
+// Depth returns the precision of the color channels of the document
+func (iv *View) Depth() raster.Depth {
+	return iv.depth
+}
+
+// SetDepth changes the precision of the document. Lowering it rounds the
+// pixels of every layer.
+func (iv *View) SetDepth(d raster.Depth) error {
+	if _, err := raster.ParseDepth(d.String()); err != nil {
+		return err
+	}
+	if iv.palette != nil && d != raster.Depth8 {
+		return ErrIndexedDepth
+	}
+	if err := iv.RevertPreview(); err != nil {
+		return err
+	}
+	for _, l := range iv.layers {
+		if d == raster.Depth8 {
+			l.deep = nil
+			continue
+		}
+		f := l.Deep()
+		f.Quantize(d)
+		l.deep = f
+	}
+	iv.depth = d
+	iv.edited()
+	return nil
+}
+
+// Linear reports whether layers are blended in linear light
+func (iv *View) Linear() bool {
+	return iv.linear
+}
+
+// SetLinear sets whether layers are blended in linear light when the canvas
+// is exported. The display always blends sRGB values.
+func (iv *View) SetLinear(linear bool) {
+	iv.linear = linear
+	iv.edited()
+}
+
+// AddDeepLayer adds a new layer at the origin holding a copy of f, rounded
+// to the precision of the document
+func (iv *View) AddDeepLayer(f *raster.Float) error {
+	if err := iv.AddImageLayer(f.NRGBA()); err != nil {
+		return err
+	}
+	if iv.depth != raster.Depth8 {
+		f = f.Copy()
+		f.Quantize(iv.depth)
+		l := iv.layers[len(iv.layers)-1]
+		if err := l.SetDeep(sdl.Point{X: l.area.X, Y: l.area.Y}, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Empty reports whether the document has no layers besides the canvas
+func (iv *View) Empty() bool {
+	return len(iv.layers) == 1
+}
+
+// deepCanvas composites the visible layers on the CPU at the precision of
+// the document, blending in linear light if it is enabled
+func (iv *View) deepCanvas() *raster.Float {
+	return iv.Document().Composite()
+}
+
+// filterLayer replaces the pixels of l with the result of deep at the
+// precision of the document, or with the result of f if the document is
+// 8-bit or deep is nil
+func (iv *View) filterLayer(l *Layer, f LayerFilter, deep DeepFilter) error {
+	iv.edited()
+	offset := sdl.Point{X: l.area.X, Y: l.area.Y}
+	if iv.depth == raster.Depth8 || deep == nil {
+		img, err := f(l.Image())
+		if err != nil {
+			return err
+		}
+		return l.SetImage(offset, img)
+	}
+	out, err := deep(l.Deep())
+	if err != nil {
+		return err
+	}
+	out.Quantize(iv.depth)
+	return l.SetDeep(offset, out)
+}
+
+// resampleLayer replaces the pixels of l with the result of f, or with the
+// result of deep at the precision of the document if l has precise pixels,
+// with the top left corner placed at offset
+func (iv *View) resampleLayer(l *Layer, offset sdl.Point, f func(*image.NRGBA) *image.NRGBA, deep func(*raster.Float) *raster.Float) error {
+	iv.edited()
+	if l.deep == nil {
+		return l.SetImage(offset, f(l.Image()))
+	}
+	out := deep(l.deep)
+	out.Quantize(iv.depth)
+	return l.SetDeep(offset, out)
+}
+
+// FilterLayer replaces the pixels of l with the result of deep at the
+// precision of the document, or with the result of f if l has no precise
+// pixels or deep is nil
+func (d *Document) FilterLayer(l *DocumentLayer, f LayerFilter, deep DeepFilter) error {
+	if l.Deep == nil || deep == nil {
+		img, err := f(l.Image)
+		if err != nil {
+			return err
+		}
+		l.SetImage(img)
+		return nil
+	}
+	out, err := deep(l.Deep)
+	if err != nil {
+		return err
+	}
+	out.Quantize(d.Depth)
+	if out.Rect.Min != (image.Point{}) {
+		out = out.Copy()
+		out.Rect = out.Rect.Sub(out.Rect.Min)
+	}
+	l.Deep = out
+	l.Image = out.NRGBA()
+	l.Area.W, l.Area.H = int32(out.Rect.Dx()), int32(out.Rect.Dy())
+	return nil
+}
@@ -0,0 +1,653 @@
+package image
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/codec"
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// Document is a Project held without OpenGL, so that projects can be read,
+// composited and written without a window. The first layer is the canvas
+// background.
+type Document struct {
+	ProjName string
+	Mult     int32
+	Canvas   sdl.Rect
+	View     sdl.FRect
+	Layers   []*DocumentLayer
+	Metadata codec.Metadata
+	Depth    raster.Depth
+	Linear   bool
+	Palette  []color.NRGBA
+}
+
+// DocumentLayer is a Layer of a Document
+type DocumentLayer struct {
+	// Area is the position of the layer in project coordinates and its size
+	Area  sdl.Rect
+	Image *image.NRGBA
+	// Deep, if set, holds the pixels at the precision of the document
+	Deep *raster.Float
+	// Indexed, if set, holds the palette index of every pixel
+	Indexed *image.Paletted
+	layerAttrs
+}
+
+// ErrInvalidScale indicates a scale that leaves no pixels or is not a number
+const ErrInvalidScale log.ConstErr = "scale must be a positive number"
+
+// MarshalBinary fulfills a requirement for gob to encode DocumentLayer
+func (l DocumentLayer) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	enc := gob.NewEncoder(&buf)
+	if err = enc.Encode(l.Area); err != nil {
+		return nil, err
+	}
+	if err = enc.Encode(packed(l.Image)); err != nil {
+		return nil, err
+	}
+	if err = enc.Encode(l.layerAttrs); err != nil {
+		return nil, err
+	}
+	var deep []float32
+	if l.Deep != nil {
+		deep = l.Deep.Pix
+	}
+	if err = enc.Encode(deep); err != nil {
+		return nil, err
+	}
+	var indices []byte
+	if l.Indexed != nil {
+		indices = l.Indexed.Pix
+	}
+	if err = enc.Encode(indices); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary fulfills a requirement for gob to decode DocumentLayer
+func (l *DocumentLayer) UnmarshalBinary(data []byte) error {
+	var err error
+	dec := gob.NewDecoder(bytes.NewReader(data))
+
+	if err = dec.Decode(&l.Area); err != nil {
+		return err
+	}
+	bounds := image.Rect(0, 0, int(l.Area.W), int(l.Area.H))
+	var texData = make([]byte, l.Area.W*l.Area.H*4)
+	if err = dec.Decode(&texData); err != nil {
+		return err
+	}
+	l.Image = &image.NRGBA{Pix: texData, Stride: int(l.Area.W) * 4, Rect: bounds}
+
+	// projects saved before layers had attributes end here
+	if err = dec.Decode(&l.layerAttrs); errors.Is(err, io.EOF) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	// and projects saved before documents had a depth here
+	var deep []float32
+	if err = dec.Decode(&deep); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if len(deep) == int(l.Area.W*l.Area.H*4) {
+		l.Deep = &raster.Float{Pix: deep, Stride: int(l.Area.W) * 4, Rect: bounds}
+	}
+	// and projects saved before documents could be indexed here. The palette
+	// is set by the document.
+	var indices []byte
+	if err = dec.Decode(&indices); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if len(indices) == int(l.Area.W*l.Area.H) {
+		l.Indexed = &image.Paletted{Pix: indices, Stride: int(l.Area.W), Rect: bounds}
+	}
+	return nil
+}
+
+// packed returns the pixels of img with no gaps between rows
+func packed(img *image.NRGBA) []byte {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if img.Stride == w*4 && len(img.Pix) == w*h*4 {
+		return img.Pix
+	}
+	data := make([]byte, w*h*4)
+	for j := 0; j < h; j++ {
+		copy(data[j*w*4:(j+1)*w*4], img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+j):])
+	}
+	return data
+}
+
+// codecLayer converts the layer to a layer of a document, positioned
+// relative to the canvas
+func (l *DocumentLayer) codecLayer(canvas sdl.Rect) codec.Layer {
+	composite := l.Composite
+	if composite == "" {
+		composite = codec.CompositeSrcOver
+	}
+	return codec.Layer{
+		Name:      l.Name,
+		Image:     l.Image,
+		Offset:    image.Pt(int(l.Area.X-canvas.X), int(l.Area.Y-canvas.Y)),
+		Opacity:   l.opacity(),
+		Hidden:    l.Hidden,
+		Composite: composite,
+	}
+}
+
+// Opacity returns how opaque the layer is drawn, from zero to one
+func (l *DocumentLayer) Opacity() float64 {
+	return l.opacity()
+}
+
+// Document returns a copy of the project that needs no OpenGL
+func (iv *View) Document() *Document {
+	d := &Document{
+		ProjName: iv.projName,
+		Mult:     iv.mult,
+		Canvas:   iv.canvas,
+		View:     iv.view,
+		Metadata: iv.metadata,
+		Depth:    iv.depth,
+		Linear:   iv.linear,
+	}
+	for _, l := range iv.layers {
+		d.Layers = append(d.Layers, l.saved())
+	}
+	for _, c := range iv.palette {
+		d.Palette = append(d.Palette, color.NRGBAModel.Convert(c).(color.NRGBA))
+	}
+	return d
+}
+
+// LoadDocument replaces the project with d, which must not be used
+// afterwards. The view is kept unless the canvas changes size.
+func (iv *View) LoadDocument(d *Document) error {
+	if len(d.Layers) == 0 {
+		return fmt.Errorf("%w: document has no canvas", ErrInvalidFormat)
+	}
+	palette := d.palette()
+	layers := make([]*Layer, 0, len(d.Layers))
+	for _, dl := range d.Layers {
+		tex, err := newTexture(dl.Image)
+		if err != nil {
+			for _, l := range layers {
+				l.Destroy()
+			}
+			return err
+		}
+		l := NewLayer(sdl.Point{X: dl.Area.X, Y: dl.Area.Y}, tex)
+		l.attrs = dl.layerAttrs
+		l.deep = dl.Deep
+		if dl.Indexed != nil && palette != nil {
+			l.indexed = dl.Indexed
+			l.indexed.Palette = palette
+		}
+		layers = append(layers, l)
+	}
+
+	for _, l := range iv.layers {
+		l.Destroy()
+	}
+	resized := d.Canvas.W != iv.canvas.W || d.Canvas.H != iv.canvas.H
+	iv.edited()
+	iv.layers = layers
+	iv.canvasLayer = layers[0]
+	iv.canvas = d.Canvas
+	iv.selLayer = nil
+	iv.preview = nil
+	iv.projName = d.ProjName
+	iv.metadata = d.Metadata
+	iv.depth = d.Depth
+	if iv.depth == 0 {
+		iv.depth = raster.Depth8
+	}
+	iv.linear = d.Linear
+	iv.palette = palette
+	iv.ClearSelection()
+	if resized {
+		iv.CenterCanvas()
+	}
+	return nil
+}
+
+// ReadDocument reads the project at path without OpenGL. Files not ending
+// with '.tabula' are read as layered images, such as OpenRaster or Photoshop
+// files, and folders as project folders. Linked layers show the current
+// content of their files, and those whose file is missing keep their saved
+// pixels with a warning.
+func ReadDocument(path string) (*Document, error) {
+	d, err := readDocument(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, err := range d.RefreshLinks() {
+		log.Warn(err)
+	}
+	return d, nil
+}
+
+// readDocument reads the project at path as it was saved
+func readDocument(path string) (*Document, error) {
+	if dir, ok := folderPath(path); ok {
+		return readFolder(dir)
+	}
+	if filepath.Ext(path) != ".tabula" {
+		doc, err := codec.DecodeLayersFile(path)
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		return DocumentFromLayered(name, doc), nil
+	}
+	d := &Document{}
+	if err := readProject(path, d); err != nil {
+		return nil, err
+	}
+	if err := d.loaded(path); err != nil {
+		return nil, err
+	}
+	resolveSources(d.attrs(), filepath.Dir(path))
+	return d, nil
+}
+
+// loaded completes a document decoded from the project named name
+func (d *Document) loaded(name string) error {
+	if d.Depth == 0 {
+		d.Depth = raster.Depth8
+	}
+	if len(d.Layers) == 0 {
+		return fmt.Errorf("%w: %v has no canvas", ErrInvalidFormat, name)
+	}
+	palette := d.palette()
+	for _, l := range d.Layers {
+		if l.Indexed == nil {
+			continue
+		}
+		if palette == nil {
+			l.Indexed = nil
+			continue
+		}
+		l.Indexed.Palette = palette
+	}
+	return nil
+}
+
+// DocumentFromLayered returns a document of the layers of doc over a
+// transparent canvas. Layers without pixels are skipped.
+func DocumentFromLayered(name string, doc *codec.Layered) *Document {
+	canvas := sdl.Rect{X: -int32(doc.Width) / 2, Y: -int32(doc.Height) / 2, W: int32(doc.Width), H: int32(doc.Height)}
+	d := &Document{
+		ProjName: name,
+		Canvas:   canvas,
+		View:     sdl.FRect{X: float32(canvas.X), Y: float32(canvas.Y), W: float32(canvas.W), H: float32(canvas.H)},
+		Depth:    raster.Depth8,
+		Layers:   []*DocumentLayer{{Area: canvas, Image: image.NewNRGBA(image.Rect(0, 0, doc.Width, doc.Height))}},
+	}
+	for _, dl := range doc.Layers {
+		if dl.Image == nil || dl.Image.Rect.Empty() {
+			log.Warnf("skipping layer %q: %v", dl.Name, ErrEmptyImage)
+			continue
+		}
+		src := codec.ToNRGBA(dl.Image)
+		img := &image.NRGBA{Pix: packed(src), Stride: src.Rect.Dx() * 4, Rect: src.Rect.Sub(src.Rect.Min)}
+		l := &DocumentLayer{
+			Area: sdl.Rect{
+				X: canvas.X + int32(dl.Offset.X),
+				Y: canvas.Y + int32(dl.Offset.Y),
+				W: int32(img.Rect.Dx()),
+				H: int32(img.Rect.Dy()),
+			},
+			Image: img,
+		}
+		l.layerAttrs = layerAttrs{
+			Hidden:       dl.Hidden,
+			Name:         dl.Name,
+			Transparency: 1 - dl.Opacity,
+			Composite:    dl.Composite,
+		}
+		d.Layers = append(d.Layers, l)
+	}
+	return d
+}
+
+// Save writes the document to path as a project. The path must end with
+// '.tabula', FolderExt to save a project folder, or '.ora' to save the
+// layers as OpenRaster.
+func (d *Document) Save(path string) error {
+	ext := filepath.Ext(filepath.Clean(path))
+	switch ext {
+	case FolderExt:
+		return d.saveFolder(filepath.Clean(path))
+	case ".tabula":
+		d.ProjName = strings.TrimSuffix(filepath.Base(path), ext)
+		defer relativeSources(d.attrs(), filepath.Dir(path))()
+		return writeProject(path, d)
+	case ".ora":
+		out, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		if err = codec.EncodeORA(out, d.Layered(), d.Composite().NRGBA()); err != nil {
+			out.Close()
+			return fmt.Errorf("encoding %v: %w", path, err)
+		}
+		return out.Close()
+	}
+	return fmt.Errorf("%w: %v", ErrInvalidFormat, path)
+}
+
+// palette returns the palette of an indexed document, or nil
+func (d *Document) palette() color.Palette {
+	var p color.Palette
+	for _, c := range d.Palette {
+		p = append(p, c)
+	}
+	return p
+}
+
+// Layered returns the layers as a document the size of the canvas. The
+// canvas background is the bottom layer if any of it is visible, and
+// unnamed layers are named by their position.
+func (d *Document) Layered() *codec.Layered {
+	doc := &codec.Layered{Width: int(d.Canvas.W), Height: int(d.Canvas.H)}
+	for i, l := range d.Layers {
+		dl := l.codecLayer(d.Canvas)
+		if i == 0 {
+			if raster.OpaqueBounds(dl.Image).Empty() {
+				continue
+			}
+			dl.Name = backgroundName
+		}
+		if dl.Name == "" {
+			dl.Name = fmt.Sprintf("Layer %d", i)
+		}
+		doc.Layers = append(doc.Layers, dl)
+	}
+	return doc
+}
+
+// Composite blends the visible layers on the CPU at the precision of the
+// document, in linear light if it is enabled
+func (d *Document) Composite() *raster.Float {
+	dst := raster.NewFloat(image.Rect(0, 0, int(d.Canvas.W), int(d.Canvas.H)))
+	for _, l := range d.Layers {
+		if l.Hidden {
+			continue
+		}
+		src := l.Deep
+		if src == nil {
+			src = raster.FloatFrom(l.Image)
+		}
+		at := image.Pt(int(l.Area.X-d.Canvas.X), int(l.Area.Y-d.Canvas.Y))
+		raster.Over(dst, src, at, l.opacity(), d.Linear)
+	}
+	dst.Quantize(d.Depth)
+	return dst
+}
+
+// Frames returns each visible layer over the canvas background as a frame
+// of an animation
+func (d *Document) Frames() []codec.Frame {
+	background := d.Layers[0].codecLayer(d.Canvas)
+	var frames []codec.Frame
+	for _, l := range d.Layers[1:] {
+		if l.Hidden {
+			continue
+		}
+		doc := &codec.Layered{
+			Width:  int(d.Canvas.W),
+			Height: int(d.Canvas.H),
+			Layers: []codec.Layer{background, l.codecLayer(d.Canvas)},
+		}
+		frames = append(frames, codec.Frame{Image: codec.Flatten(doc), Delay: l.Delay})
+	}
+	return frames
+}
+
+// Scale resizes the canvas and every layer by the factor, moving the layers
+// so they keep their place on the canvas. Indexed layers are mapped back
+// onto the palette.
+func (d *Document) Scale(factor float64, f raster.Filter) error {
+	if !(factor > 0) || math.IsInf(factor, 0) {
+		return fmt.Errorf("%w, got %v", ErrInvalidScale, factor)
+	}
+	w := int(math.Round(float64(d.Canvas.W) * factor))
+	h := int(math.Round(float64(d.Canvas.H) * factor))
+	if w <= 0 || h <= 0 {
+		return fmt.Errorf("%w, %v leaves no pixels", ErrInvalidScale, factor)
+	}
+	return d.Resize(w, h, f)
+}
+
+// Resize resizes the canvas to w by h pixels and every layer by the same
+// factors, moving the layers so they keep their place on the canvas
+func (d *Document) Resize(w, h int, f raster.Filter) error {
+	if w <= 0 || h <= 0 {
+		return fmt.Errorf("%w, got %vx%v", ErrInvalidSize, w, h)
+	}
+	if err := raster.CheckSize(w, h); err != nil {
+		return err
+	}
+	sx, sy := float64(w)/float64(d.Canvas.W), float64(h)/float64(d.Canvas.H)
+	scale := func(v int32, s float64) int32 {
+		return int32(math.Round(float64(v) * s))
+	}
+	for _, l := range d.Layers {
+		// layers may be larger than the canvas
+		lw, lh := scale(l.Area.W, sx), scale(l.Area.H, sy)
+		if err := raster.CheckSize(int(lw), int(lh)); err != nil {
+			return fmt.Errorf("resizing layer %q: %w", l.Name, err)
+		}
+	}
+	canvas := sdl.Rect{X: d.Canvas.X, Y: d.Canvas.Y, W: int32(w), H: int32(h)}
+	for _, l := range d.Layers {
+		x0, y0 := scale(l.Area.X-d.Canvas.X, sx), scale(l.Area.Y-d.Canvas.Y, sy)
+		x1, y1 := scale(l.Area.X+l.Area.W-d.Canvas.X, sx), scale(l.Area.Y+l.Area.H-d.Canvas.Y, sy)
+		if x1 <= x0 {
+			x1 = x0 + 1
+		}
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		l.Area = sdl.Rect{X: canvas.X + x0, Y: canvas.Y + y0, W: x1 - x0, H: y1 - y0}
+		lw, lh := int(l.Area.W), int(l.Area.H)
+		l.Image = raster.Resize(l.Image, lw, lh, f)
+		if l.Deep != nil {
+			l.Deep = raster.ResizeFloat(l.Deep, lw, lh, f)
+			l.Deep.Quantize(d.Depth)
+			l.Image = l.Deep.NRGBA()
+		}
+		if l.Indexed != nil {
+			l.Indexed = raster.ToPaletted(l.Image, l.Indexed.Palette, false)
+			l.Image = raster.FromPaletted(l.Indexed)
+		}
+	}
+	d.Canvas = canvas
+	return nil
+}
+
+// SetImage replaces the pixels of the layer, keeping its position. Precise
+// pixels that the new ones leave unchanged at 8 bits keep their precision,
+// and the pixels of indexed layers are mapped onto their palette.
+func (l *DocumentLayer) SetImage(img *image.NRGBA) {
+	img = codec.ToNRGBA(img)
+	if l.Indexed != nil {
+		l.Indexed = raster.ToPaletted(img, l.Indexed.Palette, false)
+		img = raster.FromPaletted(l.Indexed)
+	}
+	if l.Deep != nil {
+		if l.Deep.Rect.Size() == img.Rect.Size() {
+			l.Deep = raster.Reconcile(l.Deep, l.Image, img)
+		} else {
+			l.Deep = raster.FloatFrom(img)
+		}
+	}
+	l.Image = &image.NRGBA{Pix: packed(img), Stride: img.Rect.Dx() * 4, Rect: img.Rect.Sub(img.Rect.Min)}
+	l.Area.W, l.Area.H = int32(img.Rect.Dx()), int32(img.Rect.Dy())
+}
+
+// AddImage adds a layer holding img with its top left corner at x, y on the
+// canvas and returns it. The pixels are mapped onto the palette of an
+// indexed document.
+func (d *Document) AddImage(img *image.NRGBA, x, y int, name string) *DocumentLayer {
+	l := &DocumentLayer{Area: sdl.Rect{X: d.Canvas.X + int32(x), Y: d.Canvas.Y + int32(y)}}
+	l.Name = name
+	if p := d.palette(); p != nil {
+		l.Indexed = &image.Paletted{Palette: p}
+	}
+	l.SetImage(img)
+	d.Layers = append(d.Layers, l)
+	return l
+}
+
+// ResizeCanvas changes the size of the canvas, keeping its top left corner
+// and every layer in place
+func (d *Document) ResizeCanvas(w, h int) error {
+	if w <= 0 || h <= 0 {
+		return fmt.Errorf("%w, got %vx%v", ErrInvalidSize, w, h)
+	}
+	if err := raster.CheckSize(w, h); err != nil {
+		return err
+	}
+	bg := d.Layers[0]
+	bg.SetImage(raster.Crop(bg.Image, image.Rect(0, 0, w, h)))
+	d.Canvas.W, d.Canvas.H = int32(w), int32(h)
+	return nil
+}
+
+// WriteFile composites the document on the CPU and writes it to path in the
+// format registered for its extension, like View.WriteToFile
+func (d *Document) WriteFile(path string, opts codec.Options) error {
+	enc, err := codec.EncoderFor(path)
+	if err != nil {
+		return err
+	}
+	if _, ok := opts[codec.BitDepthOption]; !ok && d.Depth != raster.Depth8 {
+		deepOpts := codec.Options{codec.BitDepthOption: "16"}
+		for k, v := range opts {
+			deepOpts[k] = v
+		}
+		if _, err = enc.Resolve(deepOpts); err == nil {
+			opts = deepOpts
+		}
+	}
+	if opts, err = enc.Resolve(opts); err != nil {
+		return err
+	}
+	if enc.Animates(opts) {
+		return codec.EncodeFramesFile(path, d.Frames(), opts)
+	}
+	canvas := d.Composite()
+	if p := d.palette(); p != nil && enc.EncodeIndexed != nil {
+		return codec.EncodeFileIndexed(path, raster.ToPaletted(canvas.NRGBA(), p, false), opts, d.Metadata)
+	}
+	if d.Depth != raster.Depth8 {
+		return codec.EncodeFileDeep(path, canvas.NRGBA64(), opts, d.Metadata)
+	}
+	return codec.EncodeFileMeta(path, canvas.NRGBA(), opts, d.Metadata)
+}
+
+// readProject decompresses and decodes the project file at fileName into
+// proj
+func readProject(fileName string, proj interface{}) error {
+	in, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return decodeProject(in, proj)
+}
+
+// decodeProject decompresses and decodes the contents of a project file
+// from r into proj
+func decodeProject(r io.Reader, proj interface{}) error {
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("zlib reader error: %w", err)
+	}
+	defer zr.Close()
+
+	if err = gob.NewDecoder(zr).Decode(proj); err != nil {
+		return fmt.Errorf("gob decoder error: %w", err)
+	}
+	return nil
+}
+
+// writeProject encodes proj and writes it compressed to fileName
+func writeProject(fileName string, proj interface{}) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(proj); err != nil {
+		return err
+	}
+	out, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	zw := zlib.NewWriter(out)
+	if _, err = zw.Write(buf.Bytes()); err != nil {
+		out.Close()
+		return err
+	}
+	if err = zw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// NewDocument returns a document of a w by h canvas filled with the
+// background color, at the given precision
+func NewDocument(w, h int, background color.NRGBA, d raster.Depth) (*Document, error) {
+	if w <= 0 || h <= 0 {
+		return nil, fmt.Errorf("%w, got %vx%v", ErrInvalidSize, w, h)
+	}
+	if err := raster.CheckSize(w, h); err != nil {
+		return nil, err
+	}
+	if _, err := raster.ParseDepth(d.String()); err != nil {
+		return nil, err
+	}
+	bg := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < len(bg.Pix); i += 4 {
+		bg.Pix[i], bg.Pix[i+1], bg.Pix[i+2], bg.Pix[i+3] = background.R, background.G, background.B, background.A
+	}
+	doc := DocumentFromLayered("New Project", &codec.Layered{Width: w, Height: h})
+	doc.Layers[0].Image = bg
+	doc.Depth = d
+	return doc, nil
+}
+
+// Flatten replaces the layers with a single one holding the composited
+// canvas, over a transparent canvas background
+func (d *Document) Flatten() {
+	canvas := d.Composite()
+	flat := &DocumentLayer{Area: d.Canvas, Image: canvas.NRGBA()}
+	flat.Name = "Flattened"
+	if d.Depth != raster.Depth8 {
+		flat.Deep = canvas
+	}
+	if p := d.palette(); p != nil {
+		flat.Indexed = raster.ToPaletted(flat.Image, p, false)
+		flat.Image = raster.FromPaletted(flat.Indexed)
+	}
+	bg := &DocumentLayer{Area: d.Canvas, Image: image.NewNRGBA(image.Rect(0, 0, int(d.Canvas.W), int(d.Canvas.H)))}
+	d.Layers = []*DocumentLayer{bg, flat}
+}
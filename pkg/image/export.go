@@ -0,0 +1,61 @@
+package image
+
+import (
+	"image"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/codec"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// LayerImage returns the pixels of the selected layer, cropped to its bounds
+// or placed on an image the size of the canvas
+func (iv *View) LayerImage(canvasSize bool) (*image.NRGBA, error) {
+	l, err := iv.selectedLayer()
+	if err != nil {
+		return nil, err
+	}
+	doc := &codec.Layered{Width: int(iv.canvas.W), Height: int(iv.canvas.H)}
+	return codec.LayerImage(doc, iv.docLayer(l), canvasSize), nil
+}
+
+// SelectionImage returns the rendered canvas cropped to the bounds of the
+// selection, with the pixels that are not selected made transparent
+func (iv *View) SelectionImage() (*image.NRGBA, error) {
+	bounds, ok := iv.selectionBounds()
+	if !ok {
+		return nil, ErrNoSelection
+	}
+	canvas, err := iv.CanvasImage()
+	if err != nil {
+		return nil, err
+	}
+	x, y := bounds.X-iv.canvas.X, bounds.Y-iv.canvas.Y
+	img := raster.Crop(canvas, image.Rect(int(x), int(y), int(x+bounds.W), int(y+bounds.H)))
+	for j := int32(0); j < bounds.H; j++ {
+		for i := int32(0); i < bounds.W; i++ {
+			if _, ok := iv.selection[sdl.Point{X: bounds.X + i, Y: bounds.Y + j}]; !ok {
+				img.Pix[img.PixOffset(int(i), int(j))+3] = 0
+			}
+		}
+	}
+	return img, nil
+}
+
+// ExportLayers writes every layer, including the canvas background if any
+// of it is visible, as a PNG into dir and returns the paths written
+func (iv *View) ExportLayers(dir string, opts codec.SplitOptions) ([]string, error) {
+	return codec.WriteLayers(dir, iv.Layered(), opts)
+}
+
+// Metadata returns the EXIF, XMP and ICC blocks kept with the document
+func (iv *View) Metadata() codec.Metadata {
+	return iv.metadata
+}
+
+// SetMetadata replaces the metadata kept with the document, which exports
+// write back unless their options strip it
+func (iv *View) SetMetadata(m codec.Metadata) {
+	iv.metadata = m
+	iv.edited()
+}
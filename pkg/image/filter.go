@@ -0,0 +1,95 @@
+package image
+
+import (
+	"image"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// ErrNoLayerSelected indicates that an operation needs a selected layer
+const ErrNoLayerSelected log.ConstErr = "no layer selected"
+
+// LayerFilter computes new pixels for a layer from a copy of its current ones
+type LayerFilter func(*image.NRGBA) (*image.NRGBA, error)
+
+// filterPreview remembers the pixels of a layer while a filter is previewed
+type filterPreview struct {
+	layer *Layer
+	orig  *image.NRGBA
+	deep  *raster.Float
+}
+
+// selectedLayer returns the selected layer, unless it is the canvas
+func (iv *View) selectedLayer() (*Layer, error) {
+	if iv.selLayer == nil || iv.selLayer == iv.canvasLayer {
+		return nil, ErrNoLayerSelected
+	}
+	return iv.selLayer, nil
+}
+
+// ApplyFilter replaces the pixels of the selected layer with the result of f
+func (iv *View) ApplyFilter(f LayerFilter) error {
+	return iv.ApplyDeepFilter(f, nil)
+}
+
+// ApplyDeepFilter is ApplyFilter using deep instead of f if the document has
+// more than 8 bits per channel
+func (iv *View) ApplyDeepFilter(f LayerFilter, deep DeepFilter) error {
+	if err := iv.RevertPreview(); err != nil {
+		return err
+	}
+	l, err := iv.selectedLayer()
+	if err != nil {
+		return err
+	}
+	return iv.filterLayer(l, f, deep)
+}
+
+// PreviewFilter shows the result of f on the selected layer until the
+// preview is committed or reverted. Any earlier preview is reverted first.
+func (iv *View) PreviewFilter(f LayerFilter) error {
+	return iv.PreviewDeepFilter(f, nil)
+}
+
+// PreviewDeepFilter is PreviewFilter using deep instead of f if the document
+// has more than 8 bits per channel
+func (iv *View) PreviewDeepFilter(f LayerFilter, deep DeepFilter) error {
+	if err := iv.RevertPreview(); err != nil {
+		return err
+	}
+	l, err := iv.selectedLayer()
+	if err != nil {
+		return err
+	}
+	p := &filterPreview{layer: l, orig: l.Image()}
+	if l.deep != nil {
+		p.deep = l.deep.Copy()
+	}
+	if err = iv.filterLayer(l, f, deep); err != nil {
+		return err
+	}
+	iv.preview = p
+	return nil
+}
+
+// CommitPreview keeps the previewed filter result
+func (iv *View) CommitPreview() {
+	iv.preview = nil
+}
+
+// RevertPreview restores the layer pixels from before the previewed filter
+func (iv *View) RevertPreview() error {
+	if iv.preview == nil {
+		return nil
+	}
+	p := iv.preview
+	iv.preview = nil
+	iv.edited()
+	if err := p.layer.SetImage(sdl.Point{X: p.layer.area.X, Y: p.layer.area.Y}, p.orig); err != nil {
+		return err
+	}
+	p.layer.deep = p.deep
+	return nil
+}
@@ -0,0 +1,469 @@
+package image
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/codec"
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// FolderExt is the extension of project folders, which hold a manifest
+// describing the canvas and layers and a PNG file for every layer, so that
+// version control can store and merge their changes
+const FolderExt = ".tabuladir"
+
+// manifestName is the file of a project folder describing the project
+const manifestName = "manifest.json"
+
+// folderVersion is the version of the manifests written
+const folderVersion = 1
+
+// ErrFolderVersion indicates a project folder written by a newer version
+const ErrFolderVersion log.ConstErr = "project folder is from a newer version"
+
+// ErrFolderEmbed indicates a linked project folder, which cannot be kept in
+// a project
+const ErrFolderEmbed log.ConstErr = "project folders cannot be embedded"
+
+// manifest describes a project folder. Layer offsets are relative to the
+// top left of the canvas.
+type manifest struct {
+	Version    int             `json:"version"`
+	Name       string          `json:"name"`
+	Canvas     manifestRect    `json:"canvas"`
+	View       manifestView    `json:"view"`
+	Depth      raster.Depth    `json:"depth"`
+	Linear     bool            `json:"linear,omitempty"`
+	Palette    []string        `json:"palette,omitempty"`
+	EXIF       string          `json:"exif,omitempty"`
+	XMP        string          `json:"xmp,omitempty"`
+	ICC        string          `json:"icc,omitempty"`
+	Background manifestLayer   `json:"background"`
+	Layers     []manifestLayer `json:"layers"`
+}
+
+type manifestRect struct {
+	X      int32 `json:"x"`
+	Y      int32 `json:"y"`
+	Width  int32 `json:"width"`
+	Height int32 `json:"height"`
+}
+
+type manifestView struct {
+	Zoom   int32   `json:"zoom"`
+	X      float32 `json:"x"`
+	Y      float32 `json:"y"`
+	Width  float32 `json:"width"`
+	Height float32 `json:"height"`
+}
+
+type manifestLayer struct {
+	File      string  `json:"file"`
+	Name      string  `json:"name,omitempty"`
+	X         int32   `json:"x"`
+	Y         int32   `json:"y"`
+	Opacity   float64 `json:"opacity"`
+	Hidden    bool    `json:"hidden,omitempty"`
+	Delay     int     `json:"delay,omitempty"`
+	Composite string  `json:"composite,omitempty"`
+	Source    string  `json:"source,omitempty"`
+	Linked    bool    `json:"linked,omitempty"`
+	Embedded  string  `json:"embedded,omitempty"`
+	ScaleX    float64 `json:"scaleX,omitempty"`
+	ScaleY    float64 `json:"scaleY,omitempty"`
+}
+
+// IsProject reports whether path names a project rather than an image: a
+// '.tabula' file or a project folder.
+func IsProject(path string) bool {
+	_, ok := folderPath(path)
+	return ok || filepath.Ext(path) == ".tabula"
+}
+
+// folderPath returns the project folder path names, either the folder or
+// its manifest, and whether it names one. Existing folders are project
+// folders whatever their name.
+func folderPath(path string) (string, bool) {
+	if filepath.Base(path) == manifestName {
+		return filepath.Dir(path), true
+	}
+	if filepath.Ext(filepath.Clean(path)) == FolderExt {
+		return filepath.Clean(path), true
+	}
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		return filepath.Clean(path), true
+	}
+	return "", false
+}
+
+// linkSource returns the absolute path a layer linked to path watches for
+// changes, and the name of the layer. Project folders are linked by their
+// manifest, which is written last when they are saved, since changes to the
+// files within a folder do not change the folder itself.
+func linkSource(path string) (string, string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", "", err
+	}
+	if dir, ok := folderPath(abs); ok {
+		return filepath.Join(dir, manifestName), filepath.Base(dir), nil
+	}
+	return abs, filepath.Base(abs), nil
+}
+
+// folderFile is a file of a project folder to be written
+type folderFile struct {
+	name string
+	data []byte
+}
+
+// saveFolder writes the document as a project folder at dir, replacing the
+// files of any project saved there before. Every file is written aside and
+// renamed into place once all are, the manifest last, and the files only
+// the previous manifest used are removed after, so that a failed save
+// leaves the previous project whole. Layers of 32-bit documents are written
+// with 16 bits per channel.
+func (d *Document) saveFolder(dir string) error {
+	if d.Depth == raster.Depth32F {
+		log.Warnf("%v keeps 16 bits per channel of the %v document", dir, d.Depth)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	stale, err := filepath.Glob(filepath.Join(dir, "layer-*"))
+	if err != nil {
+		return err
+	}
+	d.ProjName = strings.TrimSuffix(filepath.Base(dir), FolderExt)
+	// sources are relative to the folder holding the project, like those
+	// of project files
+	defer relativeSources(d.attrs(), filepath.Dir(dir))()
+
+	m := manifest{
+		Version: folderVersion,
+		Name:    d.ProjName,
+		Canvas:  manifestRect{d.Canvas.X, d.Canvas.Y, d.Canvas.W, d.Canvas.H},
+		View:    manifestView{d.Mult, d.View.X, d.View.Y, d.View.W, d.View.H},
+		Depth:   d.Depth,
+		Linear:  d.Linear,
+	}
+	for _, c := range d.Palette {
+		m.Palette = append(m.Palette, raster.Hex(c))
+	}
+	var files []folderFile
+	metadata := []struct {
+		file *string
+		name string
+		data []byte
+	}{
+		{&m.EXIF, "exif.bin", d.Metadata.EXIF},
+		{&m.XMP, "xmp.xml", d.Metadata.XMP},
+		{&m.ICC, "profile.icc", d.Metadata.ICC},
+	}
+	for _, md := range metadata {
+		stale = append(stale, filepath.Join(dir, md.name))
+		if md.data == nil {
+			continue
+		}
+		files = append(files, folderFile{md.name, md.data})
+		*md.file = md.name
+	}
+	palette := d.palette()
+	for i, l := range d.Layers {
+		ml, layerFiles, err := d.folderLayer(i, l, palette)
+		if err != nil {
+			return fmt.Errorf("layer %v: %w", i, err)
+		}
+		files = append(files, layerFiles...)
+		if i == 0 {
+			m.Background = ml
+			continue
+		}
+		m.Layers = append(m.Layers, ml)
+	}
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	files = append(files, folderFile{manifestName, append(data, '\n')})
+	if err = writeFolderFiles(dir, files); err != nil {
+		return err
+	}
+
+	used := make(map[string]bool)
+	for _, f := range files {
+		used[f.name] = true
+	}
+	for _, path := range stale {
+		if used[filepath.Base(path)] {
+			continue
+		}
+		if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Warnf("removing %v: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// writeFolderFiles writes every file to a temporary file in dir, then
+// renames them into place in order once all are written
+func writeFolderFiles(dir string, files []folderFile) error {
+	temps := make([]string, 0, len(files))
+	defer func() {
+		// left only if writing or renaming failed
+		for _, tmp := range temps {
+			os.Remove(tmp)
+		}
+	}()
+	for _, f := range files {
+		tmp, err := ioutil.TempFile(dir, "."+f.name+"-")
+		if err != nil {
+			return err
+		}
+		temps = append(temps, tmp.Name())
+		_, err = tmp.Write(f.data)
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Chmod(tmp.Name(), 0644)
+		}
+		if err != nil {
+			return fmt.Errorf("writing %v: %w", f.name, err)
+		}
+	}
+	for len(files) > 0 {
+		if err := os.Rename(temps[0], filepath.Join(dir, files[0].name)); err != nil {
+			return err
+		}
+		temps, files = temps[1:], files[1:]
+	}
+	return nil
+}
+
+// folderLayer encodes the pixels of the layer at index i, and returns its
+// description with the files holding them and the file it embeds if any
+func (d *Document) folderLayer(i int, l *DocumentLayer, palette color.Palette) (manifestLayer, []folderFile, error) {
+	ml := manifestLayer{
+		File:      fmt.Sprintf("layer-%03d.png", i),
+		Name:      l.Name,
+		X:         l.Area.X - d.Canvas.X,
+		Y:         l.Area.Y - d.Canvas.Y,
+		Opacity:   l.opacity(),
+		Hidden:    l.Hidden,
+		Delay:     l.Delay,
+		Composite: l.Composite,
+		Source:    l.Source,
+		Linked:    l.Linked,
+		ScaleX:    l.ScaleX,
+		ScaleY:    l.ScaleY,
+	}
+	var img image.Image = l.Image
+	switch {
+	case l.Indexed != nil && palette != nil:
+		img = &image.Paletted{Pix: l.Indexed.Pix, Stride: l.Indexed.Stride, Rect: l.Indexed.Rect, Palette: palette}
+	case l.Deep != nil:
+		img = l.Deep.NRGBA64()
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return ml, nil, err
+	}
+	files := []folderFile{{ml.File, buf.Bytes()}}
+	if l.Embedded != nil {
+		ml.Embedded = fmt.Sprintf("layer-%03d-embedded%v", i, filepath.Ext(l.Source))
+		files = append(files, folderFile{ml.Embedded, l.Embedded})
+	}
+	return ml, files, nil
+}
+
+// readFolder reads the project folder at dir as it was saved
+func readFolder(dir string) (*Document, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, manifestName))
+	if err != nil {
+		return nil, err
+	}
+	var m manifest
+	if err = json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%w: %v: %v", ErrInvalidFormat, manifestName, err)
+	}
+	if m.Version > folderVersion {
+		return nil, fmt.Errorf("%w: version %v", ErrFolderVersion, m.Version)
+	}
+	d := &Document{
+		ProjName: m.Name,
+		Mult:     m.View.Zoom,
+		Canvas:   sdl.Rect{X: m.Canvas.X, Y: m.Canvas.Y, W: m.Canvas.Width, H: m.Canvas.Height},
+		View:     sdl.FRect{X: m.View.X, Y: m.View.Y, W: m.View.Width, H: m.View.Height},
+		Depth:    m.Depth,
+		Linear:   m.Linear,
+	}
+	if d.Depth == 0 {
+		d.Depth = raster.Depth8
+	}
+	for _, s := range m.Palette {
+		c, err := raster.ParseHex(s)
+		if err != nil {
+			return nil, err
+		}
+		d.Palette = append(d.Palette, c)
+	}
+	metadata := []struct {
+		data *[]byte
+		name string
+	}{
+		{&d.Metadata.EXIF, m.EXIF},
+		{&d.Metadata.XMP, m.XMP},
+		{&d.Metadata.ICC, m.ICC},
+	}
+	for _, md := range metadata {
+		if md.name == "" {
+			continue
+		}
+		path, err := folderEntry(dir, md.name)
+		if err != nil {
+			return nil, err
+		}
+		if *md.data, err = ioutil.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	palette := d.palette()
+	for _, ml := range append([]manifestLayer{m.Background}, m.Layers...) {
+		l, err := d.readFolderLayer(dir, ml, palette)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", ml.File, err)
+		}
+		d.Layers = append(d.Layers, l)
+	}
+	if err = d.loaded(dir); err != nil {
+		return nil, err
+	}
+	resolveSources(d.attrs(), filepath.Dir(dir))
+	return d, nil
+}
+
+// readFolderLayer reads the layer described by ml at the precision of the
+// document, mapping its pixels onto the palette of indexed documents
+func (d *Document) readFolderLayer(dir string, ml manifestLayer, palette color.Palette) (*DocumentLayer, error) {
+	if ml.File == "" {
+		return nil, fmt.Errorf("%w: layer without a file", ErrInvalidFormat)
+	}
+	path, err := folderEntry(dir, ml.File)
+	if err != nil {
+		return nil, err
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	img, err := png.Decode(in)
+	in.Close()
+	if err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	l := &DocumentLayer{
+		Area: sdl.Rect{X: d.Canvas.X + ml.X, Y: d.Canvas.Y + ml.Y, W: int32(b.Dx()), H: int32(b.Dy())},
+	}
+	l.layerAttrs = layerAttrs{
+		Hidden:       ml.Hidden,
+		Delay:        ml.Delay,
+		Name:         ml.Name,
+		Transparency: 1 - ml.Opacity,
+		Composite:    ml.Composite,
+		Source:       ml.Source,
+		Linked:       ml.Linked,
+		ScaleX:       ml.ScaleX,
+		ScaleY:       ml.ScaleY,
+	}
+	if ml.Embedded != "" {
+		if path, err = folderEntry(dir, ml.Embedded); err != nil {
+			return nil, err
+		}
+		if l.Embedded, err = ioutil.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if d.Depth != raster.Depth8 {
+		l.Deep = raster.FloatFrom(img)
+		l.Deep.Quantize(d.Depth)
+		l.Image = l.Deep.NRGBA()
+	} else {
+		l.Image = codec.ToNRGBA(img)
+	}
+	if palette != nil {
+		if p, ok := img.(*image.Paletted); ok && samePalette(p.Palette, palette) {
+			l.Indexed = &image.Paletted{Pix: p.Pix, Stride: p.Stride, Rect: p.Rect.Sub(p.Rect.Min)}
+		} else {
+			// the file was edited by another program
+			l.Indexed = raster.ToPaletted(l.Image, palette, false)
+		}
+		l.Image = raster.FromPaletted(&image.Paletted{Pix: l.Indexed.Pix, Stride: l.Indexed.Stride, Rect: l.Indexed.Rect, Palette: palette})
+	}
+	return l, nil
+}
+
+// folderEntry returns the path of the file a manifest names in the project
+// folder at dir. Names reaching outside of the folder are rejected, so that
+// opening a project cannot read other files into it.
+func folderEntry(dir, name string) (string, error) {
+	rel := filepath.FromSlash(name)
+	if filepath.IsAbs(rel) || filepath.VolumeName(rel) != "" || strings.HasPrefix(rel, string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %q is outside of the project folder", ErrInvalidFormat, name)
+	}
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		if part == ".." {
+			return "", fmt.Errorf("%w: %q is outside of the project folder", ErrInvalidFormat, name)
+		}
+	}
+	if rel = filepath.Clean(rel); rel == "." {
+		return "", fmt.Errorf("%w: %q is not a file", ErrInvalidFormat, name)
+	}
+	return filepath.Join(dir, rel), nil
+}
+
+// samePalette reports whether the colors of p are the first colors of
+// palette, so that the indices of an image using p index palette too
+func samePalette(p, palette color.Palette) bool {
+	if len(p) > len(palette) {
+		return false
+	}
+	for i, c := range p {
+		if color.NRGBAModel.Convert(c) != color.NRGBAModel.Convert(palette[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// loadFolder replaces the project with the project folder at dir, showing
+// it as it was last viewed
+func (iv *View) loadFolder(dir string) error {
+	d, err := readFolder(dir)
+	if err != nil {
+		return err
+	}
+	if err = iv.LoadDocument(d); err != nil {
+		return err
+	}
+	// documents never shown in a window have no view
+	if d.View.W > 0 && d.View.H > 0 {
+		iv.mult = d.Mult
+		iv.view = d.View
+	}
+	iv.refreshLinks()
+	iv.updateView()
+	return nil
+}
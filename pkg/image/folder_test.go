@@ -0,0 +1,186 @@
+package image_test
+
+import (
+	"errors"
+	stdimage "image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/image"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+	"github.com/gregjohnson2017/tabula-editor/pkg/watch"
+)
+
+func TestFolder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "folder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d, err := image.NewDocument(4, 3, color.NRGBA{0x10, 0x20, 0x30, 0xFF}, raster.Depth8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Metadata.XMP = []byte("<x:xmpmeta/>")
+	img := stdimage.NewNRGBA(stdimage.Rect(0, 0, 2, 2))
+	img.SetNRGBA(1, 1, color.NRGBA{0xFF, 0, 0, 0x80})
+	d.AddImage(img, 1, 1, "top")
+	d.Layers[1].Hidden = true
+	d.Layers[1].Transparency = 0.25
+	d.AddImage(img, 2, 0, "")
+
+	path := filepath.Join(dir, "p"+image.FolderExt)
+	if err = d.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	// saving fewer layers leaves no stale layer files
+	saved := *d
+	saved.Layers = saved.Layers[:2]
+	if err = saved.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(path, "layer-002.png")); !os.IsNotExist(err) {
+		t.Errorf("expected the removed layer's file to be deleted, got %v", err)
+	}
+	// a failed save leaves the saved project whole
+	broken := saved
+	broken.Layers = []*image.DocumentLayer{saved.Layers[0], {Image: stdimage.NewNRGBA(stdimage.Rect(0, 0, 0, 0))}}
+	if err = broken.Save(path); err == nil {
+		t.Fatal("expected a layer without pixels to fail to save")
+	}
+	if temps, _ := filepath.Glob(filepath.Join(path, ".*")); len(temps) > 0 {
+		t.Errorf("expected no temporary files to be left, got %v", temps)
+	}
+
+	for _, p := range []string{path, filepath.Join(path, "manifest.json")} {
+		got, err := image.ReadDocument(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ProjName != "p" || got.Canvas != d.Canvas || len(got.Layers) != 2 {
+			t.Fatalf("expected project p with a %v canvas and 2 layers, got %v with %v and %v", d.Canvas, got.ProjName, got.Canvas, len(got.Layers))
+		}
+		l := got.Layers[1]
+		if l.Name != "top" || !l.Hidden || l.Opacity() != 0.75 || l.Area != d.Layers[1].Area {
+			t.Errorf("expected the layer's attributes to be kept, got %q hidden %v opacity %v at %v", l.Name, l.Hidden, l.Opacity(), l.Area)
+		}
+		if !reflect.DeepEqual(l.Image.Pix, img.Pix) {
+			t.Errorf("expected the layer's pixels to be kept, got %v", l.Image.Pix)
+		}
+		if string(got.Metadata.XMP) != string(d.Metadata.XMP) {
+			t.Errorf("expected the metadata to be kept, got %q", got.Metadata.XMP)
+		}
+	}
+}
+
+func TestFolderOutside(t *testing.T) {
+	dir, err := ioutil.TempDir("", "folder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = ioutil.WriteFile(filepath.Join(dir, "secret.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := image.NewDocument(2, 2, color.NRGBA{}, raster.Depth8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "p"+image.FolderExt)
+	if err = d.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	manifest := filepath.Join(path, "manifest.json")
+	data, err := ioutil.ReadFile(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tamper := range []string{
+		`"file": "../secret.txt",`,
+		`"file": "layer-000.png", "embedded": "../secret.txt",`,
+		`"file": "layer-000.png", "embedded": "/etc/hostname",`,
+		`"file": "layer-000.png", "embedded": "a/../../secret.txt",`,
+	} {
+		evil := strings.Replace(string(data), `"file": "layer-000.png",`, tamper, 1)
+		if evil == string(data) {
+			t.Fatal("expected the manifest to name the background's file")
+		}
+		if err = ioutil.WriteFile(manifest, []byte(evil), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = image.ReadDocument(path); !errors.Is(err, image.ErrInvalidFormat) {
+			t.Errorf("%v: expected %v, got %v", tamper, image.ErrInvalidFormat, err)
+		}
+	}
+	if err = ioutil.WriteFile(manifest, []byte(strings.Replace(string(data), "{", `{"exif": "../secret.txt",`, 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = image.ReadDocument(path); !errors.Is(err, image.ErrInvalidFormat) {
+		t.Errorf("expected %v for metadata outside of the folder, got %v", image.ErrInvalidFormat, err)
+	}
+}
+
+func TestLinkedFolder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "folder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	linked, err := image.NewDocument(2, 2, color.NRGBA{0xFF, 0, 0, 0xFF}, raster.Depth8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	folder := filepath.Join(dir, "linked"+image.FolderExt)
+	if err = linked.Save(folder); err != nil {
+		t.Fatal(err)
+	}
+	d, err := image.NewDocument(4, 4, color.NRGBA{}, raster.Depth8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := d.AddLinked(folder, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Name != "linked"+image.FolderExt {
+		t.Errorf("expected the layer to be named after the folder, got %q", l.Name)
+	}
+
+	for _, mode := range []watch.Mode{watch.Poll, watch.Inotify} {
+		t.Run(string(mode), func(t *testing.T) {
+			w, err := watch.New(mode, 10*time.Millisecond)
+			if errors.Is(err, watch.ErrUnsupported) {
+				t.Skip(err)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+			if err = w.Add(l.Source); err != nil {
+				t.Fatal(err)
+			}
+			// give a poller a chance to stamp the manifest first
+			time.Sleep(30 * time.Millisecond)
+			linked.Layers[0].Hidden = !linked.Layers[0].Hidden
+			if err = linked.Save(folder); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case got := <-w.Changes():
+				if got != l.Source {
+					t.Errorf("got change of %v, want %v", got, l.Source)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("no change noticed after saving the linked folder")
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package image
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/codec"
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+)
+
+// ErrNotIndexed indicates that an operation needs an indexed document
+const ErrNotIndexed log.ConstErr = "document is not indexed"
+
+// ErrIndexRange indicates that a palette index past the end was given
+const ErrIndexRange log.ConstErr = "palette index out of range"
+
+// ErrIndexedDepth indicates that an indexed document was asked for more than
+// 8 bits per channel
+const ErrIndexedDepth log.ConstErr = "indexed documents are 8-bit"
+
+// PaletteMethod computes a palette of at most n opaque colors for img
+type PaletteMethod func(img *image.NRGBA, n int) color.Palette
+
+// defaultBrush is the color painted before any other is chosen
+var defaultBrush = color.NRGBA{R: 0xFF, G: 0x00, B: 0xFF, A: 0xFF}
+
+// Indexed reports whether every pixel of the document references a palette
+// entry
+func (iv *View) Indexed() bool {
+	return iv.palette != nil
+}
+
+// Palette returns a copy of the palette of an indexed document
+func (iv *View) Palette() color.Palette {
+	return append(color.Palette(nil), iv.palette...)
+}
+
+// BrushColor returns the color painted by the pixel color tool
+func (iv *View) BrushColor() color.NRGBA {
+	return iv.brush
+}
+
+// SetBrushColor sets the color painted by the pixel color tool. Indexed
+// documents paint the nearest palette entry.
+func (iv *View) SetBrushColor(c color.NRGBA) {
+	iv.setBrush(c)
+}
+
+// setBrush sets the brush color, recording the change
+func (iv *View) setBrush(c color.NRGBA) {
+	iv.brush = c
+	iv.record(MacroEvent{Kind: MacroBrush, Color: raster.Hex(c)})
+}
+
+// BrushIndex returns the palette entry painted in an indexed document, or -1
+// if the document is not indexed
+func (iv *View) BrushIndex() int {
+	if iv.palette == nil {
+		return -1
+	}
+	return iv.palette.Index(iv.brush)
+}
+
+// SetBrushIndex paints the palette entry i of an indexed document
+func (iv *View) SetBrushIndex(i int) error {
+	if err := iv.checkIndex(i); err != nil {
+		return err
+	}
+	iv.setBrush(color.NRGBAModel.Convert(iv.palette[i]).(color.NRGBA))
+	return nil
+}
+
+// checkIndex returns an error unless the document is indexed and i is one of
+// its palette entries
+func (iv *View) checkIndex(i int) error {
+	if iv.palette == nil {
+		return ErrNotIndexed
+	}
+	if i < 0 || i >= len(iv.palette) {
+		return fmt.Errorf("%w: %v of %v", ErrIndexRange, i, len(iv.palette))
+	}
+	return nil
+}
+
+// allPixels returns the pixels of every layer in a single row, for
+// computing a palette, so that layers of different sizes add no padding
+func (iv *View) allPixels() *image.NRGBA {
+	n := 0
+	for _, l := range iv.layers {
+		n += int(l.area.W) * int(l.area.H)
+	}
+	all := image.NewNRGBA(image.Rect(0, 0, n, 1))
+	i := 0
+	for _, l := range iv.layers {
+		img := l.Image()
+		w := img.Rect.Dx()
+		for j := 0; j < img.Rect.Dy(); j++ {
+			i += copy(all.Pix[i:], img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+j):][:w*4])
+		}
+	}
+	return all
+}
+
+// ConvertToIndexed computes a palette of at most n entries for the pixels
+// of every layer, reserving one for transparency if any pixel needs it, and
+// maps the layers onto it with the dithering method
+func (iv *View) ConvertToIndexed(method PaletteMethod, n int, d raster.Dither) error {
+	if n < 1 || n > 256 {
+		return fmt.Errorf("%w, got %v", codec.ErrPaletteSize, n)
+	}
+	all := iv.allPixels()
+	transparent := !all.Opaque()
+	if transparent && n > 1 {
+		n--
+	}
+	p := method(all, n)
+	if len(p) == 0 {
+		p = append(p, color.NRGBA{A: 0xFF})
+	}
+	return iv.RemapToPalette(p, d)
+}
+
+// RemapToPalette makes the document indexed with the palette p, mapping the
+// colors of every layer to the nearest entry with the dithering method. An
+// entry for transparency is added if p has none and room for it.
+func (iv *View) RemapToPalette(p color.Palette, d raster.Dither) error {
+	if len(p) == 0 || len(p) > 256 {
+		return fmt.Errorf("%w, got %v", codec.ErrPaletteSize, len(p))
+	}
+	p = append(color.Palette(nil), p...)
+	hasTransparent := false
+	for _, c := range p {
+		if _, _, _, a := c.RGBA(); a == 0 {
+			hasTransparent = true
+		}
+	}
+	if !hasTransparent && len(p) < 256 {
+		p = append(p, color.NRGBA{})
+	}
+	if err := iv.SetDepth(raster.Depth8); err != nil {
+		return err
+	}
+	iv.edited()
+	for _, l := range iv.layers {
+		if err := l.setIndexed(raster.ToPalettedDither(l.Image(), p, d)); err != nil {
+			return err
+		}
+	}
+	iv.palette = p
+	iv.refreshIndexed()
+	return nil
+}
+
+// ConvertToRGBA makes the document store colors instead of palette indices
+func (iv *View) ConvertToRGBA() {
+	iv.edited()
+	for _, l := range iv.layers {
+		l.indexed = nil
+	}
+	iv.palette = nil
+}
+
+// SwapPalette replaces the colors of the palette entries with those of p at
+// the same index, recoloring the pixels that use them. Entries past the end
+// of p keep their color.
+func (iv *View) SwapPalette(p color.Palette) error {
+	if iv.palette == nil {
+		return ErrNotIndexed
+	}
+	if len(p) == 0 || len(p) > 256 {
+		return fmt.Errorf("%w, got %v", codec.ErrPaletteSize, len(p))
+	}
+	for i := 0; i < len(p) && i < len(iv.palette); i++ {
+		iv.palette[i] = color.NRGBAModel.Convert(p[i])
+	}
+	iv.refreshIndexed()
+	return nil
+}
+
+// SetPaletteColor changes the color of palette entry i, recoloring the
+// pixels that use it
+func (iv *View) SetPaletteColor(i int, c color.NRGBA) error {
+	if err := iv.checkIndex(i); err != nil {
+		return err
+	}
+	iv.palette[i] = c
+	iv.refreshIndexed()
+	return nil
+}
+
+// SwapPaletteEntries exchanges the colors of palette entries i and j, so the
+// pixels using one are shown in the color of the other
+func (iv *View) SwapPaletteEntries(i, j int) error {
+	if err := iv.checkIndex(i); err != nil {
+		return err
+	}
+	if err := iv.checkIndex(j); err != nil {
+		return err
+	}
+	iv.palette[i], iv.palette[j] = iv.palette[j], iv.palette[i]
+	iv.refreshIndexed()
+	return nil
+}
+
+// refreshIndexed redraws every indexed layer with the current palette
+func (iv *View) refreshIndexed() {
+	iv.edited()
+	for _, l := range iv.layers {
+		if l.indexed == nil {
+			continue
+		}
+		l.indexed.Palette = iv.palette
+		if err := l.setIndexed(l.indexed); err != nil {
+			log.Warnf("redrawing indexed layer: %v", err)
+		}
+	}
+}
+
+// indexLayer maps the pixels of a new layer of an indexed document onto the
+// palette
+func (iv *View) indexLayer(l *Layer) error {
+	if iv.palette == nil {
+		return nil
+	}
+	return l.setIndexed(raster.ToPaletted(l.Image(), iv.palette, false))
+}
+
+// indexedCanvas returns the rendered canvas mapped onto the palette
+func (iv *View) indexedCanvas() (*image.Paletted, error) {
+	img, err := iv.CanvasImage()
+	if err != nil {
+		return nil, err
+	}
+	return raster.ToPaletted(img, iv.palette, false), nil
+}
@@ -1,11 +1,12 @@
 package image
 
 import (
-	"bytes"
-	"encoding/gob"
+	"image"
 
 	"github.com/go-gl/gl/v2.1/gl"
+	"github.com/gregjohnson2017/tabula-editor/pkg/codec"
 	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
 	"github.com/gregjohnson2017/tabula-editor/pkg/ui"
 	"github.com/kroppt/gfx"
 	"github.com/veandco/go-sdl2/sdl"
@@ -15,6 +16,52 @@ type Layer struct {
 	area    sdl.Rect
 	buffer  *gfx.VAO
 	texture gfx.Texture
+	// preview, if set, is drawn instead of the layer's area, in layer-local
+	// pixel coordinates
+	preview *raster.Affine
+	attrs   layerAttrs
+	// deep, if set, holds the pixels at the precision of the document. The
+	// texture is then an 8-bit copy for display.
+	deep *raster.Float
+	// indexed, if set, holds the palette index of every pixel of a layer of
+	// an indexed document. The texture then shows their colors.
+	indexed *image.Paletted
+	// missing is set on linked layers whose file could not be read when the
+	// project was loaded
+	missing bool
+}
+
+// layerAttrs are the saved properties of a Layer besides its pixels. Fields
+// are only ever added so older project files still load.
+type layerAttrs struct {
+	Hidden bool
+	// Delay is how long the layer is shown as an animation frame in
+	// hundredths of a second, or zero for the default.
+	Delay int
+	Name  string
+	// Transparency is one minus the opacity, so that layers saved without
+	// it are opaque.
+	Transparency float64
+	// Composite is the OpenRaster name of the blend operation, kept for
+	// interchange. Layers are always drawn source over.
+	Composite string
+	// Source is the absolute path of the image file the layer was opened
+	// from, if any
+	Source string
+	// Linked layers show the current content of Source, an image or a
+	// project, and are reloaded when it changes on disk
+	Linked bool
+	// Embedded, if set, is the file a linked layer shows, kept in the
+	// project instead of read from Source, which then only names it
+	Embedded []byte
+	// ScaleX and ScaleY resize the content a linked layer shows, zero
+	// meaning unscaled
+	ScaleX, ScaleY float64
+}
+
+// opacity returns how opaque the layer is drawn, from zero to one
+func (a layerAttrs) opacity() float64 {
+	return 1 - a.Transparency
 }
 
 func NewLayer(offset sdl.Point, texture gfx.Texture) *Layer {
@@ -32,6 +79,13 @@ func NewLayer(offset sdl.Point, texture gfx.Texture) *Layer {
 
 // Render draws the ui.Component
 func (l Layer) Render(view sdl.FRect) {
+	if l.attrs.Hidden {
+		return
+	}
+	if l.preview != nil {
+		l.renderTransformed(view, *l.preview)
+		return
+	}
 	fArea := ui.RectToFRect(l.area)
 	rect, ok := view.Intersect(&fArea)
 	if !ok {
@@ -95,47 +149,180 @@ func (l Layer) Render(view sdl.FRect) {
 	l.texture.Unbind()
 }
 
+// renderTransformed draws the whole layer through the affine transformation m
+func (l Layer) renderTransformed(view sdl.FRect, m raster.Affine) {
+	w, h := float64(l.area.W), float64(l.area.H)
+	ox, oy := float64(l.area.X)-float64(view.X), float64(l.area.Y)-float64(view.Y)
+	corner := func(x, y float64) (float32, float32) {
+		x, y = m.Apply(x, y)
+		return float32(x + ox), float32(y + oy)
+	}
+	blx, bly := corner(0, h)
+	tlx, tly := corner(0, 0)
+	trx, try := corner(w, 0)
+	brx, bry := corner(w, h)
+
+	triangles := []float32{
+		blx, bly, 0.0, 1.0, // bottom-left
+		tlx, tly, 0.0, 0.0, // top-left
+		trx, try, 1.0, 0.0, // top-right
+
+		blx, bly, 0.0, 1.0, // bottom-left
+		trx, try, 1.0, 0.0, // top-right
+		brx, bry, 1.0, 1.0, // bottom-right
+	}
+
+	err := l.buffer.Load(triangles, gl.STATIC_DRAW)
+	if err != nil {
+		log.Warnf("failed to load image triangles: %v", err)
+	}
+
+	l.texture.Bind()
+	l.buffer.Draw()
+	l.texture.Unbind()
+}
+
+// Image returns a copy of the Layer's pixels
+func (l Layer) Image() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, int(l.area.W), int(l.area.H)))
+	copy(img.Pix, l.texture.GetData())
+	return img
+}
+
+// ErrEmptyImage indicates that an image without any pixels was given
+const ErrEmptyImage log.ConstErr = "image has no pixels"
+
+// Deep returns a copy of the Layer's pixels at the precision of the document
+func (l Layer) Deep() *raster.Float {
+	if l.deep == nil {
+		return raster.FloatFrom(l.Image())
+	}
+	return l.deep.Copy()
+}
+
+// SetImage replaces the Layer's texture with the given pixels, with the top
+// left corner placed at offset. If the layer has more precise pixels, those
+// the new ones leave unchanged at 8 bits keep their precision.
+func (l *Layer) SetImage(offset sdl.Point, img *image.NRGBA) error {
+	var indexed *image.Paletted
+	if l.indexed != nil {
+		// indexed layers only hold colors of their palette
+		indexed = raster.ToPaletted(codec.ToNRGBA(img), l.indexed.Palette, false)
+		img = raster.FromPaletted(indexed)
+	}
+	tex, err := newTexture(img)
+	if err != nil {
+		return err
+	}
+	if l.deep != nil {
+		if l.deep.Rect.Size() == img.Rect.Size() {
+			after := codec.ToNRGBA(img)
+			l.deep = raster.Reconcile(l.deep, l.Image(), after)
+		} else {
+			l.deep = raster.FloatFrom(codec.ToNRGBA(img))
+		}
+	}
+	l.texture.Destroy()
+	l.texture = tex
+	l.area = sdl.Rect{X: offset.X, Y: offset.Y, W: tex.GetWidth(), H: tex.GetHeight()}
+	if indexed != nil {
+		l.indexed = indexed
+	}
+	return nil
+}
+
+// setIndexed replaces the Layer's pixels with the colors of the indices of p,
+// which must be the size of the layer, and keeps the indices
+func (l *Layer) setIndexed(p *image.Paletted) error {
+	tex, err := newTexture(raster.FromPaletted(p))
+	if err != nil {
+		return err
+	}
+	l.texture.Destroy()
+	l.texture = tex
+	l.indexed = p
+	return nil
+}
+
+// SetDeep replaces the Layer's pixels with f, which must already be rounded
+// to the precision of the document, with the top left corner placed at
+// offset
+func (l *Layer) SetDeep(offset sdl.Point, f *raster.Float) error {
+	tex, err := newTexture(f.NRGBA())
+	if err != nil {
+		return err
+	}
+	l.texture.Destroy()
+	l.texture = tex
+	l.area = sdl.Rect{X: offset.X, Y: offset.Y, W: tex.GetWidth(), H: tex.GetHeight()}
+	l.deep = f
+	if f.Rect.Min != (image.Point{}) {
+		l.deep = f.Copy()
+		l.deep.Rect = l.deep.Rect.Sub(f.Rect.Min)
+	}
+	return nil
+}
+
+// newTexture uploads the pixels of img to a new texture
+func newTexture(img *image.NRGBA) (gfx.Texture, error) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if w <= 0 || h <= 0 {
+		return gfx.Texture{}, ErrEmptyImage
+	}
+	tex, err := gfx.NewTexture(int32(w), int32(h), packed(img), gl.RGBA, 4, 4)
+	if err != nil {
+		return gfx.Texture{}, err
+	}
+	tex.SetParameter(gl.TEXTURE_MIN_FILTER, gl.LINEAR_MIPMAP_NEAREST)
+	tex.SetParameter(gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	return tex, nil
+}
+
 // Destroy destroys OpenGL assets associated with the Layer
 func (l Layer) Destroy() {
 	l.buffer.Destroy()
 	l.texture.Destroy()
 }
 
-// MarshalBinary fulfills a requirement for gob to encode Layer
-func (l Layer) MarshalBinary() ([]byte, error) {
-	var buf bytes.Buffer
-	var err error
-	enc := gob.NewEncoder(&buf)
-	if err = enc.Encode(l.area); err != nil {
-		return nil, err
+// saved returns a copy of the Layer in the form it is stored in a project
+func (l Layer) saved() *DocumentLayer {
+	d := &DocumentLayer{Area: l.area, Image: l.Image(), layerAttrs: l.attrs}
+	if l.deep != nil {
+		d.Deep = l.deep.Copy()
 	}
-	if err = enc.Encode(l.texture.GetData()); err != nil {
-		return nil, err
+	if l.indexed != nil {
+		d.Indexed = &image.Paletted{
+			Pix:     append([]byte(nil), l.indexed.Pix...),
+			Stride:  l.indexed.Stride,
+			Rect:    l.indexed.Rect,
+			Palette: l.indexed.Palette,
+		}
 	}
-	return buf.Bytes(), nil
+	return d
+}
+
+// MarshalBinary fulfills a requirement for gob to encode Layer
+func (l Layer) MarshalBinary() ([]byte, error) {
+	return l.saved().MarshalBinary()
 }
 
 // UnmarshalBinary fulfills a requirement for gob to decode Layer
 func (l *Layer) UnmarshalBinary(data []byte) error {
-	var err error
-	dec := gob.NewDecoder(bytes.NewReader(data))
-
-	if err = dec.Decode(&l.area); err != nil {
+	var d DocumentLayer
+	if err := d.UnmarshalBinary(data); err != nil {
 		return err
 	}
-	l.buffer = gfx.NewVAO(gl.TRIANGLES, []int32{2, 2})
-
-	var texData = make([]byte, l.area.W*l.area.H*4)
-	if err = dec.Decode(&texData); err != nil {
-		return err
-	}
-	tex, err := gfx.NewTexture(l.area.W, l.area.H, texData, gl.RGBA, 4, 4)
+	tex, err := gfx.NewTexture(d.Area.W, d.Area.H, d.Image.Pix, gl.RGBA, 4, 4)
 	if err != nil {
 		return err
 	}
 	tex.SetParameter(gl.TEXTURE_MIN_FILTER, gl.LINEAR_MIPMAP_NEAREST)
 	tex.SetParameter(gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	l.area = d.Area
+	l.buffer = gfx.NewVAO(gl.TRIANGLES, []int32{2, 2})
 	l.texture = tex
-
+	l.attrs = d.layerAttrs
+	l.deep = d.Deep
+	l.indexed = d.Indexed
 	return nil
 }
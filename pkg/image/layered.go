@@ -0,0 +1,221 @@
+package image
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-gl/gl/v2.1/gl"
+	"github.com/gregjohnson2017/tabula-editor/pkg/codec"
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/kroppt/gfx"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// backgroundName names the canvas background when it is exported as a layer
+const backgroundName = "Background"
+
+// docLayer converts the layer to a layer of a document, positioned relative
+// to the canvas
+func (iv *View) docLayer(l *Layer) codec.Layer {
+	return l.saved().codecLayer(iv.canvas)
+}
+
+// Layered returns the layers as a document the size of the canvas. The
+// canvas background is the bottom layer if any of it is visible, and
+// unnamed layers are named by their position.
+func (iv *View) Layered() *codec.Layered {
+	return iv.Document().Layered()
+}
+
+// LoadLayered replaces the canvas and layers with those of doc. Layers
+// without pixels are skipped.
+func (iv *View) LoadLayered(doc *codec.Layered) error {
+	canvas := sdl.Rect{X: -int32(doc.Width) / 2, Y: -int32(doc.Height) / 2, W: int32(doc.Width), H: int32(doc.Height)}
+	canvasTex, err := gfx.NewTexture(canvas.W, canvas.H, make([]byte, canvas.W*canvas.H*4), gl.RGBA, 4, 4)
+	if err != nil {
+		return err
+	}
+	canvasTex.SetParameter(gl.TEXTURE_MIN_FILTER, gl.LINEAR_MIPMAP_NEAREST)
+	canvasTex.SetParameter(gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	layers := []*Layer{NewLayer(sdl.Point{X: canvas.X, Y: canvas.Y}, canvasTex)}
+	for _, dl := range doc.Layers {
+		tex, err := newTexture(dl.Image)
+		if err != nil {
+			log.Warnf("skipping layer %q: %v", dl.Name, err)
+			continue
+		}
+		l := NewLayer(sdl.Point{X: canvas.X + int32(dl.Offset.X), Y: canvas.Y + int32(dl.Offset.Y)}, tex)
+		l.attrs = layerAttrs{
+			Hidden:       dl.Hidden,
+			Name:         dl.Name,
+			Transparency: 1 - dl.Opacity,
+			Composite:    dl.Composite,
+		}
+		layers = append(layers, l)
+	}
+
+	for _, l := range iv.layers {
+		l.Destroy()
+	}
+	iv.edited()
+	iv.layers = layers
+	iv.canvasLayer = layers[0]
+	iv.canvas = canvas
+	iv.selLayer = nil
+	iv.preview = nil
+	iv.metadata = codec.Metadata{}
+	iv.palette = nil
+	iv.ClearSelection()
+	iv.CenterCanvas()
+	return nil
+}
+
+// saveORA writes the layers to an OpenRaster file
+func (iv *View) saveORA(fileName string) error {
+	merged, err := iv.CanvasImage()
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err = codec.EncodeORA(out, iv.Layered(), merged); err != nil {
+		out.Close()
+		return fmt.Errorf("encoding %v: %w", fileName, err)
+	}
+	return out.Close()
+}
+
+// loadLayers replaces the layers with those of a layered image file
+func (iv *View) loadLayers(fileName string) error {
+	doc, err := codec.DecodeLayersFile(fileName)
+	if err != nil {
+		return err
+	}
+	if err = iv.LoadLayered(doc); err != nil {
+		return err
+	}
+	iv.rename(strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName)))
+	return nil
+}
+
+// LayerName returns the name of the selected layer
+func (iv *View) LayerName() (string, error) {
+	l, err := iv.selectedLayer()
+	if err != nil {
+		return "", err
+	}
+	return l.attrs.Name, nil
+}
+
+// SetLayerName renames the selected layer
+func (iv *View) SetLayerName(name string) error {
+	l, err := iv.selectedLayer()
+	if err != nil {
+		return err
+	}
+	l.attrs.Name = name
+	iv.edited()
+	return nil
+}
+
+// LayerOpacity returns how opaque the selected layer is drawn, from zero to
+// one
+func (iv *View) LayerOpacity() (float64, error) {
+	l, err := iv.selectedLayer()
+	if err != nil {
+		return 0, err
+	}
+	return l.attrs.opacity(), nil
+}
+
+// ErrInvalidOpacity indicates an opacity outside of zero to one
+const ErrInvalidOpacity log.ConstErr = "opacity must be from 0 to 1"
+
+// SetLayerOpacity sets how opaque the selected layer is drawn, from zero to
+// one
+func (iv *View) SetLayerOpacity(opacity float64) error {
+	if opacity < 0 || opacity > 1 {
+		return fmt.Errorf("%w: %v", ErrInvalidOpacity, opacity)
+	}
+	l, err := iv.selectedLayer()
+	if err != nil {
+		return err
+	}
+	l.attrs.Transparency = 1 - opacity
+	iv.edited()
+	return nil
+}
+
+// ErrNoSuchLayer indicates a layer index out of range
+const ErrNoSuchLayer log.ConstErr = "no such layer"
+
+// ErrMoveCanvas indicates an attempt to move the canvas background
+const ErrMoveCanvas log.ConstErr = "the canvas cannot be moved"
+
+// SelectedLayer returns the index of the selected layer, the canvas
+// background being 0, or -1 if none is selected
+func (iv *View) SelectedLayer() int {
+	for i, l := range iv.layers {
+		if l == iv.selLayer {
+			return i
+		}
+	}
+	return -1
+}
+
+// SelectLayer selects the layer at index i, the canvas background being 0
+func (iv *View) SelectLayer(i int) error {
+	if i < 0 || i >= len(iv.layers) {
+		return fmt.Errorf("%w: %v of %v", ErrNoSuchLayer, i, len(iv.layers)-1)
+	}
+	iv.selLayer = iv.layers[i]
+	return nil
+}
+
+// MoveLayer puts the top left corner of the layer at index i at x, y on the
+// canvas
+func (iv *View) MoveLayer(i int, x, y int32) error {
+	if i < 0 || i >= len(iv.layers) {
+		return fmt.Errorf("%w: %v of %v", ErrNoSuchLayer, i, len(iv.layers)-1)
+	}
+	if i == 0 {
+		return ErrMoveCanvas
+	}
+	l := iv.layers[i]
+	l.area.X, l.area.Y = iv.canvas.X+x, iv.canvas.Y+y
+	iv.edited()
+	return nil
+}
+
+// LayerInfo describes a layer without its pixels
+type LayerInfo struct {
+	Name string
+	// Area is the position of the layer relative to the canvas and its size
+	Area    sdl.Rect
+	Opacity float64
+	Hidden  bool
+	// Linked layers show the current content of Source, which is Missing if
+	// it could not be read when the project was loaded
+	Linked  bool
+	Missing bool
+	Source  string
+}
+
+// Layers describes every layer, the canvas background first
+func (iv *View) Layers() []LayerInfo {
+	infos := make([]LayerInfo, len(iv.layers))
+	for i, l := range iv.layers {
+		area := l.area
+		area.X -= iv.canvas.X
+		area.Y -= iv.canvas.Y
+		infos[i] = LayerInfo{
+			Name: l.attrs.Name, Area: area, Opacity: l.attrs.opacity(), Hidden: l.attrs.Hidden,
+			Linked: l.attrs.Linked, Missing: l.missing, Source: l.attrs.Source,
+		}
+	}
+	return infos
+}
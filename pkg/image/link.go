@@ -0,0 +1,212 @@
+package image
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/codec"
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+)
+
+// ErrNotLinked indicates a layer that is not linked to a file
+const ErrNotLinked log.ConstErr = "layer is not linked to a file"
+
+// ErrEmbedded indicates a linked layer whose file is kept in the project
+// rather than on disk
+const ErrEmbedded log.ConstErr = "linked file is embedded in the project"
+
+// ErrMissingLink indicates a linked layer whose file cannot be read
+const ErrMissingLink log.ConstErr = "linked file is missing"
+
+// linkFilter resamples the content of scaled linked layers
+const linkFilter = raster.Bicubic
+
+// ReadLinked reads the current content of a file a layer can be linked to:
+// an image, or a project which is composited as it was saved.
+func ReadLinked(path string) (*raster.Float, error) {
+	if IsProject(path) {
+		d, err := readDocument(path)
+		if err != nil {
+			return nil, err
+		}
+		return d.Composite(), nil
+	}
+	f, _, _, err := codec.DecodeFileDeep(path)
+	return f, err
+}
+
+// linkContent reads the file the linked layer shows at its own size
+func (a *layerAttrs) linkContent() (*raster.Float, error) {
+	if a.Embedded == nil {
+		f, err := ReadLinked(a.Source)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %v", ErrMissingLink, a.Source)
+		}
+		return f, err
+	}
+	if filepath.Ext(a.Source) == ".tabula" {
+		d := &Document{}
+		if err := decodeProject(bytes.NewReader(a.Embedded), d); err != nil {
+			return nil, fmt.Errorf("embedded %v: %w", filepath.Base(a.Source), err)
+		}
+		if err := d.loaded(a.Source); err != nil {
+			return nil, err
+		}
+		return d.Composite(), nil
+	}
+	f, _, _, err := codec.DecodeDeep(a.Source, a.Embedded)
+	return f, err
+}
+
+// linkedImage returns what the linked layer shows: the content of its file
+// resampled by its scale factors
+func (a *layerAttrs) linkedImage() (*raster.Float, error) {
+	f, err := a.linkContent()
+	if err != nil {
+		return nil, err
+	}
+	w, h := f.Rect.Dx(), f.Rect.Dy()
+	sw, sh := a.scaled(w, h)
+	if sw != w || sh != h {
+		f = raster.ResizeFloat(f, sw, sh, linkFilter)
+	}
+	return f, nil
+}
+
+// scaled returns the size content of w by h pixels is shown at by the
+// linked layer, at least one pixel
+func (a *layerAttrs) scaled(w, h int) (int, int) {
+	sx, sy := a.ScaleX, a.ScaleY
+	if sx == 0 {
+		sx = 1
+	}
+	if sy == 0 {
+		sy = 1
+	}
+	sw, sh := int(math.Round(float64(w)*sx)), int(math.Round(float64(h)*sy))
+	if sw < 1 {
+		sw = 1
+	}
+	if sh < 1 {
+		sh = 1
+	}
+	return sw, sh
+}
+
+// validScale reports whether a linked layer can be scaled by s
+func validScale(s float64) error {
+	if !(s > 0) || math.IsInf(s, 0) {
+		return fmt.Errorf("%w, got %v", ErrInvalidScale, s)
+	}
+	return nil
+}
+
+// relativeSources makes the source paths of the layers relative to dir
+// with forward slashes, as projects in dir store them, and returns a
+// function restoring them. Paths on another volume stay absolute.
+func relativeSources(attrs []*layerAttrs, dir string) func() {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	saved := make([]string, len(attrs))
+	for i, a := range attrs {
+		saved[i] = a.Source
+		if a.Source == "" {
+			continue
+		}
+		if rel, err := filepath.Rel(dir, a.Source); err == nil {
+			a.Source = filepath.ToSlash(rel)
+		}
+	}
+	return func() {
+		for i, a := range attrs {
+			a.Source = saved[i]
+		}
+	}
+}
+
+// resolveSources makes the source paths stored by a project in dir
+// absolute, and links project folders by their manifest like linkSource
+func resolveSources(attrs []*layerAttrs, dir string) {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	for _, a := range attrs {
+		if a.Source == "" {
+			continue
+		}
+		p := filepath.FromSlash(a.Source)
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(dir, p)
+		}
+		if folder, ok := folderPath(p); ok {
+			p = filepath.Join(folder, manifestName)
+		}
+		a.Source = p
+	}
+}
+
+// attrs returns the attributes of every layer of the document
+func (d *Document) attrs() []*layerAttrs {
+	attrs := make([]*layerAttrs, len(d.Layers))
+	for i, l := range d.Layers {
+		attrs[i] = &l.layerAttrs
+	}
+	return attrs
+}
+
+// RefreshLinks shows the current content of the file of every linked layer,
+// keeping the layers in place. Layers whose file cannot be read keep their
+// pixels, and their errors are returned.
+func (d *Document) RefreshLinks() []error {
+	var errs []error
+	for _, l := range d.Layers {
+		if !l.Linked {
+			continue
+		}
+		f, err := l.linkedImage()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("layer %q: %w", l.Name, err))
+			continue
+		}
+		if d.Depth == raster.Depth8 {
+			l.SetImage(f.NRGBA())
+			continue
+		}
+		f = f.Copy()
+		f.Quantize(d.Depth)
+		// the content replaces the precise pixels rather than being
+		// reconciled with them
+		l.Deep = nil
+		l.SetImage(f.NRGBA())
+		l.Deep = f
+	}
+	return errs
+}
+
+// AddLinked adds a layer linked to the image or project at path with its
+// top left corner at x, y on the canvas and returns it.
+func (d *Document) AddLinked(path string, x, y int) (*DocumentLayer, error) {
+	source, name, err := linkSource(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := ReadLinked(source)
+	if err != nil {
+		return nil, err
+	}
+	l := d.AddImage(f.NRGBA(), x, y, name)
+	l.Source = source
+	l.Linked = true
+	if d.Depth != raster.Depth8 {
+		f = f.Copy()
+		f.Quantize(d.Depth)
+		l.Deep = f
+	}
+	return l, nil
+}
@@ -0,0 +1,107 @@
+package image_test
+
+import (
+	"bytes"
+	"errors"
+	stdimage "image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/image"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+)
+
+// encodePNG returns a square PNG of the given size and color
+func encodePNG(t *testing.T, size int, c color.NRGBA) []byte {
+	img := stdimage.NewNRGBA(stdimage.Rect(0, 0, size, size))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func writePNG(t *testing.T, path string, size int, c color.NRGBA) {
+	if err := ioutil.WriteFile(path, encodePNG(t, size, c), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src.png")
+	writePNG(t, src, 2, color.NRGBA{0xFF, 0, 0, 0xFF})
+
+	d, err := image.NewDocument(4, 4, color.NRGBA{}, raster.Depth8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = d.AddLinked(src, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Mkdir(filepath.Join(dir, "out"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	project := filepath.Join(dir, "out", "p.tabula")
+	if err = d.Save(project); err != nil {
+		t.Fatal(err)
+	}
+
+	// the project shows the current content of the file
+	writePNG(t, src, 3, color.NRGBA{0, 0, 0xFF, 0xFF})
+	doc, err := image.ReadDocument(project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := doc.Layers[1]
+	if !l.Linked || l.Source != src {
+		t.Fatalf("expected a layer linked to %v, got %v linked to %q", src, l.Linked, l.Source)
+	}
+	if l.Area.W != 3 || l.Area.X-doc.Canvas.X != 1 || l.Image.NRGBAAt(0, 0).B != 0xFF {
+		t.Fatalf("expected the new content at 1,1, got %v", l.Area)
+	}
+
+	// and keeps its saved pixels if the file is missing
+	if err = os.Remove(src); err != nil {
+		t.Fatal(err)
+	}
+	if doc, err = image.ReadDocument(project); err != nil {
+		t.Fatal(err)
+	}
+	if l = doc.Layers[1]; l.Area.W != 2 || l.Image.NRGBAAt(0, 0).R != 0xFF {
+		t.Fatalf("expected the saved pixels, got %v", l.Area)
+	}
+	errs := doc.RefreshLinks()
+	if len(errs) != 1 || !errors.Is(errs[0], image.ErrMissingLink) {
+		t.Fatalf("expected %v, got %v", image.ErrMissingLink, errs)
+	}
+
+	// embedded content is shown without the file
+	l.Embedded = encodePNG(t, 1, color.NRGBA{0, 0xFF, 0, 0xFF})
+	if errs = doc.RefreshLinks(); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if l.Area.W != 1 || l.Image.NRGBAAt(0, 0).G != 0xFF {
+		t.Fatalf("expected the embedded content, got %v", l.Area)
+	}
+	if err = doc.Save(project); err != nil {
+		t.Fatal(err)
+	}
+	if doc, err = image.ReadDocument(project); err != nil {
+		t.Fatal(err)
+	}
+	if l = doc.Layers[1]; !l.Linked || l.Embedded == nil || l.Image.NRGBAAt(0, 0).G != 0xFF {
+		t.Fatalf("expected the embedded content to be saved, got %v", l.Area)
+	}
+}
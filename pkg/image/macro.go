@@ -0,0 +1,301 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"math"
+	"strings"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+	"github.com/gregjohnson2017/tabula-editor/pkg/ui"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// MacroVersion is the version of the macro files written
+const MacroVersion = 1
+
+// ErrMacroVersion indicates a macro file written by a newer version
+const ErrMacroVersion log.ConstErr = "unsupported macro version"
+
+// ErrNeedsWindow indicates a recorded action that can only be played in the
+// editor window
+const ErrNeedsWindow log.ConstErr = "action can only be played in the editor window"
+
+// MacroKind is the kind of a recorded event
+type MacroKind string
+
+// The kinds of recorded events
+const (
+	// MacroMenu is a chosen menu entry
+	MacroMenu MacroKind = "menu"
+	// MacroTool is a change of the active tool
+	MacroTool MacroKind = "tool"
+	// MacroBrush is a change of the brush color
+	MacroBrush MacroKind = "brush"
+	// MacroClick is a mouse button pressed or released over the canvas
+	MacroClick MacroKind = "click"
+	// MacroMotion is a mouse movement over the canvas with a button held
+	MacroMotion MacroKind = "motion"
+)
+
+// MacroEvent is one recorded action. Fields the kind of event does not use
+// are left empty.
+type MacroEvent struct {
+	Kind MacroKind `json:"kind"`
+	// Menu is the text of each menu entry leading to the chosen one
+	Menu []string `json:"menu,omitempty"`
+	// Tool is the name of the tool, as given by its String method
+	Tool string `json:"tool,omitempty"`
+	// Color is the brush color as RRGGBBAA
+	Color string `json:"color,omitempty"`
+	// X and Y are the mouse position relative to the top left corner of the
+	// canvas, in canvas pixels
+	X float64 `json:"x,omitempty"`
+	Y float64 `json:"y,omitempty"`
+	// Button, Pressed and Clicks describe a click
+	Button  uint8 `json:"button,omitempty"`
+	Pressed bool  `json:"pressed,omitempty"`
+	Clicks  uint8 `json:"clicks,omitempty"`
+	// Buttons is the mask of the buttons held during a motion
+	Buttons uint32 `json:"buttons,omitempty"`
+}
+
+// Macro is a recorded sequence of actions that can be played on any
+// document.
+type Macro struct {
+	Version int          `json:"version"`
+	Events  []MacroEvent `json:"events"`
+}
+
+// ReadMacro reads a macro file.
+func ReadMacro(path string) (*Macro, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &Macro{}
+	if err = json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("reading %v: %w", path, err)
+	}
+	if m.Version > MacroVersion {
+		return nil, fmt.Errorf("reading %v: %w %v", path, ErrMacroVersion, m.Version)
+	}
+	return m, nil
+}
+
+// Save writes the macro to path.
+func (m *Macro) Save(path string) error {
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// NewTool returns a new tool with the given name if it can be chosen without
+// a menu. Other tools are set by the menu entry choosing them.
+func NewTool(name string) (Tool, bool) {
+	for _, t := range []Tool{EmptyTool{}, &PixelSelectionTool{}, &PixelColorTool{}} {
+		if t.String() == name {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// StartRecording starts recording a macro, beginning with the current tool
+// and brush color, and discards any unfinished recording.
+func (iv *View) StartRecording() {
+	iv.macro = &Macro{Version: MacroVersion}
+	iv.record(MacroEvent{Kind: MacroTool, Tool: iv.activeTool.String()})
+	iv.record(MacroEvent{Kind: MacroBrush, Color: raster.Hex(iv.brush)})
+}
+
+// StopRecording stops recording and returns the recorded macro, or nil if
+// none was being recorded.
+func (iv *View) StopRecording() *Macro {
+	m := iv.macro
+	iv.macro = nil
+	return m
+}
+
+// Recording returns whether a macro is being recorded.
+func (iv *View) Recording() bool {
+	return iv.macro != nil
+}
+
+// RecordMenu records the choice of the menu entry at path while recording.
+func (iv *View) RecordMenu(path []string) {
+	iv.record(MacroEvent{Kind: MacroMenu, Menu: append([]string(nil), path...)})
+}
+
+// record appends e to the macro being recorded, if any
+func (iv *View) record(e MacroEvent) {
+	if iv.macro != nil {
+		iv.macro.Events = append(iv.macro.Events, e)
+	}
+}
+
+// recordMotion records a motion to the mouse position with the given
+// buttons held
+func (iv *View) recordMotion(buttons uint32) {
+	iv.record(MacroEvent{
+		Kind: MacroMotion, X: iv.mouseX - float64(iv.canvas.X), Y: iv.mouseY - float64(iv.canvas.Y),
+		Buttons: buttons,
+	})
+}
+
+// PlayInput applies a recorded click or motion at its position on the
+// canvas, as if the user made it.
+func (iv *View) PlayInput(e MacroEvent) {
+	iv.setMousePos(float64(iv.canvas.X)+e.X, float64(iv.canvas.Y)+e.Y)
+	switch e.Kind {
+	case MacroClick:
+		evt := &sdl.MouseButtonEvent{Button: e.Button, State: sdl.RELEASED, Clicks: e.Clicks}
+		if e.Pressed {
+			evt.State = sdl.PRESSED
+		}
+		iv.click(evt)
+	case MacroMotion:
+		if !iv.dragging {
+			iv.motion(&sdl.MouseMotionEvent{State: e.Buttons})
+		} else if e.Buttons == sdl.ButtonRMask() {
+			iv.drag()
+		}
+	}
+}
+
+// MacroMenuAction plays a menu entry on a document without a window. The
+// layer is the selected one, or nil if none is.
+type MacroMenuAction func(d *Document, layer *DocumentLayer) error
+
+// macroPlayer holds the state of the editor window while playing a macro on
+// a document
+type macroPlayer struct {
+	d        *Document
+	menus    map[string]MacroMenuAction
+	tool     string
+	brush    color.NRGBA
+	sel      *DocumentLayer
+	dragging bool
+	dragPix  sdl.Point
+	lastDrag sdl.Point
+}
+
+// PlayMacro plays m on the document without a window. Menu entries are
+// looked up in menus by the texts of their path joined with "/". Painting
+// with the pixel color tool, selecting layers and dragging them is supported,
+// while other tools need the editor window.
+func (d *Document) PlayMacro(m *Macro, menus map[string]MacroMenuAction) error {
+	p := &macroPlayer{d: d, menus: menus, tool: EmptyTool{}.String(), brush: defaultBrush}
+	for i, e := range m.Events {
+		if err := p.play(e); err != nil {
+			return fmt.Errorf("event %v (%v): %w", i+1, e.Kind, err)
+		}
+	}
+	return nil
+}
+
+func (p *macroPlayer) play(e MacroEvent) error {
+	pt := sdl.Point{X: p.d.Canvas.X + int32(math.Floor(e.X)), Y: p.d.Canvas.Y + int32(math.Floor(e.Y))}
+	colorTool := (&PixelColorTool{}).String()
+	switch e.Kind {
+	case MacroMenu:
+		path := strings.Join(e.Menu, "/")
+		action, ok := p.menus[path]
+		if !ok {
+			return fmt.Errorf("%w: %v", ErrNeedsWindow, path)
+		}
+		sel := p.sel
+		if sel == p.d.Layers[0] {
+			sel = nil
+		}
+		return action(p.d, sel)
+	case MacroTool:
+		p.tool = e.Tool
+	case MacroBrush:
+		c, err := raster.ParseHex(e.Color)
+		if err != nil {
+			return err
+		}
+		p.brush = c
+	case MacroClick:
+		if e.Button == sdl.BUTTON_LEFT && e.Pressed {
+			switch p.tool {
+			case colorTool:
+				p.paint(pt)
+				p.lastDrag = pt
+			case EmptyTool{}.String(), (&PixelSelectionTool{}).String():
+			default:
+				return fmt.Errorf("%w: %v", ErrNeedsWindow, p.tool)
+			}
+		}
+		p.sel = p.layerAt(pt)
+		if e.Button == sdl.BUTTON_RIGHT {
+			if e.Pressed {
+				if p.sel == nil {
+					return nil
+				}
+				p.dragging = true
+			} else {
+				p.dragging = false
+			}
+			p.dragPix = pt
+		}
+	case MacroMotion:
+		if p.dragging {
+			if e.Buttons == sdl.ButtonRMask() && p.sel != nil && p.sel != p.d.Layers[0] {
+				p.sel.Area.X += pt.X - p.dragPix.X
+				p.sel.Area.Y += pt.Y - p.dragPix.Y
+				p.dragPix = pt
+			}
+		} else if p.tool == colorTool && e.Buttons == sdl.ButtonLMask() {
+			for _, q := range ui.Interpolate(pt, p.lastDrag) {
+				p.paint(q)
+			}
+			p.lastDrag = pt
+		}
+	}
+	return nil
+}
+
+// layerAt returns the topmost visible layer containing the canvas pixel
+func (p *macroPlayer) layerAt(pt sdl.Point) *DocumentLayer {
+	for i := len(p.d.Layers) - 1; i >= 0; i-- {
+		l := p.d.Layers[i]
+		if !l.Hidden && ui.InBounds(l.Area, pt) {
+			return l
+		}
+	}
+	return nil
+}
+
+// paint sets the pixel of the selected layer at the canvas pixel to the
+// brush color, or to the nearest palette entry of an indexed layer
+func (p *macroPlayer) paint(pt sdl.Point) {
+	l := p.sel
+	if l == nil {
+		return
+	}
+	x, y := int(pt.X-l.Area.X), int(pt.Y-l.Area.Y)
+	if !(image.Point{x, y}).In(l.Image.Rect) {
+		return
+	}
+	c := p.brush
+	if l.Indexed != nil {
+		i := l.Indexed.Palette.Index(c)
+		l.Indexed.SetColorIndex(x, y, uint8(i))
+		c = color.NRGBAModel.Convert(l.Indexed.Palette[i]).(color.NRGBA)
+	}
+	l.Image.SetNRGBA(x, y, c)
+	if l.Deep != nil {
+		px := l.Deep.Pix[l.Deep.PixOffset(x, y):]
+		for i, v := range []uint8{c.R, c.G, c.B, c.A} {
+			px[i] = float32(v) / 255
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package image_test
+
+import (
+	"errors"
+	stdimage "image"
+	"image/color"
+	"testing"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/image"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+func TestPlayMacro(t *testing.T) {
+	d, err := image.NewDocument(6, 4, color.NRGBA{0xFF, 0xFF, 0xFF, 0xFF}, raster.Depth16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := d.AddImage(stdimage.NewNRGBA(stdimage.Rect(0, 0, 4, 1)), 1, 1, "line")
+	l.Deep = raster.FloatFrom(l.Image)
+
+	left := func(x, y float64, pressed bool) image.MacroEvent {
+		return image.MacroEvent{Kind: image.MacroClick, X: x, Y: y, Button: sdl.BUTTON_LEFT, Pressed: pressed, Clicks: 1}
+	}
+	right := func(x, y float64, pressed bool) image.MacroEvent {
+		return image.MacroEvent{Kind: image.MacroClick, X: x, Y: y, Button: sdl.BUTTON_RIGHT, Pressed: pressed, Clicks: 1}
+	}
+	var filtered *image.DocumentLayer
+	menus := map[string]image.MacroMenuAction{
+		"Filters/Invert": func(d *image.Document, l *image.DocumentLayer) error {
+			filtered = l
+			return nil
+		},
+	}
+	m := &image.Macro{Version: image.MacroVersion, Events: []image.MacroEvent{
+		{Kind: image.MacroTool, Tool: (&image.PixelColorTool{}).String()},
+		{Kind: image.MacroBrush, Color: "ff0000ff"},
+		// the first click selects the layer, the second paints on it
+		left(1.5, 1.5, true),
+		left(1.5, 1.5, false),
+		left(1.5, 1.5, true),
+		// each motion paints up to the previous position, the last one past
+		// the end of the line
+		{Kind: image.MacroMotion, X: 4.5, Y: 1.5, Buttons: sdl.ButtonLMask()},
+		{Kind: image.MacroMotion, X: 5.5, Y: 1.5, Buttons: sdl.ButtonLMask()},
+		left(5.5, 1.5, false),
+		right(2.5, 1.5, true),
+		{Kind: image.MacroMotion, X: 2.5, Y: 3.5, Buttons: sdl.ButtonRMask()},
+		right(2.5, 3.5, false),
+		{Kind: image.MacroMenu, Menu: []string{"Filters", "Invert"}},
+	}}
+	if err = d.PlayMacro(m, menus); err != nil {
+		t.Fatal(err)
+	}
+
+	red := color.NRGBA{R: 0xFF, A: 0xFF}
+	for x := 0; x < 4; x++ {
+		if c := l.Image.NRGBAAt(x, 0); c != red {
+			t.Errorf("expected pixel %v of the line to be painted %v, got %v", x, red, c)
+		}
+		if p := l.Deep.Pix[l.Deep.PixOffset(x, 0):][:4]; p[0] != 1 || p[1] != 0 || p[2] != 0 || p[3] != 1 {
+			t.Errorf("expected precise pixel %v of the line to be painted, got %v", x, p)
+		}
+	}
+	if c := d.Layers[0].Image.NRGBAAt(0, 0); c != (color.NRGBA{0xFF, 0xFF, 0xFF, 0xFF}) {
+		t.Errorf("expected the canvas to be left alone, got %v", c)
+	}
+	if x, y := l.Area.X-d.Canvas.X, l.Area.Y-d.Canvas.Y; x != 1 || y != 3 {
+		t.Errorf("expected the layer to be dragged to (1, 3), got (%v, %v)", x, y)
+	}
+	if filtered != l {
+		t.Errorf("expected the menu entry to be played on the selected layer, got %v", filtered)
+	}
+
+	for name, events := range map[string][]image.MacroEvent{
+		"menu": {{Kind: image.MacroMenu, Menu: []string{"Image", "Trim"}}},
+		"tool": {{Kind: image.MacroTool, Tool: "image.CropTool"}, left(0, 0, true)},
+	} {
+		err = d.PlayMacro(&image.Macro{Version: image.MacroVersion, Events: events}, menus)
+		if !errors.Is(err, image.ErrNeedsWindow) {
+			t.Errorf("%v: expected %v, got %v", name, image.ErrNeedsWindow, err)
+		}
+	}
+}
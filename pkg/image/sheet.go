@@ -0,0 +1,102 @@
+package image
+
+import (
+	"fmt"
+	"image"
+	"path/filepath"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/codec"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// LayerSize returns the size of the selected layer in pixels
+func (iv *View) LayerSize() (image.Point, error) {
+	l, err := iv.selectedLayer()
+	if err != nil {
+		return image.Point{}, err
+	}
+	return image.Pt(int(l.area.W), int(l.area.H)), nil
+}
+
+// tileName names the tile in column col and row row of a layer
+func tileName(layer string, col, row int) string {
+	return fmt.Sprintf("%v %v,%v", layer, row, col)
+}
+
+// ExportTiles writes every cell of g on the selected layer as a PNG into
+// dir, named by its row and column, and returns the paths written
+func (iv *View) ExportTiles(dir string, g raster.Grid) ([]string, error) {
+	l, err := iv.selectedLayer()
+	if err != nil {
+		return nil, err
+	}
+	img := l.Image()
+	var paths []string
+	for row := 0; row < g.Rows; row++ {
+		for col := 0; col < g.Cols; col++ {
+			path := filepath.Join(dir, fmt.Sprintf("tile_%03d_%03d.png", row, col))
+			if err = codec.EncodeFile(path, raster.Crop(img, g.Cell(col, row)), nil); err != nil {
+				return paths, err
+			}
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// SliceToLayers adds a layer above the others for every cell of g on the
+// selected layer, in place over it, and returns how many were added. Fully
+// transparent cells are skipped.
+func (iv *View) SliceToLayers(g raster.Grid) (int, error) {
+	l, err := iv.selectedLayer()
+	if err != nil {
+		return 0, err
+	}
+	name := l.attrs.Name
+	if name == "" {
+		name = "Tile"
+	}
+	img := l.Image()
+	n := 0
+	for row := 0; row < g.Rows; row++ {
+		for col := 0; col < g.Cols; col++ {
+			cell := g.Cell(col, row)
+			tile := raster.Crop(img, cell)
+			if raster.OpaqueBounds(tile).Empty() {
+				continue
+			}
+			tex, err := newTexture(tile)
+			if err != nil {
+				return n, err
+			}
+			t := NewLayer(sdl.Point{X: l.area.X + int32(cell.Min.X), Y: l.area.Y + int32(cell.Min.Y)}, tex)
+			t.attrs.Name = tileName(name, col, row)
+			if err = iv.indexLayer(t); err != nil {
+				return n, err
+			}
+			iv.layers = append(iv.layers, t)
+			iv.edited()
+			n++
+		}
+	}
+	return n, nil
+}
+
+// ExportSpriteSheet packs every visible layer besides the canvas into one
+// image at path, with padding pixels between them, and writes a JSON atlas of
+// their bounds named after the image. It returns the path of the atlas.
+func (iv *View) ExportSpriteSheet(path string, padding int) (string, error) {
+	var frames []codec.SheetFrame
+	for i, l := range iv.layers {
+		if l == iv.canvasLayer || l.attrs.Hidden {
+			continue
+		}
+		name := l.attrs.Name
+		if name == "" {
+			name = fmt.Sprintf("Layer %d", i)
+		}
+		frames = append(frames, codec.SheetFrame{Name: name, Image: l.Image()})
+	}
+	return codec.WriteSheet(path, frames, padding)
+}
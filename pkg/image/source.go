@@ -0,0 +1,270 @@
+package image
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// ErrNoSource indicates a layer that was not opened from a file
+const ErrNoSource log.ConstErr = "layer has no source file"
+
+// SetLayerSource records path as the file the layer at index i was opened
+// from, so that it can be linked to it.
+func (iv *View) SetLayerSource(i int, path string) error {
+	if i <= 0 || i >= len(iv.layers) {
+		return fmt.Errorf("%w: %v of %v", ErrNoSuchLayer, i, len(iv.layers)-1)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	iv.layers[i].attrs.Source = abs
+	iv.edited()
+	return nil
+}
+
+// AddLinkedLayer adds a layer at the origin linked to the image or project
+// at path, and selects it.
+func (iv *View) AddLinkedLayer(path string) error {
+	source, name, err := linkSource(path)
+	if err != nil {
+		return err
+	}
+	f, err := ReadLinked(source)
+	if err != nil {
+		return err
+	}
+	if err = iv.AddDeepLayer(f); err != nil {
+		return err
+	}
+	l := iv.layers[len(iv.layers)-1]
+	l.attrs.Name = name
+	l.attrs.Source = source
+	l.attrs.Linked = true
+	iv.selLayer = l
+	return nil
+}
+
+// LayerLinked returns the source file of the selected layer and whether the
+// layer is linked to it.
+func (iv *View) LayerLinked() (string, bool, error) {
+	l, err := iv.selectedLayer()
+	if err != nil {
+		return "", false, err
+	}
+	return l.attrs.Source, l.attrs.Linked, nil
+}
+
+// ToggleLinked links the selected layer to its source file if it is not,
+// showing the current content of the file, and unlinks it otherwise, which
+// keeps its pixels and drops any embedded file.
+func (iv *View) ToggleLinked() error {
+	l, err := iv.selectedLayer()
+	if err != nil {
+		return err
+	}
+	if l.attrs.Linked {
+		iv.edited()
+		l.attrs.Linked = false
+		l.attrs.Embedded = nil
+		l.missing = false
+		return nil
+	}
+	if l.attrs.Source == "" {
+		return ErrNoSource
+	}
+	l.attrs.Linked = true
+	if err = iv.refreshLink(l); err != nil {
+		l.attrs.Linked = false
+		return err
+	}
+	return nil
+}
+
+// linkedLayer returns the selected layer if it is linked
+func (iv *View) linkedLayer() (*Layer, error) {
+	l, err := iv.selectedLayer()
+	if err != nil {
+		return nil, err
+	}
+	if !l.attrs.Linked {
+		return nil, ErrNotLinked
+	}
+	return l, nil
+}
+
+// LinkedScale returns the factors the selected linked layer resizes its
+// content by.
+func (iv *View) LinkedScale() (float64, float64, error) {
+	l, err := iv.linkedLayer()
+	if err != nil {
+		return 0, 0, err
+	}
+	sx, sy := l.attrs.ScaleX, l.attrs.ScaleY
+	if sx == 0 {
+		sx = 1
+	}
+	if sy == 0 {
+		sy = 1
+	}
+	return sx, sy, nil
+}
+
+// ScaleLinked resizes the content of the selected linked layer by sx and sy
+// from its original size, so that scaling never degrades it.
+func (iv *View) ScaleLinked(sx, sy float64) error {
+	if err := validScale(sx); err != nil {
+		return err
+	}
+	if err := validScale(sy); err != nil {
+		return err
+	}
+	l, err := iv.linkedLayer()
+	if err != nil {
+		return err
+	}
+	iv.edited()
+	oldX, oldY := l.attrs.ScaleX, l.attrs.ScaleY
+	l.attrs.ScaleX, l.attrs.ScaleY = sx, sy
+	if err = iv.refreshLink(l); err != nil {
+		l.attrs.ScaleX, l.attrs.ScaleY = oldX, oldY
+		return err
+	}
+	return nil
+}
+
+// EmbedLink keeps the file of the selected linked layer in the project, so
+// that the project no longer depends on it.
+func (iv *View) EmbedLink() error {
+	l, err := iv.linkedLayer()
+	if err != nil {
+		return err
+	}
+	if l.attrs.Embedded != nil {
+		return ErrEmbedded
+	}
+	if _, ok := folderPath(l.attrs.Source); ok {
+		return ErrFolderEmbed
+	}
+	data, err := ioutil.ReadFile(l.attrs.Source)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrMissingLink, err)
+	}
+	iv.edited()
+	l.attrs.Embedded = data
+	l.missing = false
+	return iv.refreshLink(l)
+}
+
+// OriginalPath returns the file the selected linked layer shows, to be
+// edited by another program.
+func (iv *View) OriginalPath() (string, error) {
+	l, err := iv.linkedLayer()
+	if err != nil {
+		return "", err
+	}
+	if l.attrs.Embedded != nil {
+		return "", ErrEmbedded
+	}
+	return l.attrs.Source, nil
+}
+
+// MissingLinks returns the files of linked layers that could not be read
+// when the project was loaded, and have not been found since.
+func (iv *View) MissingLinks() []string {
+	var paths []string
+	for _, l := range iv.layers {
+		if l.missing {
+			paths = append(paths, l.attrs.Source)
+		}
+	}
+	return paths
+}
+
+// LinkedSources returns the file on disk of every linked layer, once each.
+func (iv *View) LinkedSources() []string {
+	seen := make(map[string]bool)
+	var paths []string
+	for _, l := range iv.layers {
+		p := l.attrs.Source
+		if l.attrs.Linked && l.attrs.Embedded == nil && !seen[p] {
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// ReloadSource shows f, the new content of the file at path as read by
+// ReadLinked, in every layer linked to it, keeping the layers in place, and
+// returns how many were reloaded.
+func (iv *View) ReloadSource(path string, f *raster.Float) (int, error) {
+	n := 0
+	for _, l := range iv.layers {
+		if !l.attrs.Linked || l.attrs.Embedded != nil || l.attrs.Source != path {
+			continue
+		}
+		w, h := l.attrs.scaled(f.Rect.Dx(), f.Rect.Dy())
+		scaled := f
+		if w != f.Rect.Dx() || h != f.Rect.Dy() {
+			scaled = raster.ResizeFloat(f, w, h, linkFilter)
+		}
+		if err := iv.showLinked(l, scaled); err != nil {
+			return n, fmt.Errorf("reloading %v: %w", path, err)
+		}
+		l.missing = false
+		n++
+	}
+	return n, nil
+}
+
+// refreshLink shows the current content of the file of the linked layer
+func (iv *View) refreshLink(l *Layer) error {
+	f, err := l.attrs.linkedImage()
+	if err != nil {
+		return err
+	}
+	return iv.showLinked(l, f)
+}
+
+// showLinked replaces the pixels of l with f at the precision of the
+// document, keeping the layer in place
+func (iv *View) showLinked(l *Layer, f *raster.Float) error {
+	iv.edited()
+	offset := sdl.Point{X: l.area.X, Y: l.area.Y}
+	if iv.depth == raster.Depth8 {
+		return l.SetImage(offset, f.NRGBA())
+	}
+	q := f.Copy()
+	q.Quantize(iv.depth)
+	return l.SetDeep(offset, q)
+}
+
+// refreshLinks shows the current content of every linked layer, flagging
+// the layers whose file cannot be read, which keep their pixels
+func (iv *View) refreshLinks() {
+	for _, l := range iv.layers {
+		l.missing = false
+		if !l.attrs.Linked {
+			continue
+		}
+		if err := iv.refreshLink(l); err != nil {
+			l.missing = true
+			log.Warnf("layer %q: %v", l.attrs.Name, err)
+		}
+	}
+}
+
+// attrs returns the attributes of every layer
+func (iv *View) attrs() []*layerAttrs {
+	attrs := make([]*layerAttrs, len(iv.layers))
+	for i, l := range iv.layers {
+		attrs[i] = &l.attrs
+	}
+	return attrs
+}
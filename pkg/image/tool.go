@@ -2,7 +2,6 @@ package image
 
 import (
 	"fmt"
-	"image/color"
 
 	"github.com/gregjohnson2017/tabula-editor/pkg/ui"
 	"github.com/veandco/go-sdl2/sdl"
@@ -15,10 +14,26 @@ type Tool interface {
 	fmt.Stringer
 }
 
+// Overlay is implemented by tools that draw on top of the image view.
+type Overlay interface {
+	RenderOverlay(iv *View)
+}
+
+// statefulTool is implemented by tools that hold state in the image view which
+// must be set up when the tool is chosen and released when it is replaced.
+type statefulTool interface {
+	activate(iv *View)
+	deactivate(iv *View)
+}
+
 // Make sure the tools satisfy the interface
 var _ Tool = Tool(EmptyTool{})
 var _ Tool = Tool(&PixelSelectionTool{})
 var _ Tool = Tool(&PixelColorTool{})
+var _ Tool = Tool(&TransformTool{})
+var _ Tool = Tool(&CropTool{})
+var _ Overlay = Overlay(&TransformTool{})
+var _ Overlay = Overlay(&CropTool{})
 
 // EmptyTool does nothing.
 type EmptyTool struct {
@@ -66,7 +81,7 @@ func (t *PixelSelectionTool) String() string {
 	return "image.PixelSelectionTool"
 }
 
-// PixelColorTool colors any clicked pixels purple
+// PixelColorTool colors any clicked pixels with the brush color
 type PixelColorTool struct {
 	lastDrag sdl.Point
 }
@@ -75,7 +90,7 @@ type PixelColorTool struct {
 // tool is currently active for the image view.
 func (t *PixelColorTool) OnClick(evt *sdl.MouseButtonEvent, iv *View) {
 	if evt.Button == sdl.BUTTON_LEFT && evt.State == sdl.PRESSED {
-		_ = iv.setPixel(iv.mousePix, color.RGBA{R: 0xFF, G: 0x00, B: 0xFF, A: 0xFF})
+		_ = iv.setPixel(iv.mousePix, iv.brush)
 		t.lastDrag = iv.mousePix
 	}
 }
@@ -85,7 +100,7 @@ func (t *PixelColorTool) OnClick(evt *sdl.MouseButtonEvent, iv *View) {
 func (t *PixelColorTool) OnMotion(evt *sdl.MouseMotionEvent, iv *View) {
 	if evt.State == sdl.ButtonLMask() {
 		for _, p := range ui.Interpolate(iv.mousePix, t.lastDrag) {
-			_ = iv.setPixel(p, color.RGBA{R: 0xFF, G: 0x00, B: 0xFF, A: 0xFF})
+			_ = iv.setPixel(p, iv.brush)
 		}
 		t.lastDrag = iv.mousePix
 	}
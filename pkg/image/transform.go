@@ -0,0 +1,516 @@
+package image
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// handle identifies the part of the transform box that is being dragged
+type handle int
+
+const (
+	handleNone handle = iota
+	handleMove
+	handleRotate
+	handlePivot
+	handleTopLeft
+	handleTop
+	handleTopRight
+	handleRight
+	handleBottomRight
+	handleBottom
+	handleBottomLeft
+	handleLeft
+)
+
+// resizeHandles lists the handles on the transform box in drawing order
+var resizeHandles = []handle{
+	handleTopLeft, handleTop, handleTopRight, handleRight,
+	handleBottomRight, handleBottom, handleBottomLeft, handleLeft,
+}
+
+// frac returns the position of a resize handle as a fraction of the layer size
+func (h handle) frac() (float64, float64) {
+	switch h {
+	case handleTopLeft:
+		return 0, 0
+	case handleTop:
+		return 0.5, 0
+	case handleTopRight:
+		return 1, 0
+	case handleRight:
+		return 1, 0.5
+	case handleBottomRight:
+		return 1, 1
+	case handleBottom:
+		return 0.5, 1
+	case handleBottomLeft:
+		return 0, 1
+	case handleLeft:
+		return 0, 0.5
+	}
+	return 0.5, 0.5
+}
+
+const (
+	// handleRadius is the half-width of a handle in screen pixels
+	handleRadius = 4
+	// rotateDistance is how far the rotation handle sits above the box in
+	// screen pixels
+	rotateDistance = 24
+	// snapAngle is the rotation increment used while shift is held
+	snapAngle = math.Pi / 12
+	// minScale keeps the transformation invertible
+	minScale = 0.01
+	// maxScale keeps the transformed layer within the size limits of most
+	// layers
+	maxScale = 100
+)
+
+var transformColor = [4]float32{0.1, 0.5, 1.0, 1.0}
+
+// transformParams describe a free transformation in layer-local pixels. The
+// layer is scaled, skewed and rotated about the pivot, then moved.
+type transformParams struct {
+	scaleX, scaleY float64
+	skewX, skewY   float64
+	angle          float64
+	pivotX, pivotY float64
+	moveX, moveY   float64
+}
+
+// linear returns the transformation without the pivot and move offsets
+func (p transformParams) linear() raster.Affine {
+	return raster.Rotate(p.angle).
+		Mul(raster.Shear(p.skewX, p.skewY)).
+		Mul(raster.Scale(p.scaleX, p.scaleY))
+}
+
+// matrix returns the full transformation from layer-local source pixels to
+// layer-local destination pixels
+func (p transformParams) matrix() raster.Affine {
+	return raster.Translate(p.pivotX+p.moveX, p.pivotY+p.moveY).
+		Mul(p.linear()).
+		Mul(raster.Translate(-p.pivotX, -p.pivotY))
+}
+
+// TransformValues are the user-facing parameters of a free transformation.
+// Scales are in percent, angles are in degrees and moves are in pixels.
+type TransformValues struct {
+	ScaleX, ScaleY float64
+	Angle          float64
+	SkewX, SkewY   float64
+	MoveX, MoveY   float64
+}
+
+// TransformTool scales, rotates, skews and flips a layer using handles drawn
+// on the canvas. Changes are previewed until Apply bakes them into a new
+// texture with the chosen resampling filter.
+//
+// Dragging a corner scales both axes and an edge scales one; shift toggles the
+// aspect ratio lock. Holding ctrl while dragging an edge skews instead. The
+// handle above the box rotates around the pivot, which can be dragged too.
+type TransformTool struct {
+	filter     raster.Filter
+	lockAspect bool
+	layer      *Layer
+	params     transformParams
+	start      transformParams
+	grab       handle
+	startX     float64
+	startY     float64
+}
+
+// SetFilter sets the resampling filter used when the transformation is applied.
+func (t *TransformTool) SetFilter(f raster.Filter) {
+	t.filter = f
+}
+
+// SetAspectLock sets whether scaling keeps the aspect ratio by default.
+func (t *TransformTool) SetAspectLock(lock bool) {
+	t.lockAspect = lock
+}
+
+// AspectLock returns whether scaling keeps the aspect ratio by default.
+func (t *TransformTool) AspectLock() bool {
+	return t.lockAspect
+}
+
+// Values returns the pending transformation of the layer being transformed.
+// The boolean is false if no layer is being transformed.
+func (t *TransformTool) Values(iv *View) (TransformValues, bool) {
+	t.bind(iv)
+	if t.layer == nil {
+		return TransformValues{}, false
+	}
+	p := t.params
+	return TransformValues{
+		ScaleX: p.scaleX * 100,
+		ScaleY: p.scaleY * 100,
+		Angle:  p.angle * 180 / math.Pi,
+		SkewX:  math.Atan(p.skewX) * 180 / math.Pi,
+		SkewY:  math.Atan(p.skewY) * 180 / math.Pi,
+		MoveX:  p.moveX,
+		MoveY:  p.moveY,
+	}, true
+}
+
+// SetValues replaces the pending transformation of the layer being
+// transformed, keeping the current pivot. Scales above 10000% are rejected
+// with raster.ErrTooLarge.
+func (t *TransformTool) SetValues(iv *View, v TransformValues) error {
+	if math.Abs(v.ScaleX) > maxScale*100 || math.Abs(v.ScaleY) > maxScale*100 {
+		return fmt.Errorf("%w: scale of %v%% by %v%%", raster.ErrTooLarge, v.ScaleX, v.ScaleY)
+	}
+	t.bind(iv)
+	if t.layer == nil {
+		return nil
+	}
+	skew := func(deg float64) float64 {
+		return math.Tan(math.Max(-89, math.Min(89, deg)) * math.Pi / 180)
+	}
+	t.params.scaleX = limitScale(v.ScaleX / 100)
+	t.params.scaleY = limitScale(v.ScaleY / 100)
+	t.params.angle = v.Angle * math.Pi / 180
+	t.params.skewX = skew(v.SkewX)
+	t.params.skewY = skew(v.SkewY)
+	t.params.moveX = v.MoveX
+	t.params.moveY = v.MoveY
+	t.updatePreview()
+	return nil
+}
+
+// FlipHorizontal mirrors the layer being transformed about its pivot.
+func (t *TransformTool) FlipHorizontal(iv *View) {
+	t.bind(iv)
+	if t.layer == nil {
+		return
+	}
+	t.params.scaleX = -t.params.scaleX
+	t.updatePreview()
+}
+
+// FlipVertical mirrors the layer being transformed about its pivot.
+func (t *TransformTool) FlipVertical(iv *View) {
+	t.bind(iv)
+	if t.layer == nil {
+		return
+	}
+	t.params.scaleY = -t.params.scaleY
+	t.updatePreview()
+}
+
+// Apply resamples the layer being transformed into a new texture.
+func (t *TransformTool) Apply(iv *View) error {
+	if t.layer == nil {
+		return nil
+	}
+	m := t.params.matrix()
+	if m.IsIdentity() {
+		return nil
+	}
+	iv.edited()
+	if t.layer.deep != nil {
+		f, off, err := raster.TransformFloat(t.layer.deep, m, t.filter)
+		if err != nil {
+			return err
+		}
+		f.Quantize(iv.depth)
+		if err = t.layer.SetDeep(sdl.Point{X: t.layer.area.X + int32(off.X), Y: t.layer.area.Y + int32(off.Y)}, f); err != nil {
+			return err
+		}
+		t.reset(t.layer)
+		return nil
+	}
+	img, off, err := raster.Transform(t.layer.Image(), m, t.filter)
+	if err != nil {
+		return err
+	}
+	offset := sdl.Point{X: t.layer.area.X + int32(off.X), Y: t.layer.area.Y + int32(off.Y)}
+	if err = t.layer.SetImage(offset, img); err != nil {
+		return err
+	}
+	t.reset(t.layer)
+	return nil
+}
+
+// Cancel discards the pending transformation.
+func (t *TransformTool) Cancel(iv *View) {
+	if t.layer != nil {
+		t.layer.preview = nil
+	}
+	t.layer = nil
+	t.grab = handleNone
+}
+
+// activate starts transforming the selected layer.
+func (t *TransformTool) activate(iv *View) {
+	t.bind(iv)
+}
+
+// deactivate applies any pending transformation.
+func (t *TransformTool) deactivate(iv *View) {
+	if err := t.Apply(iv); err != nil {
+		log.Warnf("failed to apply transformation: %v", err)
+	}
+	t.Cancel(iv)
+}
+
+// bind starts transforming the selected layer if no layer is being
+// transformed yet.
+func (t *TransformTool) bind(iv *View) {
+	if t.layer != nil {
+		return
+	}
+	if iv.selLayer == nil || iv.selLayer == iv.canvasLayer {
+		return
+	}
+	t.reset(iv.selLayer)
+}
+
+// reset clears the pending transformation of the given layer
+func (t *TransformTool) reset(layer *Layer) {
+	layer.preview = nil
+	t.layer = layer
+	t.grab = handleNone
+	t.params = transformParams{
+		scaleX: 1,
+		scaleY: 1,
+		pivotX: float64(layer.area.W) / 2,
+		pivotY: float64(layer.area.H) / 2,
+	}
+}
+
+// updatePreview shows the pending transformation on the layer
+func (t *TransformTool) updatePreview() {
+	m := t.params.matrix()
+	if m.IsIdentity() {
+		t.layer.preview = nil
+		return
+	}
+	t.layer.preview = &m
+}
+
+// canvasPoint maps a layer-local source pixel position to canvas coordinates
+// through the pending transformation
+func (t *TransformTool) canvasPoint(x, y float64) (float64, float64) {
+	x, y = t.params.matrix().Apply(x, y)
+	return x + float64(t.layer.area.X), y + float64(t.layer.area.Y)
+}
+
+// handlePoint returns the canvas position of the given handle
+func (t *TransformTool) handlePoint(h handle, iv *View) (float64, float64) {
+	w, hgt := float64(t.layer.area.W), float64(t.layer.area.H)
+	switch h {
+	case handlePivot:
+		p := t.params
+		return p.pivotX + p.moveX + float64(t.layer.area.X), p.pivotY + p.moveY + float64(t.layer.area.Y)
+	case handleRotate:
+		tx, ty := t.canvasPoint(w/2, 0)
+		bx, by := t.canvasPoint(w/2, hgt)
+		dx, dy := tx-bx, ty-by
+		length := math.Hypot(dx, dy)
+		if length == 0 {
+			dx, dy, length = 0, -1, 1
+		}
+		dist := rotateDistance * iv.screenScale()
+		return tx + dx/length*dist, ty + dy/length*dist
+	}
+	fx, fy := h.frac()
+	return t.canvasPoint(fx*w, fy*hgt)
+}
+
+// handleAt returns the handle at the given canvas position
+func (t *TransformTool) handleAt(x, y float64, iv *View) handle {
+	r := handleRadius * iv.screenScale()
+	hit := func(h handle) bool {
+		hx, hy := t.handlePoint(h, iv)
+		return math.Abs(hx-x) <= r && math.Abs(hy-y) <= r
+	}
+	for _, h := range append([]handle{handlePivot, handleRotate}, resizeHandles...) {
+		if hit(h) {
+			return h
+		}
+	}
+	inv, ok := t.params.matrix().Invert()
+	if !ok {
+		return handleNone
+	}
+	lx, ly := inv.Apply(x-float64(t.layer.area.X), y-float64(t.layer.area.Y))
+	if lx >= 0 && ly >= 0 && lx <= float64(t.layer.area.W) && ly <= float64(t.layer.area.H) {
+		return handleMove
+	}
+	return handleNone
+}
+
+// OnClick is called when the user clicks within the Image View's region and the
+// tool is currently active for the image view.
+func (t *TransformTool) OnClick(evt *sdl.MouseButtonEvent, iv *View) {
+	if evt.Button != sdl.BUTTON_LEFT {
+		return
+	}
+	if evt.State == sdl.RELEASED {
+		t.grab = handleNone
+		return
+	}
+	x, y := iv.mouseX, iv.mouseY
+	if t.layer != nil {
+		t.grab = t.handleAt(x, y, iv)
+	}
+	if t.grab == handleNone {
+		// start over on another layer unless there are pending changes
+		if t.layer == nil || t.params.matrix().IsIdentity() {
+			t.layer = nil
+			if layer := iv.layerAt(iv.mousePix); layer != nil && layer != iv.canvasLayer {
+				t.reset(layer)
+			}
+		}
+		return
+	}
+	if t.grab == handleMove && evt.Clicks == 2 {
+		if err := t.Apply(iv); err != nil {
+			log.Warnf("failed to apply transformation: %v", err)
+		}
+		return
+	}
+	t.start = t.params
+	t.startX, t.startY = x, y
+}
+
+// OnMotion is called when the user clicks within the Image View's region and
+// the tool is currently active for the image view.
+func (t *TransformTool) OnMotion(evt *sdl.MouseMotionEvent, iv *View) {
+	if t.layer == nil || t.grab == handleNone || evt.State != sdl.ButtonLMask() {
+		return
+	}
+	x, y := iv.mouseX, iv.mouseY
+	mods := sdl.GetModState()
+	shift := mods&sdl.KMOD_SHIFT != 0
+	ctrl := mods&sdl.KMOD_CTRL != 0
+
+	p := t.start
+	// mouse and pivot positions in layer-local destination space
+	lx, ly := x-float64(t.layer.area.X), y-float64(t.layer.area.Y)
+	cx, cy := p.pivotX+p.moveX, p.pivotY+p.moveY
+
+	switch t.grab {
+	case handleMove:
+		p.moveX += x - t.startX
+		p.moveY += y - t.startY
+	case handleRotate:
+		sx, sy := t.startX-float64(t.layer.area.X), t.startY-float64(t.layer.area.Y)
+		p.angle += math.Atan2(ly-cy, lx-cx) - math.Atan2(sy-cy, sx-cx)
+		if shift {
+			p.angle = math.Round(p.angle/snapAngle) * snapAngle
+		}
+	case handlePivot:
+		inv, ok := p.matrix().Invert()
+		if !ok {
+			return
+		}
+		px, py := inv.Apply(lx, ly)
+		// keep the image in place by compensating the move for the new pivot
+		dx, dy := px-p.pivotX, py-p.pivotY
+		ax, ay := p.linear().ApplyVector(dx, dy)
+		p.moveX += ax - dx
+		p.moveY += ay - dy
+		p.pivotX, p.pivotY = px, py
+	default:
+		fx, fy := t.grab.frac()
+		hx := fx*float64(t.layer.area.W) - p.pivotX
+		hy := fy*float64(t.layer.area.H) - p.pivotY
+		// undo the rotation so the mouse is in the skewed and scaled frame
+		rx, ry := raster.Rotate(-p.angle).ApplyVector(lx-cx, ly-cy)
+		if ctrl && fx == 0.5 && hy != 0 {
+			p.skewX = (rx - p.scaleX*hx) / (p.scaleY * hy)
+			break
+		}
+		if ctrl && fy == 0.5 && hx != 0 {
+			p.skewY = (ry - p.scaleY*hy) / (p.scaleX * hx)
+			break
+		}
+		kinv, ok := raster.Shear(p.skewX, p.skewY).Invert()
+		if !ok {
+			return
+		}
+		qx, qy := kinv.ApplyVector(rx, ry)
+		if fx != 0.5 && math.Abs(hx) > 1e-6 {
+			p.scaleX = qx / hx
+		}
+		if fy != 0.5 && math.Abs(hy) > 1e-6 {
+			p.scaleY = qy / hy
+		}
+		if t.lockAspect != shift {
+			kx, ky := p.scaleX/t.start.scaleX, p.scaleY/t.start.scaleY
+			k := kx
+			if fx == 0.5 || (fy != 0.5 && math.Abs(ky) > math.Abs(kx)) {
+				k = ky
+			}
+			p.scaleX, p.scaleY = t.start.scaleX*k, t.start.scaleY*k
+		}
+		p.scaleX, p.scaleY = limitScale(p.scaleX), limitScale(p.scaleY)
+	}
+	t.params = p
+	t.updatePreview()
+}
+
+// RenderOverlay draws the transform box and its handles.
+func (t *TransformTool) RenderOverlay(iv *View) {
+	if t.layer == nil {
+		return
+	}
+	point := func(h handle) (float32, float32) {
+		x, y := t.handlePoint(h, iv)
+		return float32(x), float32(y)
+	}
+	r := float32(handleRadius * iv.screenScale())
+
+	var lines, tris []float32
+	for i, h := range resizeHandles {
+		// connect the corners to outline the box
+		if i%2 == 0 {
+			x1, y1 := point(h)
+			x2, y2 := point(resizeHandles[(i+2)%len(resizeHandles)])
+			lines = append(lines, x1, y1, x2, y2)
+		}
+	}
+	tx, ty := point(handleTop)
+	rx, ry := point(handleRotate)
+	lines = append(lines, tx, ty, rx, ry)
+	px, py := point(handlePivot)
+	lines = append(lines, px-2*r, py, px+2*r, py, px, py-2*r, px, py+2*r)
+
+	for _, h := range append(resizeHandles, handleRotate) {
+		x, y := point(h)
+		tris = append(tris,
+			x-r, y+r, x-r, y-r, x+r, y-r,
+			x-r, y+r, x+r, y-r, x+r, y+r,
+		)
+	}
+	iv.drawOverlay(iv.lineBuf, lines, transformColor)
+	iv.drawOverlay(iv.triBuf, tris, transformColor)
+}
+
+func (t *TransformTool) String() string {
+	return "image.TransformTool"
+}
+
+// limitScale keeps a scale factor away from zero so it stays invertible, and
+// below maxScale
+func limitScale(s float64) float64 {
+	if math.Abs(s) >= minScale && math.Abs(s) <= maxScale {
+		return s
+	}
+	limit := minScale
+	if math.Abs(s) > maxScale {
+		limit = maxScale
+	}
+	if s < 0 {
+		return -limit
+	}
+	return limit
+}
@@ -1,23 +1,19 @@
 package image
 
 import (
-	"bytes"
-	"compress/zlib"
-	"encoding/gob"
 	"fmt"
 	"image"
 	"image/color"
-	"image/jpeg"
-	"image/png"
 	"math"
-	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/go-gl/gl/v2.1/gl"
+	"github.com/gregjohnson2017/tabula-editor/pkg/codec"
 	"github.com/gregjohnson2017/tabula-editor/pkg/comms"
 	"github.com/gregjohnson2017/tabula-editor/pkg/config"
 	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
 	"github.com/gregjohnson2017/tabula-editor/pkg/shaders"
 	"github.com/gregjohnson2017/tabula-editor/pkg/ui"
 	"github.com/gregjohnson2017/tabula-editor/pkg/util"
@@ -34,12 +30,14 @@ type View struct {
 	canvas      sdl.Rect
 	view        sdl.FRect
 	mousePix    sdl.Point
+	mouseX      float64
+	mouseY      float64
 	mult        int32
 	activeTool  Tool
 	layers      []*Layer
 	selLayer    *Layer
 	canvasLayer *Layer
-	dragLoc     sdl.Point
+	dragPix     sdl.Point
 	panLoc      sdl.Point
 	dragging    bool
 	panning     bool
@@ -47,11 +45,49 @@ type View struct {
 	toolComms   <-chan Tool
 	checkerProg gfx.Program
 	program     gfx.Program
+	overlayProg gfx.Program
+	lineBuf     *gfx.VAO
+	triBuf      *gfx.VAO
 	projName    string
+	selection   map[sdl.Point]struct{}
+	preview     *filterPreview
+	metadata    codec.Metadata
+	depth       raster.Depth
+	linear      bool
+	palette     color.Palette
+	brush       color.NRGBA
+	macro       *Macro
+	// revision counts the edits made to the project
+	revision uint64
 }
 
+var selectionColor = [4]float32{0.1, 0.5, 1.0, 0.4}
+
 func (iv *View) AddLayer(tex gfx.Texture) {
 	iv.layers = append(iv.layers, NewLayer(sdl.Point{X: 0, Y: 0}, tex))
+	iv.edited()
+}
+
+// Revision returns a number that changes whenever the project is edited,
+// but not when it is panned or zoomed
+func (iv *View) Revision() uint64 {
+	return iv.revision
+}
+
+// edited records a change to the canvas, the layers or the document
+// settings
+func (iv *View) edited() {
+	iv.revision++
+}
+
+// AddImageLayer adds a new layer at the origin holding a copy of img
+func (iv *View) AddImageLayer(img *image.NRGBA) error {
+	tex, err := newTexture(img)
+	if err != nil {
+		return err
+	}
+	iv.AddLayer(tex)
+	return iv.indexLayer(iv.layers[len(iv.layers)-1])
 }
 
 // NewView returns a pointer to a new View struct that implements ui.Component
@@ -63,6 +99,8 @@ func NewView(area sdl.Rect, bbComms chan<- comms.Image, toolComms <-chan Tool, c
 	iv.bbComms = bbComms
 	iv.toolComms = toolComms
 	iv.mult = 0
+	iv.depth = raster.Depth8
+	iv.brush = defaultBrush
 
 	iv.canvas = sdl.Rect{
 		X: -50,
@@ -103,14 +141,22 @@ func NewView(area sdl.Rect, bbComms chan<- comms.Image, toolComms <-chan Tool, c
 		return nil, err
 	}
 
-	err = iv.checkerProg.UploadUniform("area", float32(iv.view.W), float32(iv.view.H))
+	v2, err := gfx.NewShader(shaders.OverlayVertex, gl.VERTEX_SHADER)
 	if err != nil {
-		log.Warnf("failed to upload uniform \"%v\": %v", "area", err)
+		return nil, err
 	}
-	err = iv.program.UploadUniform("area", float32(iv.view.W), float32(iv.view.H))
+	f3, err := gfx.NewShader(shaders.SolidColorFragment, gl.FRAGMENT_SHADER)
 	if err != nil {
-		log.Warnf("failed to upload uniform \"%v\": %v", "area", err)
+		return nil, err
+	}
+
+	if iv.overlayProg, err = gfx.NewProgram(v2, f3); err != nil {
+		return nil, err
 	}
+	iv.lineBuf = gfx.NewVAO(gl.LINES, []int32{2})
+	iv.triBuf = gfx.NewVAO(gl.TRIANGLES, []int32{2})
+
+	iv.uploadArea()
 
 	iv.activeTool = &EmptyTool{}
 
@@ -124,6 +170,9 @@ func NewView(area sdl.Rect, bbComms chan<- comms.Image, toolComms <-chan Tool, c
 func (iv *View) Destroy() {
 	iv.checkerProg.Destroy()
 	iv.program.Destroy()
+	iv.overlayProg.Destroy()
+	iv.lineBuf.Destroy()
+	iv.triBuf.Destroy()
 	for _, layer := range iv.layers {
 		layer.Destroy()
 	}
@@ -141,32 +190,48 @@ func (iv *View) Render() {
 		iv.bbComms <- comms.Image{FileName: iv.projName, MousePix: iv.mousePix, Mult: iv.mult}
 	}()
 
-	// TODO selection outline
-
 	// gl viewport 0, 0 is bottom left
 	gl.Viewport(iv.area.X, iv.cfg.BottomBarHeight, iv.area.W, iv.area.H)
 
-	iv.program.Bind()
 	for _, layer := range iv.layers {
 		if layer == iv.canvasLayer {
 			iv.checkerProg.Bind()
 			iv.canvasLayer.Render(iv.view)
-			iv.program.Bind()
 		} else {
-			layer.Render(iv.view)
+			iv.renderLayer(layer, iv.view)
 		}
 	}
 	iv.program.Unbind()
 
+	iv.renderSelection()
+	if o, ok := iv.activeTool.(Overlay); ok {
+		o.RenderOverlay(iv)
+	}
+
 	select {
 	case tool := <-iv.toolComms:
-		log.Debugln("image.View switching tool to", tool.String())
-		iv.activeTool = tool
+		iv.SetTool(tool)
 	default:
 	}
 	sw.StopRecordAverage(iv.String() + ".Render")
 }
 
+// SetTool switches the active tool of the image view
+func (iv *View) SetTool(tool Tool) {
+	if tool == iv.activeTool {
+		return
+	}
+	log.Debugln("image.View switching tool to", tool.String())
+	iv.record(MacroEvent{Kind: MacroTool, Tool: tool.String()})
+	if st, ok := iv.activeTool.(statefulTool); ok {
+		st.deactivate(iv)
+	}
+	iv.activeTool = tool
+	if st, ok := tool.(statefulTool); ok {
+		st.activate(iv)
+	}
+}
+
 // RenderCanvas draws what is on the canvas or area, whichever is larger
 func (iv *View) RenderCanvas() {
 	sw := util.Start()
@@ -177,9 +242,8 @@ func (iv *View) RenderCanvas() {
 	// gl viewport 0, 0 is bottom left
 	gl.Viewport(0, 0, iv.canvas.W, iv.canvas.H)
 
-	iv.program.Bind()
 	for _, layer := range iv.layers {
-		layer.Render(ui.RectToFRect(iv.canvas))
+		iv.renderLayer(layer, ui.RectToFRect(iv.canvas))
 	}
 	iv.program.Unbind()
 
@@ -187,6 +251,15 @@ func (iv *View) RenderCanvas() {
 	sw.Stop("RenderCanvas")
 }
 
+// renderLayer draws the layer with the texture program at its opacity
+func (iv *View) renderLayer(layer *Layer, view sdl.FRect) {
+	if err := iv.program.UploadUniform("opacity", float32(layer.attrs.opacity())); err != nil {
+		log.Warnf("failed to upload uniform \"%v\": %v", "opacity", err)
+	}
+	iv.program.Bind()
+	layer.Render(view)
+}
+
 const maxZoom = 8
 
 // updateView updates the view rectangle according to the zoom multiplier,
@@ -199,14 +272,7 @@ func (iv *View) updateView() {
 	newView.X = (iv.view.W-newView.W)/2 + iv.view.X
 	newView.Y = (iv.view.H-newView.H)/2 + iv.view.Y
 	iv.view = newView
-	err := iv.checkerProg.UploadUniform("area", float32(iv.view.W), float32(iv.view.H))
-	if err != nil {
-		log.Warnf("failed to upload uniform \"%v\": %v", "area", err)
-	}
-	err = iv.program.UploadUniform("area", float32(iv.view.W), float32(iv.view.H))
-	if err != nil {
-		log.Warnf("failed to upload uniform \"%v\": %v", "area", err)
-	}
+	iv.uploadArea()
 }
 
 // CenterCanvas updates the view so the canvas is in the center of the window
@@ -218,24 +284,69 @@ func (iv *View) CenterCanvas() {
 		H: float32(iv.area.H),
 	}
 	iv.updateView()
-	err := iv.checkerProg.UploadUniform("area", float32(iv.view.W), float32(iv.view.H))
-	if err != nil {
-		log.Warnf("failed to upload uniform \"%v\": %v", "area", err)
+}
+
+// uploadArea uploads the view dimensions to every program drawing in view space
+func (iv *View) uploadArea() {
+	for _, prog := range []gfx.Program{iv.checkerProg, iv.program, iv.overlayProg} {
+		err := prog.UploadUniform("area", float32(iv.view.W), float32(iv.view.H))
+		if err != nil {
+			log.Warnf("failed to upload uniform \"%v\": %v", "area", err)
+		}
+	}
+}
+
+// drawOverlay draws the given vertices, in canvas pixel coordinates, on top of
+// the image view with the given color. The buffer determines whether the
+// vertices are drawn as lines or triangles.
+func (iv *View) drawOverlay(buf *gfx.VAO, points []float32, col [4]float32) {
+	if len(points) == 0 {
+		return
+	}
+	shifted := make([]float32, len(points))
+	for i := 0; i < len(points); i += 2 {
+		shifted[i] = points[i] - iv.view.X
+		shifted[i+1] = points[i+1] - iv.view.Y
 	}
-	err = iv.program.UploadUniform("area", float32(iv.view.W), float32(iv.view.H))
+	err := iv.overlayProg.UploadUniform("uni_color", col[0], col[1], col[2], col[3])
 	if err != nil {
-		log.Warnf("failed to upload uniform \"%v\": %v", "area", err)
+		log.Warnf("failed to upload uniform \"%v\": %v", "uni_color", err)
+	}
+	if err = buf.Load(shifted, gl.STATIC_DRAW); err != nil {
+		log.Warnf("failed to load overlay vertices: %v", err)
+		return
 	}
+	iv.overlayProg.Bind()
+	buf.Draw()
+	iv.overlayProg.Unbind()
+}
+
+// screenScale returns the number of canvas pixels spanned by one screen pixel
+func (iv *View) screenScale() float64 {
+	return float64(iv.view.W) / float64(iv.area.W)
 }
 
 // setPixel sets the currently hovered texel of the selected layer
-// to the specified color
-func (iv *View) setPixel(p sdl.Point, col color.RGBA) error {
+// to the specified color, or to the nearest palette entry in an indexed
+// document
+func (iv *View) setPixel(p sdl.Point, col color.NRGBA) error {
 	if iv.selLayer != nil {
 		p.X -= iv.selLayer.area.X
 		p.Y -= iv.selLayer.area.Y
 		pt := gfx.Point{X: p.X, Y: p.Y}
+		if ix := iv.selLayer.indexed; ix != nil && (image.Point{int(p.X), int(p.Y)}).In(ix.Rect) {
+			i := ix.Palette.Index(col)
+			ix.SetColorIndex(int(p.X), int(p.Y), uint8(i))
+			col = color.NRGBAModel.Convert(ix.Palette[i]).(color.NRGBA)
+		}
 		bs := []byte{col.R, col.G, col.B, col.A}
+		iv.edited()
+		if d := iv.selLayer.deep; d != nil && (image.Point{int(p.X), int(p.Y)}).In(d.Rect) {
+			px := d.Pix[d.PixOffset(int(p.X), int(p.Y)):]
+			for i, v := range bs {
+				px[i] = float32(v) / 255
+			}
+		}
 		return iv.selLayer.texture.SetPixel(pt, bs, true)
 	}
 	return nil
@@ -243,15 +354,20 @@ func (iv *View) setPixel(p sdl.Point, col color.RGBA) error {
 
 // x and y is in the SDL window coordinate space.
 func (iv *View) updateMousePos(x, y int32) {
-	iv.mousePix = iv.getMousePix(x, y)
+	iv.setMousePos(iv.getMousePos(x, y))
 }
 
+// setMousePos sets the unrounded canvas position under the cursor
+func (iv *View) setMousePos(x, y float64) {
+	iv.mouseX, iv.mouseY = x, y
+	iv.mousePix = sdl.Point{X: int32(math.Floor(x)), Y: int32(math.Floor(y))}
+}
+
+// getMousePos returns the unrounded canvas position under the cursor.
 // x and y is in the SDL window coordinate space.
-func (iv *View) getMousePix(x, y int32) sdl.Point {
-	return sdl.Point{
-		X: int32(math.Floor(float64(iv.view.X + float32(x)*iv.view.W/float32(iv.area.W)))),
-		Y: int32(math.Floor(float64(iv.view.Y + float32(y)*iv.view.H/float32(iv.area.H)))),
-	}
+func (iv *View) getMousePos(x, y int32) (float64, float64) {
+	return float64(iv.view.X + float32(x)*iv.view.W/float32(iv.area.W)),
+		float64(iv.view.Y + float32(y)*iv.view.H/float32(iv.area.H))
 }
 
 // OnEnter is called when the cursor enters the ui.Component's region
@@ -265,57 +381,57 @@ func (iv *View) OnLeave() {
 // OnClick is called when the user clicks within the ui.Component's region
 func (iv *View) OnClick(evt *sdl.MouseButtonEvent) bool {
 	iv.updateMousePos(evt.X, evt.Y)
+	iv.click(evt)
+	if evt.Button == sdl.BUTTON_MIDDLE {
+		if evt.State == sdl.PRESSED {
+			iv.panning = true
+		} else if evt.State == sdl.RELEASED {
+			iv.panning = false
+		}
+		iv.panLoc.X = evt.X
+		iv.panLoc.Y = evt.Y
+	}
+	return true
+}
+
+// click passes a click at the mouse position to the tool, selects the layer
+// under it and starts or ends dragging it
+func (iv *View) click(evt *sdl.MouseButtonEvent) {
+	if evt.Button == sdl.BUTTON_LEFT || evt.Button == sdl.BUTTON_RIGHT {
+		iv.record(MacroEvent{
+			Kind: MacroClick, X: iv.mouseX - float64(iv.canvas.X), Y: iv.mouseY - float64(iv.canvas.Y),
+			Button: evt.Button, Pressed: evt.State == sdl.PRESSED, Clicks: evt.Clicks,
+		})
+	}
 	iv.activeTool.OnClick(evt, iv)
 	iv.selectLayer()
 	if evt.Button == sdl.BUTTON_RIGHT {
 		if evt.State == sdl.PRESSED {
 			if iv.selLayer == nil {
 				// no layer was clicked on
-				return true
+				return
 			}
 			iv.dragging = true
 		} else if evt.State == sdl.RELEASED {
 			iv.dragging = false
 		}
-		iv.dragLoc.X = evt.X
-		iv.dragLoc.Y = evt.Y
-	} else if evt.Button == sdl.BUTTON_MIDDLE {
-		if evt.State == sdl.PRESSED {
-			iv.panning = true
-		} else if evt.State == sdl.RELEASED {
-			iv.panning = false
-		}
-		iv.panLoc.X = evt.X
-		iv.panLoc.Y = evt.Y
+		iv.dragPix = iv.mousePix
 	}
-	return true
 }
 
 // OnMotion is called when the cursor moves within the ui.Component's region
 func (iv *View) OnMotion(evt *sdl.MouseMotionEvent) bool {
 	if !iv.dragging && !iv.panning {
 		iv.updateMousePos(evt.X, evt.Y)
-		iv.activeTool.OnMotion(evt, iv)
+		iv.motion(evt)
 		if iv.selLayer == nil {
 			return true
 		}
 		return ui.InBounds(iv.selLayer.area, sdl.Point{X: evt.X, Y: evt.Y})
 	}
 	if evt.State == sdl.ButtonRMask() {
-		// do not allow the canvas to be dragged
-		if iv.selLayer == nil || iv.selLayer == iv.canvasLayer {
-			return true
-		}
-		newImgPix := iv.getMousePix(evt.X, evt.Y)
-		oldImgPix := iv.getMousePix(iv.dragLoc.X, iv.dragLoc.Y)
-		diff := sdl.Point{
-			X: newImgPix.X - oldImgPix.X,
-			Y: newImgPix.Y - oldImgPix.Y,
-		}
-		iv.selLayer.area.X += diff.X
-		iv.selLayer.area.Y += diff.Y
-		iv.dragLoc.X = evt.X
-		iv.dragLoc.Y = evt.Y
+		iv.updateMousePos(evt.X, evt.Y)
+		iv.drag()
 	} else if evt.State == sdl.ButtonMMask() {
 		if iv.panning {
 			iv.view.X += float32(iv.panLoc.X-evt.X) * float32(iv.view.W) / float32(iv.area.W)
@@ -327,6 +443,28 @@ func (iv *View) OnMotion(evt *sdl.MouseMotionEvent) bool {
 	return true
 }
 
+// motion passes a movement to the mouse position to the tool
+func (iv *View) motion(evt *sdl.MouseMotionEvent) {
+	if evt.State&(sdl.ButtonLMask()|sdl.ButtonRMask()) != 0 {
+		iv.recordMotion(evt.State)
+	}
+	iv.activeTool.OnMotion(evt, iv)
+}
+
+// drag moves the dragged layer by the pixels the mouse moved since the last
+// drag
+func (iv *View) drag() {
+	iv.recordMotion(sdl.ButtonRMask())
+	// do not allow the canvas to be dragged
+	if iv.selLayer == nil || iv.selLayer == iv.canvasLayer {
+		return
+	}
+	iv.edited()
+	iv.selLayer.area.X += iv.mousePix.X - iv.dragPix.X
+	iv.selLayer.area.Y += iv.mousePix.Y - iv.dragPix.Y
+	iv.dragPix = iv.mousePix
+}
+
 // OnScroll is called when the user scrolls within the ui.Component's region
 func (iv *View) OnScroll(evt *sdl.MouseWheelEvent) bool {
 	if iv.dragging {
@@ -348,20 +486,24 @@ func (iv *View) OnScroll(evt *sdl.MouseWheelEvent) bool {
 // selectLayer sets the currently selected layer to nil, and sets the layer
 // that the mouse is currently hovering over, if any.
 func (iv *View) selectLayer() {
-	iv.selLayer = nil
+	iv.selLayer = iv.layerAt(iv.mousePix)
+}
+
+// layerAt returns the topmost layer containing the given canvas pixel, if any.
+func (iv *View) layerAt(p sdl.Point) *Layer {
 	for i := len(iv.layers) - 1; i >= 0; i-- {
 		layer := iv.layers[i]
-		if ui.InBounds(layer.area, iv.mousePix) {
-			iv.selLayer = layer
-			return
+		if !layer.attrs.Hidden && ui.InBounds(layer.area, p) {
+			return layer
 		}
 	}
+	return nil
 }
 
 // ErrCoordOutOfRange indicates that given coordinates are out of range
 const ErrCoordOutOfRange log.ConstErr = "coordinates out of range"
 
-// SelectPixel adds the given x, y pixel to the
+// SelectPixel adds the given x, y pixel to the selection
 func (iv *View) SelectPixel(p sdl.Point) error {
 	if iv.selLayer == nil {
 		return nil
@@ -369,10 +511,50 @@ func (iv *View) SelectPixel(p sdl.Point) error {
 	if !ui.InBounds(iv.selLayer.area, p) {
 		return nil
 	}
-	// TODO
+	if iv.selection == nil {
+		iv.selection = make(map[sdl.Point]struct{})
+	}
+	iv.selection[p] = struct{}{}
 	return nil
 }
 
+// ClearSelection deselects all pixels
+func (iv *View) ClearSelection() {
+	iv.selection = nil
+}
+
+// selectionBounds returns the smallest rectangle containing every selected
+// pixel. The boolean is false if nothing is selected.
+func (iv *View) selectionBounds() (sdl.Rect, bool) {
+	if len(iv.selection) == 0 {
+		return sdl.Rect{}, false
+	}
+	var r sdl.Rect
+	first := true
+	for p := range iv.selection {
+		px := sdl.Rect{X: p.X, Y: p.Y, W: 1, H: 1}
+		if first {
+			r, first = px, false
+		} else {
+			r = r.Union(&px)
+		}
+	}
+	return r, true
+}
+
+// renderSelection highlights the selected pixels
+func (iv *View) renderSelection() {
+	tris := make([]float32, 0, len(iv.selection)*12)
+	for p := range iv.selection {
+		x, y := float32(p.X), float32(p.Y)
+		tris = append(tris,
+			x, y+1, x, y, x+1, y,
+			x, y+1, x+1, y, x+1, y+1,
+		)
+	}
+	iv.drawOverlay(iv.triBuf, tris, selectionColor)
+}
+
 // OnResize is called when the user resizes the window
 func (iv *View) OnResize(x, y int32) {
 	iv.area.W += x
@@ -385,20 +567,21 @@ func (iv *View) String() string {
 	return "image.View"
 }
 
-// ErrWriteFormat indicates that an unsupported image format was trying to be written to
-const ErrWriteFormat log.ConstErr = "unsupported image format"
-
-// WriteToFile uses an OpenGL Frame Buffer Object to render the data in the canvas
-// to a texture, and then write the data in that texture to the specified file
-func (iv *View) WriteToFile(fileName string) error {
-	sw := util.Start()
-	// TODO after canvas figured out
+// CanvasImage uses an OpenGL Frame Buffer Object to render the data in the
+// canvas to a texture, and returns the pixels of that texture. Documents
+// blended in linear light are composited on the CPU instead.
+func (iv *View) CanvasImage() (*image.NRGBA, error) {
+	if iv.linear {
+		return iv.deepCanvas().NRGBA(), nil
+	}
 	w, h := iv.canvas.W, iv.canvas.H
 
-	fb, err := gfx.NewFrameBuffer(iv.canvas.W, iv.canvas.H)
+	fb, err := gfx.NewFrameBuffer(w, h)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer fb.Destroy()
+	defer fb.GetTexture().Destroy()
 	fb.Bind()
 	iv.RenderCanvas()
 	fb.Unbind()
@@ -413,26 +596,64 @@ func (iv *View) WriteToFile(fileName string) error {
 		}
 	}
 	copy(img.Pix, data)
-	out, err := os.Create(fileName)
+	return img, nil
+}
+
+// WriteToFile uses an OpenGL Frame Buffer Object to render the data in the canvas
+// to a texture, and then write the data in that texture to the specified file
+// in the format registered for its extension. If the options ask for an
+// animation, the visible layers are written as frames instead. Documents of
+// more than 8 bits per channel are composited on the CPU and written at 16
+// bits unless the options choose a bit depth.
+func (iv *View) WriteToFile(fileName string, opts codec.Options) error {
+	sw := util.Start()
+	enc, err := codec.EncoderFor(fileName)
 	if err != nil {
 		return err
 	}
-	switch ext := filepath.Ext(fileName); ext {
-	case ".png":
-		err = png.Encode(out, img)
-		if err != nil {
+	if _, ok := opts[codec.BitDepthOption]; !ok && iv.depth != raster.Depth8 {
+		deepOpts := codec.Options{codec.BitDepthOption: "16"}
+		for k, v := range opts {
+			deepOpts[k] = v
+		}
+		if _, err = enc.Resolve(deepOpts); err == nil {
+			opts = deepOpts
+		}
+	}
+	if opts, err = enc.Resolve(opts); err != nil {
+		return err
+	}
+	if enc.Animates(opts) {
+		if err = codec.EncodeFramesFile(fileName, iv.Frames(), opts); err != nil {
 			return err
 		}
-	case ".jpg", ".jpeg", ".jpe", ".jfif":
-		// TODO add dialog to choose quality
-		var opt jpeg.Options
-		opt.Quality = 100
-		err = jpeg.Encode(out, img, &opt)
+		sw.Stop("WriteToFile")
+		return nil
+	}
+	if iv.palette != nil && enc.EncodeIndexed != nil {
+		img, err := iv.indexedCanvas()
 		if err != nil {
 			return err
 		}
-	default:
-		return fmt.Errorf("writing to file extension %v: %w", ext, ErrWriteFormat)
+		if err = codec.EncodeFileIndexed(fileName, img, opts, iv.metadata); err != nil {
+			return err
+		}
+		sw.Stop("WriteToFile")
+		return nil
+	}
+	if iv.depth != raster.Depth8 {
+		if err = codec.EncodeFileDeep(fileName, iv.deepCanvas().NRGBA64(), opts, iv.metadata); err != nil {
+			return err
+		}
+		sw.Stop("WriteToFile")
+		return nil
+	}
+	img, err := iv.CanvasImage()
+	if err != nil {
+		return err
+	}
+	if err = codec.EncodeFileMeta(fileName, img, opts, iv.metadata); err != nil {
+		return err
 	}
 
 	sw.Stop("WriteToFile")
@@ -445,82 +666,128 @@ type Project struct {
 	Canvas   sdl.Rect
 	View     sdl.FRect
 	Layers   []*Layer
+	Metadata codec.Metadata
+	// Depth is zero in projects saved before documents had a depth
+	Depth  raster.Depth
+	Linear bool
+	// Palette is empty unless the document is indexed
+	Palette []color.NRGBA
 }
 
-const ErrInvalidFormat log.ConstErr = "invalid project file (not .tabula)"
+const ErrInvalidFormat log.ConstErr = "invalid project file (not .tabula, .tabuladir or .ora)"
 
 // SaveProject saves the relevant project data at the specified file location
-// in a compressed format. The fileName must end with '.tabula'
+// in a compressed format. The fileName must end with '.tabula', FolderExt to
+// save a project folder, or '.ora' to save the layers as OpenRaster
 func (iv *View) SaveProject(fileName string) error {
 	sw := util.Start()
 	var ext string
-	if ext = filepath.Ext(fileName); ext != ".tabula" {
-		return fmt.Errorf("%w: %v", ErrInvalidFormat, fileName)
+	if ext = filepath.Ext(fileName); ext == ".ora" {
+		if err := iv.saveORA(fileName); err != nil {
+			return err
+		}
+		sw.Stop("SaveProject")
+		return nil
 	}
-	out, err := os.Create(fileName)
-	if err != nil {
-		return err
+	if filepath.Ext(filepath.Clean(fileName)) == FolderExt {
+		d := iv.Document()
+		if err := d.saveFolder(filepath.Clean(fileName)); err != nil {
+			return err
+		}
+		iv.rename(d.ProjName)
+		sw.Stop("SaveProject")
+		return nil
+	}
+	if ext != ".tabula" {
+		return fmt.Errorf("%w: %v", ErrInvalidFormat, fileName)
 	}
-	defer out.Close()
-
 	proj := Project{
 		ProjName: strings.TrimSuffix(filepath.Base(fileName), ext),
 		Mult:     iv.mult,
 		Canvas:   iv.canvas,
 		View:     iv.view,
 		Layers:   iv.layers,
+		Metadata: iv.metadata,
+		Depth:    iv.depth,
+		Linear:   iv.linear,
 	}
-
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	if err = enc.Encode(proj); err != nil {
-		return err
+	for _, c := range iv.palette {
+		proj.Palette = append(proj.Palette, color.NRGBAModel.Convert(c).(color.NRGBA))
 	}
-
-	zw := zlib.NewWriter(out)
-	if _, err = zw.Write(buf.Bytes()); err != nil {
+	restore := relativeSources(iv.attrs(), filepath.Dir(fileName))
+	err := writeProject(fileName, proj)
+	restore()
+	if err != nil {
 		return err
 	}
-	defer zw.Close()
 
-	iv.projName = proj.ProjName
+	iv.rename(proj.ProjName)
 	sw.Stop("SaveProject")
 	return nil
 }
 
+// rename changes the name of the project to the name it was saved as
+func (iv *View) rename(name string) {
+	if name != iv.projName {
+		iv.projName = name
+		iv.edited()
+	}
+}
+
 // LoadProject loads the project data at the specified file location,
 // decompresses and decodes the data and populates the relevant fields in
-// the image view. The fileName must end with '.tabula'
+// the image view. Folders are read as project folders, and files not ending
+// with '.tabula' as layered images, such as OpenRaster or Photoshop files.
+// Linked layers show the current content of their files, and those whose
+// file is missing keep their saved pixels and are flagged, as listed by
+// MissingLinks.
 func (iv *View) LoadProject(fileName string) error {
 	sw := util.Start()
 	var err error
-	var in *os.File
-	if in, err = os.Open(fileName); err != nil {
-		return err
-	}
-	defer in.Close()
-	if ext := filepath.Ext(fileName); ext != ".tabula" {
-		return fmt.Errorf("%w: %v", ErrInvalidFormat, fileName)
+	if dir, ok := folderPath(fileName); ok {
+		if err = iv.loadFolder(dir); err != nil {
+			return err
+		}
+		sw.Stop("LoadProject")
+		return nil
 	}
-
-	zr, err := zlib.NewReader(in)
-	if err != nil {
-		return fmt.Errorf("zlib reader error: %w", err)
+	if filepath.Ext(fileName) != ".tabula" {
+		if err = iv.loadLayers(fileName); err != nil {
+			return err
+		}
+		sw.Stop("LoadProject")
+		return nil
 	}
-	defer zr.Close()
-
 	var proj Project
-	dec := gob.NewDecoder(zr)
-	if err = dec.Decode(&proj); err != nil {
-		return fmt.Errorf("gob decoder error: %w", err)
+	if err = readProject(fileName, &proj); err != nil {
+		return err
 	}
 
+	iv.edited()
 	iv.layers = proj.Layers
 	iv.canvasLayer = proj.Layers[0]
 	iv.mult = proj.Mult
 	iv.view = proj.View
 	iv.canvas = proj.Canvas
 	iv.projName = proj.ProjName
+	iv.metadata = proj.Metadata
+	iv.depth = proj.Depth
+	if iv.depth == 0 {
+		iv.depth = raster.Depth8
+	}
+	iv.linear = proj.Linear
+	iv.palette = nil
+	for _, c := range proj.Palette {
+		iv.palette = append(iv.palette, c)
+	}
+	if iv.palette == nil {
+		for _, l := range iv.layers {
+			l.indexed = nil
+		}
+	}
+	iv.refreshIndexed()
+	resolveSources(iv.attrs(), filepath.Dir(fileName))
+	iv.refreshLinks()
 
 	iv.updateView()
 	sw.Stop("LoadProject")
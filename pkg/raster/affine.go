@@ -0,0 +1,81 @@
+// Package raster implements CPU-side pixel operations on image.NRGBA buffers,
+// such as resampling and geometric transformations. It has no OpenGL
+// dependencies so it can be used without a window.
+package raster
+
+import (
+	"math"
+)
+
+// Affine is a 2D affine transformation stored in row-major order. It maps
+// (x, y) to (m[0]*x + m[1]*y + m[2], m[3]*x + m[4]*y + m[5]).
+type Affine [6]float64
+
+// Identity returns the transformation that leaves points unchanged.
+func Identity() Affine {
+	return Affine{1, 0, 0, 0, 1, 0}
+}
+
+// Translate returns a transformation that moves points by (x, y).
+func Translate(x, y float64) Affine {
+	return Affine{1, 0, x, 0, 1, y}
+}
+
+// Scale returns a transformation that scales points about the origin.
+func Scale(sx, sy float64) Affine {
+	return Affine{sx, 0, 0, 0, sy, 0}
+}
+
+// Rotate returns a transformation that rotates points about the origin by
+// theta radians. With y pointing down, positive angles rotate clockwise.
+func Rotate(theta float64) Affine {
+	sin, cos := math.Sincos(theta)
+	return Affine{cos, -sin, 0, sin, cos, 0}
+}
+
+// Shear returns a transformation that shifts x by kx*y and y by ky*x.
+func Shear(kx, ky float64) Affine {
+	return Affine{1, kx, 0, ky, 1, 0}
+}
+
+// Mul returns the transformation that applies n and then m.
+func (m Affine) Mul(n Affine) Affine {
+	return Affine{
+		m[0]*n[0] + m[1]*n[3],
+		m[0]*n[1] + m[1]*n[4],
+		m[0]*n[2] + m[1]*n[5] + m[2],
+		m[3]*n[0] + m[4]*n[3],
+		m[3]*n[1] + m[4]*n[4],
+		m[3]*n[2] + m[4]*n[5] + m[5],
+	}
+}
+
+// Apply transforms the point (x, y).
+func (m Affine) Apply(x, y float64) (float64, float64) {
+	return m[0]*x + m[1]*y + m[2], m[3]*x + m[4]*y + m[5]
+}
+
+// ApplyVector transforms the direction (x, y), ignoring translation.
+func (m Affine) ApplyVector(x, y float64) (float64, float64) {
+	return m[0]*x + m[1]*y, m[3]*x + m[4]*y
+}
+
+// Invert returns the inverse transformation. The boolean is false if m is
+// singular and cannot be inverted.
+func (m Affine) Invert() (Affine, bool) {
+	det := m[0]*m[4] - m[1]*m[3]
+	if math.Abs(det) < 1e-12 {
+		return Affine{}, false
+	}
+	inv := 1 / det
+	a, b, d, e := m[4]*inv, -m[1]*inv, -m[3]*inv, m[0]*inv
+	return Affine{
+		a, b, -(a*m[2] + b*m[5]),
+		d, e, -(d*m[2] + e*m[5]),
+	}, true
+}
+
+// IsIdentity returns whether m leaves every point unchanged.
+func (m Affine) IsIdentity() bool {
+	return m == Identity()
+}
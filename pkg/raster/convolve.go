@@ -0,0 +1,218 @@
+package raster
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+)
+
+// EdgeMode decides which pixels a convolution reads past the image edges.
+type EdgeMode int
+
+// Constants for all edge modes.
+const (
+	EdgeClamp EdgeMode = iota
+	EdgeWrap
+	EdgeTransparent
+)
+
+// EdgeModes lists every edge mode.
+var EdgeModes = []EdgeMode{EdgeClamp, EdgeWrap, EdgeTransparent}
+
+func (e EdgeMode) String() string {
+	switch e {
+	case EdgeClamp:
+		return "clamp"
+	case EdgeWrap:
+		return "wrap"
+	case EdgeTransparent:
+		return "transparent"
+	}
+	return fmt.Sprintf("EdgeMode(%d)", int(e))
+}
+
+// ErrUnknownEdgeMode indicates that an edge mode name was not recognized
+const ErrUnknownEdgeMode log.ConstErr = "unknown edge mode"
+
+// ParseEdgeMode returns the edge mode with the given case-insensitive name.
+func ParseEdgeMode(name string) (EdgeMode, error) {
+	for _, e := range EdgeModes {
+		if strings.EqualFold(name, e.String()) {
+			return e, nil
+		}
+	}
+	return EdgeClamp, fmt.Errorf("%w: %v", ErrUnknownEdgeMode, name)
+}
+
+// MarshalText encodes the edge mode by name.
+func (e EdgeMode) MarshalText() ([]byte, error) {
+	return []byte(e.String()), nil
+}
+
+// UnmarshalText decodes an edge mode name.
+func (e *EdgeMode) UnmarshalText(text []byte) error {
+	mode, err := ParseEdgeMode(string(text))
+	if err != nil {
+		return err
+	}
+	*e = mode
+	return nil
+}
+
+// MaxKernelSize is the largest supported kernel width and height.
+const MaxKernelSize = 9
+
+// Kernel is a square convolution matrix. Each output channel is the weighted
+// sum of the surrounding input pixels, divided by Divisor, plus Offset.
+type Kernel struct {
+	Size    int       `json:"size"`
+	Weights []float64 `json:"weights"`
+	Divisor float64   `json:"divisor"`
+	Offset  float64   `json:"offset"`
+	Edge    EdgeMode  `json:"edge"`
+	// Alpha also convolves the alpha channel instead of keeping it.
+	Alpha bool `json:"alpha"`
+}
+
+// ErrInvalidKernel indicates that a kernel can not be used for convolution
+const ErrInvalidKernel log.ConstErr = "invalid kernel"
+
+// Validate checks that the kernel is an odd size up to MaxKernelSize with a
+// weight for every cell and a non-zero divisor.
+func (k Kernel) Validate() error {
+	if k.Size < 1 || k.Size > MaxKernelSize || k.Size%2 == 0 {
+		return fmt.Errorf("%w: size %v must be odd and at most %v", ErrInvalidKernel, k.Size, MaxKernelSize)
+	}
+	if len(k.Weights) != k.Size*k.Size {
+		return fmt.Errorf("%w: %v weights for size %v", ErrInvalidKernel, len(k.Weights), k.Size)
+	}
+	if k.Divisor == 0 {
+		return fmt.Errorf("%w: divisor is zero", ErrInvalidKernel)
+	}
+	return nil
+}
+
+// ParseKernel reads kernel weights as rows separated by semicolons or new
+// lines, with values separated by spaces or commas. The divisor defaults to
+// the sum of the weights, or 1 if they sum to zero.
+func ParseKernel(text string) (Kernel, error) {
+	rows := strings.FieldsFunc(text, func(r rune) bool { return r == ';' || r == '\n' })
+	var k Kernel
+	for _, row := range rows {
+		fields := strings.FieldsFunc(row, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+		if len(fields) == 0 {
+			continue
+		}
+		if k.Size == 0 {
+			k.Size = len(fields)
+		} else if len(fields) != k.Size {
+			return Kernel{}, fmt.Errorf("%w: row %q has %v values, expected %v", ErrInvalidKernel, row, len(fields), k.Size)
+		}
+		for _, f := range fields {
+			w, err := strconv.ParseFloat(f, 64)
+			if err != nil {
+				return Kernel{}, fmt.Errorf("%w: %v", ErrInvalidKernel, err)
+			}
+			k.Weights = append(k.Weights, w)
+		}
+	}
+	if len(k.Weights) != k.Size*k.Size {
+		return Kernel{}, fmt.Errorf("%w: %v values do not form a square", ErrInvalidKernel, len(k.Weights))
+	}
+	k.Divisor = 1
+	var sum float64
+	for _, w := range k.Weights {
+		sum += w
+	}
+	if sum != 0 {
+		k.Divisor = sum
+	}
+	return k, k.Validate()
+}
+
+// FormatWeights writes the kernel weights in the form read by ParseKernel.
+func (k Kernel) FormatWeights() string {
+	rows := make([]string, 0, k.Size)
+	for j := 0; j < k.Size; j++ {
+		vals := make([]string, 0, k.Size)
+		for i := 0; i < k.Size; i++ {
+			vals = append(vals, strconv.FormatFloat(k.Weights[j*k.Size+i], 'g', -1, 64))
+		}
+		rows = append(rows, strings.Join(vals, " "))
+	}
+	return strings.Join(rows, "; ")
+}
+
+// Convolve applies the kernel to every pixel of src.
+func Convolve(src *image.NRGBA, k Kernel) (*image.NRGBA, error) {
+	if err := k.Validate(); err != nil {
+		return nil, err
+	}
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	r := k.Size / 2
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var acc [4]float64
+			for j := -r; j <= r; j++ {
+				for i := -r; i <= r; i++ {
+					wt := k.Weights[(j+r)*k.Size+i+r]
+					if wt == 0 {
+						continue
+					}
+					p, ok := edgePixel(src, x+i, y+j, k.Edge)
+					if !ok {
+						continue
+					}
+					acc[0] += float64(p[0]) * wt
+					acc[1] += float64(p[1]) * wt
+					acc[2] += float64(p[2]) * wt
+					acc[3] += float64(p[3]) * wt
+				}
+			}
+			out := dst.Pix[dst.PixOffset(x, y):]
+			for c := 0; c < 3; c++ {
+				out[c] = clamp(acc[c]/k.Divisor + k.Offset)
+			}
+			if k.Alpha {
+				out[3] = clamp(acc[3] / k.Divisor)
+			} else {
+				out[3] = src.Pix[src.PixOffset(src.Rect.Min.X+x, src.Rect.Min.Y+y)+3]
+			}
+		}
+	}
+	return dst, nil
+}
+
+// edgePixel returns the pixel at (x, y) relative to the origin of src,
+// resolving coordinates outside of the image with the edge mode. The boolean
+// is false if the pixel is transparent.
+func edgePixel(src *image.NRGBA, x, y int, edge EdgeMode) ([]uint8, bool) {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	if x < 0 || y < 0 || x >= w || y >= h {
+		switch edge {
+		case EdgeWrap:
+			x = (x%w + w) % w
+			y = (y%h + h) % h
+		case EdgeTransparent:
+			return nil, false
+		default:
+			x = clampInt(x, 0, w-1)
+			y = clampInt(y, 0, h-1)
+		}
+	}
+	return src.Pix[src.PixOffset(src.Rect.Min.X+x, src.Rect.Min.Y+y):], true
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
@@ -0,0 +1,73 @@
+package raster
+
+import (
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+)
+
+// Crop returns a copy of the part of src inside r, relative to the origin of
+// src. Parts of r outside of src are transparent.
+func Crop(src *image.NRGBA, r image.Rectangle) *image.NRGBA {
+	dst := image.NewNRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	r = r.Add(src.Rect.Min)
+	inter := r.Intersect(src.Rect)
+	for y := inter.Min.Y; y < inter.Max.Y; y++ {
+		from := src.Pix[src.PixOffset(inter.Min.X, y):src.PixOffset(inter.Max.X, y)]
+		copy(dst.Pix[dst.PixOffset(inter.Min.X-r.Min.X, y-r.Min.Y):], from)
+	}
+	return dst
+}
+
+// OpaqueBounds returns the smallest rectangle containing every pixel of img
+// that is not fully transparent, relative to the origin of img. The rectangle
+// is empty if every pixel is transparent.
+func OpaqueBounds(img *image.NRGBA) image.Rectangle {
+	b := img.Rect
+	var r image.Rectangle
+	found := false
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if img.Pix[img.PixOffset(x, y)+3] == 0 {
+				continue
+			}
+			px := image.Rect(x, y, x+1, y+1)
+			if !found {
+				r, found = px, true
+			} else {
+				r = r.Union(px)
+			}
+		}
+	}
+	return r.Sub(b.Min)
+}
+
+// ErrInvalidColor indicates that a color could not be parsed
+const ErrInvalidColor log.ConstErr = "invalid hex color"
+
+// ParseHex parses a color in the form RRGGBB or RRGGBBAA, optionally starting
+// with '#'. Colors without alpha are opaque.
+func ParseHex(s string) (color.NRGBA, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(s) != 6 && len(s) != 8 {
+		return color.NRGBA{}, fmt.Errorf("%w: %q", ErrInvalidColor, s)
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("%w: %q", ErrInvalidColor, s)
+	}
+	c := color.NRGBA{R: b[0], G: b[1], B: b[2], A: 0xFF}
+	if len(b) == 4 {
+		c.A = b[3]
+	}
+	return c, nil
+}
+
+// Hex formats a color in the form RRGGBBAA.
+func Hex(c color.NRGBA) string {
+	return fmt.Sprintf("%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+}
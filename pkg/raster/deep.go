@@ -0,0 +1,322 @@
+package raster
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+)
+
+// Depth is the precision of each color channel of a document.
+type Depth int
+
+// Constants for all depths, valued by their bits per channel.
+const (
+	Depth8   Depth = 8
+	Depth16  Depth = 16
+	Depth32F Depth = 32
+)
+
+// Depths lists every depth.
+var Depths = []Depth{Depth8, Depth16, Depth32F}
+
+func (d Depth) String() string {
+	switch d {
+	case Depth8:
+		return "8-bit"
+	case Depth16:
+		return "16-bit"
+	case Depth32F:
+		return "32-bit float"
+	}
+	return fmt.Sprintf("Depth(%d)", int(d))
+}
+
+// ErrUnknownDepth indicates that a depth name was not recognized
+const ErrUnknownDepth log.ConstErr = "unknown depth"
+
+// ParseDepth returns the depth with the given case-insensitive name, which
+// may also be just its number of bits.
+func ParseDepth(s string) (Depth, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for _, d := range Depths {
+		if s == d.String() || s == fmt.Sprint(int(d)) {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", ErrUnknownDepth, s)
+}
+
+// Float is an image of non-premultiplied RGBA samples from zero to one,
+// stored as 32-bit floats.
+type Float struct {
+	Pix    []float32
+	Stride int
+	Rect   image.Rectangle
+}
+
+// NewFloat returns a transparent image with the given bounds.
+func NewFloat(r image.Rectangle) *Float {
+	return &Float{Pix: make([]float32, 4*r.Dx()*r.Dy()), Stride: 4 * r.Dx(), Rect: r}
+}
+
+// PixOffset returns the index of the first sample of the pixel at (x, y).
+func (f *Float) PixOffset(x, y int) int {
+	return (y-f.Rect.Min.Y)*f.Stride + (x-f.Rect.Min.X)*4
+}
+
+// ColorModel fulfills image.Image. Colors are rounded to 16 bits.
+func (f *Float) ColorModel() color.Model {
+	return color.NRGBA64Model
+}
+
+// Bounds fulfills image.Image.
+func (f *Float) Bounds() image.Rectangle {
+	return f.Rect
+}
+
+// At fulfills image.Image.
+func (f *Float) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(f.Rect)) {
+		return color.NRGBA64{}
+	}
+	p := f.Pix[f.PixOffset(x, y):]
+	return color.NRGBA64{R: unit16(p[0]), G: unit16(p[1]), B: unit16(p[2]), A: unit16(p[3])}
+}
+
+// Copy returns a copy of f.
+func (f *Float) Copy() *Float {
+	c := NewFloat(f.Rect)
+	for y := f.Rect.Min.Y; y < f.Rect.Max.Y; y++ {
+		copy(c.Pix[c.PixOffset(f.Rect.Min.X, y):c.PixOffset(f.Rect.Max.X, y)], f.Pix[f.PixOffset(f.Rect.Min.X, y):])
+	}
+	return c
+}
+
+// FloatFrom converts img to a float image with the same bounds, keeping up
+// to 16 bits of each channel.
+func FloatFrom(img image.Image) *Float {
+	r := img.Bounds()
+	f := NewFloat(r)
+	switch src := img.(type) {
+	case *Float:
+		return src.Copy()
+	case *image.NRGBA:
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			s := src.Pix[src.PixOffset(r.Min.X, y):src.PixOffset(r.Max.X, y)]
+			d := f.Pix[f.PixOffset(r.Min.X, y):]
+			for i, v := range s {
+				d[i] = float32(v) / 255
+			}
+		}
+		return f
+	case *image.NRGBA64:
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			s := src.Pix[src.PixOffset(r.Min.X, y):src.PixOffset(r.Max.X, y)]
+			d := f.Pix[f.PixOffset(r.Min.X, y):]
+			for i := 0; i < len(s)/2; i++ {
+				d[i] = float32(uint16(s[i*2])<<8|uint16(s[i*2+1])) / 0xffff
+			}
+		}
+		return f
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+			d := f.Pix[f.PixOffset(x, y):]
+			d[0], d[1], d[2], d[3] = float32(c.R)/0xffff, float32(c.G)/0xffff, float32(c.B)/0xffff, float32(c.A)/0xffff
+		}
+	}
+	return f
+}
+
+// NRGBA returns f rounded to 8 bits per channel.
+func (f *Float) NRGBA() *image.NRGBA {
+	img := image.NewNRGBA(f.Rect)
+	for y := f.Rect.Min.Y; y < f.Rect.Max.Y; y++ {
+		s := f.Pix[f.PixOffset(f.Rect.Min.X, y):f.PixOffset(f.Rect.Max.X, y)]
+		d := img.Pix[img.PixOffset(f.Rect.Min.X, y):]
+		for i, v := range s {
+			d[i] = clamp(float64(v) * 255)
+		}
+	}
+	return img
+}
+
+// NRGBA64 returns f rounded to 16 bits per channel.
+func (f *Float) NRGBA64() *image.NRGBA64 {
+	img := image.NewNRGBA64(f.Rect)
+	for y := f.Rect.Min.Y; y < f.Rect.Max.Y; y++ {
+		s := f.Pix[f.PixOffset(f.Rect.Min.X, y):f.PixOffset(f.Rect.Max.X, y)]
+		d := img.Pix[img.PixOffset(f.Rect.Min.X, y):]
+		for i, v := range s {
+			u := unit16(v)
+			d[i*2], d[i*2+1] = uint8(u>>8), uint8(u)
+		}
+	}
+	return img
+}
+
+// Quantize rounds every sample of f in place to what the depth can store.
+// Float samples are only clamped to the unit range.
+func (f *Float) Quantize(d Depth) {
+	levels := float32(0)
+	switch d {
+	case Depth8:
+		levels = 0xff
+	case Depth16:
+		levels = 0xffff
+	}
+	for i, v := range f.Pix {
+		v = clampUnit(v)
+		if levels > 0 {
+			v = float32(math.Floor(float64(v*levels)+0.5)) / levels
+		}
+		f.Pix[i] = v
+	}
+}
+
+// Reconcile returns the pixels of after at full precision, taking samples
+// from prev where the 8-bit rounding of prev is unchanged in after. This
+// keeps the precision of pixels an 8-bit operation did not touch. All three
+// images must have the same bounds.
+func Reconcile(prev *Float, prev8, after *image.NRGBA) *Float {
+	f := FloatFrom(after)
+	r := f.Rect
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			i, j := prev8.PixOffset(x, y), after.PixOffset(x, y)
+			if string(prev8.Pix[i:i+4]) == string(after.Pix[j:j+4]) {
+				copy(f.Pix[f.PixOffset(x, y):f.PixOffset(x, y)+4], prev.Pix[prev.PixOffset(x, y):])
+			}
+		}
+	}
+	return f
+}
+
+// ToLinear converts an sRGB encoded sample to linear light.
+func ToLinear(v float32) float32 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return float32(math.Pow((float64(v)+0.055)/1.055, 2.4))
+}
+
+// FromLinear converts a linear light sample to sRGB encoding.
+func FromLinear(v float32) float32 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return float32(1.055*math.Pow(float64(v), 1/2.4) - 0.055)
+}
+
+// Over composites src onto dst source over, with the origin of src placed at
+// at in dst and its alpha scaled by opacity. If linear is set, colors are
+// blended in linear light instead of their sRGB encoding.
+func Over(dst, src *Float, at image.Point, opacity float64, linear bool) {
+	r := src.Rect.Sub(src.Rect.Min).Add(at).Intersect(dst.Rect)
+	op := float32(opacity)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			s := src.Pix[src.PixOffset(x-at.X+src.Rect.Min.X, y-at.Y+src.Rect.Min.Y):]
+			d := dst.Pix[dst.PixOffset(x, y):]
+			sa := clampUnit(s[3]) * op
+			if sa == 0 {
+				continue
+			}
+			da := d[3]
+			oa := sa + da*(1-sa)
+			for c := 0; c < 3; c++ {
+				sc, dc := clampUnit(s[c]), d[c]
+				if linear {
+					sc, dc = ToLinear(sc), ToLinear(dc)
+				}
+				v := (sc*sa + dc*da*(1-sa)) / oa
+				if linear {
+					v = FromLinear(v)
+				}
+				d[c] = v
+			}
+			d[3] = oa
+		}
+	}
+}
+
+// ConvolveFloat is Convolve for float images. The kernel offset is in 8-bit
+// units like for Convolve.
+func ConvolveFloat(src *Float, k Kernel) (*Float, error) {
+	if err := k.Validate(); err != nil {
+		return nil, err
+	}
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dst := NewFloat(image.Rect(0, 0, w, h))
+	r := k.Size / 2
+	offset := k.Offset / 255
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var acc [4]float64
+			for j := -r; j <= r; j++ {
+				for i := -r; i <= r; i++ {
+					wt := k.Weights[(j+r)*k.Size+i+r]
+					if wt == 0 {
+						continue
+					}
+					p, ok := edgeFloat(src, x+i, y+j, k.Edge)
+					if !ok {
+						continue
+					}
+					acc[0] += float64(p[0]) * wt
+					acc[1] += float64(p[1]) * wt
+					acc[2] += float64(p[2]) * wt
+					acc[3] += float64(p[3]) * wt
+				}
+			}
+			out := dst.Pix[dst.PixOffset(x, y):]
+			for c := 0; c < 3; c++ {
+				out[c] = clampUnit(float32(acc[c]/k.Divisor + offset))
+			}
+			if k.Alpha {
+				out[3] = clampUnit(float32(acc[3] / k.Divisor))
+			} else {
+				out[3] = src.Pix[src.PixOffset(src.Rect.Min.X+x, src.Rect.Min.Y+y)+3]
+			}
+		}
+	}
+	return dst, nil
+}
+
+// edgeFloat is edgePixel for float images
+func edgeFloat(src *Float, x, y int, edge EdgeMode) ([]float32, bool) {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	if x < 0 || y < 0 || x >= w || y >= h {
+		switch edge {
+		case EdgeWrap:
+			x = (x%w + w) % w
+			y = (y%h + h) % h
+		case EdgeTransparent:
+			return nil, false
+		default:
+			x = clampInt(x, 0, w-1)
+			y = clampInt(y, 0, h-1)
+		}
+	}
+	return src.Pix[src.PixOffset(src.Rect.Min.X+x, src.Rect.Min.Y+y):], true
+}
+
+func clampUnit(v float32) float32 {
+	if v <= 0 || v != v {
+		return 0
+	}
+	if v >= 1 {
+		return 1
+	}
+	return v
+}
+
+// unit16 rounds a unit sample to 16 bits
+func unit16(v float32) uint16 {
+	return uint16(float64(clampUnit(v))*0xffff + 0.5)
+}
@@ -0,0 +1,170 @@
+package raster
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+)
+
+// Dither decides how the error of mapping a pixel onto a palette is spread.
+type Dither int
+
+// Constants for all dithering methods.
+const (
+	DitherNone Dither = iota
+	DitherFloydSteinberg
+	DitherOrdered
+)
+
+// Dithers lists every dithering method.
+var Dithers = []Dither{DitherNone, DitherFloydSteinberg, DitherOrdered}
+
+func (d Dither) String() string {
+	switch d {
+	case DitherNone:
+		return "none"
+	case DitherFloydSteinberg:
+		return "floyd-steinberg"
+	case DitherOrdered:
+		return "ordered"
+	}
+	return fmt.Sprintf("Dither(%d)", int(d))
+}
+
+// ErrUnknownDither indicates that a dithering method name was not recognized
+const ErrUnknownDither log.ConstErr = "unknown dithering method"
+
+// ParseDither returns the dithering method with the given case-insensitive
+// name.
+func ParseDither(s string) (Dither, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for _, d := range Dithers {
+		if s == d.String() {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", ErrUnknownDither, s)
+}
+
+// bayer is the 4 by 4 ordered dithering threshold matrix
+var bayer = [4][4]float64{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// ToPalettedDither is ToPaletted with a choice of dithering method. Ordered
+// dithering offsets each pixel by a threshold pattern scaled to the average
+// distance between palette colors.
+func ToPalettedDither(img *image.NRGBA, p color.Palette, d Dither) *image.Paletted {
+	if d != DitherOrdered {
+		return ToPaletted(img, p, d == DitherFloydSteinberg)
+	}
+	colors := 0
+	for _, c := range p {
+		if _, _, _, a := c.RGBA(); a != 0 {
+			colors++
+		}
+	}
+	spread := 255.0
+	if colors > 1 {
+		spread = 255 / (math.Cbrt(float64(colors)) - 1 + 1e-9)
+		if spread > 255 {
+			spread = 255
+		}
+	}
+	b := img.Rect
+	shifted := image.NewNRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			off := ((bayer[y&3][x&3]+0.5)/16 - 0.5) * spread
+			s := img.Pix[img.PixOffset(x, y):]
+			d := shifted.Pix[shifted.PixOffset(x, y):]
+			for c := 0; c < 3; c++ {
+				d[c] = clamp(float64(s[c]) + off)
+			}
+			d[3] = s[3]
+		}
+	}
+	return ToPaletted(shifted, p, false)
+}
+
+// KMeans returns a palette of at most n opaque colors for the pixels of img
+// with an alpha of at least one half. It starts from the median cut palette
+// and moves every color to the mean of the pixels nearest to it for the
+// given number of iterations, or until no color moves.
+func KMeans(img *image.NRGBA, n, iterations int) color.Palette {
+	bins := histogram(img)
+	p := MedianCut(img, n)
+	centers := make([][3]float64, len(p))
+	for i, c := range p {
+		nc := c.(color.NRGBA)
+		centers[i] = [3]float64{float64(nc.R), float64(nc.G), float64(nc.B)}
+	}
+	for it := 0; it < iterations; it++ {
+		sums := make([][3]float64, len(centers))
+		counts := make([]float64, len(centers))
+		for _, b := range bins {
+			mean := [3]float64{
+				float64(b.sum[0]) / float64(b.count),
+				float64(b.sum[1]) / float64(b.count),
+				float64(b.sum[2]) / float64(b.count),
+			}
+			best, bestDist := 0, math.Inf(1)
+			for i, c := range centers {
+				dr, dg, db := mean[0]-c[0], mean[1]-c[1], mean[2]-c[2]
+				if dist := dr*dr + dg*dg + db*db; dist < bestDist {
+					best, bestDist = i, dist
+				}
+			}
+			for c := 0; c < 3; c++ {
+				sums[best][c] += float64(b.sum[c])
+			}
+			counts[best] += float64(b.count)
+		}
+		moved := false
+		for i := range centers {
+			if counts[i] == 0 {
+				continue
+			}
+			next := [3]float64{sums[i][0] / counts[i], sums[i][1] / counts[i], sums[i][2] / counts[i]}
+			if math.Abs(next[0]-centers[i][0])+math.Abs(next[1]-centers[i][1])+math.Abs(next[2]-centers[i][2]) > 0.5 {
+				moved = true
+			}
+			centers[i] = next
+		}
+		if !moved {
+			break
+		}
+	}
+	for i, c := range centers {
+		p[i] = color.NRGBA{R: clamp(c[0]), G: clamp(c[1]), B: clamp(c[2]), A: 0xFF}
+	}
+	return p
+}
+
+// FromPaletted returns the colors of the pixels of p.
+func FromPaletted(p *image.Paletted) *image.NRGBA {
+	img := image.NewNRGBA(p.Rect)
+	colors := make([]color.NRGBA, len(p.Palette))
+	for i, c := range p.Palette {
+		colors[i] = color.NRGBAModel.Convert(c).(color.NRGBA)
+	}
+	for y := p.Rect.Min.Y; y < p.Rect.Max.Y; y++ {
+		for x := p.Rect.Min.X; x < p.Rect.Max.X; x++ {
+			i := int(p.Pix[p.PixOffset(x, y)])
+			if i >= len(colors) {
+				continue
+			}
+			c := colors[i]
+			d := img.Pix[img.PixOffset(x, y):]
+			d[0], d[1], d[2], d[3] = c.R, c.G, c.B, c.A
+		}
+	}
+	return img
+}
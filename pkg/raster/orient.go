@@ -0,0 +1,131 @@
+package raster
+
+import (
+	"fmt"
+	"image"
+)
+
+// Orientation is one of the eight lossless rotations and mirrorings of an
+// image. The values match the EXIF orientation tag, where each value names
+// the operation that must be applied to the stored pixels for display.
+type Orientation int
+
+// Constants for all orientations, numbered as in EXIF.
+const (
+	OrientNormal Orientation = iota + 1
+	OrientFlipH
+	OrientRotate180
+	OrientFlipV
+	OrientTranspose
+	OrientRotate90
+	OrientTransverse
+	OrientRotate270
+)
+
+func (o Orientation) String() string {
+	switch o {
+	case OrientNormal:
+		return "normal"
+	case OrientFlipH:
+		return "flip horizontal"
+	case OrientRotate180:
+		return "rotate 180"
+	case OrientFlipV:
+		return "flip vertical"
+	case OrientTranspose:
+		return "transpose"
+	case OrientRotate90:
+		return "rotate 90 clockwise"
+	case OrientTransverse:
+		return "transverse"
+	case OrientRotate270:
+		return "rotate 90 counterclockwise"
+	}
+	return fmt.Sprintf("Orientation(%d)", int(o))
+}
+
+// SwapsAxes returns whether the orientation exchanges width and height.
+func (o Orientation) SwapsAxes() bool {
+	return o >= OrientTranspose && o <= OrientRotate270
+}
+
+// mapPoint maps the continuous point (x, y) within a w by h frame. Mapping
+// both corners of a pixel gives the corners of the pixel after reorienting.
+func (o Orientation) mapPoint(x, y, w, h int) (int, int) {
+	switch o {
+	case OrientFlipH:
+		return w - x, y
+	case OrientRotate180:
+		return w - x, h - y
+	case OrientFlipV:
+		return x, h - y
+	case OrientTranspose:
+		return y, x
+	case OrientRotate90:
+		return h - y, x
+	case OrientTransverse:
+		return h - y, w - x
+	case OrientRotate270:
+		return y, w - x
+	}
+	return x, y
+}
+
+// MapRect returns where the rectangle r ends up when a w by h frame
+// containing it is reoriented.
+func (o Orientation) MapRect(r image.Rectangle, w, h int) image.Rectangle {
+	x0, y0 := o.mapPoint(r.Min.X, r.Min.Y, w, h)
+	x1, y1 := o.mapPoint(r.Max.X, r.Max.Y, w, h)
+	return image.Rect(x0, y0, x1, y1).Canon()
+}
+
+// Reorient returns a copy of src rotated or mirrored according to o.
+func Reorient(src *image.NRGBA, o Orientation) *image.NRGBA {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dw, dh := o.size(w, h)
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	o.each(w, h, func(x, y, dx, dy int) {
+		s := src.PixOffset(src.Rect.Min.X+x, src.Rect.Min.Y+y)
+		copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[s:s+4])
+	})
+	return dst
+}
+
+// ReorientFloat is Reorient for float images.
+func ReorientFloat(src *Float, o Orientation) *Float {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dw, dh := o.size(w, h)
+	dst := NewFloat(image.Rect(0, 0, dw, dh))
+	o.each(w, h, func(x, y, dx, dy int) {
+		s := src.PixOffset(src.Rect.Min.X+x, src.Rect.Min.Y+y)
+		copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[s:s+4])
+	})
+	return dst
+}
+
+// size returns the size of a w by h frame after reorienting it
+func (o Orientation) size(w, h int) (int, int) {
+	if o.SwapsAxes() {
+		return h, w
+	}
+	return w, h
+}
+
+// each calls f with every pixel of a w by h frame and the pixel it moves to
+func (o Orientation) each(w, h int, f func(x, y, dx, dy int)) {
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			// the destination pixel is the one spanned by the mapped corners
+			x0, y0 := o.mapPoint(x, y, w, h)
+			x1, y1 := o.mapPoint(x+1, y+1, w, h)
+			dx, dy := x0, y0
+			if x1 < dx {
+				dx = x1
+			}
+			if y1 < dy {
+				dy = y1
+			}
+			f(x, y, dx, dy)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package raster
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+)
+
+// opaqueAlpha is the alpha from which a pixel counts as opaque when
+// reducing colors.
+const opaqueAlpha = 0x80
+
+// colorBin accumulates the pixels falling into one 5 bit per channel cell of
+// the color cube
+type colorBin struct {
+	key   [3]uint8
+	sum   [3]uint64
+	count uint64
+}
+
+// histogram returns the non-empty color bins of the opaque pixels of img
+func histogram(img *image.NRGBA) []*colorBin {
+	var cube [1 << 15]*colorBin
+	var bins []*colorBin
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			p := img.Pix[img.PixOffset(x, y):]
+			if p[3] < opaqueAlpha {
+				continue
+			}
+			k := [3]uint8{p[0] >> 3, p[1] >> 3, p[2] >> 3}
+			i := int(k[0])<<10 | int(k[1])<<5 | int(k[2])
+			b := cube[i]
+			if b == nil {
+				b = &colorBin{key: k}
+				cube[i] = b
+				bins = append(bins, b)
+			}
+			b.sum[0] += uint64(p[0])
+			b.sum[1] += uint64(p[1])
+			b.sum[2] += uint64(p[2])
+			b.count++
+		}
+	}
+	return bins
+}
+
+// MedianCut returns a palette of at most n opaque colors for the pixels of
+// img with an alpha of at least one half. The color cube is split
+// repeatedly at the median of the widest channel of the widest box.
+func MedianCut(img *image.NRGBA, n int) color.Palette {
+	boxes := [][]*colorBin{histogram(img)}
+	if len(boxes[0]) == 0 {
+		return color.Palette{}
+	}
+	for len(boxes) < n {
+		// find the box and channel with the widest range
+		best, axis, width := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			for c := 0; c < 3; c++ {
+				lo, hi := box[0].key[c], box[0].key[c]
+				for _, b := range box {
+					if b.key[c] < lo {
+						lo = b.key[c]
+					}
+					if b.key[c] > hi {
+						hi = b.key[c]
+					}
+				}
+				if int(hi-lo) > width || best < 0 {
+					best, axis, width = i, c, int(hi-lo)
+				}
+			}
+		}
+		if best < 0 {
+			break
+		}
+		box := boxes[best]
+		sort.Slice(box, func(a, b int) bool { return box[a].key[axis] < box[b].key[axis] })
+		var total, acc uint64
+		for _, b := range box {
+			total += b.count
+		}
+		split := 1
+		for i, b := range box[:len(box)-1] {
+			acc += b.count
+			split = i + 1
+			if acc*2 >= total {
+				break
+			}
+		}
+		boxes[best] = box[:split]
+		boxes = append(boxes, box[split:])
+	}
+
+	palette := make(color.Palette, 0, len(boxes))
+	for _, box := range boxes {
+		var sum [3]uint64
+		var count uint64
+		for _, b := range box {
+			sum[0] += b.sum[0]
+			sum[1] += b.sum[1]
+			sum[2] += b.sum[2]
+			count += b.count
+		}
+		palette = append(palette, color.NRGBA{
+			R: uint8((sum[0] + count/2) / count),
+			G: uint8((sum[1] + count/2) / count),
+			B: uint8((sum[2] + count/2) / count),
+			A: 0xFF,
+		})
+	}
+	return palette
+}
+
+// ToPaletted maps img onto the palette, optionally spreading the error with
+// Floyd-Steinberg dithering. Pixels with an alpha below one half use the
+// first fully transparent palette entry, if there is one, and all other
+// pixels are treated as opaque.
+func ToPaletted(img *image.NRGBA, p color.Palette, dither bool) *image.Paletted {
+	transparent := -1
+	for i, c := range p {
+		if _, _, _, a := c.RGBA(); a == 0 {
+			transparent = i
+			break
+		}
+	}
+	opaque := mapPixels(img, func(px []uint8) {
+		if px[3] < opaqueAlpha && transparent >= 0 {
+			px[0], px[1], px[2], px[3] = 0, 0, 0, 0
+		} else {
+			px[3] = 0xFF
+		}
+	})
+	dst := image.NewPaletted(opaque.Rect, p)
+	var drawer draw.Drawer = draw.Src
+	if dither {
+		drawer = draw.FloydSteinberg
+	}
+	drawer.Draw(dst, dst.Rect, opaque, image.Point{})
+	if transparent >= 0 {
+		for i := 0; i < len(opaque.Pix); i += 4 {
+			if opaque.Pix[i+3] == 0 {
+				dst.Pix[i/4] = uint8(transparent)
+			}
+		}
+	}
+	return dst
+}
+
+// octreeNode is a cell of the color cube in an octree quantizer
+type octreeNode struct {
+	children [8]*octreeNode
+	sum      [3]uint64
+	count    uint64
+	leaf     bool
+}
+
+// Octree returns a palette of at most n opaque colors for the pixels of img
+// with an alpha of at least one half. Colors are sorted into an octree eight
+// levels deep whose deepest nodes are merged into their parents until no more
+// than n leaves remain.
+func Octree(img *image.NRGBA, n int) color.Palette {
+	if n < 1 {
+		n = 1
+	}
+	root := &octreeNode{}
+	// reducible holds the inner nodes of each level
+	var reducible [8][]*octreeNode
+	reducible[0] = []*octreeNode{root}
+	leaves := 0
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			p := img.Pix[img.PixOffset(x, y):]
+			if p[3] < opaqueAlpha {
+				continue
+			}
+			node := root
+			for level := 0; level < 8 && !node.leaf; level++ {
+				shift := uint(7 - level)
+				i := (p[0]>>shift&1)<<2 | (p[1]>>shift&1)<<1 | p[2]>>shift&1
+				child := node.children[i]
+				if child == nil {
+					child = &octreeNode{leaf: level == 7}
+					node.children[i] = child
+					if child.leaf {
+						leaves++
+					} else {
+						reducible[level+1] = append(reducible[level+1], child)
+					}
+				}
+				node = child
+			}
+			node.sum[0] += uint64(p[0])
+			node.sum[1] += uint64(p[1])
+			node.sum[2] += uint64(p[2])
+			node.count++
+		}
+	}
+	if leaves == 0 {
+		return color.Palette{}
+	}
+
+	for level := 7; leaves > n && level >= 0; {
+		nodes := reducible[level]
+		if len(nodes) == 0 {
+			level--
+			continue
+		}
+		node := nodes[len(nodes)-1]
+		reducible[level] = nodes[:len(nodes)-1]
+		merged := 0
+		for i, child := range node.children {
+			if child == nil {
+				continue
+			}
+			node.sum[0] += child.sum[0]
+			node.sum[1] += child.sum[1]
+			node.sum[2] += child.sum[2]
+			node.count += child.count
+			node.children[i] = nil
+			merged++
+		}
+		node.leaf = true
+		leaves -= merged - 1
+	}
+
+	palette := make(color.Palette, 0, leaves)
+	var collect func(*octreeNode)
+	collect = func(node *octreeNode) {
+		if node.leaf {
+			if node.count > 0 {
+				palette = append(palette, color.NRGBA{
+					R: uint8((node.sum[0] + node.count/2) / node.count),
+					G: uint8((node.sum[1] + node.count/2) / node.count),
+					B: uint8((node.sum[2] + node.count/2) / node.count),
+					A: 0xFF,
+				})
+			}
+			return
+		}
+		for _, child := range node.children {
+			if child != nil {
+				collect(child)
+			}
+		}
+	}
+	collect(root)
+	return palette
+}
@@ -0,0 +1,544 @@
+package raster_test
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+)
+
+// gradient returns a w by h opaque image where every pixel is unique
+func gradient(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for j := 0; j < h; j++ {
+		for i := 0; i < w; i++ {
+			img.SetNRGBA(i, j, color.NRGBA{R: uint8(i * 16), G: uint8(j * 16), B: uint8(i + j), A: 0xFF})
+		}
+	}
+	return img
+}
+
+func TestAffineInvert(t *testing.T) {
+	m := raster.Translate(3, -2).Mul(raster.Rotate(0.7)).Mul(raster.Shear(0.2, 0)).Mul(raster.Scale(2, -0.5))
+	inv, ok := m.Invert()
+	if !ok {
+		t.Fatal("expected matrix to be invertible")
+	}
+	x, y := inv.Apply(m.Apply(5, 7))
+	if math.Abs(x-5) > 1e-9 || math.Abs(y-7) > 1e-9 {
+		t.Fatalf("expected (5, 7), got (%v, %v)", x, y)
+	}
+	if _, ok := raster.Scale(0, 1).Invert(); ok {
+		t.Fatal("expected zero scale to be singular")
+	}
+}
+
+func testTransform(src *image.NRGBA, m raster.Affine, expectedOff image.Point, expected func(x, y int) color.NRGBA) func(t *testing.T) {
+	return func(t *testing.T) {
+		for _, f := range raster.Filters {
+			actual, off, err := raster.Transform(src, m, f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if off != expectedOff {
+				t.Fatalf("%v: expected offset %v, got %v", f, expectedOff, off)
+			}
+			b := actual.Bounds()
+			for j := b.Min.Y; j < b.Max.Y; j++ {
+				for i := b.Min.X; i < b.Max.X; i++ {
+					if e, a := expected(i, j), actual.NRGBAAt(i, j); !reflect.DeepEqual(e, a) {
+						t.Fatalf("%v: pixel (%v, %v) expected %v, got %v", f, i, j, e, a)
+					}
+				}
+			}
+			deep, off, err := raster.TransformFloat(raster.FloatFrom(src), m, f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if off != expectedOff {
+				t.Fatalf("%v: expected float offset %v, got %v", f, expectedOff, off)
+			}
+			if a := deep.NRGBA(); !reflect.DeepEqual(a.Pix, actual.Pix) {
+				t.Fatalf("%v: expected float pixels %v, got %v", f, actual.Pix, a.Pix)
+			}
+		}
+	}
+}
+
+func TestTransform(t *testing.T) {
+	src := gradient(4, 3)
+	t.Run("identity", testTransform(src, raster.Identity(), image.Pt(0, 0),
+		func(x, y int) color.NRGBA { return src.NRGBAAt(x, y) },
+	))
+	t.Run("translate", testTransform(src, raster.Translate(-5, 2), image.Pt(-5, 2),
+		func(x, y int) color.NRGBA { return src.NRGBAAt(x, y) },
+	))
+	t.Run("flip horizontal", testTransform(src, raster.Scale(-1, 1), image.Pt(-4, 0),
+		func(x, y int) color.NRGBA { return src.NRGBAAt(3-x, y) },
+	))
+	t.Run("rotate clockwise", testTransform(src, raster.Rotate(math.Pi/2), image.Pt(-3, 0),
+		func(x, y int) color.NRGBA { return src.NRGBAAt(y, 2-x) },
+	))
+	t.Run("too large", func(t *testing.T) {
+		for _, m := range []raster.Affine{raster.Scale(10000, 10000), raster.Scale(20000, 1)} {
+			if _, _, err := raster.Transform(src, m, raster.Nearest); !errors.Is(err, raster.ErrTooLarge) {
+				t.Errorf("expected %v, got %v", raster.ErrTooLarge, err)
+			}
+			if _, _, err := raster.TransformFloat(raster.FloatFrom(src), m, raster.Nearest); !errors.Is(err, raster.ErrTooLarge) {
+				t.Errorf("float: expected %v, got %v", raster.ErrTooLarge, err)
+			}
+		}
+	})
+}
+
+func TestParseFilter(t *testing.T) {
+	for _, f := range raster.Filters {
+		actual, err := raster.ParseFilter(f.String())
+		if err != nil || actual != f {
+			t.Fatalf("expected %v, got %v (%v)", f, actual, err)
+		}
+	}
+	if _, err := raster.ParseFilter("lanczos"); err == nil {
+		t.Fatal("expected error for unknown filter")
+	}
+}
+
+func TestResize(t *testing.T) {
+	src := gradient(4, 4)
+	for _, f := range raster.Filters {
+		same := raster.Resize(src, 4, 4, f)
+		if !reflect.DeepEqual(src.Pix, same.Pix) {
+			t.Fatalf("%v: expected resize to the same size to be lossless", f)
+		}
+		up := raster.Resize(src, 8, 2, f)
+		if b := up.Bounds(); b.Dx() != 8 || b.Dy() != 2 {
+			t.Fatalf("%v: expected 8x2, got %v", f, b)
+		}
+		deep := raster.ResizeFloat(raster.FloatFrom(src), 8, 2, f).NRGBA()
+		if !reflect.DeepEqual(up.Pix, deep.Pix) {
+			t.Fatalf("%v: expected float resize to match, got %v and %v", f, up.Pix, deep.Pix)
+		}
+	}
+	// solid colors stay solid at any size
+	solid := image.NewNRGBA(image.Rect(0, 0, 5, 3))
+	for i := range solid.Pix {
+		solid.Pix[i] = 0x80
+	}
+	for _, f := range raster.Filters {
+		for _, p := range raster.Resize(solid, 2, 7, f).Pix {
+			if p != 0x80 {
+				t.Fatalf("%v: expected solid color, got %v", f, p)
+			}
+		}
+	}
+}
+
+func TestCrop(t *testing.T) {
+	src := gradient(4, 4)
+	actual := raster.Crop(src, image.Rect(2, 1, 6, 3))
+	for j := 0; j < 2; j++ {
+		for i := 0; i < 4; i++ {
+			var expected color.NRGBA
+			if i < 2 {
+				expected = src.NRGBAAt(i+2, j+1)
+			}
+			if a := actual.NRGBAAt(i, j); a != expected {
+				t.Fatalf("pixel (%v, %v) expected %v, got %v", i, j, expected, a)
+			}
+		}
+	}
+}
+
+func TestOpaqueBounds(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 6, 5))
+	if b := raster.OpaqueBounds(img); !b.Empty() {
+		t.Fatalf("expected empty bounds, got %v", b)
+	}
+	img.SetNRGBA(1, 3, color.NRGBA{A: 1})
+	img.SetNRGBA(4, 2, color.NRGBA{A: 1})
+	if b, expected := raster.OpaqueBounds(img), image.Rect(1, 2, 5, 4); b != expected {
+		t.Fatalf("expected %v, got %v", expected, b)
+	}
+}
+
+func TestParseHex(t *testing.T) {
+	c, err := raster.ParseHex("#10203f")
+	if err != nil || c != (color.NRGBA{R: 0x10, G: 0x20, B: 0x3F, A: 0xFF}) {
+		t.Fatalf("unexpected color %v (%v)", c, err)
+	}
+	if c, err = raster.ParseHex(raster.Hex(c)); err != nil || raster.Hex(c) != "10203fff" {
+		t.Fatalf("unexpected round trip %v (%v)", c, err)
+	}
+	if _, err = raster.ParseHex("12345"); err == nil {
+		t.Fatal("expected error for short color")
+	}
+}
+
+func TestReorient(t *testing.T) {
+	src := gradient(4, 3)
+	t.Run("rotate 90 four times", func(t *testing.T) {
+		img := src
+		for i := 0; i < 4; i++ {
+			img = raster.Reorient(img, raster.OrientRotate90)
+		}
+		if !reflect.DeepEqual(src.Pix, img.Pix) {
+			t.Fatal("expected four rotations to be lossless")
+		}
+	})
+	t.Run("rotate 90 then 270", func(t *testing.T) {
+		img := raster.Reorient(raster.Reorient(src, raster.OrientRotate90), raster.OrientRotate270)
+		if !reflect.DeepEqual(src.Pix, img.Pix) {
+			t.Fatal("expected opposite rotations to cancel")
+		}
+	})
+	t.Run("matches transform", func(t *testing.T) {
+		actual := raster.Reorient(src, raster.OrientRotate90)
+		expected, _, err := raster.Transform(src, raster.Rotate(math.Pi/2), raster.Nearest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expected.Pix, actual.Pix) {
+			t.Fatal("expected rotation to match the affine transform")
+		}
+	})
+	t.Run("16 bit", func(t *testing.T) {
+		// neighbouring samples share their 8-bit value, so only moving the
+		// precise pixels keeps them in the right place
+		deep := raster.NewFloat(image.Rect(0, 0, 4, 3))
+		for i := range deep.Pix {
+			deep.Pix[i] = float32(i) / 0xFFFF
+		}
+		for _, o := range []raster.Orientation{raster.OrientRotate90, raster.OrientFlipH} {
+			out := raster.ReorientFloat(deep, o)
+			out.Quantize(raster.Depth16)
+			for y := 0; y < 3; y++ {
+				for x := 0; x < 4; x++ {
+					r := o.MapRect(image.Rect(x, y, x+1, y+1), 4, 3)
+					s, d := deep.PixOffset(x, y), out.PixOffset(r.Min.X, r.Min.Y)
+					if !reflect.DeepEqual(deep.Pix[s:s+4], out.Pix[d:d+4]) {
+						t.Fatalf("%v: expected %v at %v, got %v", o, deep.Pix[s:s+4], r.Min, out.Pix[d:d+4])
+					}
+				}
+			}
+		}
+	})
+	for o := raster.OrientNormal; o <= raster.OrientRotate270; o++ {
+		// a single opaque pixel must land where MapRect says it does
+		img := image.NewNRGBA(image.Rect(0, 0, 4, 3))
+		img.SetNRGBA(1, 0, color.NRGBA{A: 0xFF})
+		r := o.MapRect(image.Rect(1, 0, 2, 1), 4, 3)
+		if b := raster.OpaqueBounds(raster.Reorient(img, o)); b != r {
+			t.Fatalf("%v: expected pixel at %v, got %v", o, r, b)
+		}
+	}
+}
+
+func TestConvolve(t *testing.T) {
+	src := gradient(5, 4)
+	identity, err := raster.ParseKernel("0 0 0; 0 1 0; 0 0 0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range raster.EdgeModes {
+		identity.Edge = e
+		actual, err := raster.Convolve(src, identity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(src.Pix, actual.Pix) {
+			t.Fatalf("%v: expected identity kernel to keep pixels", e)
+		}
+	}
+
+	// shifting right by one reads past the left edge for the first column
+	shift, err := raster.ParseKernel("0 0 0, 1 0 0, 0 0 0")
+	if err == nil {
+		t.Fatal("expected one row of nine values to be rejected")
+	}
+	if shift, err = raster.ParseKernel("0 0 0; 0 0 1; 0 0 0"); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[raster.EdgeMode]color.NRGBA{
+		raster.EdgeClamp:       src.NRGBAAt(4, 0),
+		raster.EdgeWrap:        src.NRGBAAt(0, 0),
+		raster.EdgeTransparent: {A: 0xFF},
+	}
+	for e, c := range expected {
+		shift.Edge = e
+		actual, err := raster.Convolve(src, shift)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a := actual.NRGBAAt(4, 0); a != c {
+			t.Fatalf("%v: expected %v, got %v", e, c, a)
+		}
+	}
+
+	if _, err = raster.ParseKernel("1 1; 1 1"); err == nil {
+		t.Fatal("expected even kernel size to be rejected")
+	}
+}
+
+func TestStylize(t *testing.T) {
+	src := gradient(6, 4)
+	if !reflect.DeepEqual(raster.Invert(raster.Invert(src)).Pix, src.Pix) {
+		t.Fatal("expected inverting twice to keep pixels")
+	}
+	gray := raster.Desaturate(src)
+	for i := 0; i < len(gray.Pix); i += 4 {
+		if p := gray.Pix[i : i+4]; p[0] != p[1] || p[1] != p[2] || p[3] != src.Pix[i+3] {
+			t.Fatalf("expected gray pixel with alpha %v, got %v", src.Pix[i+3], p)
+		}
+	}
+	bw := raster.Threshold(src, 128)
+	for i := 0; i < len(bw.Pix); i += 4 {
+		if v := bw.Pix[i]; v != 0 && v != 0xFF {
+			t.Fatalf("expected black or white, got %v", v)
+		}
+	}
+	poster, err := raster.Posterize(src, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(poster.Pix); i += 4 {
+		if v := poster.Pix[i]; v != 0 && v != 0xFF {
+			t.Fatalf("expected two levels, got %v", v)
+		}
+	}
+	if _, err = raster.Posterize(src, 1); !errors.Is(err, raster.ErrInvalidParameter) {
+		t.Fatalf("expected %v, got %v", raster.ErrInvalidParameter, err)
+	}
+	mosaic, err := raster.Pixelate(src, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, b := mosaic.NRGBAAt(0, 0), mosaic.NRGBAAt(3, 3); a != b {
+		t.Fatalf("expected one color per cell, got %v and %v", a, b)
+	}
+	if a, b := mosaic.NRGBAAt(3, 0), mosaic.NRGBAAt(4, 0); a == b {
+		t.Fatalf("expected neighbouring cells to differ, got %v", a)
+	}
+
+	speck := image.NewNRGBA(image.Rect(0, 0, 3, 3))
+	speck.SetNRGBA(1, 1, color.NRGBA{R: 0xFF, A: 0xFF})
+	clean, err := raster.Median(speck, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := clean.NRGBAAt(1, 1); c != (color.NRGBA{}) {
+		t.Fatalf("expected speck to be removed, got %v", c)
+	}
+	for _, o := range raster.EdgeOperators {
+		if c := raster.DetectEdges(speck, o).NRGBAAt(0, 0); c.R == 0 {
+			t.Fatalf("%v: expected an edge next to the speck", o)
+		}
+	}
+
+	// float filters agree with their 8-bit counterparts up to rounding
+	f := raster.FloatFrom(src)
+	floatPoster, err := raster.PosterizeFloat(f, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	floatMosaic, err := raster.PixelateFloat(f, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	floatClean, err := raster.MedianFloat(raster.FloatFrom(speck), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, pair := range map[string][2]*image.NRGBA{
+		"invert":     {raster.Invert(src), raster.InvertFloat(f).NRGBA()},
+		"desaturate": {gray, raster.DesaturateFloat(f).NRGBA()},
+		"threshold":  {bw, raster.ThresholdFloat(f, 128).NRGBA()},
+		"posterize":  {poster, floatPoster.NRGBA()},
+		"pixelate":   {mosaic, floatMosaic.NRGBA()},
+		"emboss":     {raster.Emboss(src), raster.EmbossFloat(f).NRGBA()},
+		"median":     {clean, floatClean.NRGBA()},
+		"edges":      {raster.DetectEdges(speck, raster.Sobel), raster.DetectEdgesFloat(raster.FloatFrom(speck), raster.Sobel).NRGBA()},
+	} {
+		for i := range pair[0].Pix {
+			if d := int(pair[0].Pix[i]) - int(pair[1].Pix[i]); d < -1 || d > 1 {
+				t.Fatalf("%v: expected %v, got %v", name, pair[0].Pix, pair[1].Pix)
+			}
+		}
+	}
+	if _, err = raster.PixelateFloat(f, 0); !errors.Is(err, raster.ErrInvalidParameter) {
+		t.Fatalf("expected %v, got %v", raster.ErrInvalidParameter, err)
+	}
+}
+
+func TestOctree(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 16), G: uint8(y * 16), B: 0x80, A: 0xFF})
+		}
+	}
+	for _, n := range []int{1, 2, 7, 16, 256, 300} {
+		p := raster.Octree(img, n)
+		if len(p) == 0 || len(p) > n {
+			t.Fatalf("expected 1 to %v colors, got %v", n, len(p))
+		}
+		if n >= 256 && len(p) != 256 {
+			t.Fatalf("expected every color kept, got %v", len(p))
+		}
+	}
+	if p := raster.Octree(image.NewNRGBA(image.Rect(0, 0, 2, 2)), 4); len(p) != 0 {
+		t.Fatalf("expected no colors for a transparent image, got %v", len(p))
+	}
+}
+
+func TestFloat(t *testing.T) {
+	src := gradient(4, 3)
+	f := raster.FloatFrom(src)
+	if actual := f.NRGBA(); !reflect.DeepEqual(actual.Pix, src.Pix) {
+		t.Fatalf("expected %v after round trip, got %v", src.Pix, actual.Pix)
+	}
+
+	// 16 bit steps survive quantization at 16 bits but not at 8
+	fine := raster.NewFloat(image.Rect(0, 0, 1, 1))
+	fine.Pix[0] = 1000.0 / 0xffff
+	fine.Quantize(raster.Depth16)
+	if v := fine.NRGBA64().Pix[:2]; v[0] != 1000>>8 || v[1] != 1000&0xff {
+		t.Fatalf("expected 16 bit sample 1000, got %v", v)
+	}
+	fine.Quantize(raster.Depth8)
+	if fine.Pix[0] != 4.0/255 {
+		t.Fatalf("expected 8 bit sample 4/255, got %v", fine.Pix[0])
+	}
+
+	// pixels an 8 bit operation leaves alone keep their precision
+	deep := raster.FloatFrom(src)
+	deep.Pix[0] += 0.5 / 0xffff
+	after := raster.FloatFrom(src).NRGBA()
+	after.Pix[4] = 0
+	rec := raster.Reconcile(deep, src, after)
+	if rec.Pix[0] != deep.Pix[0] || rec.Pix[4] != 0 {
+		t.Fatalf("expected untouched pixel kept and changed one taken, got %v and %v", rec.Pix[0], rec.Pix[4])
+	}
+
+	// half transparent white over black is lighter in linear light
+	for _, linear := range []bool{false, true} {
+		dst := raster.NewFloat(image.Rect(0, 0, 1, 1))
+		copy(dst.Pix, []float32{0, 0, 0, 1})
+		white := raster.NewFloat(image.Rect(0, 0, 1, 1))
+		copy(white.Pix, []float32{1, 1, 1, 1})
+		raster.Over(dst, white, image.Point{}, 0.5, linear)
+		expected := float32(0.5)
+		if linear {
+			expected = raster.FromLinear(0.5)
+		}
+		if math.Abs(float64(dst.Pix[0]-expected)) > 1e-6 || dst.Pix[3] != 1 {
+			t.Fatalf("linear %v: expected %v, got %v", linear, expected, dst.Pix[:4])
+		}
+	}
+
+	for _, d := range raster.Depths {
+		if actual, err := raster.ParseDepth(d.String()); err != nil || actual != d {
+			t.Fatalf("expected %v from parsing its name, got %v, %v", d, actual, err)
+		}
+	}
+	if _, err := raster.ParseDepth("12"); !errors.Is(err, raster.ErrUnknownDepth) {
+		t.Fatalf("expected %v, got %v", raster.ErrUnknownDepth, err)
+	}
+}
+
+func TestGrid(t *testing.T) {
+	size := image.Pt(38, 20)
+	bySize, err := raster.GridBySize(size, 10, 8, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byCount, err := raster.GridByCount(size, 3, 2, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := raster.Grid{CellW: 10, CellH: 8, Cols: 3, Rows: 2, Margin: 1, Spacing: 2}
+	if bySize != expected || byCount != expected {
+		t.Fatalf("expected %+v, got %+v by size and %+v by count", expected, bySize, byCount)
+	}
+	cells := expected.Cells()
+	if len(cells) != 6 || cells[1] != image.Rect(13, 1, 23, 9) || cells[5] != image.Rect(25, 11, 35, 19) {
+		t.Fatalf("unexpected cells %v", cells)
+	}
+	if _, err = raster.GridBySize(size, 40, 8, 0, 0); !errors.Is(err, raster.ErrInvalidGrid) {
+		t.Fatalf("expected %v, got %v", raster.ErrInvalidGrid, err)
+	}
+	if _, err = raster.GridByCount(size, 3, 2, -1, 0); !errors.Is(err, raster.ErrInvalidGrid) {
+		t.Fatalf("expected %v, got %v", raster.ErrInvalidGrid, err)
+	}
+}
+
+func TestPack(t *testing.T) {
+	sizes := []image.Point{{4, 2}, {3, 5}, {6, 1}, {2, 2}, {1, 7}}
+	rects, sheet := raster.Pack(sizes, 1)
+	for i, r := range rects {
+		if r.Size() != sizes[i] {
+			t.Fatalf("rect %v has size %v, expected %v", i, r.Size(), sizes[i])
+		}
+		if !r.In(image.Rectangle{Max: sheet}) {
+			t.Fatalf("rect %v at %v is outside the %v sheet", i, r, sheet)
+		}
+		// each rect grown by the padding must not reach another
+		padded := func(r image.Rectangle) image.Rectangle {
+			return image.Rectangle{Min: r.Min, Max: r.Max.Add(image.Pt(1, 1))}
+		}
+		for j := 0; j < i; j++ {
+			if padded(r).Overlaps(rects[j]) || padded(rects[j]).Overlaps(r) {
+				t.Fatalf("rects %v and %v are closer than the padding", r, rects[j])
+			}
+		}
+	}
+}
+
+func TestKMeans(t *testing.T) {
+	// two clusters of nearby colors
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 2))
+	for x := 0; x < 8; x++ {
+		img.SetNRGBA(x, 0, color.NRGBA{R: uint8(200 + x), G: 10, B: 10, A: 0xFF})
+		img.SetNRGBA(x, 1, color.NRGBA{R: 10, G: 10, B: uint8(200 + x), A: 0xFF})
+	}
+	p := raster.KMeans(img, 2, 10)
+	if len(p) != 2 {
+		t.Fatalf("expected 2 colors, got %v", len(p))
+	}
+	for _, c := range p {
+		nc := c.(color.NRGBA)
+		if !(nc.R > 190 && nc.B < 20) && !(nc.B > 190 && nc.R < 20) {
+			t.Fatalf("expected each color at a cluster, got %v", p)
+		}
+	}
+}
+
+func TestOrderedDither(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = 0x80
+	}
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xFF
+	}
+	p := color.Palette{color.NRGBA{A: 0xFF}, color.NRGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF}}
+	counts := [2]int{}
+	for _, i := range raster.ToPalettedDither(img, p, raster.DitherOrdered).Pix {
+		counts[i]++
+	}
+	if counts[0] < 24 || counts[1] < 24 {
+		t.Fatalf("expected gray dithered to about half black and white, got %v", counts)
+	}
+	if pix := raster.ToPalettedDither(img, p, raster.DitherNone).Pix; !bytes.Equal(pix, make([]uint8, len(pix))) && !bytes.Equal(pix, bytes.Repeat([]uint8{1}, len(pix))) {
+		t.Fatalf("expected a solid color without dithering")
+	}
+	for _, d := range raster.Dithers {
+		if actual, err := raster.ParseDither(d.String()); err != nil || actual != d {
+			t.Fatalf("expected %v from parsing its name, got %v, %v", d, actual, err)
+		}
+	}
+}
@@ -0,0 +1,413 @@
+package raster
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+)
+
+// Filter selects the interpolation used when resampling pixels.
+type Filter int
+
+// Constants for all supported resampling filters.
+const (
+	Nearest Filter = iota
+	Bilinear
+	Bicubic
+)
+
+// Filters lists every supported resampling filter, in order of quality.
+var Filters = []Filter{Nearest, Bilinear, Bicubic}
+
+func (f Filter) String() string {
+	switch f {
+	case Nearest:
+		return "nearest"
+	case Bilinear:
+		return "bilinear"
+	case Bicubic:
+		return "bicubic"
+	}
+	return fmt.Sprintf("Filter(%d)", int(f))
+}
+
+// ErrUnknownFilter indicates that a resampling filter name was not recognized
+const ErrUnknownFilter log.ConstErr = "unknown resampling filter"
+
+// ParseFilter returns the filter with the given case-insensitive name.
+func ParseFilter(name string) (Filter, error) {
+	for _, f := range Filters {
+		if strings.EqualFold(name, f.String()) {
+			return f, nil
+		}
+	}
+	return Nearest, fmt.Errorf("%w: %v", ErrUnknownFilter, name)
+}
+
+// ErrSingular indicates that a transformation collapses the image to nothing
+const ErrSingular log.ConstErr = "transformation is not invertible"
+
+// ErrTooLarge indicates that an image would exceed MaxDimension or MaxPixels
+const ErrTooLarge log.ConstErr = "image is too large"
+
+// Limits on the size of images created by editing.
+const (
+	MaxDimension = 1 << 16
+	MaxPixels    = 1 << 28
+)
+
+// CheckSize returns ErrTooLarge if a w by h image exceeds the limits on the
+// size of edited images.
+func CheckSize(w, h int) error {
+	if w > MaxDimension || h > MaxDimension || w*h > MaxPixels {
+		return fmt.Errorf("%w: %vx%v", ErrTooLarge, w, h)
+	}
+	return nil
+}
+
+// Transform resamples src through m. The returned image is the smallest one
+// containing every transformed pixel, and the returned point is the position
+// of its top-left corner in the destination space.
+func Transform(src *image.NRGBA, m Affine, f Filter) (*image.NRGBA, image.Point, error) {
+	inv, r, err := transformBounds(src.Bounds(), m)
+	if err != nil {
+		return nil, image.Point{}, err
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	s := sampler{filter: f, w: src.Rect.Dx(), h: src.Rect.Dy(), at: func(x, y int) [4]float64 {
+		p := src.Pix[src.PixOffset(src.Rect.Min.X+x, src.Rect.Min.Y+y):]
+		return [4]float64{float64(p[0]), float64(p[1]), float64(p[2]), float64(p[3])}
+	}}
+	for j := 0; j < dst.Rect.Dy(); j++ {
+		for i := 0; i < dst.Rect.Dx(); i++ {
+			// sample at the pixel center, then shift back to source pixel space
+			u, v := inv.Apply(float64(r.Min.X+i)+0.5, float64(r.Min.Y+j)+0.5)
+			writePremul(s.sample(u-0.5, v-0.5), dst.Pix[dst.PixOffset(i, j):])
+		}
+	}
+	return dst, r.Min, nil
+}
+
+// TransformFloat is Transform for float images.
+func TransformFloat(src *Float, m Affine, f Filter) (*Float, image.Point, error) {
+	inv, r, err := transformBounds(src.Bounds(), m)
+	if err != nil {
+		return nil, image.Point{}, err
+	}
+	dst := NewFloat(image.Rect(0, 0, r.Dx(), r.Dy()))
+	s := sampler{filter: f, w: src.Rect.Dx(), h: src.Rect.Dy(), at: func(x, y int) [4]float64 {
+		p := src.Pix[src.PixOffset(src.Rect.Min.X+x, src.Rect.Min.Y+y):]
+		return [4]float64{float64(p[0]), float64(p[1]), float64(p[2]), float64(clampUnit(p[3]))}
+	}}
+	for j := 0; j < dst.Rect.Dy(); j++ {
+		for i := 0; i < dst.Rect.Dx(); i++ {
+			u, v := inv.Apply(float64(r.Min.X+i)+0.5, float64(r.Min.Y+j)+0.5)
+			acc := s.sample(u-0.5, v-0.5)
+			if acc[3] <= 0 {
+				continue
+			}
+			d := dst.Pix[dst.PixOffset(i, j):]
+			for c := 0; c < 3; c++ {
+				d[c] = clampUnit(float32(acc[c] / acc[3]))
+			}
+			d[3] = clampUnit(float32(acc[3]))
+		}
+	}
+	return dst, r.Min, nil
+}
+
+// transformBounds returns the inverse of m and the smallest rectangle of
+// whole pixels containing sb transformed by m
+func transformBounds(sb image.Rectangle, m Affine) (Affine, image.Rectangle, error) {
+	inv, ok := m.Invert()
+	if !ok {
+		return Affine{}, image.Rectangle{}, ErrSingular
+	}
+	w, h := float64(sb.Dx()), float64(sb.Dy())
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, c := range [][2]float64{{0, 0}, {w, 0}, {0, h}, {w, h}} {
+		x, y := m.Apply(c[0], c[1])
+		minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+		minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+	}
+	if maxX-minX > MaxDimension || maxY-minY > MaxDimension {
+		return Affine{}, image.Rectangle{}, fmt.Errorf("%w: %.0fx%.0f", ErrTooLarge, maxX-minX, maxY-minY)
+	}
+	// round away tiny floating point error before snapping to whole pixels
+	const eps = 1e-6
+	off := image.Pt(int(math.Floor(minX+eps)), int(math.Floor(minY+eps)))
+	end := image.Pt(int(math.Ceil(maxX-eps)), int(math.Ceil(maxY-eps)))
+	if end.X <= off.X {
+		end.X = off.X + 1
+	}
+	if end.Y <= off.Y {
+		end.Y = off.Y + 1
+	}
+	if err := CheckSize(end.X-off.X, end.Y-off.Y); err != nil {
+		return Affine{}, image.Rectangle{}, err
+	}
+	return inv, image.Rectangle{Min: off, Max: end}, nil
+}
+
+// sampler reads interpolated colors from a w by h image, treating anything
+// outside of its bounds as transparent.
+type sampler struct {
+	filter Filter
+	w, h   int
+	// at returns the channels of the pixel at (x, y) counted from the
+	// top-left corner of the image
+	at func(x, y int) [4]float64
+}
+
+// sample returns the premultiplied color at source pixel coordinates (x, y),
+// where integer coordinates are pixel centers.
+func (s sampler) sample(x, y float64) [4]float64 {
+	var acc [4]float64
+	switch s.filter {
+	case Bilinear:
+		x0, y0 := math.Floor(x), math.Floor(y)
+		fx, fy := x-x0, y-y0
+		ix, iy := int(x0), int(y0)
+		s.add(&acc, ix, iy, (1-fx)*(1-fy))
+		s.add(&acc, ix+1, iy, fx*(1-fy))
+		s.add(&acc, ix, iy+1, (1-fx)*fy)
+		s.add(&acc, ix+1, iy+1, fx*fy)
+	case Bicubic:
+		x0, y0 := math.Floor(x), math.Floor(y)
+		fx, fy := x-x0, y-y0
+		ix, iy := int(x0), int(y0)
+		for n := -1; n <= 2; n++ {
+			wy := cubic(float64(n) - fy)
+			for m := -1; m <= 2; m++ {
+				s.add(&acc, ix+m, iy+n, cubic(float64(m)-fx)*wy)
+			}
+		}
+	default:
+		s.add(&acc, int(math.Floor(x+0.5)), int(math.Floor(y+0.5)), 1)
+	}
+	return acc
+}
+
+// add accumulates the premultiplied color of pixel (x, y) scaled by weight.
+func (s sampler) add(acc *[4]float64, x, y int, weight float64) {
+	if weight == 0 || x < 0 || y < 0 || x >= s.w || y >= s.h {
+		return
+	}
+	p := s.at(x, y)
+	a := p[3] * weight
+	acc[0] += p[0] * a
+	acc[1] += p[1] * a
+	acc[2] += p[2] * a
+	acc[3] += a
+}
+
+// writePremul converts an accumulated premultiplied color back to
+// non-premultiplied bytes.
+func writePremul(acc [4]float64, out []byte) {
+	a := acc[3]
+	if a <= 0 {
+		out[0], out[1], out[2], out[3] = 0, 0, 0, 0
+		return
+	}
+	if a > 255 {
+		a = 255
+	}
+	out[0] = clamp(acc[0] / acc[3])
+	out[1] = clamp(acc[1] / acc[3])
+	out[2] = clamp(acc[2] / acc[3])
+	out[3] = clamp(a)
+}
+
+// cubic is the Catmull-Rom interpolation kernel.
+func cubic(x float64) float64 {
+	x = math.Abs(x)
+	switch {
+	case x < 1:
+		return 1.5*x*x*x - 2.5*x*x + 1
+	case x < 2:
+		return -0.5*x*x*x + 2.5*x*x - 4*x + 2
+	}
+	return 0
+}
+
+func clamp(v float64) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 255 {
+		return 255
+	}
+	return uint8(v + 0.5)
+}
+
+// Resize resamples src to w by h pixels. When shrinking, the filter is
+// widened so that every source pixel contributes to the result.
+func Resize(src *image.NRGBA, w, h int, f Filter) *image.NRGBA {
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	if w <= 0 || h <= 0 || sw <= 0 || sh <= 0 {
+		return dst
+	}
+
+	// premultiply once so both passes can blend colors directly
+	in := make([]float64, sw*sh*4)
+	for j := 0; j < sh; j++ {
+		for i := 0; i < sw; i++ {
+			p := src.Pix[src.PixOffset(src.Rect.Min.X+i, src.Rect.Min.Y+j):]
+			a := float64(p[3])
+			k := (j*sw + i) * 4
+			in[k], in[k+1], in[k+2], in[k+3] = float64(p[0])*a, float64(p[1])*a, float64(p[2])*a, a
+		}
+	}
+	out := resizePremul(in, sw, sh, w, h, f)
+	for j := 0; j < h; j++ {
+		for i := 0; i < w; i++ {
+			k := (j*w + i) * 4
+			writePremul([4]float64{out[k], out[k+1], out[k+2], out[k+3]}, dst.Pix[dst.PixOffset(i, j):])
+		}
+	}
+	return dst
+}
+
+// ResizeFloat is Resize for pixels of more than 8 bits per channel.
+func ResizeFloat(src *Float, w, h int, f Filter) *Float {
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	dst := NewFloat(image.Rect(0, 0, w, h))
+	if w <= 0 || h <= 0 || sw <= 0 || sh <= 0 {
+		return dst
+	}
+
+	in := make([]float64, sw*sh*4)
+	for j := 0; j < sh; j++ {
+		for i := 0; i < sw; i++ {
+			p := src.Pix[src.PixOffset(src.Rect.Min.X+i, src.Rect.Min.Y+j):]
+			a := float64(p[3])
+			k := (j*sw + i) * 4
+			in[k], in[k+1], in[k+2], in[k+3] = float64(p[0])*a, float64(p[1])*a, float64(p[2])*a, a
+		}
+	}
+	out := resizePremul(in, sw, sh, w, h, f)
+	for j := 0; j < h; j++ {
+		for i := 0; i < w; i++ {
+			k := (j*w + i) * 4
+			d := dst.Pix[dst.PixOffset(i, j):]
+			a := out[k+3]
+			if a <= 0 {
+				continue
+			}
+			for c := 0; c < 3; c++ {
+				d[c] = clampUnit(float32(out[k+c] / a))
+			}
+			d[3] = clampUnit(float32(a))
+		}
+	}
+	return dst
+}
+
+// resizePremul resamples sw by sh premultiplied pixels to w by h in a
+// horizontal and a vertical pass
+func resizePremul(in []float64, sw, sh, w, h int, f Filter) []float64 {
+	// horizontal pass: sw by sh to w by sh
+	xw := resizeWeights(sw, w, f)
+	tmp := make([]float64, w*sh*4)
+	for j := 0; j < sh; j++ {
+		for i, ws := range xw {
+			k := (j*w + i) * 4
+			for _, t := range ws {
+				s := (j*sw + t.index) * 4
+				tmp[k] += in[s] * t.weight
+				tmp[k+1] += in[s+1] * t.weight
+				tmp[k+2] += in[s+2] * t.weight
+				tmp[k+3] += in[s+3] * t.weight
+			}
+		}
+	}
+
+	// vertical pass: w by sh to w by h
+	yw := resizeWeights(sh, h, f)
+	out := make([]float64, w*h*4)
+	for j, ws := range yw {
+		for i := 0; i < w; i++ {
+			k := (j*w + i) * 4
+			for _, t := range ws {
+				s := (t.index*w + i) * 4
+				out[k] += tmp[s] * t.weight
+				out[k+1] += tmp[s+1] * t.weight
+				out[k+2] += tmp[s+2] * t.weight
+				out[k+3] += tmp[s+3] * t.weight
+			}
+		}
+	}
+	return out
+}
+
+// tap is the contribution of one source pixel to a destination pixel
+type tap struct {
+	index  int
+	weight float64
+}
+
+// resizeWeights returns the normalized source taps for each of the dst pixels
+// when resampling a row of src pixels. Taps past the edges are clamped.
+func resizeWeights(src, dst int, f Filter) [][]tap {
+	scale := float64(src) / float64(dst)
+	weights := make([][]tap, dst)
+	if f == Nearest {
+		for i := range weights {
+			s := int((float64(i) + 0.5) * scale)
+			if s >= src {
+				s = src - 1
+			}
+			weights[i] = []tap{{index: s, weight: 1}}
+		}
+		return weights
+	}
+
+	kernel, radius := triangle, 1.0
+	if f == Bicubic {
+		kernel, radius = cubic, 2.0
+	}
+	// widen the kernel when shrinking to avoid aliasing
+	stretch := math.Max(scale, 1)
+	radius *= stretch
+	for i := range weights {
+		center := (float64(i)+0.5)*scale - 0.5
+		lo, hi := int(math.Ceil(center-radius)), int(math.Floor(center+radius))
+		var total float64
+		taps := make([]tap, 0, hi-lo+1)
+		for s := lo; s <= hi; s++ {
+			wt := kernel((float64(s) - center) / stretch)
+			if wt == 0 {
+				continue
+			}
+			idx := s
+			if idx < 0 {
+				idx = 0
+			} else if idx >= src {
+				idx = src - 1
+			}
+			taps = append(taps, tap{index: idx, weight: wt})
+			total += wt
+		}
+		if total != 0 {
+			for k := range taps {
+				taps[k].weight /= total
+			}
+		}
+		weights[i] = taps
+	}
+	return weights
+}
+
+// triangle is the linear interpolation kernel.
+func triangle(x float64) float64 {
+	x = math.Abs(x)
+	if x < 1 {
+		return 1 - x
+	}
+	return 0
+}
@@ -0,0 +1,120 @@
+package raster
+
+import (
+	"fmt"
+	"image"
+	"sort"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+)
+
+// ErrInvalidGrid indicates that a tile grid does not fit its image
+const ErrInvalidGrid log.ConstErr = "invalid tile grid"
+
+// Grid divides an image into equally sized tiles. Margin is left around the
+// outside of the tiles and Spacing between neighboring ones.
+type Grid struct {
+	CellW, CellH    int
+	Cols, Rows      int
+	Margin, Spacing int
+}
+
+// GridBySize returns the grid of as many w by h cells as fit an image of the
+// given size.
+func GridBySize(size image.Point, w, h, margin, spacing int) (Grid, error) {
+	g := Grid{CellW: w, CellH: h, Margin: margin, Spacing: spacing}
+	if w > 0 && h > 0 {
+		g.Cols = (size.X - 2*margin + spacing) / (w + spacing)
+		g.Rows = (size.Y - 2*margin + spacing) / (h + spacing)
+	}
+	return g, g.validate(size)
+}
+
+// GridByCount returns the grid of cols by rows cells as large as fit an image
+// of the given size.
+func GridByCount(size image.Point, cols, rows, margin, spacing int) (Grid, error) {
+	g := Grid{Cols: cols, Rows: rows, Margin: margin, Spacing: spacing}
+	if cols > 0 && rows > 0 {
+		g.CellW = (size.X - 2*margin - (cols-1)*spacing) / cols
+		g.CellH = (size.Y - 2*margin - (rows-1)*spacing) / rows
+	}
+	return g, g.validate(size)
+}
+
+// validate checks that the grid has cells and fits an image of the size
+func (g Grid) validate(size image.Point) error {
+	if g.Margin < 0 || g.Spacing < 0 {
+		return fmt.Errorf("%w: margin and spacing must not be negative", ErrInvalidGrid)
+	}
+	if g.CellW <= 0 || g.CellH <= 0 || g.Cols <= 0 || g.Rows <= 0 {
+		return fmt.Errorf("%w: no %vx%v cells fit a %vx%v image", ErrInvalidGrid, g.CellW, g.CellH, size.X, size.Y)
+	}
+	used := image.Pt(2*g.Margin+g.Cols*g.CellW+(g.Cols-1)*g.Spacing, 2*g.Margin+g.Rows*g.CellH+(g.Rows-1)*g.Spacing)
+	if used.X > size.X || used.Y > size.Y {
+		return fmt.Errorf("%w: %vx%v cells do not fit a %vx%v image", ErrInvalidGrid, g.Cols, g.Rows, size.X, size.Y)
+	}
+	return nil
+}
+
+// Cell returns the bounds of the cell in column col and row row.
+func (g Grid) Cell(col, row int) image.Rectangle {
+	x := g.Margin + col*(g.CellW+g.Spacing)
+	y := g.Margin + row*(g.CellH+g.Spacing)
+	return image.Rect(x, y, x+g.CellW, y+g.CellH)
+}
+
+// Cells returns the bounds of every cell, row by row.
+func (g Grid) Cells() []image.Rectangle {
+	cells := make([]image.Rectangle, 0, g.Cols*g.Rows)
+	for row := 0; row < g.Rows; row++ {
+		for col := 0; col < g.Cols; col++ {
+			cells = append(cells, g.Cell(col, row))
+		}
+	}
+	return cells
+}
+
+// Pack places rectangles of the given sizes on a sheet without overlap, with
+// padding between them, and returns their bounds in the order given along
+// with the size of the sheet. Rows are filled tallest first and the sheet is
+// kept close to square.
+func Pack(sizes []image.Point, padding int) ([]image.Rectangle, image.Point) {
+	area, widest := 0, 0
+	order := make([]int, len(sizes))
+	for i, s := range sizes {
+		order[i] = i
+		area += (s.X + padding) * (s.Y + padding)
+		if s.X > widest {
+			widest = s.X
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return sizes[order[a]].Y > sizes[order[b]].Y
+	})
+	width := widest
+	for width*width < area {
+		width++
+	}
+
+	rects := make([]image.Rectangle, len(sizes))
+	var sheet image.Point
+	x, y, rowH := 0, 0, 0
+	for _, i := range order {
+		s := sizes[i]
+		if x > 0 && x+s.X > width {
+			x, y, rowH = 0, y+rowH+padding, 0
+		}
+		rects[i] = image.Rectangle{Min: image.Pt(x, y), Max: image.Pt(x+s.X, y+s.Y)}
+		if rects[i].Max.X > sheet.X {
+			sheet.X = rects[i].Max.X
+		}
+		if rects[i].Max.Y > sheet.Y {
+			sheet.Y = rects[i].Max.Y
+		}
+		x += s.X + padding
+		if s.Y > rowH {
+			rowH = s.Y
+		}
+	}
+	return rects, sheet
+}
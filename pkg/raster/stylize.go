@@ -0,0 +1,463 @@
+package raster
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+)
+
+// Noise is a distribution of random values added to pixels.
+type Noise int
+
+// Constants for all noise distributions.
+const (
+	NoiseUniform Noise = iota
+	NoiseGaussian
+)
+
+// Noises lists every noise distribution.
+var Noises = []Noise{NoiseUniform, NoiseGaussian}
+
+func (n Noise) String() string {
+	switch n {
+	case NoiseUniform:
+		return "uniform"
+	case NoiseGaussian:
+		return "gaussian"
+	}
+	return fmt.Sprintf("Noise(%d)", int(n))
+}
+
+// ErrUnknownNoise indicates that a noise distribution name was not recognized
+const ErrUnknownNoise log.ConstErr = "unknown noise distribution"
+
+// ParseNoise returns the noise distribution with the given case-insensitive
+// name.
+func ParseNoise(name string) (Noise, error) {
+	for _, n := range Noises {
+		if strings.EqualFold(name, n.String()) {
+			return n, nil
+		}
+	}
+	return NoiseUniform, fmt.Errorf("%w: %v", ErrUnknownNoise, name)
+}
+
+// ErrInvalidParameter indicates that a filter parameter is out of range
+const ErrInvalidParameter log.ConstErr = "invalid filter parameter"
+
+// AddNoise returns a copy of src with random values added to the color
+// channels. For uniform noise amount is the largest change, for gaussian
+// noise it is the standard deviation. Mono noise changes all channels of a
+// pixel by the same value.
+func AddNoise(src *image.NRGBA, n Noise, amount float64, mono bool, rng *rand.Rand) *image.NRGBA {
+	sample := func() float64 { return (rng.Float64()*2 - 1) * amount }
+	if n == NoiseGaussian {
+		sample = func() float64 { return rng.NormFloat64() * amount }
+	}
+	return mapPixels(src, func(p []uint8) {
+		v := sample()
+		for c := 0; c < 3; c++ {
+			if !mono && c > 0 {
+				v = sample()
+			}
+			p[c] = clamp(float64(p[c]) + v)
+		}
+	})
+}
+
+// AddNoiseFloat is AddNoise for float images. The amount is in 8-bit units
+// like for AddNoise.
+func AddNoiseFloat(src *Float, n Noise, amount float64, mono bool, rng *rand.Rand) *Float {
+	sample := func() float32 { return float32((rng.Float64()*2 - 1) * amount / 255) }
+	if n == NoiseGaussian {
+		sample = func() float32 { return float32(rng.NormFloat64() * amount / 255) }
+	}
+	return mapFloat(src, func(p []float32) {
+		v := sample()
+		for c := 0; c < 3; c++ {
+			if !mono && c > 0 {
+				v = sample()
+			}
+			p[c] = clampUnit(p[c] + v)
+		}
+	})
+}
+
+// Pixelate returns a copy of src made of cell by cell squares, each filled
+// with the average color of the pixels it covers.
+func Pixelate(src *image.NRGBA, cell int) (*image.NRGBA, error) {
+	if cell < 1 {
+		return nil, fmt.Errorf("%w: cell size %v", ErrInvalidParameter, cell)
+	}
+	dst := copyImage(src)
+	w, h := dst.Rect.Dx(), dst.Rect.Dy()
+	for y0 := 0; y0 < h; y0 += cell {
+		for x0 := 0; x0 < w; x0 += cell {
+			block := image.Rect(x0, y0, x0+cell, y0+cell).Intersect(dst.Rect)
+			var acc [4]float64
+			for y := block.Min.Y; y < block.Max.Y; y++ {
+				for x := block.Min.X; x < block.Max.X; x++ {
+					p := dst.Pix[dst.PixOffset(x, y):]
+					a := float64(p[3])
+					acc[0] += float64(p[0]) * a
+					acc[1] += float64(p[1]) * a
+					acc[2] += float64(p[2]) * a
+					acc[3] += a
+				}
+			}
+			var avg [4]uint8
+			if acc[3] > 0 {
+				avg = [4]uint8{clamp(acc[0] / acc[3]), clamp(acc[1] / acc[3]), clamp(acc[2] / acc[3]), clamp(acc[3] / float64(block.Dx()*block.Dy()))}
+			}
+			for y := block.Min.Y; y < block.Max.Y; y++ {
+				for x := block.Min.X; x < block.Max.X; x++ {
+					copy(dst.Pix[dst.PixOffset(x, y):], avg[:])
+				}
+			}
+		}
+	}
+	return dst, nil
+}
+
+// PixelateFloat is Pixelate for float images.
+func PixelateFloat(src *Float, cell int) (*Float, error) {
+	if cell < 1 {
+		return nil, fmt.Errorf("%w: cell size %v", ErrInvalidParameter, cell)
+	}
+	dst := copyFloat(src)
+	w, h := dst.Rect.Dx(), dst.Rect.Dy()
+	for y0 := 0; y0 < h; y0 += cell {
+		for x0 := 0; x0 < w; x0 += cell {
+			block := image.Rect(x0, y0, x0+cell, y0+cell).Intersect(dst.Rect)
+			var acc [4]float64
+			for y := block.Min.Y; y < block.Max.Y; y++ {
+				for x := block.Min.X; x < block.Max.X; x++ {
+					p := dst.Pix[dst.PixOffset(x, y):]
+					a := float64(p[3])
+					acc[0] += float64(p[0]) * a
+					acc[1] += float64(p[1]) * a
+					acc[2] += float64(p[2]) * a
+					acc[3] += a
+				}
+			}
+			var avg [4]float32
+			if acc[3] > 0 {
+				avg = [4]float32{float32(acc[0] / acc[3]), float32(acc[1] / acc[3]), float32(acc[2] / acc[3]), float32(acc[3] / float64(block.Dx()*block.Dy()))}
+			}
+			for y := block.Min.Y; y < block.Max.Y; y++ {
+				for x := block.Min.X; x < block.Max.X; x++ {
+					copy(dst.Pix[dst.PixOffset(x, y):], avg[:])
+				}
+			}
+		}
+	}
+	return dst, nil
+}
+
+// Emboss returns a copy of src lit from the top left, with flat areas gray.
+func Emboss(src *image.NRGBA) *image.NRGBA {
+	dst, _ := Convolve(src, embossKernel)
+	return Desaturate(dst)
+}
+
+// embossKernel lights an image from the top left
+var embossKernel = Kernel{
+	Size:    3,
+	Weights: []float64{-1, -1, 0, -1, 0, 1, 0, 1, 1},
+	Divisor: 1,
+	Offset:  128,
+}
+
+// EmbossFloat is Emboss for float images.
+func EmbossFloat(src *Float) *Float {
+	dst, _ := ConvolveFloat(src, embossKernel)
+	return DesaturateFloat(dst)
+}
+
+// EdgeOperator is a pair of gradient kernels used for edge detection.
+type EdgeOperator int
+
+// Constants for all edge operators.
+const (
+	Sobel EdgeOperator = iota
+	Prewitt
+)
+
+// EdgeOperators lists every edge operator.
+var EdgeOperators = []EdgeOperator{Sobel, Prewitt}
+
+func (o EdgeOperator) String() string {
+	switch o {
+	case Sobel:
+		return "sobel"
+	case Prewitt:
+		return "prewitt"
+	}
+	return fmt.Sprintf("EdgeOperator(%d)", int(o))
+}
+
+// kernels returns the horizontal and vertical gradient kernels of o
+func (o EdgeOperator) kernels() ([]float64, []float64) {
+	gx := []float64{-1, 0, 1, -2, 0, 2, -1, 0, 1}
+	if o == Prewitt {
+		gx = []float64{-1, 0, 1, -1, 0, 1, -1, 0, 1}
+	}
+	// the vertical kernel is the transpose of the horizontal one
+	gy := make([]float64, 9)
+	for j := 0; j < 3; j++ {
+		for i := 0; i < 3; i++ {
+			gy[i*3+j] = gx[j*3+i]
+		}
+	}
+	return gx, gy
+}
+
+// DetectEdges returns the gradient magnitude of the luminance of src as a
+// grayscale image, keeping the alpha channel.
+func DetectEdges(src *image.NRGBA, o EdgeOperator) *image.NRGBA {
+	gx, gy := o.kernels()
+	gray := Desaturate(src)
+	w, h := gray.Rect.Dx(), gray.Rect.Dy()
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var sx, sy float64
+			for j := -1; j <= 1; j++ {
+				for i := -1; i <= 1; i++ {
+					p, _ := edgePixel(gray, x+i, y+j, EdgeClamp)
+					v := float64(p[0])
+					sx += v * gx[(j+1)*3+i+1]
+					sy += v * gy[(j+1)*3+i+1]
+				}
+			}
+			m := clamp(math.Hypot(sx, sy))
+			out := dst.Pix[dst.PixOffset(x, y):]
+			out[0], out[1], out[2] = m, m, m
+			out[3] = gray.Pix[gray.PixOffset(x, y)+3]
+		}
+	}
+	return dst
+}
+
+// DetectEdgesFloat is DetectEdges for float images.
+func DetectEdgesFloat(src *Float, o EdgeOperator) *Float {
+	gx, gy := o.kernels()
+	gray := DesaturateFloat(src)
+	w, h := gray.Rect.Dx(), gray.Rect.Dy()
+	dst := NewFloat(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var sx, sy float64
+			for j := -1; j <= 1; j++ {
+				for i := -1; i <= 1; i++ {
+					p, _ := edgeFloat(gray, x+i, y+j, EdgeClamp)
+					v := float64(p[0])
+					sx += v * gx[(j+1)*3+i+1]
+					sy += v * gy[(j+1)*3+i+1]
+				}
+			}
+			m := clampUnit(float32(math.Hypot(sx, sy)))
+			out := dst.Pix[dst.PixOffset(x, y):]
+			out[0], out[1], out[2] = m, m, m
+			out[3] = gray.Pix[gray.PixOffset(x, y)+3]
+		}
+	}
+	return dst
+}
+
+// Posterize returns a copy of src with each color channel reduced to the
+// given number of evenly spaced levels.
+func Posterize(src *image.NRGBA, levels int) (*image.NRGBA, error) {
+	if levels < 2 || levels > 256 {
+		return nil, fmt.Errorf("%w: %v levels, expected 2 to 256", ErrInvalidParameter, levels)
+	}
+	step := 255 / float64(levels-1)
+	return mapPixels(src, func(p []uint8) {
+		for c := 0; c < 3; c++ {
+			p[c] = clamp(math.Round(float64(p[c])/step) * step)
+		}
+	}), nil
+}
+
+// PosterizeFloat is Posterize for float images.
+func PosterizeFloat(src *Float, levels int) (*Float, error) {
+	if levels < 2 || levels > 256 {
+		return nil, fmt.Errorf("%w: %v levels, expected 2 to 256", ErrInvalidParameter, levels)
+	}
+	step := 1 / float64(levels-1)
+	return mapFloat(src, func(p []float32) {
+		for c := 0; c < 3; c++ {
+			p[c] = clampUnit(float32(math.Round(float64(p[c])/step) * step))
+		}
+	}), nil
+}
+
+// Threshold returns a copy of src where pixels with a luminance of at least
+// level are white and all others black.
+func Threshold(src *image.NRGBA, level uint8) *image.NRGBA {
+	return mapPixels(src, func(p []uint8) {
+		v := uint8(0)
+		if luminance(p) >= float64(level) {
+			v = 0xFF
+		}
+		p[0], p[1], p[2] = v, v, v
+	})
+}
+
+// ThresholdFloat is Threshold for float images. The level is in 8-bit units
+// like for Threshold.
+func ThresholdFloat(src *Float, level uint8) *Float {
+	return mapFloat(src, func(p []float32) {
+		v := float32(0)
+		if luminanceFloat(p)*255 >= float64(level) {
+			v = 1
+		}
+		p[0], p[1], p[2] = v, v, v
+	})
+}
+
+// Invert returns a copy of src with the color channels inverted.
+func Invert(src *image.NRGBA) *image.NRGBA {
+	return mapPixels(src, func(p []uint8) {
+		p[0], p[1], p[2] = 0xFF-p[0], 0xFF-p[1], 0xFF-p[2]
+	})
+}
+
+// InvertFloat is Invert for float images.
+func InvertFloat(src *Float) *Float {
+	return mapFloat(src, func(p []float32) {
+		p[0], p[1], p[2] = 1-clampUnit(p[0]), 1-clampUnit(p[1]), 1-clampUnit(p[2])
+	})
+}
+
+// Desaturate returns a grayscale copy of src using Rec. 601 luma weights.
+func Desaturate(src *image.NRGBA) *image.NRGBA {
+	return mapPixels(src, func(p []uint8) {
+		v := clamp(luminance(p))
+		p[0], p[1], p[2] = v, v, v
+	})
+}
+
+// DesaturateFloat is Desaturate for float images.
+func DesaturateFloat(src *Float) *Float {
+	return mapFloat(src, func(p []float32) {
+		v := clampUnit(float32(luminanceFloat(p)))
+		p[0], p[1], p[2] = v, v, v
+	})
+}
+
+// Median returns a copy of src where each channel of each pixel is the
+// median of the square of the given radius around it. A radius of one
+// removes isolated specks.
+func Median(src *image.NRGBA, radius int) (*image.NRGBA, error) {
+	if radius < 1 || radius > MaxKernelSize/2 {
+		return nil, fmt.Errorf("%w: radius %v, expected 1 to %v", ErrInvalidParameter, radius, MaxKernelSize/2)
+	}
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	vals := make([][]uint8, 4)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			for c := range vals {
+				vals[c] = vals[c][:0]
+			}
+			for j := -radius; j <= radius; j++ {
+				for i := -radius; i <= radius; i++ {
+					p, _ := edgePixel(src, x+i, y+j, EdgeClamp)
+					for c := range vals {
+						vals[c] = append(vals[c], p[c])
+					}
+				}
+			}
+			out := dst.Pix[dst.PixOffset(x, y):]
+			for c := range vals {
+				sort.Slice(vals[c], func(a, b int) bool { return vals[c][a] < vals[c][b] })
+				out[c] = vals[c][len(vals[c])/2]
+			}
+		}
+	}
+	return dst, nil
+}
+
+// MedianFloat is Median for float images.
+func MedianFloat(src *Float, radius int) (*Float, error) {
+	if radius < 1 || radius > MaxKernelSize/2 {
+		return nil, fmt.Errorf("%w: radius %v, expected 1 to %v", ErrInvalidParameter, radius, MaxKernelSize/2)
+	}
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dst := NewFloat(image.Rect(0, 0, w, h))
+	vals := make([][]float32, 4)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			for c := range vals {
+				vals[c] = vals[c][:0]
+			}
+			for j := -radius; j <= radius; j++ {
+				for i := -radius; i <= radius; i++ {
+					p, _ := edgeFloat(src, x+i, y+j, EdgeClamp)
+					for c := range vals {
+						vals[c] = append(vals[c], p[c])
+					}
+				}
+			}
+			out := dst.Pix[dst.PixOffset(x, y):]
+			for c := range vals {
+				sort.Slice(vals[c], func(a, b int) bool { return vals[c][a] < vals[c][b] })
+				out[c] = vals[c][len(vals[c])/2]
+			}
+		}
+	}
+	return dst, nil
+}
+
+// luminance returns the Rec. 601 luma of an RGBA pixel
+func luminance(p []uint8) float64 {
+	return 0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])
+}
+
+// mapPixels returns a copy of src with f applied to the RGBA values of each
+// pixel
+func mapPixels(src *image.NRGBA, f func(p []uint8)) *image.NRGBA {
+	dst := copyImage(src)
+	for i := 0; i+4 <= len(dst.Pix); i += 4 {
+		f(dst.Pix[i : i+4])
+	}
+	return dst
+}
+
+// copyImage returns a copy of src with its origin at zero
+func copyImage(src *image.NRGBA) *image.NRGBA {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		s := src.PixOffset(src.Rect.Min.X, src.Rect.Min.Y+y)
+		copy(dst.Pix[y*dst.Stride:y*dst.Stride+w*4], src.Pix[s:s+w*4])
+	}
+	return dst
+}
+
+// luminanceFloat is luminance for float pixels
+func luminanceFloat(p []float32) float64 {
+	return 0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])
+}
+
+// mapFloat is mapPixels for float images
+func mapFloat(src *Float, f func(p []float32)) *Float {
+	dst := copyFloat(src)
+	for i := 0; i+4 <= len(dst.Pix); i += 4 {
+		f(dst.Pix[i : i+4])
+	}
+	return dst
+}
+
+// copyFloat is copyImage for float images
+func copyFloat(src *Float) *Float {
+	dst := src.Copy()
+	dst.Rect = dst.Rect.Sub(dst.Rect.Min)
+	return dst
+}
@@ -0,0 +1,218 @@
+// Package remote serves JSON-RPC 2.0 calls on a Unix domain socket so that
+// other programs on the same machine can drive the editor. Requests and
+// responses are JSON values, conventionally one per line.
+package remote
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+)
+
+// ErrInUse indicates a socket another server is listening on
+const ErrInUse log.ConstErr = "socket is in use by another server"
+
+// ErrNotSocket indicates a path to listen on that holds another kind of
+// file, which is left alone
+const ErrNotSocket log.ConstErr = "path exists and is not a socket"
+
+// ErrInvalidParams indicates parameters that do not suit the method. Method
+// errors wrapping it are reported with the invalid params code.
+const ErrInvalidParams log.ConstErr = "invalid params"
+
+// JSON-RPC 2.0 error codes
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	// CodeServerError is the code of errors returned by methods
+	CodeServerError = -32000
+)
+
+// Method handles a call given its raw params, which are null if absent, and
+// returns a result to be encoded as JSON.
+type Method func(params json.RawMessage) (interface{}, error)
+
+// Error is the error member of a response.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v (%v)", e.Message, e.Code)
+}
+
+// Request is a call or a notification, which has no id and gets no
+// response.
+type Request struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// Response is the outcome of a call, holding either a result or an error.
+type Response struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Server answers calls to its methods on a Unix domain socket.
+type Server struct {
+	ln      net.Listener
+	methods map[string]Method
+	mu      sync.Mutex
+	conns   map[net.Conn]struct{}
+	closed  bool
+}
+
+// Listen creates the socket at path, readable and writable only by the
+// user from the start, replacing a stale socket left by a server that did
+// not close it.
+func Listen(path string, methods map[string]Method) (*Server, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%w: %v", ErrNotSocket, path)
+		}
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("%w: %v", ErrInUse, path)
+		}
+		if err = os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	var ln net.Listener
+	err := privately(func() error {
+		var err error
+		ln, err = net.Listen("unix", path)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return &Server{ln: ln, methods: methods, conns: make(map[net.Conn]struct{})}, nil
+}
+
+// Serve accepts connections until the server is closed, answering the calls
+// of each connection in order.
+func (s *Server) Serve() {
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if !closed {
+				log.Warnf("remote: %v", err)
+			}
+			return
+		}
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+		go s.serveConn(c)
+	}
+}
+
+// Close stops accepting connections, closes the open ones and removes the
+// socket.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	// closing a unix listener removes its socket file
+	return s.ln.Close()
+}
+
+func (s *Server) serveConn(c net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.Close()
+	}()
+	dec := json.NewDecoder(c)
+	enc := json.NewEncoder(c)
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			var syntax *json.SyntaxError
+			if errors.As(err, &syntax) {
+				// the stream cannot be resynchronized after bad JSON
+				_ = enc.Encode(Response{Version: "2.0", ID: json.RawMessage("null"), Error: &Error{CodeParseError, err.Error()}})
+			}
+			return
+		}
+		if resp, ok := s.call(raw); ok {
+			if err := enc.Encode(resp); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// call handles one request, returning false for notifications
+func (s *Server) call(raw json.RawMessage) (Response, bool) {
+	resp := Response{Version: "2.0", ID: json.RawMessage("null")}
+	var req Request
+	if err := json.Unmarshal(raw, &req); err != nil || req.Version != "2.0" || req.Method == "" {
+		resp.Error = &Error{CodeInvalidRequest, "invalid request"}
+		return resp, true
+	}
+	if req.ID != nil {
+		resp.ID = req.ID
+	}
+	m, ok := s.methods[req.Method]
+	if !ok {
+		resp.Error = &Error{CodeMethodNotFound, "method not found: " + req.Method}
+		return resp, req.ID != nil
+	}
+	if req.Params == nil {
+		req.Params = json.RawMessage("null")
+	}
+	result, err := m(req.Params)
+	if err != nil {
+		code := CodeServerError
+		if errors.Is(err, ErrInvalidParams) {
+			code = CodeInvalidParams
+		}
+		resp.Error = &Error{code, err.Error()}
+	} else if result == nil {
+		result = true
+	}
+	resp.Result = result
+	return resp, req.ID != nil
+}
+
+// Decode unmarshals params into v, reporting failures as invalid params.
+// Absent params leave v unchanged.
+func Decode(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 || string(params) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidParams, err)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package remote_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/remote"
+)
+
+func TestServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tabula.sock")
+	s, err := remote.Listen(path, map[string]remote.Method{
+		"add": func(params json.RawMessage) (interface{}, error) {
+			var p struct{ A, B int }
+			if err := remote.Decode(params, &p); err != nil {
+				return nil, err
+			}
+			return p.A + p.B, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve()
+	defer s.Close()
+	if _, err = remote.Listen(path, nil); err == nil {
+		t.Fatal("expected a second server on the same socket to fail")
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("expected a socket only the user can use, got %v, %v", fi, err)
+	}
+	notes := filepath.Join(dir, "notes.txt")
+	if err = ioutil.WriteFile(notes, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = remote.Listen(notes, nil); !errors.Is(err, remote.ErrNotSocket) {
+		t.Errorf("expected %v, got %v", remote.ErrNotSocket, err)
+	}
+	if data, err := ioutil.ReadFile(notes); err != nil || string(data) != "keep" {
+		t.Errorf("expected a regular file to be left alone, got %q, %v", data, err)
+	}
+
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	r := bufio.NewScanner(c)
+	for _, tc := range []struct {
+		req  string
+		want string
+	}{
+		{`{"jsonrpc":"2.0","id":1,"method":"add","params":{"A":2,"B":3}}`, `{"jsonrpc":"2.0","id":1,"result":5}`},
+		{`{"jsonrpc":"2.0","method":"add"}`, ""},
+		{`{"jsonrpc":"2.0","id":"x","method":"sub"}`, fmt.Sprintf(`{"jsonrpc":"2.0","id":"x","error":{"code":%v,"message":"method not found: sub"}}`, remote.CodeMethodNotFound)},
+		{`{"jsonrpc":"2.0","id":2,"method":"add","params":[1]}`, fmt.Sprintf(`"code":%v`, remote.CodeInvalidParams)},
+		{`{"id":3,"method":"add"}`, fmt.Sprintf(`{"jsonrpc":"2.0","id":null,"error":{"code":%v,"message":"invalid request"}}`, remote.CodeInvalidRequest)},
+	} {
+		if _, err = fmt.Fprintln(c, tc.req); err != nil {
+			t.Fatal(err)
+		}
+		if tc.want == "" {
+			continue
+		}
+		if !r.Scan() {
+			t.Fatalf("%v: no response: %v", tc.req, r.Err())
+		}
+		if got := r.Text(); !strings.Contains(got, tc.want) {
+			t.Fatalf("%v: expected %v, got %v", tc.req, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package remote
+
+import "syscall"
+
+// privately runs f with a umask keeping the files it creates from other
+// users, so that a socket is never briefly open to them
+func privately(f func() error) error {
+	old := syscall.Umask(0077)
+	defer syscall.Umask(old)
+	return f()
+}
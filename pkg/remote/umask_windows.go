@@ -0,0 +1,7 @@
+package remote
+
+// privately runs f. Windows has no umask, and sockets are created with the
+// permissions of the directory holding them.
+func privately(f func() error) error {
+	return f()
+}
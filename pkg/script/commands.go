@@ -0,0 +1,414 @@
+package script
+
+import (
+	"fmt"
+	stdimage "image"
+	"image/color"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/batch"
+	"github.com/gregjohnson2017/tabula-editor/pkg/codec"
+	"github.com/gregjohnson2017/tabula-editor/pkg/image"
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+)
+
+// ErrUnknownCommand indicates a command the language does not have
+const ErrUnknownCommand log.ConstErr = "unknown command"
+
+// ErrArgs indicates a command given the wrong number of arguments
+const ErrArgs log.ConstErr = "wrong number of arguments"
+
+// ErrNoLayer indicates a layer index or name matching no layer
+const ErrNoLayer log.ConstErr = "no such layer"
+
+// command is a built in command taking between min and max arguments, or
+// any number from min if max is negative
+type command struct {
+	min, max int
+	usage    string
+	summary  string
+	run      func(in *interp, args []value) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"new":      {2, 3, "WIDTH HEIGHT [COLOR]", "replace the document with a blank canvas", cmdNew},
+		"open":     {1, 1, "PATH", "replace the document with a project or image file", cmdOpen},
+		"save":     {1, 1, "PATH", "save the document as a project (.tabula, " + image.FolderExt + " or .ora)", cmdSave},
+		"export":   {1, -1, "PATH [NAME=VALUE...]", "composite the document and write it as an image", cmdExport},
+		"layer":    {1, 3, "PATH [X Y]", "add an image file as a layer and select it", cmdLayer},
+		"link":     {1, 3, "PATH [X Y]", "add a layer linked to an image or project file and select it", cmdLink},
+		"newlayer": {2, 3, "WIDTH HEIGHT [COLOR]", "add a layer filled with a color and select it", cmdNewLayer},
+		"select":   {1, 1, "INDEX|NAME", "select a layer by position from 1 or by name", cmdSelect},
+		"move":     {2, 2, "DX DY", "move the selected layer", cmdMove},
+		"place":    {2, 2, "X Y", "put the top left corner of the selected layer at a canvas position", cmdPlace},
+		"name":     {1, 1, "NAME", "rename the selected layer", cmdName},
+		"opacity":  {1, 1, "AMOUNT", "set the opacity of the selected layer from 0 to 1", cmdOpacity},
+		"hide":     {0, 0, "", "hide the selected layer", cmdHide},
+		"show":     {0, 0, "", "show the selected layer", cmdShow},
+		"delete":   {0, 0, "", "delete the selected layer", cmdDelete},
+		"filter":   {1, 2, "NAME [AMOUNT]", "apply a filter to the selected layer", cmdFilter},
+		"convolve": {1, 1, "KERNEL", "convolve the selected layer with a kernel", cmdConvolve},
+		"resize":   {2, 3, "WIDTH HEIGHT [FILTER]", "resample the canvas and every layer", cmdResize},
+		"scale":    {1, 2, "FACTOR [FILTER]", "resample the canvas and every layer by a factor", cmdScale},
+		"canvas":   {2, 2, "WIDTH HEIGHT", "change the canvas size, keeping the layers in place", cmdCanvas},
+		"flatten":  {0, 0, "", "merge every layer into one", cmdFlatten},
+		"print":    {0, -1, "VALUE...", "log the values", cmdPrint},
+	}
+}
+
+// Commands returns a line describing each command, sorted by name.
+func Commands() []string {
+	var lines []string
+	for name, c := range commands {
+		lines = append(lines, strings.TrimSpace(name+" "+c.usage)+": "+c.summary)
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// path resolves a path argument against the folder of the script
+func (in *interp) path(v value) string {
+	p := format(v)
+	if in.dir != "" && !filepath.IsAbs(p) {
+		return filepath.Join(in.dir, p)
+	}
+	return p
+}
+
+// selected returns the selected layer
+func (in *interp) selected() (*image.DocumentLayer, error) {
+	if in.layer <= 0 || in.layer >= len(in.doc.Layers) {
+		return nil, image.ErrNoLayerSelected
+	}
+	return in.doc.Layers[in.layer], nil
+}
+
+// colorArg parses an optional color argument, transparent if missing
+func colorArg(args []value, i int) (color.NRGBA, error) {
+	if len(args) <= i {
+		return color.NRGBA{}, nil
+	}
+	if s := format(args[i]); s != "transparent" {
+		return raster.ParseHex(s)
+	}
+	return color.NRGBA{}, nil
+}
+
+// filterArg parses an optional resampling filter argument, nearest if
+// missing
+func filterArg(args []value, i int) (raster.Filter, error) {
+	if len(args) <= i {
+		return raster.Nearest, nil
+	}
+	return raster.ParseFilter(format(args[i]))
+}
+
+// ints converts the arguments to integers
+func ints(args []value) ([]int, error) {
+	n := make([]int, len(args))
+	for i, a := range args {
+		var err error
+		if n[i], err = integer(a); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+func cmdNew(in *interp, args []value) error {
+	n, err := ints(args[:2])
+	if err != nil {
+		return err
+	}
+	bg, err := colorArg(args, 2)
+	if err != nil {
+		return err
+	}
+	doc, err := image.NewDocument(n[0], n[1], bg, raster.Depth8)
+	if err != nil {
+		return err
+	}
+	in.doc, in.layer = doc, 0
+	return nil
+}
+
+func cmdOpen(in *interp, args []value) error {
+	doc, err := image.ReadDocument(in.path(args[0]))
+	if err != nil {
+		return err
+	}
+	in.doc, in.layer = doc, 0
+	return nil
+}
+
+func cmdSave(in *interp, args []value) error {
+	return in.doc.Save(in.path(args[0]))
+}
+
+func cmdExport(in *interp, args []value) error {
+	opts := codec.Options{}
+	for _, a := range args[1:] {
+		s := format(a)
+		i := strings.Index(s, "=")
+		if i <= 0 {
+			return fmt.Errorf("%w: expected name=value, got %q", codec.ErrInvalidOption, s)
+		}
+		opts[s[:i]] = s[i+1:]
+	}
+	return in.doc.WriteFile(in.path(args[0]), opts)
+}
+
+// add adds img as a layer at x, y on the canvas and selects it
+func (in *interp) add(img *stdimage.NRGBA, x, y int, name string) {
+	in.doc.AddImage(img, x, y, name)
+	in.layer = len(in.doc.Layers) - 1
+}
+
+func cmdLayer(in *interp, args []value) error {
+	if len(args) == 2 {
+		return fmt.Errorf("%w: give both X and Y", ErrArgs)
+	}
+	n, err := ints(args[1:])
+	if err != nil {
+		return err
+	}
+	path := in.path(args[0])
+	img, err := codec.DecodeFile(path)
+	if err != nil {
+		return err
+	}
+	if len(n) == 0 {
+		n = []int{0, 0}
+	}
+	in.add(img, n[0], n[1], filepath.Base(path))
+	return nil
+}
+
+func cmdLink(in *interp, args []value) error {
+	if len(args) == 2 {
+		return fmt.Errorf("%w: give both X and Y", ErrArgs)
+	}
+	n, err := ints(args[1:])
+	if err != nil {
+		return err
+	}
+	if len(n) == 0 {
+		n = []int{0, 0}
+	}
+	if _, err = in.doc.AddLinked(in.path(args[0]), n[0], n[1]); err != nil {
+		return err
+	}
+	in.layer = len(in.doc.Layers) - 1
+	return nil
+}
+
+func cmdNewLayer(in *interp, args []value) error {
+	n, err := ints(args[:2])
+	if err != nil {
+		return err
+	}
+	if n[0] <= 0 || n[1] <= 0 {
+		return fmt.Errorf("%w, got %vx%v", image.ErrInvalidSize, n[0], n[1])
+	}
+	c, err := colorArg(args, 2)
+	if err != nil {
+		return err
+	}
+	img := stdimage.NewNRGBA(stdimage.Rect(0, 0, n[0], n[1]))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	in.add(img, 0, 0, fmt.Sprintf("Layer %v", len(in.doc.Layers)))
+	return nil
+}
+
+func cmdSelect(in *interp, args []value) error {
+	if name, ok := args[0].(string); ok {
+		for i := len(in.doc.Layers) - 1; i > 0; i-- {
+			if in.doc.Layers[i].Name == name {
+				in.layer = i
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: %q", ErrNoLayer, name)
+	}
+	i, err := integer(args[0])
+	if err != nil {
+		return err
+	}
+	if i <= 0 || i >= len(in.doc.Layers) {
+		return fmt.Errorf("%w: %v of %v", ErrNoLayer, i, len(in.doc.Layers)-1)
+	}
+	in.layer = i
+	return nil
+}
+
+func cmdMove(in *interp, args []value) error {
+	l, err := in.selected()
+	if err != nil {
+		return err
+	}
+	n, err := ints(args)
+	if err != nil {
+		return err
+	}
+	l.Area.X += int32(n[0])
+	l.Area.Y += int32(n[1])
+	return nil
+}
+
+func cmdPlace(in *interp, args []value) error {
+	l, err := in.selected()
+	if err != nil {
+		return err
+	}
+	n, err := ints(args)
+	if err != nil {
+		return err
+	}
+	l.Area.X = in.doc.Canvas.X + int32(n[0])
+	l.Area.Y = in.doc.Canvas.Y + int32(n[1])
+	return nil
+}
+
+func cmdName(in *interp, args []value) error {
+	l, err := in.selected()
+	if err != nil {
+		return err
+	}
+	l.Name = format(args[0])
+	return nil
+}
+
+func cmdOpacity(in *interp, args []value) error {
+	l, err := in.selected()
+	if err != nil {
+		return err
+	}
+	f, err := number(args[0])
+	if err != nil {
+		return err
+	}
+	if f < 0 || f > 1 {
+		return fmt.Errorf("%w: opacity must be from 0 to 1, got %v", raster.ErrInvalidParameter, f)
+	}
+	l.Transparency = 1 - f
+	return nil
+}
+
+func cmdHide(in *interp, args []value) error {
+	l, err := in.selected()
+	if err != nil {
+		return err
+	}
+	l.Hidden = true
+	return nil
+}
+
+func cmdShow(in *interp, args []value) error {
+	l, err := in.selected()
+	if err != nil {
+		return err
+	}
+	l.Hidden = false
+	return nil
+}
+
+func cmdDelete(in *interp, args []value) error {
+	if _, err := in.selected(); err != nil {
+		return err
+	}
+	in.doc.Layers = append(in.doc.Layers[:in.layer], in.doc.Layers[in.layer+1:]...)
+	in.layer = 0
+	return nil
+}
+
+// apply runs a batch step on the pixels of the selected layer
+func (in *interp) apply(s batch.Step) error {
+	l, err := in.selected()
+	if err != nil {
+		return err
+	}
+	img, err := s(l.Image)
+	if err != nil {
+		return err
+	}
+	l.SetImage(img)
+	return nil
+}
+
+func cmdFilter(in *interp, args []value) error {
+	amount := 0
+	if len(args) > 1 {
+		var err error
+		if amount, err = integer(args[1]); err != nil {
+			return err
+		}
+	}
+	s, err := batch.FilterStep(format(args[0]), amount, "")
+	if err != nil {
+		return err
+	}
+	return in.apply(s)
+}
+
+func cmdConvolve(in *interp, args []value) error {
+	s, err := batch.FilterStep("convolve", 0, format(args[0]))
+	if err != nil {
+		return err
+	}
+	return in.apply(s)
+}
+
+func cmdResize(in *interp, args []value) error {
+	n, err := ints(args[:2])
+	if err != nil {
+		return err
+	}
+	f, err := filterArg(args, 2)
+	if err != nil {
+		return err
+	}
+	return in.doc.Resize(n[0], n[1], f)
+}
+
+func cmdScale(in *interp, args []value) error {
+	factor, err := number(args[0])
+	if err != nil {
+		return err
+	}
+	f, err := filterArg(args, 1)
+	if err != nil {
+		return err
+	}
+	return in.doc.Scale(factor, f)
+}
+
+func cmdCanvas(in *interp, args []value) error {
+	n, err := ints(args)
+	if err != nil {
+		return err
+	}
+	return in.doc.ResizeCanvas(n[0], n[1])
+}
+
+func cmdFlatten(in *interp, args []value) error {
+	in.doc.Flatten()
+	in.layer = 1
+	return nil
+}
+
+func cmdPrint(in *interp, args []value) error {
+	s := make([]string, len(args))
+	for i, a := range args {
+		s[i] = format(a)
+	}
+	log.Info(strings.Join(s, " "))
+	return nil
+}
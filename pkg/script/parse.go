@@ -0,0 +1,412 @@
+package script
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+)
+
+// ErrSyntax indicates a script that cannot be parsed
+const ErrSyntax log.ConstErr = "syntax error"
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNewline
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	line int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of script"
+	case tokNewline:
+		return "end of line"
+	}
+	return strconv.Quote(t.text)
+}
+
+// twoCharOps are the operators made of two characters, checked before the
+// single character ones
+var twoCharOps = []string{"==", "!=", "<=", ">="}
+
+// lex splits src into tokens, dropping comments and collapsing blank lines
+func lex(src string) ([]token, error) {
+	var toks []token
+	line := 1
+	emit := func(kind tokenKind, text string) {
+		if kind == tokNewline && (len(toks) == 0 || toks[len(toks)-1].kind == tokNewline) {
+			return
+		}
+		toks = append(toks, token{kind, text, line})
+	}
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			emit(tokNewline, "")
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '"':
+			j := i + 1
+			for ; j < len(src) && src[j] != '"' && src[j] != '\n'; j++ {
+				if src[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(src) || src[j] != '"' {
+				return nil, &Error{Line: line, Err: fmt.Errorf("%w: unterminated string", ErrSyntax)}
+			}
+			s, err := strconv.Unquote(src[i : j+1])
+			if err != nil {
+				return nil, &Error{Line: line, Err: fmt.Errorf("%w: bad string %v", ErrSyntax, src[i:j+1])}
+			}
+			emit(tokString, s)
+			i = j + 1
+		case c >= '0' && c <= '9' || c == '.':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			emit(tokNumber, src[i:j])
+			i = j
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i
+			for j < len(src) && (src[j] == '_' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			emit(tokIdent, src[i:j])
+			i = j
+		default:
+			op := string(c)
+			for _, two := range twoCharOps {
+				if strings.HasPrefix(src[i:], two) {
+					op = two
+				}
+			}
+			if !strings.Contains("+-*/%()<>={}", op) && len(op) == 1 {
+				return nil, &Error{Line: line, Err: fmt.Errorf("%w: unexpected character %q", ErrSyntax, c)}
+			}
+			emit(tokOp, op)
+			i += len(op)
+		}
+	}
+	emit(tokNewline, "")
+	toks = append(toks, token{tokEOF, "", line})
+	return toks, nil
+}
+
+// expr is an expression evaluated to a number or a string
+type expr interface{}
+
+type (
+	literal  struct{ val value }
+	variable struct{ name string }
+	unary    struct {
+		op string
+		x  expr
+	}
+	binary struct {
+		op   string
+		x, y expr
+	}
+)
+
+// stmt is a statement of a script
+type stmt interface{}
+
+type (
+	letStmt struct {
+		line int
+		name string
+		val  expr
+	}
+	forStmt struct {
+		line     int
+		name     string
+		from, to expr
+		body     []stmt
+	}
+	ifStmt struct {
+		line      int
+		cond      expr
+		then, els []stmt
+	}
+	commandStmt struct {
+		line int
+		name string
+		args []expr
+	}
+)
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorf(t token, format string, v ...interface{}) error {
+	return &Error{Line: t.line, Err: fmt.Errorf("%w: "+format, append([]interface{}{ErrSyntax}, v...)...)}
+}
+
+func (p *parser) expectOp(op string) error {
+	if t := p.next(); t.kind != tokOp || t.text != op {
+		return p.errorf(t, "expected %q, got %v", op, t)
+	}
+	return nil
+}
+
+func (p *parser) expectIdent() (string, error) {
+	t := p.next()
+	if t.kind != tokIdent {
+		return "", p.errorf(t, "expected a name, got %v", t)
+	}
+	return t.text, nil
+}
+
+func (p *parser) endOfStatement() error {
+	if t := p.peek(); t.kind == tokNewline {
+		p.next()
+	} else if !(t.kind == tokOp && t.text == "}") && t.kind != tokEOF {
+		return p.errorf(t, "unexpected %v", t)
+	}
+	return nil
+}
+
+// parse returns the statements of src
+func parse(src string) ([]stmt, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	if p.peek().kind == tokNewline {
+		p.next()
+	}
+	body, err := p.block(false)
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// block parses statements up to the end of the script, or up to and
+// including a closing brace when braced
+func (p *parser) block(braced bool) ([]stmt, error) {
+	var body []stmt
+	for {
+		t := p.peek()
+		switch {
+		case t.kind == tokEOF:
+			if braced {
+				return nil, p.errorf(t, "missing \"}\"")
+			}
+			return body, nil
+		case t.kind == tokOp && t.text == "}":
+			if !braced {
+				return nil, p.errorf(t, "unexpected \"}\"")
+			}
+			p.next()
+			return body, nil
+		}
+		s, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, s)
+		if err = p.endOfStatement(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// braced parses an opening brace and the block following it
+func (p *parser) braced() ([]stmt, error) {
+	if err := p.expectOp("{"); err != nil {
+		return nil, err
+	}
+	if p.peek().kind == tokNewline {
+		p.next()
+	}
+	return p.block(true)
+}
+
+func (p *parser) statement() (stmt, error) {
+	t := p.next()
+	if t.kind != tokIdent {
+		return nil, p.errorf(t, "expected a command, got %v", t)
+	}
+	switch t.text {
+	case "let":
+		name, err := p.expectIdent()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expectOp("="); err != nil {
+			return nil, err
+		}
+		val, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return &letStmt{t.line, name, val}, nil
+	case "for":
+		name, err := p.expectIdent()
+		if err != nil {
+			return nil, err
+		}
+		if kw := p.next(); kw.kind != tokIdent || kw.text != "from" {
+			return nil, p.errorf(kw, "expected \"from\", got %v", kw)
+		}
+		from, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if kw := p.next(); kw.kind != tokIdent || kw.text != "to" {
+			return nil, p.errorf(kw, "expected \"to\", got %v", kw)
+		}
+		to, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		body, err := p.braced()
+		if err != nil {
+			return nil, err
+		}
+		return &forStmt{t.line, name, from, to, body}, nil
+	case "if":
+		cond, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		then, err := p.braced()
+		if err != nil {
+			return nil, err
+		}
+		s := &ifStmt{line: t.line, cond: cond, then: then}
+		if kw := p.peek(); kw.kind == tokIdent && kw.text == "else" {
+			p.next()
+			if kw = p.peek(); kw.kind == tokIdent && kw.text == "if" {
+				elif, err := p.statement()
+				if err != nil {
+					return nil, err
+				}
+				s.els = []stmt{elif}
+			} else if s.els, err = p.braced(); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	}
+	s := &commandStmt{line: t.line, name: t.text}
+	for {
+		a := p.peek()
+		if a.kind == tokNewline || a.kind == tokEOF || a.kind == tokOp && a.text == "}" {
+			return s, nil
+		}
+		arg, err := p.primary()
+		if err != nil {
+			return nil, err
+		}
+		s.args = append(s.args, arg)
+	}
+}
+
+// precedence is the binding strength of the binary operators
+var precedence = map[string]int{
+	"==": 1, "!=": 1, "<": 1, "<=": 1, ">": 1, ">=": 1,
+	"+": 2, "-": 2,
+	"*": 3, "/": 3, "%": 3,
+}
+
+func (p *parser) expr() (expr, error) {
+	return p.binary(1)
+}
+
+// binary parses operators binding at least as strongly as min
+func (p *parser) binary(min int) (expr, error) {
+	x, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		prec, ok := precedence[t.text]
+		if t.kind != tokOp || !ok || prec < min {
+			return x, nil
+		}
+		p.next()
+		y, err := p.binary(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = &binary{t.text, x, y}
+	}
+}
+
+// primary parses a literal, a variable, a negation or a parenthesized
+// expression
+func (p *parser) primary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.errorf(t, "bad number %v", t)
+		}
+		return &literal{f}, nil
+	case tokString:
+		return &literal{t.text}, nil
+	case tokIdent:
+		return &variable{t.text}, nil
+	case tokOp:
+		switch t.text {
+		case "-":
+			x, err := p.primary()
+			if err != nil {
+				return nil, err
+			}
+			return &unary{"-", x}, nil
+		case "(":
+			x, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			if err = p.expectOp(")"); err != nil {
+				return nil, err
+			}
+			return x, nil
+		}
+	}
+	return nil, p.errorf(t, "expected a value, got %v", t)
+}
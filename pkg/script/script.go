@@ -0,0 +1,321 @@
+// Package script interprets a small language automating the editing of a
+// document: creating and loading layers, moving them, applying filters and
+// exporting the result.
+//
+// A script has one statement per line, and # starts a comment:
+//
+//	let NAME = EXPR
+//	for NAME from EXPR to EXPR { ... }
+//	if EXPR { ... } else { ... }
+//	COMMAND ARG...
+//
+// Values are numbers or strings. Expressions use + - * / % and the
+// comparisons == != < <= > >=, which give 1 or 0, and + joins strings.
+// Arguments of commands are numbers, strings, variables, negations or
+// expressions in parentheses. The variables width, height and layers hold the
+// size of the canvas and the number of layers. A script stops with an error
+// after MaxIterations loop iterations in all, or once it has run for
+// MaxDuration, so that a mistaken loop cannot run forever.
+package script
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/image"
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+)
+
+// ErrUndefined indicates a variable that was never assigned
+const ErrUndefined log.ConstErr = "undefined variable"
+
+// ErrReadOnly indicates an assignment to a variable describing the document
+const ErrReadOnly log.ConstErr = "variable cannot be assigned"
+
+// ErrType indicates a value of the wrong type for an operator or command
+const ErrType log.ConstErr = "wrong type"
+
+// ErrTooLong indicates a script stopped by its iteration or time limit
+const ErrTooLong log.ConstErr = "script ran too long"
+
+// Limits of a run of a script
+const (
+	MaxIterations = 1000000
+	MaxDuration   = 10 * time.Minute
+)
+
+// Error is an error raised by a line of a script.
+type Error struct {
+	// File is the name of the script, if any
+	File string
+	Line int
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.File == "" {
+		return fmt.Sprintf("line %v: %v", e.Line, e.Err)
+	}
+	return fmt.Sprintf("%v:%v: %v", e.File, e.Line, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// value is a float64 or a string
+type value interface{}
+
+func format(v value) string {
+	if f, ok := v.(float64); ok {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return v.(string)
+}
+
+func number(v value) (float64, error) {
+	if f, ok := v.(float64); ok {
+		return f, nil
+	}
+	return 0, fmt.Errorf("%w: expected a number, got %q", ErrType, v)
+}
+
+func integer(v value) (int, error) {
+	f, err := number(v)
+	if err != nil {
+		return 0, err
+	}
+	return int(math.Round(f)), nil
+}
+
+func truth(b bool) value {
+	if b {
+		return 1.0
+	}
+	return 0.0
+}
+
+// interp runs the statements of a script on a document
+type interp struct {
+	doc *image.Document
+	// layer is the index of the selected layer in doc.Layers, or 0 if none
+	layer int
+	vars  map[string]value
+	// dir is the folder relative paths are resolved against
+	dir string
+	// iterations counts the loop iterations run, up to MaxIterations
+	// before the deadline
+	iterations int
+	deadline   time.Time
+}
+
+// builtins are the variables describing the document
+var builtins = map[string]func(*interp) value{
+	"width":  func(in *interp) value { return float64(in.doc.Canvas.W) },
+	"height": func(in *interp) value { return float64(in.doc.Canvas.H) },
+	"layers": func(in *interp) value { return float64(len(in.doc.Layers) - 1) },
+}
+
+// Run runs the script src on doc, which may be replaced by the script, and
+// returns the resulting document. The name is used in errors and relative
+// paths are resolved against the working directory.
+func Run(name, src string, doc *image.Document) (*image.Document, error) {
+	return run(name, src, "", doc)
+}
+
+// RunFile runs the script at path on doc like Run, resolving relative paths
+// against the folder of the script.
+func RunFile(path string, doc *image.Document) (*image.Document, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return run(filepath.Base(path), string(src), filepath.Dir(path), doc)
+}
+
+func run(name, src, dir string, doc *image.Document) (*image.Document, error) {
+	body, err := parse(src)
+	if err != nil {
+		err.(*Error).File = name
+		return nil, err
+	}
+	in := &interp{doc: doc, vars: make(map[string]value), dir: dir, deadline: time.Now().Add(MaxDuration)}
+	if err = in.exec(body); err != nil {
+		if e, ok := err.(*Error); ok {
+			e.File = name
+		}
+		return nil, err
+	}
+	return in.doc, nil
+}
+
+func (in *interp) exec(body []stmt) error {
+	for _, s := range body {
+		if err := in.stmt(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stmt runs s, attributing errors to its line
+func (in *interp) stmt(s stmt) error {
+	switch s := s.(type) {
+	case *letStmt:
+		if _, ok := builtins[s.name]; ok {
+			return &Error{Line: s.line, Err: fmt.Errorf("%w: %v", ErrReadOnly, s.name)}
+		}
+		v, err := in.eval(s.val)
+		if err != nil {
+			return &Error{Line: s.line, Err: err}
+		}
+		in.vars[s.name] = v
+	case *forStmt:
+		from, err := in.evalInt(s.from)
+		if err != nil {
+			return &Error{Line: s.line, Err: err}
+		}
+		to, err := in.evalInt(s.to)
+		if err != nil {
+			return &Error{Line: s.line, Err: err}
+		}
+		if _, ok := builtins[s.name]; ok {
+			return &Error{Line: s.line, Err: fmt.Errorf("%w: %v", ErrReadOnly, s.name)}
+		}
+		for i := from; i <= to; i++ {
+			if in.iterations++; in.iterations > MaxIterations {
+				return &Error{Line: s.line, Err: fmt.Errorf("%w: more than %v loop iterations", ErrTooLong, MaxIterations)}
+			}
+			if time.Now().After(in.deadline) {
+				return &Error{Line: s.line, Err: fmt.Errorf("%w: more than %v", ErrTooLong, MaxDuration)}
+			}
+			in.vars[s.name] = float64(i)
+			if err = in.exec(s.body); err != nil {
+				return err
+			}
+		}
+	case *ifStmt:
+		v, err := in.eval(s.cond)
+		if err != nil {
+			return &Error{Line: s.line, Err: err}
+		}
+		if v != 0.0 && v != "" {
+			return in.exec(s.then)
+		}
+		return in.exec(s.els)
+	case *commandStmt:
+		cmd, ok := commands[s.name]
+		if !ok {
+			return &Error{Line: s.line, Err: fmt.Errorf("%w: %v", ErrUnknownCommand, s.name)}
+		}
+		if len(s.args) < cmd.min || cmd.max >= 0 && len(s.args) > cmd.max {
+			return &Error{Line: s.line, Err: fmt.Errorf("%w: usage: %v %v", ErrArgs, s.name, cmd.usage)}
+		}
+		args := make([]value, len(s.args))
+		for i, a := range s.args {
+			v, err := in.eval(a)
+			if err != nil {
+				return &Error{Line: s.line, Err: err}
+			}
+			args[i] = v
+		}
+		if err := cmd.run(in, args); err != nil {
+			return &Error{Line: s.line, Err: fmt.Errorf("%v: %w", s.name, err)}
+		}
+	}
+	return nil
+}
+
+func (in *interp) evalInt(e expr) (int, error) {
+	v, err := in.eval(e)
+	if err != nil {
+		return 0, err
+	}
+	return integer(v)
+}
+
+func (in *interp) eval(e expr) (value, error) {
+	switch e := e.(type) {
+	case *literal:
+		return e.val, nil
+	case *variable:
+		if b, ok := builtins[e.name]; ok {
+			return b(in), nil
+		}
+		v, ok := in.vars[e.name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %v", ErrUndefined, e.name)
+		}
+		return v, nil
+	case *unary:
+		v, err := in.eval(e.x)
+		if err != nil {
+			return nil, err
+		}
+		f, err := number(v)
+		if err != nil {
+			return nil, err
+		}
+		return -f, nil
+	case *binary:
+		x, err := in.eval(e.x)
+		if err != nil {
+			return nil, err
+		}
+		y, err := in.eval(e.y)
+		if err != nil {
+			return nil, err
+		}
+		return operate(e.op, x, y)
+	}
+	panic(fmt.Sprintf("unexpected expression %T", e))
+}
+
+// operate applies a binary operator. Strings are joined by + and compared
+// by == and !=, and joined with a number they take its text.
+func operate(op string, x, y value) (value, error) {
+	xs, xstr := x.(string)
+	ys, ystr := y.(string)
+	if xstr || ystr {
+		switch op {
+		case "+":
+			return format(x) + format(y), nil
+		case "==":
+			return truth(xstr && ystr && xs == ys), nil
+		case "!=":
+			return truth(!(xstr && ystr && xs == ys)), nil
+		}
+		return nil, fmt.Errorf("%w: %q cannot be applied to strings", ErrType, op)
+	}
+	a, b := x.(float64), y.(float64)
+	switch op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		return a / b, nil
+	case "%":
+		return math.Mod(a, b), nil
+	case "==":
+		return truth(a == b), nil
+	case "!=":
+		return truth(a != b), nil
+	case "<":
+		return truth(a < b), nil
+	case "<=":
+		return truth(a <= b), nil
+	case ">":
+		return truth(a > b), nil
+	case ">=":
+		return truth(a >= b), nil
+	}
+	panic("unexpected operator " + op)
+}
@@ -0,0 +1,73 @@
+package script_test
+
+import (
+	"errors"
+	"image/color"
+	"testing"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/image"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+	"github.com/gregjohnson2017/tabula-editor/pkg/script"
+)
+
+func TestErrors(t *testing.T) {
+	for _, tc := range []struct {
+		src  string
+		line int
+		err  error
+	}{
+		{"let x = 1\nlet y = (x +\n", 2, script.ErrSyntax},
+		{"\n\nfor i from 1 to 2 {\n", 4, script.ErrSyntax},
+		{"# comment\nspin 90", 2, script.ErrUnknownCommand},
+		{"let a = 1\nmove a\n", 2, script.ErrArgs},
+		{"print x", 1, script.ErrUndefined},
+		{"let width = 3", 1, script.ErrReadOnly},
+		{"name \"a\"", 1, image.ErrNoLayerSelected},
+		{"let s = \"a\" * 2", 1, script.ErrType},
+		{"let n = 0\nfor i from 0 to 1000000000000 {\n\tlet n = n + 1\n}\n", 2, script.ErrTooLong},
+	} {
+		doc, err := image.NewDocument(4, 4, color.NRGBA{}, raster.Depth8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = script.Run("test", tc.src, doc)
+		var e *script.Error
+		if !errors.As(err, &e) || e.Line != tc.line || !errors.Is(err, tc.err) {
+			t.Errorf("%q: expected %v on line %v, got %v", tc.src, tc.err, tc.line, err)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	doc, err := image.NewDocument(4, 4, color.NRGBA{}, raster.Depth8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err = script.Run("test", `
+let size = 2
+for i from 1 to 3 {
+	newlayer size size "ff0000"
+	place (i - 1) 1
+	if i == 2 {
+		name "middle"
+	}
+}
+select "middle"
+move 0 -1
+filter "invert"
+canvas (width * 2) height
+`, doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Layers) != 4 || doc.Canvas.W != 8 || doc.Canvas.H != 4 {
+		t.Fatalf("expected 3 layers on an 8x4 canvas, got %v on %vx%v", len(doc.Layers)-1, doc.Canvas.W, doc.Canvas.H)
+	}
+	l := doc.Layers[2]
+	if l.Name != "middle" || l.Area.X-doc.Canvas.X != 1 || l.Area.Y-doc.Canvas.Y != 0 {
+		t.Fatalf("expected middle at 1,0, got %q at %v", l.Name, l.Area)
+	}
+	if c := l.Image.NRGBAAt(0, 0); c != (color.NRGBA{0, 0xFF, 0xFF, 0xFF}) {
+		t.Fatalf("expected an inverted layer, got %v", c)
+	}
+}
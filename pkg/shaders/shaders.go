@@ -52,6 +52,21 @@ const (
 	}
 ` + "\x00"
 
+	// Uniform `area` is the (width, height) of the view in canvas pixels.
+	// Input `position_in` is the view-relative position in canvas pixels.
+	OverlayVertex = `
+	#version 330
+	uniform vec2 area;
+	uniform vec4 uni_color;
+	layout(location = 0) in vec2 position_in;
+	out vec4 color;
+	void main() {
+		vec2 glSpace = vec2(2.0, -2.0) * (position_in / area) + vec2(-1.0, 1.0);
+		gl_Position = vec4(glSpace, 0.0, 1.0);
+		color = uni_color;
+	}
+` + "\x00"
+
 	VertexShaderSource = `
 	#version 330
 	uniform vec2 area;
@@ -65,13 +80,16 @@ const (
 	}
 ` + "\x00"
 
+	// Uniform `opacity` scales the alpha of the texture.
 	FragmentShaderSource = `
 	#version 330
 	uniform sampler2D frag_tex;
+	uniform float opacity;
 	in vec2 tex_coords;
 	out vec4 frag_color;
 	void main() {
-		frag_color = texture(frag_tex, tex_coords);
+		vec4 col = texture(frag_tex, tex_coords);
+		frag_color = vec4(col.rgb, col.a * opacity);
 	}
 ` + "\x00"
 
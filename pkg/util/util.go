@@ -20,6 +20,15 @@ const (
 // ErrNoImageChosen indicates that an image selection was cancelled
 const ErrNoImageChosen log.ConstErr = "no image chosen"
 
+// ErrDialogUnsupported indicates that a dialog is not available on this platform
+const ErrDialogUnsupported log.ConstErr = "dialog not supported on this platform"
+
+// FileFilter is a named group of file name patterns offered by a file dialog
+type FileFilter struct {
+	Name     string
+	Patterns []string
+}
+
 // StopWatch is a time.Time with a stopping methods
 type StopWatch struct {
 	t time.Time
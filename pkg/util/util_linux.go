@@ -2,26 +2,33 @@ package util
 
 import (
 	"fmt"
+	"os/exec"
+	"strings"
 
 	"github.com/gregjohnson2017/tabula-editor/pkg/log"
 	"github.com/jcmuller/gozenity"
 	"github.com/veandco/go-sdl2/sdl"
 )
 
-// OpenFileDialog uses a system file picker to get a filename from the user
-func OpenFileDialog(win *sdl.Window) (string, error) {
-	files, err := gozenity.FileSelection("Choose a picture to open", map[string][]string{
-		"PNG":  []string{"*.png"},
-		"JPEG": []string{"*.jpg", "*.jpeg", "*.jpe", "*.jfif"},
-	})
+// OpenFileDialog uses a system file picker to get a filename from the user,
+// offering the given filters in order, the first selected
+func OpenFileDialog(win *sdl.Window, filters ...FileFilter) (string, error) {
+	// zenity is run directly since gozenity takes the filters as a map,
+	// which would show them in random order
+	args := []string{"--file-selection", "--title", "Choose a file to open"}
+	for _, f := range filters {
+		args = append(args, "--file-filter", f.Name+"|"+strings.Join(f.Patterns, " "))
+	}
+	out, err := exec.Command("zenity", args...).Output()
 	if err != nil {
 		return "", fmt.Errorf("OpenFileDialog: %w", err)
 	}
-	return files[0], nil
+	return strings.TrimSpace(string(out)), nil
 }
 
-// SaveFileDialog uses a system file picker to get a file path from the user for the purpose of saving an image
-func SaveFileDialog(*sdl.Window) (string, error) {
+// SaveFileDialog uses a system file picker to get a file path from the user for the purpose of saving an image.
+// The folder picker used here does not offer filters, so they are ignored.
+func SaveFileDialog(win *sdl.Window, filters ...FileFilter) (string, error) {
 	folders, err := gozenity.DirectorySelection("Choose a folder to save in")
 	if err != nil {
 		return "", fmt.Errorf("SaveFileDialog: %w", err)
@@ -33,3 +40,48 @@ func SaveFileDialog(*sdl.Window) (string, error) {
 	log.Debugf("SaveFileDialog got back with %v folders and \"%v\" file name", folders, file)
 	return folders[0] + "/" + file, nil
 }
+
+// FolderDialog uses a system folder picker to get a folder from the user
+func FolderDialog(win *sdl.Window) (string, error) {
+	folders, err := gozenity.DirectorySelection("Choose a folder")
+	if err != nil {
+		return "", fmt.Errorf("FolderDialog: %w", err)
+	}
+	return folders[0], nil
+}
+
+// EntryDialog prompts the user for a line of text, prefilled with placeholder
+func EntryDialog(win *sdl.Window, prompt, placeholder string) (string, error) {
+	text, err := gozenity.Entry(prompt, placeholder)
+	if err != nil {
+		return "", fmt.Errorf("EntryDialog: %w", err)
+	}
+	return text, nil
+}
+
+// ListDialog prompts the user to choose one of the given options
+func ListDialog(win *sdl.Window, prompt string, options ...string) (string, error) {
+	choice, err := gozenity.List(prompt, options...)
+	if err != nil {
+		return "", fmt.Errorf("ListDialog: %w", err)
+	}
+	return choice, nil
+}
+
+// QuestionDialog asks the user a yes or no question
+func QuestionDialog(win *sdl.Window, prompt string) (bool, error) {
+	answer, err := gozenity.Question(prompt)
+	if err != nil {
+		return false, fmt.Errorf("QuestionDialog: %w", err)
+	}
+	return answer, nil
+}
+
+// OpenExternal opens the file at path in the program the desktop associates
+// with it
+func OpenExternal(path string) error {
+	if err := exec.Command("xdg-open", path).Start(); err != nil {
+		return fmt.Errorf("OpenExternal: %w", err)
+	}
+	return nil
+}
@@ -1,21 +1,29 @@
 package util
 
 import (
+	"encoding/base64"
+	"encoding/binary"
 	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
 
 	"github.com/kroppt/winfileask"
 	"github.com/veandco/go-sdl2/sdl"
 )
 
-// OpenFileDialog uses a system file picker to get a filename from the user
-func OpenFileDialog(win *sdl.Window) (string, error) {
+// OpenFileDialog uses a system file picker to get a filename from the user,
+// offering the given filters
+func OpenFileDialog(win *sdl.Window, filters ...FileFilter) (string, error) {
 	var wm *sdl.SysWMInfo
 	var err error
 	if wm, err = win.GetWMInfo(); err != nil {
 		return "", err
 	}
 	info := wm.GetWindowsInfo()
-	filter := winfileask.FileFilter{winfileask.Filter{}}
+	filter := fileFilter(filters)
 	str, ok, err := winfileask.GetOpenFileName(info.Window, "Open an Image", filter, "")
 	if !ok {
 		err = ErrNoImageChosen
@@ -26,24 +34,28 @@ func OpenFileDialog(win *sdl.Window) (string, error) {
 	return str, nil
 }
 
+// fileFilter converts filters to the form taken by winfileask
+func fileFilter(filters []FileFilter) winfileask.FileFilter {
+	filter := winfileask.FileFilter{}
+	for _, f := range filters {
+		pattern := strings.Join(f.Patterns, ";")
+		filter = append(filter, winfileask.Filter{
+			Name:    fmt.Sprintf("%v (%v)", f.Name, pattern),
+			Pattern: pattern,
+		})
+	}
+	return filter
+}
+
 // SaveFileDialog uses a system file picker to get a file path from the user for the purpose of saving an image
-func SaveFileDialog(win *sdl.Window) (string, error) {
+func SaveFileDialog(win *sdl.Window, filters ...FileFilter) (string, error) {
 	var wm *sdl.SysWMInfo
 	var err error
 	if wm, err = win.GetWMInfo(); err != nil {
 		return "", err
 	}
 	info := wm.GetWindowsInfo()
-	filter := winfileask.FileFilter{
-		winfileask.Filter{
-			Name:    "JPEG (*.jpeg;*.jpg;*.jpe;*.jfif)",
-			Pattern: "*.jpeg;*.jpg;*.jpe;*.jfif",
-		},
-		winfileask.Filter{
-			Name:    "PNG (*.png)",
-			Pattern: "*.png",
-		},
-	}
+	filter := fileFilter(filters)
 	str, ok, err := winfileask.GetSaveFileName(info.Window, "Save an Image", filter, "")
 	if !ok {
 		err = ErrNoImageChosen
@@ -53,3 +65,113 @@ func SaveFileDialog(win *sdl.Window) (string, error) {
 	}
 	return str, nil
 }
+
+// FolderDialog gets a folder from the user. Windows has no folder picker
+// here, so the folder of a file chosen in the save dialog is used.
+func FolderDialog(win *sdl.Window) (string, error) {
+	file, err := SaveFileDialog(win)
+	if err != nil {
+		return "", fmt.Errorf("FolderDialog: %w", err)
+	}
+	return filepath.Dir(file), nil
+}
+
+// EntryDialog prompts the user for a line of text, prefilled with placeholder
+func EntryDialog(win *sdl.Window, prompt, placeholder string) (string, error) {
+	text, err := powershell(entryScript, "TABULA_PROMPT="+prompt, "TABULA_TEXT="+placeholder)
+	if err != nil {
+		return "", fmt.Errorf("EntryDialog: %w", err)
+	}
+	return text, nil
+}
+
+// ListDialog prompts the user to choose one of the given options
+func ListDialog(win *sdl.Window, prompt string, options ...string) (string, error) {
+	choice, err := powershell(listScript, "TABULA_PROMPT="+prompt, "TABULA_OPTIONS="+strings.Join(options, "\n"))
+	if err != nil {
+		return "", fmt.Errorf("ListDialog: %w", err)
+	}
+	return choice, nil
+}
+
+// QuestionDialog asks the user a yes or no question
+func QuestionDialog(win *sdl.Window, prompt string) (bool, error) {
+	id, err := sdl.ShowMessageBox(&sdl.MessageBoxData{
+		Flags:   sdl.MESSAGEBOX_INFORMATION,
+		Window:  win,
+		Title:   "Tabula",
+		Message: prompt,
+		Buttons: []sdl.MessageBoxButtonData{
+			{Flags: sdl.MESSAGEBOX_BUTTON_RETURNKEY_DEFAULT, ButtonID: 1, Text: "Yes"},
+			{Flags: sdl.MESSAGEBOX_BUTTON_ESCAPEKEY_DEFAULT, ButtonID: 0, Text: "No"},
+		},
+	})
+	if err != nil {
+		return false, fmt.Errorf("QuestionDialog: %w", err)
+	}
+	return id == 1, nil
+}
+
+// entryScript shows $env:TABULA_PROMPT above a text box holding
+// $env:TABULA_TEXT and prints the text, exiting with 1 if canceled
+const entryScript = `
+Add-Type -AssemblyName System.Windows.Forms
+[Console]::OutputEncoding = [System.Text.Encoding]::UTF8
+$form = New-Object System.Windows.Forms.Form -Property @{Text = 'Tabula'; Width = 420; Height = 150; FormBorderStyle = 'FixedDialog'; StartPosition = 'CenterScreen'; TopMost = $true; MaximizeBox = $false; MinimizeBox = $false}
+$label = New-Object System.Windows.Forms.Label -Property @{Text = $env:TABULA_PROMPT; Left = 10; Top = 10; Width = 385}
+$box = New-Object System.Windows.Forms.TextBox -Property @{Text = $env:TABULA_TEXT; Left = 10; Top = 35; Width = 385}
+$ok = New-Object System.Windows.Forms.Button -Property @{Text = 'OK'; Left = 235; Top = 70; DialogResult = 'OK'}
+$cancel = New-Object System.Windows.Forms.Button -Property @{Text = 'Cancel'; Left = 320; Top = 70; DialogResult = 'Cancel'}
+$form.Controls.AddRange(@($label, $box, $ok, $cancel))
+$form.AcceptButton = $ok
+$form.CancelButton = $cancel
+if ($form.ShowDialog() -ne 'OK') { exit 1 }
+$box.Text
+`
+
+// listScript shows $env:TABULA_PROMPT above the lines of
+// $env:TABULA_OPTIONS and prints the chosen one, exiting with 1 if canceled
+const listScript = `
+Add-Type -AssemblyName System.Windows.Forms
+[Console]::OutputEncoding = [System.Text.Encoding]::UTF8
+$form = New-Object System.Windows.Forms.Form -Property @{Text = 'Tabula'; Width = 420; Height = 320; FormBorderStyle = 'FixedDialog'; StartPosition = 'CenterScreen'; TopMost = $true; MaximizeBox = $false; MinimizeBox = $false}
+$label = New-Object System.Windows.Forms.Label -Property @{Text = $env:TABULA_PROMPT; Left = 10; Top = 10; Width = 385}
+$list = New-Object System.Windows.Forms.ListBox -Property @{Left = 10; Top = 35; Width = 385; Height = 200}
+$list.Items.AddRange($env:TABULA_OPTIONS.Split([char]10))
+$list.SelectedIndex = 0
+$ok = New-Object System.Windows.Forms.Button -Property @{Text = 'OK'; Left = 235; Top = 245; DialogResult = 'OK'}
+$cancel = New-Object System.Windows.Forms.Button -Property @{Text = 'Cancel'; Left = 320; Top = 245; DialogResult = 'Cancel'}
+$list.Add_DoubleClick({ $form.DialogResult = 'OK' })
+$form.Controls.AddRange(@($label, $list, $ok, $cancel))
+$form.AcceptButton = $ok
+$form.CancelButton = $cancel
+if ($form.ShowDialog() -ne 'OK') { exit 1 }
+$list.SelectedItem
+`
+
+// powershell runs script with the environment variables added and returns
+// what it prints without the final line break. The script is passed
+// encoded, so it needs no quoting.
+func powershell(script string, env ...string) (string, error) {
+	units := utf16.Encode([]rune(script))
+	encoded := make([]byte, 2*len(units))
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(encoded[2*i:], u)
+	}
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-EncodedCommand", base64.StdEncoding.EncodeToString(encoded))
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
+
+// OpenExternal opens the file at path in the program the desktop associates
+// with it
+func OpenExternal(path string) error {
+	if err := exec.Command("rundll32", "url.dll,FileProtocolHandler", path).Start(); err != nil {
+		return fmt.Errorf("OpenExternal: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package watch
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// inotifyMask notices files finished being written or renamed into place,
+// which is how most programs replace a file atomically
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO
+
+// inotify is a Watcher notified by the kernel. It watches the folder of each
+// file so that files replaced by a rename are still noticed.
+type inotify struct {
+	// fd is kept apart from f since calling f.Fd makes reads block
+	fd      int
+	f       *os.File
+	mu      sync.Mutex
+	dirs    map[string]int32
+	names   map[int32]string
+	files   map[string]bool
+	changes chan string
+	done    chan struct{}
+	once    sync.Once
+}
+
+func newInotify() (Watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	w := &inotify{
+		// a non-blocking file is read through the runtime poller, so
+		// closing it ends a pending read
+		fd:      fd,
+		f:       os.NewFile(uintptr(fd), "inotify"),
+		dirs:    make(map[string]int32),
+		names:   make(map[int32]string),
+		files:   make(map[string]bool),
+		changes: make(chan string),
+		done:    make(chan struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+func (w *inotify) Add(path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	dir := filepath.Dir(path)
+	if _, ok := w.dirs[dir]; !ok {
+		wd, err := syscall.InotifyAddWatch(w.fd, dir, inotifyMask)
+		if err != nil {
+			return &os.PathError{Op: "inotify_add_watch", Path: dir, Err: err}
+		}
+		w.dirs[dir] = int32(wd)
+		w.names[int32(wd)] = dir
+	}
+	w.files[path] = true
+	return nil
+}
+
+func (w *inotify) Remove(path string) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.files, path)
+	dir := filepath.Dir(path)
+	for f := range w.files {
+		if filepath.Dir(f) == dir {
+			return
+		}
+	}
+	if wd, ok := w.dirs[dir]; ok {
+		_, _ = syscall.InotifyRmWatch(w.fd, uint32(wd))
+		delete(w.dirs, dir)
+		delete(w.names, wd)
+	}
+}
+
+func (w *inotify) Changes() <-chan string {
+	return w.changes
+}
+
+func (w *inotify) Close() error {
+	var err error
+	w.once.Do(func() {
+		close(w.done)
+		err = w.f.Close()
+	})
+	return err
+}
+
+func (w *inotify) run() {
+	defer close(w.changes)
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, path := range w.parse(buf[:n]) {
+			select {
+			case w.changes <- path:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// parse returns the watched files named by the events in buf
+func (w *inotify) parse(buf []byte) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var changed []string
+	for off := 0; off+syscall.SizeofInotifyEvent <= len(buf); {
+		e := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+		start := off + syscall.SizeofInotifyEvent
+		off = start + int(e.Len)
+		if e.Mask&inotifyMask == 0 || e.Len == 0 || off > len(buf) {
+			continue
+		}
+		dir, ok := w.names[e.Wd]
+		if !ok {
+			continue
+		}
+		name := string(bytes.TrimRight(buf[start:off], "\x00"))
+		if path := filepath.Join(dir, name); w.files[path] {
+			changed = append(changed, path)
+		}
+	}
+	return changed
+}
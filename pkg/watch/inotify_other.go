@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package watch
+
+// newInotify is unsupported outside of Linux, where watchers poll instead
+func newInotify() (Watcher, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,192 @@
+// Package watch reports changes to files on disk, either by polling their
+// modification times or through the notifications of the operating system.
+package watch
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+)
+
+// ErrUnknownMode indicates a watch mode that is not one of the Modes
+const ErrUnknownMode log.ConstErr = "unknown watch mode"
+
+// ErrUnsupported indicates a watch mode the operating system does not offer
+const ErrUnsupported log.ConstErr = "watch mode unsupported on this system"
+
+// Mode is how changes are noticed
+type Mode string
+
+// The watch modes
+const (
+	// Off watches nothing
+	Off Mode = "off"
+	// Poll compares the modification time and size of each file at an
+	// interval
+	Poll Mode = "poll"
+	// Inotify is notified by the kernel when a file is written or replaced
+	Inotify Mode = "inotify"
+	// Auto uses inotify where it is supported and polls otherwise
+	Auto Mode = "auto"
+)
+
+// Modes lists the watch modes
+var Modes = []Mode{Off, Poll, Inotify, Auto}
+
+// ParseMode returns the mode with the given name.
+func ParseMode(s string) (Mode, error) {
+	for _, m := range Modes {
+		if string(m) == s {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q, expected one of %v", ErrUnknownMode, s, Modes)
+}
+
+// Watcher sends the path of a watched file on Changes whenever it is written,
+// replaced or created.
+type Watcher interface {
+	// Add watches the file at path. Paths are made absolute, and the file
+	// need not exist yet.
+	Add(path string) error
+	// Remove stops watching the file at path.
+	Remove(path string)
+	// Changes receives the absolute path of every changed file.
+	Changes() <-chan string
+	// Close stops watching every file and closes Changes.
+	Close() error
+}
+
+// New returns a watcher using the given mode, or nil for Off. The interval
+// is how often a polling watcher checks the files.
+func New(mode Mode, interval time.Duration) (Watcher, error) {
+	switch mode {
+	case Off:
+		return nil, nil
+	case Poll:
+		return NewPoller(interval), nil
+	case Inotify:
+		return newInotify()
+	case Auto:
+		w, err := newInotify()
+		if err != nil {
+			log.Debugf("falling back to polling: %v", err)
+			return NewPoller(interval), nil
+		}
+		return w, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownMode, mode)
+}
+
+// stamp is what a poller compares to notice changes
+type stamp struct {
+	modTime int64
+	size    int64
+	exists  bool
+}
+
+func statStamp(path string) stamp {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return stamp{}
+	}
+	return stamp{modTime: fi.ModTime().UnixNano(), size: fi.Size(), exists: true}
+}
+
+// Poller is a Watcher comparing the modification time and size of each file
+// at an interval. It works on every system and file system, at the cost of
+// noticing changes late.
+type Poller struct {
+	mu      sync.Mutex
+	files   map[string]stamp
+	changes chan string
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NewPoller returns a poller checking its files every interval.
+func NewPoller(interval time.Duration) *Poller {
+	p := &Poller{
+		files:   make(map[string]stamp),
+		changes: make(chan string),
+		done:    make(chan struct{}),
+	}
+	go p.run(interval)
+	return p
+}
+
+// Add watches the file at path.
+func (p *Poller) Add(path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.files[path]; !ok {
+		p.files[path] = statStamp(path)
+	}
+	return nil
+}
+
+// Remove stops watching the file at path.
+func (p *Poller) Remove(path string) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	p.mu.Lock()
+	delete(p.files, path)
+	p.mu.Unlock()
+}
+
+// Changes receives the path of every changed file.
+func (p *Poller) Changes() <-chan string {
+	return p.changes
+}
+
+// Close stops polling and closes Changes.
+func (p *Poller) Close() error {
+	p.once.Do(func() { close(p.done) })
+	return nil
+}
+
+func (p *Poller) run(interval time.Duration) {
+	defer close(p.changes)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-t.C:
+		}
+		for _, path := range p.poll() {
+			select {
+			case p.changes <- path:
+			case <-p.done:
+				return
+			}
+		}
+	}
+}
+
+// poll returns the files whose stamp changed to that of an existing file
+func (p *Poller) poll() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var changed []string
+	for path, old := range p.files {
+		s := statStamp(path)
+		if s != old {
+			p.files[path] = s
+			if s.exists {
+				changed = append(changed, path)
+			}
+		}
+	}
+	return changed
+}
@@ -0,0 +1,84 @@
+package watch_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/watch"
+)
+
+func TestWatchers(t *testing.T) {
+	for _, mode := range []watch.Mode{watch.Poll, watch.Inotify} {
+		t.Run(string(mode), func(t *testing.T) {
+			w, err := watch.New(mode, 10*time.Millisecond)
+			if errors.Is(err, watch.ErrUnsupported) {
+				t.Skip(err)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+			dir, err := ioutil.TempDir("", "watch")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "layer.png")
+			other := filepath.Join(dir, "other.png")
+			if err = w.Add(path); err != nil {
+				t.Fatal(err)
+			}
+			// give a poller a chance to stamp the missing file first
+			time.Sleep(30 * time.Millisecond)
+			if err = ioutil.WriteFile(other, []byte("a"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			// replace the file by renaming, as many programs do
+			tmp := filepath.Join(dir, "tmp")
+			if err = ioutil.WriteFile(tmp, []byte("ab"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err = os.Rename(tmp, path); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case got := <-w.Changes():
+				if got != path {
+					t.Errorf("got change of %v, want %v", got, path)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("no change noticed")
+			}
+			w.Remove(path)
+			if err = ioutil.WriteFile(path, []byte("abc"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case got := <-w.Changes():
+				t.Errorf("got change of removed file %v", got)
+			case <-time.After(100 * time.Millisecond):
+			}
+			if err = w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := <-w.Changes(); ok {
+				t.Error("changes still open after close")
+			}
+		})
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for _, m := range watch.Modes {
+		if got, err := watch.ParseMode(string(m)); err != nil || got != m {
+			t.Errorf("ParseMode(%q) = %v, %v", m, got, err)
+		}
+	}
+	if _, err := watch.ParseMode("sometimes"); !errors.Is(err, watch.ErrUnknownMode) {
+		t.Errorf("got %v, want %v", err, watch.ErrUnknownMode)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"image/color"
+
+	"github.com/gregjohnson2017/tabula-editor/pkg/image"
+	"github.com/gregjohnson2017/tabula-editor/pkg/log"
+	"github.com/gregjohnson2017/tabula-editor/pkg/raster"
+	"github.com/gregjohnson2017/tabula-editor/pkg/script"
+)
+
+// runScript runs a script on the project, or on a blank canvas if there is
+// none, without opening a window. The script is expected to save or export
+// its results.
+func runScript(path, project string) int {
+	var doc *image.Document
+	var err error
+	if project != "" {
+		doc, err = image.ReadDocument(project)
+	} else {
+		doc, err = image.NewDocument(100, 100, color.NRGBA{}, raster.Depth8)
+	}
+	if err != nil {
+		log.Warn(err)
+		return exitError
+	}
+	if _, err = script.RunFile(path, doc); err != nil {
+		log.Warn(err)
+		return exitError
+	}
+	return exitOK
+}